@@ -0,0 +1,63 @@
+package future
+
+import "context"
+
+// Then returns a Future that waits for f and passes its result to fn.
+// If f fails, fn is not called and the error is passed on. Starting the
+// returned Future starts f; cancelling it cancels f.
+func Then[T, U any](f Future[T], fn func(T) (U, error)) Future[U] {
+	return New(context.Background(), func(ctx context.Context) (U, error) {
+		v, err := await(ctx, f)
+		if err != nil {
+			var zero U
+			return zero, err
+		}
+		return fn(v)
+	})
+}
+
+// Map is like Then for a conversion that cannot fail.
+func Map[T, U any](f Future[T], fn func(T) U) Future[U] {
+	return Then(f, func(v T) (U, error) {
+		return fn(v), nil
+	})
+}
+
+// Chain is like Then for a step that is itself asynchronous: the Future
+// returned by fn is started and waited for.
+func Chain[T, U any](f Future[T], fn func(T) Future[U]) Future[U] {
+	return New(context.Background(), func(ctx context.Context) (U, error) {
+		v, err := await(ctx, f)
+		if err != nil {
+			var zero U
+			return zero, err
+		}
+		return await(ctx, fn(v))
+	})
+}
+
+// Catch returns a Future that resolves like f, except that an error is
+// passed to fn, which may recover by returning a value or return another
+// error.
+func Catch[T any](f Future[T], fn func(error) (T, error)) Future[T] {
+	return New(context.Background(), func(ctx context.Context) (T, error) {
+		v, err := await(ctx, f)
+		if err != nil {
+			return fn(err)
+		}
+		return v, nil
+	})
+}
+
+// await starts f and waits for it, cancelling f if ctx is done first.
+func await[T any](ctx context.Context, f Future[T]) (T, error) {
+	f.Start()
+	select {
+	case <-f.Done():
+		return f.Wait()
+	case <-ctx.Done():
+		f.Cancel()
+		var zero T
+		return zero, ctx.Err()
+	}
+}
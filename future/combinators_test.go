@@ -0,0 +1,103 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThenMapChain(t *testing.T) {
+	ctx := context.Background()
+
+	build := New(ctx, func(ctx context.Context) (string, error) {
+		return "artifact-42", nil
+	})
+	parse := Then(build, func(out string) (int, error) {
+		return strconv.Atoi(out[len("artifact-"):])
+	})
+	upload := Chain(parse, func(id int) Future[string] {
+		return New(ctx, func(ctx context.Context) (string, error) {
+			return "uploaded " + strconv.Itoa(id), nil
+		})
+	})
+	shout := Map(upload, func(s string) string { return s + "!" })
+
+	// Nothing runs until the last future is started
+	time.Sleep(10 * time.Millisecond)
+	if build.IsDone() {
+		t.Error("Expected the chain not to start on its own")
+	}
+
+	result, err := shout.Start().Wait()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result != "uploaded 42!" {
+		t.Errorf("Expected 'uploaded 42!', got %q", result)
+	}
+}
+
+func TestThenPropagatesErrors(t *testing.T) {
+	errBuild := errors.New("build failed")
+	called := false
+
+	build := New(context.Background(), func(ctx context.Context) (string, error) {
+		return "", errBuild
+	})
+	parse := Then(build, func(string) (int, error) {
+		called = true
+		return 0, nil
+	})
+
+	if _, err := parse.Start().Wait(); !errors.Is(err, errBuild) {
+		t.Errorf("Expected the build error, got %v", err)
+	}
+	if called {
+		t.Error("Expected fn not to be called after an error")
+	}
+}
+
+func TestCatch(t *testing.T) {
+	failing := New(context.Background(), func(ctx context.Context) (int, error) {
+		return 0, errors.New("not found")
+	})
+	recovered := Catch(failing, func(err error) (int, error) {
+		return -1, nil
+	})
+
+	result, err := recovered.Start().Wait()
+	if err != nil || result != -1 {
+		t.Errorf("Expected the recovered value, got %v, %v", result, err)
+	}
+
+	ok := Catch(Map(New(context.Background(), func(ctx context.Context) (int, error) {
+		return 1, nil
+	}), func(v int) int { return v * 2 }), func(error) (int, error) {
+		t.Error("Expected Catch not to be called on success")
+		return 0, nil
+	})
+	if result, _ := ok.Start().Wait(); result != 2 {
+		t.Errorf("Expected 2, got %d", result)
+	}
+}
+
+func TestThenCancel(t *testing.T) {
+	slow := New(context.Background(), func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	next := Then(slow, func(v int) (int, error) { return v, nil })
+	next.Start()
+	next.Cancel()
+
+	if _, err := WaitTimeout(time.Second, next); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected cancelling the chain to cancel the upstream future")
+	}
+}
@@ -3,10 +3,31 @@ package future
 
 import (
 	"context"
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"time"
 )
 
+// PanicError is the error of a Future whose function panicked, which
+// would otherwise crash the program from the goroutine running it.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("future: panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 type Future[T any] interface {
 	Start() Future[T]
 	Cancel()
@@ -62,6 +83,7 @@ func (fu *futureImpl[T]) IsDone() bool {
 // execute runs the function in a goroutine and handles the result
 func (fu *futureImpl[T]) execute() {
 	defer close(fu.done)
+	defer fu.recoverPanic()
 
 	result, err := fu.fn(fu.ctx)
 
@@ -71,6 +93,17 @@ func (fu *futureImpl[T]) execute() {
 	fu.mu.Unlock()
 }
 
+// recoverPanic, deferred by execute, resolves the future with a
+// *PanicError if its function panicked.
+func (fu *futureImpl[T]) recoverPanic() {
+	if r := recover(); r != nil {
+		var zero T
+		fu.mu.Lock()
+		fu.res, fu.err = zero, &PanicError{Value: r, Stack: debug.Stack()}
+		fu.mu.Unlock()
+	}
+}
+
 // New creates a new Future that executes the given function with the provided context.
 // The Future is not started automatically - call Start() to begin execution.
 func New[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) Future[T] {
@@ -91,28 +124,28 @@ func Start[T any](ctx context.Context, fn func(ctx context.Context) (T, error))
 	return future
 }
 
-// WaitAll waits for all provided futures to complete and returns their results.
-// immediately cancels all futures if any of them fails or if the context is done.
-// If any future returns an error, it will return the first error encountered.
+// WaitAll starts fus and waits for all of them to complete, returning their
+// results in the order of fus. It waits on all futures at once, so the first
+// failure is noticed however long the others take: the remaining futures are
+// then cancelled and the error is returned. If ctx is done first, all futures
+// are cancelled and the context's error is returned.
 func WaitAll[T any](ctx context.Context, fus ...Future[T]) ([]T, error) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	res := make([]T, 0, len(fus))
+	stop := make(chan struct{})
+	defer close(stop)
+	done := firstDone(fus, stop)
 
-	for _, fu := range fus {
+	res := make([]T, len(fus))
+	for range fus {
 		select {
-		case <-fu.Done():
-			r, err := fu.Wait()
+		case i := <-done:
+			r, err := fus[i].Wait()
 			if err != nil {
+				cancelAll(fus)
 				return nil, err
 			}
-
-			res = append(res, r)
+			res[i] = r
 		case <-ctx.Done():
-			for _, fu := range fus {
-				fu.Cancel()
-			}
+			cancelAll(fus)
 			return nil, ctx.Err()
 		}
 	}
@@ -120,6 +153,47 @@ func WaitAll[T any](ctx context.Context, fus ...Future[T]) ([]T, error) {
 	return res, nil
 }
 
+// Settled is the outcome of one of the futures passed to WaitAllSettled.
+type Settled[T any] struct {
+	Value T
+	Err   error
+}
+
+// WaitAllSettled starts fus and waits for all of them to complete, whether
+// they succeed or fail, returning the outcome of each in the order of fus.
+// errors.Join of the Err fields gives all failures at once. If ctx is done
+// first, the futures still running are cancelled and their outcome is the
+// context's error.
+func WaitAllSettled[T any](ctx context.Context, fus ...Future[T]) []Settled[T] {
+	stop := make(chan struct{})
+	defer close(stop)
+	done := firstDone(fus, stop)
+
+	res := make([]Settled[T], len(fus))
+	settled := make([]bool, len(fus))
+	for range fus {
+		select {
+		case i := <-done:
+			res[i].Value, res[i].Err = fus[i].Wait()
+			settled[i] = true
+		case <-ctx.Done():
+			for i, fu := range fus {
+				switch {
+				case settled[i]:
+				case fu.IsDone():
+					res[i].Value, res[i].Err = fu.Wait()
+				default:
+					fu.Cancel()
+					res[i].Err = ctx.Err()
+				}
+			}
+			return res
+		}
+	}
+
+	return res
+}
+
 func WaitTimeout[T any](d time.Duration, fu Future[T]) (r T, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), d)
 	defer cancel()
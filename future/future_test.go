@@ -464,3 +464,80 @@ func TestWaitTimeout_DifferentTypes(t *testing.T) {
 		}
 	})
 }
+
+func TestWaitAll(t *testing.T) {
+	ctx := context.Background()
+	results, err := WaitAll(ctx,
+		after(30*time.Millisecond, 1, nil),
+		after(10*time.Millisecond, 2, nil),
+		after(20*time.Millisecond, 3, nil))
+	if err != nil || len(results) != 3 || results[0] != 1 || results[1] != 2 || results[2] != 3 {
+		t.Errorf("Expected results in order, got %v, %v", results, err)
+	}
+
+	// A failure is noticed while an earlier future is still running
+	errFast := errors.New("fast failure")
+	slow := after(time.Second, 0, nil)
+	start := time.Now()
+	if _, err := WaitAll(ctx, slow, after(10*time.Millisecond, 0, errFast)); !errors.Is(err, errFast) {
+		t.Errorf("Expected the failure, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected WaitAll to fail fast, took %v", elapsed)
+	}
+	if _, err := WaitTimeout(time.Second, slow); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the slow future to be cancelled, got %v", err)
+	}
+
+	if results, err := WaitAll[int](ctx); err != nil || len(results) != 0 {
+		t.Errorf("Expected no results, got %v, %v", results, err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := WaitAll(timeout, after(time.Second, 0, nil)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+func TestWaitAllSettled(t *testing.T) {
+	errA := errors.New("a failed")
+	errC := errors.New("c failed")
+	res := WaitAllSettled(context.Background(),
+		after(20*time.Millisecond, "a", errA),
+		after(10*time.Millisecond, "b", nil),
+		after(5*time.Millisecond, "c", errC))
+
+	if len(res) != 3 {
+		t.Fatalf("Expected 3 outcomes, got %v", res)
+	}
+	if !errors.Is(res[0].Err, errA) || res[1].Err != nil || res[1].Value != "b" || !errors.Is(res[2].Err, errC) {
+		t.Errorf("Unexpected outcomes %+v", res)
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slow := after(time.Second, "slow", nil)
+	res = WaitAllSettled(timeout, after(time.Millisecond, "fast", nil), slow)
+	if res[0].Err != nil || res[0].Value != "fast" || !errors.Is(res[1].Err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected outcomes %+v", res)
+	}
+	if _, err := WaitTimeout(time.Second, slow); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the slow future to be cancelled, got %v", err)
+	}
+}
+
+func TestPanicResolvesWithError(t *testing.T) {
+	fu := Start(context.Background(), func(ctx context.Context) (int, error) {
+		panic("task bug")
+	})
+
+	_, err := fu.Wait()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "task bug" {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+	if len(panicErr.Stack) == 0 {
+		t.Error("Expected the stack trace")
+	}
+}
@@ -0,0 +1,61 @@
+package future
+
+import (
+	"context"
+	"sync"
+)
+
+// LazyFuture is a Future that runs its function on first use and memoizes
+// the outcome, such as a once-per-process version check or auth token
+// lookup. Reset discards the outcome so that it is computed again.
+type LazyFuture[T any] struct {
+	ctx context.Context
+	fn  func(ctx context.Context) (T, error)
+
+	mu  sync.Mutex
+	cur Future[T]
+}
+
+// Lazy returns a LazyFuture for fn. Unlike New, fn runs when the future is
+// first waited on or started, and all waiters share its result or error.
+func Lazy[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *LazyFuture[T] {
+	return &LazyFuture[T]{ctx: ctx, fn: fn, cur: New(ctx, fn)}
+}
+
+func (l *LazyFuture[T]) current() Future[T] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cur
+}
+
+func (l *LazyFuture[T]) Start() Future[T] {
+	l.current().Start()
+	return l
+}
+
+func (l *LazyFuture[T]) Cancel() {
+	l.current().Cancel()
+}
+
+// Wait runs the function unless it already ran or is running and returns
+// its result.
+func (l *LazyFuture[T]) Wait() (T, error) {
+	return l.current().Start().Wait()
+}
+
+func (l *LazyFuture[T]) Done() chan any {
+	return l.current().Done()
+}
+
+func (l *LazyFuture[T]) IsDone() bool {
+	return l.current().IsDone()
+}
+
+// Reset discards the memoized outcome, e.g. after an error or once a token
+// has expired, so that the next Wait runs the function again. Callers
+// already waiting on a run in progress still receive its result.
+func (l *LazyFuture[T]) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cur = New(l.ctx, l.fn)
+}
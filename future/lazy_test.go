@@ -0,0 +1,82 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLazy(t *testing.T) {
+	var calls atomic.Int32
+	lazy := Lazy(context.Background(), func(ctx context.Context) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return int(calls.Add(1)), nil
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	if calls.Load() != 0 || lazy.IsDone() {
+		t.Fatal("Expected Lazy to defer running until the first Wait")
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := lazy.Wait(); r != 1 || err != nil {
+				t.Errorf("Expected the memoized result 1, got %d, %v", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("Expected one call, got %d", calls.Load())
+	}
+
+	lazy.Reset()
+	if lazy.IsDone() {
+		t.Error("Expected Reset to discard the result")
+	}
+	if r, _ := lazy.Wait(); r != 2 {
+		t.Errorf("Expected a recomputed result 2, got %d", r)
+	}
+}
+
+func TestLazyError(t *testing.T) {
+	errToken := errors.New("token expired")
+	var calls atomic.Int32
+	lazy := Lazy(context.Background(), func(ctx context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			return "", errToken
+		}
+		return "token", nil
+	})
+
+	for range 2 {
+		if _, err := lazy.Wait(); !errors.Is(err, errToken) {
+			t.Errorf("Expected the memoized error, got %v", err)
+		}
+	}
+
+	lazy.Reset()
+	if r, err := lazy.Wait(); err != nil || r != "token" {
+		t.Errorf("Expected a retry after Reset, got %q, %v", r, err)
+	}
+}
+
+func TestLazyWithCombinators(t *testing.T) {
+	lazy := Lazy(context.Background(), func(ctx context.Context) (int, error) {
+		return 21, nil
+	})
+	doubled := Map(Future[int](lazy), func(v int) int { return v * 2 })
+
+	if r, err := doubled.Start().Wait(); err != nil || r != 42 {
+		t.Errorf("Expected 42, got %d, %v", r, err)
+	}
+	if !lazy.IsDone() {
+		t.Error("Expected the combinator to run the lazy future")
+	}
+}
@@ -0,0 +1,194 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is the error of futures submitted to a closed Pool.
+var ErrPoolClosed = errors.New("future: pool is closed")
+
+// Pool runs futures on a fixed number of worker goroutines. Submitted
+// futures wait in a bounded queue until a worker is free, and Submit blocks
+// while the queue is full, so producers cannot outrun the workers.
+type Pool[T any] struct {
+	workers int
+	jobs    chan *pooledFuture[T]
+	quit    chan struct{}
+
+	mu        sync.Mutex
+	idle      *sync.Cond
+	pending   int // queued or running
+	running   int
+	completed int64
+	closed    bool
+}
+
+// PoolStats is a snapshot of a Pool's load.
+type PoolStats struct {
+	Workers int
+	// Queued is the number of submitted futures waiting for a worker.
+	Queued int
+	// Running is the number of futures being executed.
+	Running int
+	// Completed is the number of futures that have finished.
+	Completed int64
+}
+
+// NewPool starts a pool of workers goroutines with room for queueSize
+// futures waiting to run.
+func NewPool[T any](workers, queueSize int) *Pool[T] {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool[T]{
+		workers: workers,
+		jobs:    make(chan *pooledFuture[T], max(queueSize, 0)),
+		quit:    make(chan struct{}),
+	}
+	p.idle = sync.NewCond(&p.mu)
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues fn to run on a worker and returns its Future, which is
+// already started; calling Start on it has no effect. Submit blocks while
+// the queue is full. If ctx is done before fn was queued or run, the
+// Future fails with the context's error; cancelling the Future has the
+// same effect.
+func (p *Pool[T]) Submit(ctx context.Context, fn func(ctx context.Context) (T, error)) Future[T] {
+	fu := newPooledFuture(ctx, fn)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		fu.fail(ErrPoolClosed)
+		return fu
+	}
+	p.pending++
+	p.mu.Unlock()
+
+	select {
+	case p.jobs <- fu:
+	case <-fu.ctx.Done():
+		fu.run()
+		p.finish(false)
+	}
+	return fu
+}
+
+// Drain blocks until every submitted future has finished. Futures may be
+// submitted while draining; Drain waits for them too.
+func (p *Pool[T]) Drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+}
+
+// Close stops accepting futures, waits for those already submitted and
+// stops the workers.
+func (p *Pool[T]) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	p.Drain()
+	close(p.quit)
+}
+
+// Stats returns the current load of the pool.
+func (p *Pool[T]) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Workers:   p.workers,
+		Queued:    p.pending - p.running,
+		Running:   p.running,
+		Completed: p.completed,
+	}
+}
+
+func (p *Pool[T]) work() {
+	for {
+		select {
+		case fu := <-p.jobs:
+			p.mu.Lock()
+			p.running++
+			p.mu.Unlock()
+
+			fu.run()
+			fu.stop()
+			p.finish(true)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// finish records that a future left the pool, after running on a worker
+// if ran is set.
+func (p *Pool[T]) finish(ran bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ran {
+		p.running--
+	}
+	p.pending--
+	p.completed++
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+}
+
+// pooledFuture is a Future executed by a Pool worker rather than its own
+// goroutine.
+type pooledFuture[T any] struct {
+	*futureImpl[T]
+	stop func() bool
+}
+
+func newPooledFuture[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *pooledFuture[T] {
+	fu := &pooledFuture[T]{
+		futureImpl: New(ctx, func(ctx context.Context) (T, error) {
+			if err := ctx.Err(); err != nil {
+				var zero T
+				return zero, err
+			}
+			return fn(ctx)
+		}).(*futureImpl[T]),
+	}
+	// Resolve futures cancelled while queued right away rather than when
+	// a worker gets to them
+	fu.stop = context.AfterFunc(fu.ctx, fu.run)
+	return fu
+}
+
+// Start does nothing: the future runs once a worker is free.
+func (fu *pooledFuture[T]) Start() Future[T] {
+	return fu
+}
+
+// run executes the future unless it already ran.
+func (fu *pooledFuture[T]) run() {
+	fu.once.Do(fu.execute)
+}
+
+// fail resolves the future with err without running it.
+func (fu *pooledFuture[T]) fail(err error) {
+	fu.once.Do(func() {
+		fu.mu.Lock()
+		fu.err = err
+		fu.mu.Unlock()
+		close(fu.done)
+	})
+	fu.stop()
+}
@@ -0,0 +1,152 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	pool := NewPool[int](3, 100)
+	defer pool.Close()
+
+	var running, peak atomic.Int32
+	futures := make([]Future[int], 50)
+	for i := range futures {
+		futures[i] = pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			running.Add(-1)
+			return i * i, nil
+		})
+	}
+
+	results, err := WaitAll(context.Background(), futures...)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for i, r := range results {
+		if r != i*i {
+			t.Errorf("Expected %d, got %d", i*i, r)
+		}
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("Expected at most 3 concurrent futures, got %d", p)
+	}
+	if stats := pool.Stats(); stats.Completed != 50 || stats.Queued != 0 || stats.Running != 0 || stats.Workers != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestPoolBackpressure(t *testing.T) {
+	pool := NewPool[int](1, 1)
+	defer pool.Close()
+
+	release := make(chan struct{})
+	block := func(ctx context.Context) (int, error) {
+		<-release
+		return 0, nil
+	}
+
+	pool.Submit(context.Background(), block) // running
+	for pool.Stats().Running != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	pool.Submit(context.Background(), block) // queued
+	if stats := pool.Stats(); stats.Queued != 1 {
+		t.Errorf("Expected a queue depth of 1, got %+v", stats)
+	}
+
+	// The queue is full, so Submit blocks until ctx gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	fu := pool.Submit(ctx, block)
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Expected Submit to block while the queue is full")
+	}
+	if _, err := fu.Wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+
+	close(release)
+	pool.Drain()
+	if stats := pool.Stats(); stats.Completed != 3 || stats.Queued != 0 {
+		t.Errorf("Unexpected stats after Drain: %+v", stats)
+	}
+}
+
+func TestPoolCancelQueued(t *testing.T) {
+	pool := NewPool[int](1, 10)
+	defer pool.Close()
+
+	release := make(chan struct{})
+	pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		<-release
+		return 0, nil
+	})
+
+	ran := false
+	queued := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		ran = true
+		return 1, nil
+	})
+	queued.Start() // no effect: it still waits for a worker
+	queued.Cancel()
+
+	if _, err := WaitTimeout(time.Second, queued); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a queued future to resolve when cancelled, got %v", err)
+	}
+	close(release)
+	pool.Drain()
+	if ran {
+		t.Error("Expected the cancelled future not to run")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	pool := NewPool[string](2, 2)
+	done := pool.Submit(context.Background(), func(ctx context.Context) (string, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "done", nil
+	})
+	pool.Close()
+
+	if !done.IsDone() {
+		t.Error("Expected Close to wait for submitted futures")
+	}
+	if _, err := pool.Submit(context.Background(), func(ctx context.Context) (string, error) {
+		return "", nil
+	}).Wait(); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolPanicResolvesWithError(t *testing.T) {
+	pool := NewPool[int](1, 10)
+	defer pool.Close()
+
+	failed := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		panic("task bug")
+	})
+	var panicErr *PanicError
+	if _, err := failed.Wait(); !errors.As(err, &panicErr) {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+
+	// The worker keeps serving tasks
+	next := pool.Submit(context.Background(), func(ctx context.Context) (int, error) {
+		return 42, nil
+	})
+	if v, err := next.Wait(); err != nil || v != 42 {
+		t.Errorf("Expected 42 from the same worker, got %d, %v", v, err)
+	}
+}
@@ -0,0 +1,76 @@
+package future
+
+import "context"
+
+// Promise is a Future completed from the outside, bridging values produced
+// by callbacks, channels or external events into the Future API:
+//
+//	p := future.NewPromise[string]()
+//	client.OnReply(func(reply string, err error) {
+//		if err != nil {
+//			p.Reject(err)
+//			return
+//		}
+//		p.Resolve(reply)
+//	})
+//	reply, err := p.Future().Wait()
+//
+// Only the first Resolve or Reject takes effect. Cancelling the Future
+// rejects the promise with context.Canceled.
+type Promise[T any] struct {
+	fu *futureImpl[T]
+}
+
+// NewPromise returns a pending Promise.
+func NewPromise[T any]() *Promise[T] {
+	p := &Promise[T]{fu: New[T](context.Background(), nil).(*futureImpl[T])}
+	context.AfterFunc(p.fu.ctx, func() {
+		p.Reject(p.fu.ctx.Err())
+	})
+	return p
+}
+
+// Resolve completes the promise with v. It reports whether the promise was
+// still pending.
+func (p *Promise[T]) Resolve(v T) bool {
+	return p.settle(v, nil)
+}
+
+// Reject completes the promise with err. It reports whether the promise was
+// still pending.
+func (p *Promise[T]) Reject(err error) bool {
+	var zero T
+	return p.settle(zero, err)
+}
+
+func (p *Promise[T]) settle(v T, err error) bool {
+	settled := false
+	p.fu.once.Do(func() {
+		p.fu.mu.Lock()
+		p.fu.res, p.fu.err = v, err
+		p.fu.mu.Unlock()
+		close(p.fu.done)
+		settled = true
+	})
+	if settled {
+		// Release the context watched for cancellation
+		p.fu.cancel()
+	}
+	return settled
+}
+
+// Future returns the Future completed by the promise. It is already
+// started; calling Start on it has no effect.
+func (p *Promise[T]) Future() Future[T] {
+	return promiseFuture[T]{p.fu}
+}
+
+// promiseFuture is a Future completed by a Promise rather than by running a
+// function.
+type promiseFuture[T any] struct {
+	*futureImpl[T]
+}
+
+func (fu promiseFuture[T]) Start() Future[T] {
+	return fu
+}
@@ -0,0 +1,65 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromiseResolve(t *testing.T) {
+	p := NewPromise[string]()
+	fu := p.Future().Start()
+	if fu.IsDone() {
+		t.Fatal("Expected a pending future")
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		p.Resolve("reply")
+	}()
+	r, err := fu.Wait()
+	if err != nil || r != "reply" {
+		t.Errorf("Expected the resolved value, got %q, %v", r, err)
+	}
+
+	if p.Resolve("late") || p.Reject(errors.New("late")) {
+		t.Error("Expected a settled promise to ignore further results")
+	}
+	if r, _ := fu.Wait(); r != "reply" {
+		t.Errorf("Expected the first value to stick, got %q", r)
+	}
+}
+
+func TestPromiseReject(t *testing.T) {
+	errFailed := errors.New("failed")
+	p := NewPromise[int]()
+	if !p.Reject(errFailed) {
+		t.Fatal("Expected Reject to settle the promise")
+	}
+	if _, err := p.Future().Wait(); !errors.Is(err, errFailed) {
+		t.Errorf("Expected the rejection, got %v", err)
+	}
+}
+
+func TestPromiseCancel(t *testing.T) {
+	p := NewPromise[int]()
+	p.Future().Cancel()
+
+	if _, err := WaitTimeout(time.Second, p.Future()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelling to reject the promise, got %v", err)
+	}
+	if p.Resolve(1) {
+		t.Error("Expected a cancelled promise to be settled")
+	}
+}
+
+func TestPromiseWithCombinators(t *testing.T) {
+	p := NewPromise[int]()
+	doubled := Map(p.Future(), func(v int) int { return v * 2 })
+	p.Resolve(21)
+
+	if r, err := doubled.Start().Wait(); err != nil || r != 42 {
+		t.Errorf("Expected 42, got %d, %v", r, err)
+	}
+}
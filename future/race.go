@@ -0,0 +1,96 @@
+package future
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoFutures is returned by WaitAny, Race and FirstSuccess when called
+// without any futures.
+var ErrNoFutures = errors.New("future: no futures given")
+
+// WaitAny starts fus and waits until one of them completes, returning its
+// index and result. The other futures keep running, so WaitAny can be
+// called again on the rest. If ctx is done first, it returns -1 and the
+// context's error.
+func WaitAny[T any](ctx context.Context, fus ...Future[T]) (int, T, error) {
+	var zero T
+	if len(fus) == 0 {
+		return -1, zero, ErrNoFutures
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	first := firstDone(fus, stop)
+
+	select {
+	case i := <-first:
+		r, err := fus[i].Wait()
+		return i, r, err
+	case <-ctx.Done():
+		return -1, zero, ctx.Err()
+	}
+}
+
+// Race starts fus and returns the result of the first to complete, whether
+// it succeeded or failed, cancelling the rest. If ctx is done first, all
+// futures are cancelled.
+func Race[T any](ctx context.Context, fus ...Future[T]) (T, error) {
+	defer cancelAll(fus)
+	_, r, err := WaitAny(ctx, fus...)
+	return r, err
+}
+
+// FirstSuccess starts fus and returns the result of the first to succeed,
+// cancelling the rest, e.g. to try several mirrors and take the fastest.
+// If all of them fail, it returns their errors joined.
+func FirstSuccess[T any](ctx context.Context, fus ...Future[T]) (T, error) {
+	defer cancelAll(fus)
+
+	var zero T
+	if len(fus) == 0 {
+		return zero, ErrNoFutures
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	done := firstDone(fus, stop)
+
+	errs := make([]error, len(fus))
+	for range fus {
+		select {
+		case i := <-done:
+			r, err := fus[i].Wait()
+			if err == nil {
+				return r, nil
+			}
+			errs[i] = err
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+	return zero, errors.Join(errs...)
+}
+
+// firstDone starts fus and sends the index of each on the returned channel
+// as it completes, until stop is closed.
+func firstDone[T any](fus []Future[T], stop <-chan struct{}) <-chan int {
+	done := make(chan int, len(fus))
+	for i, fu := range fus {
+		fu.Start()
+		go func() {
+			select {
+			case <-fu.Done():
+				done <- i
+			case <-stop:
+			}
+		}()
+	}
+	return done
+}
+
+func cancelAll[T any](fus []Future[T]) {
+	for _, fu := range fus {
+		fu.Cancel()
+	}
+}
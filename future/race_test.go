@@ -0,0 +1,83 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// after returns a future resolving to v, or failing with err, after d
+// unless cancelled.
+func after[T any](d time.Duration, v T, err error) Future[T] {
+	return New(context.Background(), func(ctx context.Context) (T, error) {
+		select {
+		case <-time.After(d):
+			return v, err
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	})
+}
+
+func TestWaitAny(t *testing.T) {
+	ctx := context.Background()
+	slow := after(time.Second, "slow", nil)
+	fast := after(10*time.Millisecond, "fast", nil)
+
+	i, r, err := WaitAny(ctx, slow, fast)
+	if i != 1 || r != "fast" || err != nil {
+		t.Errorf("Expected the fast future, got %d, %q, %v", i, r, err)
+	}
+	if slow.IsDone() {
+		t.Error("Expected WaitAny to leave the other futures running")
+	}
+	slow.Cancel()
+
+	if _, _, err := WaitAny[int](ctx); !errors.Is(err, ErrNoFutures) {
+		t.Errorf("Expected ErrNoFutures, got %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if i, _, err := WaitAny(timeout, after(time.Second, 0, nil)); i != -1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %d, %v", i, err)
+	}
+}
+
+func TestRace(t *testing.T) {
+	errFast := errors.New("fast failure")
+	slow := after(time.Second, 1, nil)
+	fast := after(10*time.Millisecond, 0, errFast)
+
+	if _, err := Race(context.Background(), slow, fast); !errors.Is(err, errFast) {
+		t.Errorf("Expected the first result even if it failed, got %v", err)
+	}
+	if _, err := WaitTimeout(time.Second, slow); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the slow future to be cancelled, got %v", err)
+	}
+}
+
+func TestFirstSuccess(t *testing.T) {
+	errMirror := errors.New("mirror down")
+	down := after(5*time.Millisecond, "", errMirror)
+	fast := after(20*time.Millisecond, "mirror-b", nil)
+	slow := after(time.Second, "mirror-c", nil)
+
+	r, err := FirstSuccess(context.Background(), down, fast, slow)
+	if err != nil || r != "mirror-b" {
+		t.Errorf("Expected the fastest successful mirror, got %q, %v", r, err)
+	}
+	if _, err := WaitTimeout(time.Second, slow); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the slow future to be cancelled, got %v", err)
+	}
+
+	errOther := errors.New("other failure")
+	_, err = FirstSuccess(context.Background(),
+		after(5*time.Millisecond, "", errMirror),
+		after(10*time.Millisecond, "", errOther))
+	if !errors.Is(err, errMirror) || !errors.Is(err, errOther) {
+		t.Errorf("Expected all errors joined, got %v", err)
+	}
+}
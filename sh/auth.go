@@ -0,0 +1,82 @@
+package sh
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrUnauthenticated is returned by the Authenticate functions for callers
+// that did not prove who they are.
+var ErrUnauthenticated = errors.New("sh: unauthenticated")
+
+// Identity is the authenticated caller on whose behalf commands run, for
+// servers running commands for others. Attach it to the context commands
+// are built with using WithIdentity; Policy.Authorize receives it.
+type Identity struct {
+	// Name identifies the caller, e.g. the common name of its client
+	// certificate or the subject a token was issued to.
+	Name string
+	// Groups are the roles of the caller, for role-based rules.
+	Groups []string
+	// Method is how the caller authenticated, e.g. "mtls" or "token".
+	Method string
+}
+
+// InGroup reports whether the caller has the given role.
+func (id Identity) InGroup(group string) bool {
+	return slices.Contains(id.Groups, group)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller id. Commands built
+// with it are authorized for id by Policy.Authorize.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the caller attached with WithIdentity.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// AuthenticateTLS returns the identity proven by the verified client
+// certificate of a mutual TLS connection: its common name as Name and its
+// organizational units as Groups. The server must require and verify
+// client certificates, e.g. with tls.RequireAndVerifyClientCert.
+func AuthenticateTLS(state *tls.ConnectionState) (Identity, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrUnauthenticated
+	}
+	cert := state.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	return Identity{
+		Name:   cert.Subject.CommonName,
+		Groups: cert.Subject.OrganizationalUnit,
+		Method: "mtls",
+	}, nil
+}
+
+// AuthenticateToken returns the identity of the bearer token in an
+// Authorization header value such as "Bearer abc123", looked up in tokens.
+// Tokens are compared in constant time.
+func AuthenticateToken(tokens map[string]Identity, authorization string) (Identity, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	for known, id := range tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			id.Method = "token"
+			return id, nil
+		}
+	}
+	return Identity{}, ErrUnauthenticated
+}
@@ -0,0 +1,68 @@
+package sh_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sh.SetPolicy(&sh.Policy{
+		Authorize: func(caller sh.Identity, argv []string) error {
+			if argv[0] == "true" || caller.InGroup("admin") {
+				return nil
+			}
+			return errors.New("only admins may run " + argv[0])
+		},
+	})
+	defer sh.SetPolicy(nil)
+
+	admin := sh.WithIdentity(ctx, sh.Identity{Name: "alice", Groups: []string{"admin"}})
+	user := sh.WithIdentity(ctx, sh.Identity{Name: "bob"})
+
+	if _, err := sh.New("echo").Build(admin).Run(); err != nil {
+		t.Errorf("Expected admins to run echo, got %v", err)
+	}
+	if _, err := sh.New("true").Build(user).Run(); err != nil {
+		t.Errorf("Expected anyone to run true, got %v", err)
+	}
+	_, err := sh.New("echo").Build(user).Run()
+	var policyErr *sh.PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Rule != "Authorize" || policyErr.Reason != "bob: only admins may run echo" {
+		t.Errorf("Expected bob to be refused, got %v", err)
+	}
+	if _, err := sh.New("echo").Build(ctx).Run(); !errors.Is(err, sh.ErrPolicy) {
+		t.Errorf("Expected an anonymous caller to be refused, got %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokens := map[string]sh.Identity{"s3cr3t": {Name: "ci", Groups: []string{"deploy"}}}
+	id, err := sh.AuthenticateToken(tokens, "Bearer s3cr3t")
+	if err != nil || id.Name != "ci" || id.Method != "token" {
+		t.Errorf("Expected the ci identity, got %+v, %v", id, err)
+	}
+	for _, header := range []string{"", "Bearer ", "Bearer wrong", "Basic s3cr3t"} {
+		if _, err := sh.AuthenticateToken(tokens, header); !errors.Is(err, sh.ErrUnauthenticated) {
+			t.Errorf("Expected %q to be rejected, got %v", header, err)
+		}
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "worker-1", OrganizationalUnit: []string{"ops"}}}
+	id, err = sh.AuthenticateTLS(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	if err != nil || id.Name != "worker-1" || !id.InGroup("ops") || id.Method != "mtls" {
+		t.Errorf("Expected the certificate's identity, got %+v, %v", id, err)
+	}
+	if _, err := sh.AuthenticateTLS(&tls.ConnectionState{}); !errors.Is(err, sh.ErrUnauthenticated) {
+		t.Errorf("Expected an unverified connection to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package backoff provides strategies for spacing out retries, restarts and
+// other repeated attempts.
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Strategy computes the delay before an attempt. Strategies are stateless
+// and may be shared between goroutines; the caller passes in the attempt
+// number, starting at 1 for the first retry, and the previous delay, which
+// is zero before the first retry.
+type Strategy interface {
+	Delay(attempt int, prev time.Duration) time.Duration
+}
+
+// Func adapts a function to the Strategy interface.
+type Func func(attempt int, prev time.Duration) time.Duration
+
+// Delay calls f.
+func (f Func) Delay(attempt int, prev time.Duration) time.Duration {
+	return f(attempt, prev)
+}
+
+// Constant waits d before every attempt.
+func Constant(d time.Duration) Strategy {
+	return Func(func(int, time.Duration) time.Duration {
+		return d
+	})
+}
+
+// Exponential waits Base, then Base*Multiplier, Base*Multiplier², ... up to
+// Max. A zero Multiplier means 2 and a zero Max means no limit.
+type Exponential struct {
+	Base       time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Delay implements Strategy.
+func (e Exponential) Delay(attempt int, _ time.Duration) time.Duration {
+	m := e.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	return capped(float64(e.Base)*math.Pow(m, float64(max(attempt-1, 0))), e.Max)
+}
+
+// Fibonacci waits Base, Base, 2*Base, 3*Base, 5*Base, ... up to Max. A zero
+// Max means no limit.
+type Fibonacci struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay implements Strategy.
+func (f Fibonacci) Delay(attempt int, _ time.Duration) time.Duration {
+	a, b := 1.0, 1.0
+	for i := 1; i < attempt; i++ {
+		a, b = b, a+b
+		if f.Max > 0 && float64(f.Base)*a >= float64(f.Max) {
+			return f.Max
+		}
+	}
+	return capped(float64(f.Base)*a, f.Max)
+}
+
+// DecorrelatedJitter waits a random duration between Base and three times
+// the previous delay, capped at Max, as described in "Exponential Backoff
+// And Jitter" (AWS Architecture Blog). It spreads out clients retrying at
+// the same time better than jitter added to a fixed schedule. Rand returns
+// a number in [0, 1); nil uses math/rand/v2.
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Max  time.Duration
+	Rand func() float64
+}
+
+// Delay implements Strategy.
+func (d DecorrelatedJitter) Delay(_ int, prev time.Duration) time.Duration {
+	upper := float64(max(prev, d.Base)) * 3
+	lower := float64(d.Base)
+	return capped(lower+random(d.Rand)*(upper-lower), d.Max)
+}
+
+// Jitter randomizes the delays of Strategy by up to Fraction of their
+// length in either direction; a Fraction of 1 gives delays anywhere between
+// zero and twice the original. Rand returns a number in [0, 1); nil uses
+// math/rand/v2.
+type Jitter struct {
+	Strategy Strategy
+	Fraction float64
+	Rand     func() float64
+}
+
+// Delay implements Strategy.
+func (j Jitter) Delay(attempt int, prev time.Duration) time.Duration {
+	d := float64(j.Strategy.Delay(attempt, prev))
+	return time.Duration(max(d+d*j.Fraction*(2*random(j.Rand)-1), 0))
+}
+
+// Backoff steps through the delays of a Strategy.
+type Backoff struct {
+	Strategy Strategy
+	attempt  int
+	prev     time.Duration
+}
+
+// New returns a Backoff stepping through s.
+func New(s Strategy) *Backoff {
+	return &Backoff{Strategy: s}
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	b.attempt++
+	b.prev = b.Strategy.Delay(b.attempt, b.prev)
+	return b.prev
+}
+
+// Attempt returns how many delays Next has returned since the last Reset.
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+// Reset starts over from the first delay, e.g. after a success.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+	b.prev = 0
+}
+
+// Retry calls fn until it succeeds, ctx is done or fn has been called
+// attempts times, waiting between calls as s dictates. A non-positive
+// attempts retries until ctx is done. It returns the last error of fn, or
+// the context's error if ctx was done first.
+func Retry(ctx context.Context, s Strategy, attempts int, fn func(ctx context.Context) error) error {
+	b := New(s)
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempts > 0 && b.Attempt()+1 >= attempts {
+			return err
+		}
+		if sleepErr := Sleep(ctx, b.Next()); sleepErr != nil {
+			return sleepErr
+		}
+	}
+}
+
+// Sleep waits for d or until ctx is done, returning the context's error in
+// the latter case.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func capped(d float64, limit time.Duration) time.Duration {
+	if limit > 0 && d > float64(limit) {
+		return limit
+	}
+	if d > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+func random(r func() float64) float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	return r()
+}
@@ -0,0 +1,118 @@
+package backoff_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+func delays(s backoff.Strategy, n int) []time.Duration {
+	b := backoff.New(s)
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = b.Next()
+	}
+	return out
+}
+
+func equal(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStrategies(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		strategy backoff.Strategy
+		want     []time.Duration
+	}{
+		{"constant", backoff.Constant(5 * ms), []time.Duration{5 * ms, 5 * ms, 5 * ms}},
+		{"exponential", backoff.Exponential{Base: ms, Max: 10 * ms}, []time.Duration{ms, 2 * ms, 4 * ms, 8 * ms, 10 * ms}},
+		{"exponential multiplier", backoff.Exponential{Base: ms, Multiplier: 3}, []time.Duration{ms, 3 * ms, 9 * ms}},
+		{"fibonacci", backoff.Fibonacci{Base: ms, Max: 6 * ms}, []time.Duration{ms, ms, 2 * ms, 3 * ms, 5 * ms, 6 * ms}},
+		{"custom", backoff.Func(func(attempt int, _ time.Duration) time.Duration {
+			return time.Duration(attempt) * ms
+		}), []time.Duration{ms, 2 * ms, 3 * ms}},
+	}
+
+	for _, tt := range tests {
+		if got := delays(tt.strategy, len(tt.want)); !equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJitterStrategies(t *testing.T) {
+	ms := time.Millisecond
+	top := func() float64 { return 0.999999 }
+	bottom := func() float64 { return 0 }
+
+	decorrelated := backoff.DecorrelatedJitter{Base: 10 * ms, Max: time.Second, Rand: top}
+	for i, d := range delays(decorrelated, 4) {
+		want := []time.Duration{30 * ms, 90 * ms, 270 * ms, 810 * ms}[i]
+		if d < want-ms || d > want {
+			t.Errorf("Decorrelated delay %d: got %v, want about %v", i, d, want)
+		}
+	}
+	decorrelated.Rand = bottom
+	if d := decorrelated.Delay(5, time.Second); d != 10*ms {
+		t.Errorf("Expected the lowest decorrelated delay to be the base, got %v", d)
+	}
+
+	jitter := backoff.Jitter{Strategy: backoff.Constant(100 * ms), Fraction: 0.5, Rand: bottom}
+	if d := jitter.Delay(1, 0); d != 50*ms {
+		t.Errorf("Expected 50ms, got %v", d)
+	}
+	jitter.Rand = nil
+	for range 100 {
+		if d := jitter.Delay(1, 0); d < 50*ms || d > 150*ms {
+			t.Fatalf("Jittered delay %v outside [50ms, 150ms]", d)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	errFlaky := errors.New("flaky")
+
+	calls := 0
+	err := backoff.Retry(ctx, backoff.Constant(time.Millisecond), 5, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third call, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = backoff.Retry(ctx, backoff.Constant(time.Millisecond), 2, func(context.Context) error {
+		calls++
+		return errFlaky
+	})
+	if !errors.Is(err, errFlaky) || calls != 2 {
+		t.Errorf("Expected the last error after 2 calls, got %v after %d calls", err, calls)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = backoff.Retry(cctx, backoff.Constant(time.Hour), 0, func(context.Context) error {
+		return errFlaky
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package sh
+
+import (
+	"io"
+	"os/exec"
+)
+
+// Backpressure is what happens to output lines when a consumer falls
+// behind and its buffer is full.
+type Backpressure int
+
+const (
+	// BackpressureBlock waits for the consumer. Once the command's pipe
+	// fills up as well, the command itself blocks writing output, so a slow
+	// consumer slows it down instead of lines getting lost.
+	BackpressureBlock Backpressure = iota
+	// BackpressureDropOldest discards the oldest buffered line to make room,
+	// so the consumer sees the latest output.
+	BackpressureDropOldest
+	// BackpressureDropNewest discards the new line, so the consumer sees
+	// the output up to the point it fell behind.
+	BackpressureDropNewest
+)
+
+func (b Backpressure) String() string {
+	switch b {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop-oldest"
+	case BackpressureDropNewest:
+		return "drop-newest"
+	}
+	return "unknown"
+}
+
+// DefaultLineBuffer is how many lines may wait for a line consumer whose
+// buffer size is not positive.
+const DefaultLineBuffer = 1024
+
+func (cm *cmdImpl) WithBackpressure(policy Backpressure, buffer int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if buffer <= 0 {
+		buffer = DefaultLineBuffer
+	}
+	cm.backpressure = policy
+	cm.lineBuffer = buffer
+	return cm
+}
+
+func (cm *cmdImpl) WithStdoutSink(w io.Writer, policy Backpressure, buffer int) Cmd {
+	return cm.withSink(w, policy, buffer, true)
+}
+
+func (cm *cmdImpl) WithStderrSink(w io.Writer, policy Backpressure, buffer int) Cmd {
+	return cm.withSink(w, policy, buffer, false)
+}
+
+// withSink hands the lines of stdout or stderr to a goroutine writing them
+// to w, so that only the policy decides how a slow w affects the command.
+func (cm *cmdImpl) withSink(w io.Writer, policy Backpressure, buffer int, toStdout bool) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if buffer <= 0 {
+		buffer = DefaultLineBuffer
+	}
+	var lw *lineWriter
+	var done chan error
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			lw = newLineWriter(policy, buffer)
+			if toStdout {
+				cmd.Stdout = appendWriter(cmd.Stdout, lw)
+			} else {
+				cmd.Stderr = appendWriter(cmd.Stderr, lw)
+			}
+
+			done = make(chan error, 1)
+			goSafe(func() {
+				var err error
+				for line := range lw.lines {
+					if err == nil {
+						err = protect(func() error {
+							_, err := io.WriteString(w, line+"\n")
+							return err
+						})
+					}
+				}
+				done <- err
+			})
+			return nil
+		},
+		after: func(error) error {
+			// Output has ended; wait until w has received all of it
+			lw.close()
+			return <-done
+		},
+	})
+	return cm
+}
+
+// deliver sends v on ch as policy demands when ch is full. Blocking gives
+// up once stop is closed.
+func deliver[T any](ch chan T, v T, policy Backpressure, stop <-chan struct{}) {
+	switch policy {
+	case BackpressureDropNewest:
+		select {
+		case ch <- v:
+		default:
+		}
+	case BackpressureDropOldest:
+		for {
+			select {
+			case ch <- v:
+				return
+			default:
+			}
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	default:
+		select {
+		case ch <- v:
+		case <-stop:
+		}
+	}
+}
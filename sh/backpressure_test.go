@@ -0,0 +1,137 @@
+package sh_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// slowWriter records lines, taking a while for each write.
+type slowWriter struct {
+	mu    sync.Mutex
+	lines []string
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = append(w.lines, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func numbers(from, to int) []string {
+	var lines []string
+	for i := from; i <= to; i++ {
+		lines = append(lines, strconv.Itoa(i))
+	}
+	return lines
+}
+
+func TestCmdWithStdoutSinkBlock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sink := &slowWriter{delay: time.Millisecond}
+	result, err := sh.New("seq").Arg("100").Build(ctx).
+		WithStdoutSink(sink, sh.BackpressureBlock, 1).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	// Nothing is lost, and the sink has everything once Run returns
+	if !slices.Equal(sink.lines, numbers(1, 100)) {
+		t.Errorf("Expected all lines in order, got %q", sink.lines)
+	}
+	if got := strings.Count(string(result.Stdout()), "\n"); got != 100 {
+		t.Errorf("Expected 100 captured lines, got %d", got)
+	}
+}
+
+func TestCmdWithStderrSinkDrop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, tt := range []struct {
+		policy sh.Backpressure
+		check  func([]string) bool
+	}{
+		// The sink holds on to the first line while the buffer fills
+		{sh.BackpressureDropNewest, func(lines []string) bool {
+			return slices.Equal(lines, numbers(1, len(lines)))
+		}},
+		{sh.BackpressureDropOldest, func(lines []string) bool {
+			return slices.Equal(lines[len(lines)-4:], numbers(97, 100))
+		}},
+	} {
+		sink := &slowWriter{delay: 100 * time.Millisecond}
+		result, err := sh.New("sh").OptV("-c", "seq 100 >&2").Build(ctx).
+			WithStderrSink(sink, tt.policy, 4).
+			Run()
+		if err != nil {
+			t.Fatalf("%v: Run() failed: %v", tt.policy, err)
+		}
+		if n := len(sink.lines); n < 4 || n > 6 || !tt.check(sink.lines) {
+			t.Errorf("%v: unexpected lines %q", tt.policy, sink.lines)
+		}
+		// Only the sink loses lines
+		if got := strings.Count(string(result.Stderr()), "\n"); got != 100 {
+			t.Errorf("%v: expected 100 captured lines, got %d", tt.policy, got)
+		}
+	}
+}
+
+func TestCmdLinesWithBackpressure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for policy, want := range map[sh.Backpressure][]string{
+		sh.BackpressureDropNewest: numbers(1, 5),
+		sh.BackpressureDropOldest: numbers(96, 100),
+	} {
+		cmd := sh.New("seq").Arg("100").Build(ctx).WithBackpressure(policy, 5)
+		lines := cmd.Lines()
+		// The command finishes without anyone reading
+		if _, err := cmd.Wait(); err != nil {
+			t.Fatalf("%v: Wait() failed: %v", policy, err)
+		}
+		if got := slices.Collect(lines); !slices.Equal(got, want) {
+			t.Errorf("%v: expected %q, got %q", policy, want, got)
+		}
+	}
+}
+
+func TestCmdEventsWithBackpressure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("100").Build(ctx).WithBackpressure(sh.BackpressureDropOldest, 3)
+	events := cmd.Events()
+	if _, err := cmd.Run(); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	var kinds []sh.EventKind
+	var lines []string
+	for e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.Kind == sh.EventStdout {
+			lines = append(lines, e.Line)
+		}
+	}
+	if kinds[0] != sh.EventStarted || kinds[len(kinds)-1] != sh.EventExited {
+		t.Errorf("Expected started and exited events to be kept, got %v", kinds)
+	}
+	// The pump may already hold the first line it took from the queue
+	if len(lines) < 3 || len(lines) > 4 || !slices.Equal(lines[len(lines)-3:], numbers(98, 100)) {
+		t.Errorf("Expected the last lines, got %q", lines)
+	}
+}
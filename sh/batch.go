@@ -0,0 +1,146 @@
+package sh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Batch runs a sequence of commands in order and records each completed
+// item in a checkpoint file. When a run is interrupted or an item fails,
+// running the same batch again skips the items already completed and resumes
+// from the first incomplete one.
+//
+// Items are identified by idempotency keys, so reordering or inserting items
+// between runs does not cause completed work to be repeated.
+type Batch struct {
+	checkpoint string
+	items      []batchItem
+	history    *StateStore
+	onProgress func(BatchProgress)
+}
+
+type batchItem struct {
+	key   string
+	build func(ctx context.Context) Cmd
+}
+
+// BatchResult is the outcome of a single batch item.
+type BatchResult struct {
+	Key string
+	// Result is nil when the item was skipped or failed to start.
+	Result Result
+	// Skipped is true when the item had completed in a previous run.
+	Skipped bool
+	Err     error
+}
+
+// NewBatch creates a batch that checkpoints progress to the file at path.
+func NewBatch(checkpoint string) *Batch {
+	return &Batch{checkpoint: checkpoint}
+}
+
+// Add appends an item identified by key. build is called only when the item
+// runs, so skipped items are never constructed. A panic in build fails the
+// item with a *PanicError.
+func (b *Batch) Add(key string, build func(ctx context.Context) Cmd) *Batch {
+	b.items = append(b.items, batchItem{key: key, build: build})
+	return b
+}
+
+// Run executes the items not yet recorded in the checkpoint, stopping at the
+// first failure. Each successful item is appended to the checkpoint before
+// the next one starts.
+func (b *Batch) Run(ctx context.Context) ([]BatchResult, error) {
+	seen := make(map[string]bool, len(b.items))
+	for _, item := range b.items {
+		if item.key == "" || strings.ContainsAny(item.key, "\r\n") {
+			return nil, fmt.Errorf("sh: invalid batch key %q", item.key)
+		}
+		if seen[item.key] {
+			return nil, fmt.Errorf("sh: duplicate batch key %q", item.key)
+		}
+		seen[item.key] = true
+	}
+
+	done, err := b.Completed()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(b.checkpoint, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	eta := b.newETA(done)
+	results := make([]BatchResult, 0, len(b.items))
+	for _, item := range b.items {
+		if done[item.key] {
+			results = append(results, BatchResult{Key: item.key, Skipped: true})
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		b.progress(eta.start(item.key))
+		var res Result
+		cmd, err := buildSafely(ctx, item.build)
+		if err == nil {
+			if b.history != nil {
+				cmd.WithStateStore(b.history, item.key)
+			}
+			res, err = cmd.Run()
+		}
+		results = append(results, BatchResult{Key: item.key, Result: res, Err: err})
+		b.progress(eta.finish(item.key))
+		if err != nil {
+			return results, fmt.Errorf("sh: batch item %q: %w", item.key, err)
+		}
+
+		if _, err := fmt.Fprintln(f, item.key); err != nil {
+			return results, err
+		}
+		if err := f.Sync(); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// Completed returns the keys recorded in the checkpoint.
+func (b *Batch) Completed() (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	f, err := os.Open(b.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			done[key] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// Reset removes the checkpoint so the next Run starts from the beginning.
+func (b *Batch) Reset() error {
+	err := os.Remove(b.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
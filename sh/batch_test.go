@@ -0,0 +1,94 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBatchResumesFromCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	checkpoint := filepath.Join(t.TempDir(), "batch.checkpoint")
+	runs := make(map[string]int)
+	fail := true
+
+	item := func(key string) func(ctx context.Context) sh.Cmd {
+		return func(ctx context.Context) sh.Cmd {
+			runs[key]++
+			if key == "second" && fail {
+				return sh.New("false").Build(ctx)
+			}
+			return sh.New("true").Build(ctx)
+		}
+	}
+
+	batch := sh.NewBatch(checkpoint).
+		Add("first", item("first")).
+		Add("second", item("second")).
+		Add("third", item("third"))
+
+	results, err := batch.Run(ctx)
+	if err == nil {
+		t.Fatal("Expected the failing item to stop the batch")
+	}
+	if len(results) != 2 || results[1].Err == nil {
+		t.Fatalf("Expected the batch to stop at the second item, got %+v", results)
+	}
+
+	fail = false
+	results, err = batch.Run(ctx)
+	if err != nil {
+		t.Fatalf("Resumed batch failed: %v", err)
+	}
+	if !results[0].Skipped || results[1].Skipped || results[2].Skipped {
+		t.Errorf("Expected only the first item to be skipped, got %+v", results)
+	}
+	if runs["first"] != 1 || runs["second"] != 2 || runs["third"] != 1 {
+		t.Errorf("Unexpected run counts: %v", runs)
+	}
+
+	if err := batch.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if done, _ := batch.Completed(); len(done) != 0 {
+		t.Errorf("Expected empty checkpoint after reset, got %v", done)
+	}
+}
+
+func TestBatchDuplicateKey(t *testing.T) {
+	build := func(ctx context.Context) sh.Cmd { return sh.New("true").Build(ctx) }
+	batch := sh.NewBatch(filepath.Join(t.TempDir(), "batch")).
+		Add("a", build).
+		Add("a", build)
+
+	if _, err := batch.Run(context.Background()); err == nil {
+		t.Error("Expected error for duplicate keys")
+	}
+}
+
+func TestBatchBuildPanic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	batch := sh.NewBatch(filepath.Join(t.TempDir(), "batch")).
+		Add("ok", func(ctx context.Context) sh.Cmd { return sh.New("true").Build(ctx) }).
+		Add("broken", func(context.Context) sh.Cmd { panic("no such host") })
+
+	results, err := batch.Run(ctx)
+	var panicErr *sh.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Expected the panic as the batch error, got %v", err)
+	}
+	if len(results) != 2 || results[1].Key != "broken" || !errors.As(results[1].Err, &panicErr) {
+		t.Errorf("Expected the panic reported for the broken item, got %+v", results)
+	}
+	if done, _ := batch.Completed(); !done["ok"] || done["broken"] {
+		t.Errorf("Expected only the first item checkpointed, got %v", done)
+	}
+}
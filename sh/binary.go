@@ -0,0 +1,49 @@
+package sh
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Arg0 sets the argv[0] the commands built by b see, which ps shows as the
+// process title, instead of the executable. Multi-call binaries such as
+// busybox choose the tool to run by it:
+//
+//	sh.New("/bin/busybox").Arg0("ls").Arg("-l").Build(ctx)
+func (b *Builder) Arg0(name string) *Builder {
+	b.arg0 = name
+	return b
+}
+
+// BinaryFrom makes Build resolve the executable of the commands built by b
+// with resolve, which is given the executable set with New and returns the
+// one to run, e.g. to pick a binary by platform or from a download cache.
+// An error from resolve fails the command with a StartError when it runs.
+func (b *Builder) BinaryFrom(resolve func(cmd string) (string, error)) *Builder {
+	b.resolve = resolve
+	return b
+}
+
+// EnvBinary returns a resolver for BinaryFrom running the executable named
+// by the environment variable key when it is set, like $EDITOR or $GIT,
+// and the one set with New otherwise:
+//
+//	sh.New("terraform").BinaryFrom(sh.EnvBinary("TERRAFORM_BIN"))
+func EnvBinary(key string) func(cmd string) (string, error) {
+	return func(cmd string) (string, error) {
+		if bin := os.Getenv(key); bin != "" {
+			return bin, nil
+		}
+		return cmd, nil
+	}
+}
+
+// arg0Hook replaces argv[0] of the command with name.
+func arg0Hook(name string) execHook {
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			cmd.Args[0] = name
+			return nil
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBuilderArg0(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := sh.New("sh").Arg0("my-worker").OptV("-c", `echo "$0"`)
+	result, err := b.Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := result.TrimmedString(); got != "my-worker" {
+		t.Errorf("Expected argv[0] my-worker, got %q", got)
+	}
+
+	if args := b.Build(ctx).ToExecCmd().Args; args[0] != "my-worker" {
+		t.Errorf("Expected ToExecCmd to keep argv[0], got %q", args)
+	}
+}
+
+func TestBuilderBinaryFromEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := sh.New("false").BinaryFrom(sh.EnvBinary("SH_TEST_BINARY"))
+	if _, err := b.Build(ctx).Run(); err == nil {
+		t.Error("Expected the executable set with New without the variable")
+	}
+
+	t.Setenv("SH_TEST_BINARY", "true")
+	if _, err := b.Build(ctx).Run(); err != nil {
+		t.Errorf("Expected the executable from the environment, got %v", err)
+	}
+}
+
+func TestBuilderBinaryFromError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errUnsupported := errors.New("no build for this platform")
+	_, err := sh.New("tool").BinaryFrom(func(string) (string, error) {
+		return "", errUnsupported
+	}).Build(ctx).Run()
+
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) || startErr.Stage != sh.StageLookPath {
+		t.Fatalf("Expected a StartError at StageLookPath, got %v", err)
+	}
+	if !errors.Is(err, errUnsupported) {
+		t.Errorf("Expected the resolver's error, got %v", err)
+	}
+}
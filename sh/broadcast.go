@@ -0,0 +1,172 @@
+package sh
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// DefaultBroadcastBuffer is how many bytes a Broadcast consumer may lag
+// behind the fastest one by default.
+const DefaultBroadcastBuffer = 1 << 20
+
+// Broadcaster copies one input stream to the stdin of several commands.
+type Broadcaster struct {
+	consumers []*broadcastConsumer
+	done      chan struct{}
+	err       error
+}
+
+// broadcastConsumer queues data for a single command's stdin.
+type broadcastConsumer struct {
+	w      *os.File
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	queued int
+	eof    bool
+	failed bool
+}
+
+// Broadcast sends everything read from r to the stdin of each of cmds,
+// which must be unstarted. Each command may lag up to limit bytes (or
+// DefaultBroadcastBuffer for a non-positive limit) behind the others before
+// reading r pauses until it catches up. Commands that exit without reading
+// all input are dropped without affecting the rest.
+func Broadcast(r io.Reader, limit int, cmds ...Cmd) *Broadcaster {
+	if limit <= 0 {
+		limit = DefaultBroadcastBuffer
+	}
+	b := &Broadcaster{done: make(chan struct{})}
+
+	for _, cmd := range cmds {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			b.err = err
+			continue
+		}
+		c := &broadcastConsumer{w: pw}
+		c.cond = sync.NewCond(&c.mu)
+		b.consumers = append(b.consumers, c)
+
+		cmd.WithStdin(pr)
+		if cm, ok := cmd.(*cmdImpl); ok {
+			cm.mu.Lock()
+			cm.hooks = append(cm.hooks, execHook{
+				// The child holds its own copy of the read end; dropping
+				// ours makes writes fail once the child exits
+				started: func(*exec.Cmd) error { return pr.Close() },
+			})
+			cm.mu.Unlock()
+		}
+		goSafe(func() {
+			// Commands that never start, e.g. in dry-run mode, never read
+			<-cmd.Done()
+			pr.Close()
+		})
+		goSafe(c.drain)
+	}
+
+	goSafe(func() { b.distribute(safeReader{r}, limit) })
+	return b
+}
+
+// Wait waits until the input has been read completely and delivered to
+// every command still reading, and returns the error reading it, if any.
+func (b *Broadcaster) Wait() error {
+	<-b.done
+	return b.err
+}
+
+func (b *Broadcaster) distribute(r io.Reader, limit int) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			for _, c := range b.consumers {
+				c.push(buf[:n], limit)
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				b.err = errors.Join(b.err, err)
+			}
+			break
+		}
+	}
+
+	for _, c := range b.consumers {
+		c.close()
+	}
+	for _, c := range b.consumers {
+		c.wait()
+	}
+	close(b.done)
+}
+
+// push queues a copy of p, blocking while the consumer is more than limit
+// bytes behind.
+func (c *broadcastConsumer) push(p []byte, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.failed && c.queued > 0 && c.queued+len(p) > limit {
+		c.cond.Wait()
+	}
+	if c.failed {
+		return
+	}
+	c.queue = append(c.queue, append([]byte(nil), p...))
+	c.queued += len(p)
+	c.cond.Broadcast()
+}
+
+func (c *broadcastConsumer) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eof = true
+	c.cond.Broadcast()
+}
+
+// wait blocks until the queue has been written out or writing failed.
+func (c *broadcastConsumer) wait() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.failed && (c.queued > 0 || !c.eof) {
+		c.cond.Wait()
+	}
+}
+
+// drain writes queued data to the command's stdin until the input ends or
+// the command stops reading.
+func (c *broadcastConsumer) drain() {
+	defer c.w.Close()
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.eof {
+			c.cond.Wait()
+		}
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		chunk := c.queue[0]
+		c.mu.Unlock()
+
+		_, err := c.w.Write(chunk)
+
+		c.mu.Lock()
+		c.queue = c.queue[1:]
+		c.queued -= len(chunk)
+		if err != nil {
+			c.failed = true
+			c.queue, c.queued = nil, 0
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
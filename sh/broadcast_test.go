@@ -0,0 +1,46 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBroadcast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := bytes.Repeat([]byte("0123456789\n"), 300_000) // 3.3MB
+	count := sh.New("wc").OptB("-l").Build(ctx)
+	head := sh.New("head").OptV("-n", "1").Build(ctx) // exits early
+	grep := sh.New("grep").OptB("-c").Arg("0123").Build(ctx)
+
+	b := sh.Broadcast(bytes.NewReader(input), 64*1024, count, head, grep)
+	for _, cmd := range []sh.Cmd{count, head, grep} {
+		cmd.Start()
+	}
+
+	for name, tc := range map[string]struct {
+		cmd  sh.Cmd
+		want string
+	}{
+		"wc":   {count, "300000"},
+		"head": {head, "0123456789"},
+		"grep": {grep, "300000"},
+	} {
+		result, err := tc.cmd.Wait()
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if got := strings.TrimSpace(string(result.Stdout())); got != tc.want {
+			t.Errorf("Expected %s to output %s, got %q", name, tc.want, got)
+		}
+	}
+	if err := b.Wait(); err != nil {
+		t.Errorf("Broadcast failed: %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package sh
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBundleDrift is matched by the error of Bundle.Verify when the
+// machine differs from the one the bundle was recorded on.
+var ErrBundleDrift = errors.New("sh: bundle drift")
+
+// Bundle records command runs completely enough to rerun them later or on
+// another machine with Replay: the command lines, environments, working
+// directories, tools and input files. Save one when automation fails to
+// keep a reproduction of the failure. Bundles are built with a
+// BundleRecorder and stored as JSON.
+type Bundle struct {
+	Created time.Time   `json:"created"`
+	Host    string      `json:"host,omitempty"`
+	OS      string      `json:"os"`
+	Arch    string      `json:"arch"`
+	Runs    []BundleRun `json:"runs"`
+}
+
+// BundleRun is a single command run of a Bundle.
+type BundleRun struct {
+	Args []string `json:"args"`
+	// Env is the complete environment of the command. The values of
+	// secrets marked with WithSecret or WithSecretEnv and of variables
+	// named like tokens, secrets, passwords, keys and credentials are
+	// replaced with [REDACTED].
+	Env []string `json:"env"`
+	// Dir is the absolute working directory.
+	Dir string `json:"dir"`
+	// Tool is the path the command's binary was found at, ToolDigest the
+	// SHA-256 of the binary and ToolVersion its version as reported by
+	// Version, if it could be found out.
+	Tool        string `json:"tool,omitempty"`
+	ToolDigest  string `json:"tool_digest,omitempty"`
+	ToolVersion string `json:"tool_version,omitempty"`
+	// Inputs maps the arguments naming existing files to the SHA-256 of
+	// their contents before the command ran. Standard input is not
+	// recorded.
+	Inputs   map[string]string `json:"inputs,omitempty"`
+	Start    time.Time         `json:"start"`
+	Duration time.Duration     `json:"duration"`
+	ExitCode int               `json:"exit_code"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// BundleRecorder records the commands it sees as middleware into a Bundle:
+//
+//	rec := sh.NewBundleRecorder()
+//	defer sh.Use(rec.Middleware())()
+//	if err := deploy(ctx); err != nil {
+//		rec.Bundle(ctx).Save("repro.json")
+//	}
+type BundleRecorder struct {
+	mu   sync.Mutex
+	runs []BundleRun
+}
+
+// NewBundleRecorder returns a recorder that has not recorded anything yet.
+func NewBundleRecorder() *BundleRecorder {
+	return &BundleRecorder{}
+}
+
+// exportingKey marks the context of the commands Bundle runs to find out
+// tool versions, so that they are not recorded themselves.
+type exportingKey struct{}
+
+// Middleware returns middleware recording every execution it wraps.
+func (r *BundleRecorder) Middleware() Middleware {
+	return func(next RunFunc) RunFunc {
+		return func(ctx context.Context, e *Execution) error {
+			if ctx.Value(exportingKey{}) != nil {
+				return next(ctx, e)
+			}
+
+			run := newBundleRun(e)
+			err := next(ctx, e)
+			run.Duration = time.Since(run.Start)
+			run.ExitCode = -1
+			if e.Exec.ProcessState != nil {
+				run.ExitCode = e.Exec.ProcessState.ExitCode()
+			}
+			if err != nil {
+				run.Error = err.Error()
+				if cm, ok := e.Cmd.(*cmdImpl); ok {
+					run.Error = cm.redact(run.Error)
+				}
+			}
+
+			r.mu.Lock()
+			r.runs = append(r.runs, run)
+			r.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// newBundleRun describes e as it is about to run.
+func newBundleRun(e *Execution) BundleRun {
+	run := BundleRun{
+		Args:  e.RedactedArgs(),
+		Dir:   e.Exec.Dir,
+		Start: time.Now(),
+	}
+	if !filepath.IsAbs(run.Dir) {
+		if wd, err := os.Getwd(); err == nil {
+			run.Dir = filepath.Join(wd, run.Dir)
+		}
+	}
+
+	cm, _ := e.Cmd.(*cmdImpl)
+	for _, kv := range e.Exec.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if cm != nil {
+			value = cm.redact(value)
+		}
+		if secretEnvName(name) {
+			value = redacted
+		}
+		run.Env = append(run.Env, name+"="+value)
+	}
+
+	if e.Exec.Path != "" {
+		run.Tool = e.Exec.Path
+		if sum := hashFile(e.Exec.Path); sum != nil {
+			run.ToolDigest = hex.EncodeToString(sum)
+		}
+	}
+	for _, arg := range run.Args[1:] {
+		p := arg
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(run.Dir, p)
+		}
+		if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if sum := hashFile(p); sum != nil {
+			if run.Inputs == nil {
+				run.Inputs = make(map[string]string)
+			}
+			run.Inputs[arg] = hex.EncodeToString(sum)
+		}
+	}
+	return run
+}
+
+// secretEnvName reports whether the variable name looks like it holds a
+// secret, as for logging.
+func secretEnvName(name string) bool {
+	for _, pattern := range defaultRedactEnv {
+		if ok, _ := path.Match(pattern, strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Bundle returns the runs recorded so far as a Bundle, finding out the
+// versions of the tools used with Version. Tools whose version cannot be
+// found out are recorded by digest only.
+func (r *BundleRecorder) Bundle(ctx context.Context) Bundle {
+	r.mu.Lock()
+	runs := make([]BundleRun, len(r.runs))
+	copy(runs, r.runs)
+	r.mu.Unlock()
+
+	ctx = context.WithValue(ctx, exportingKey{}, true)
+	versions := make(map[string]string)
+	for i := range runs {
+		tool := runs[i].Tool
+		if tool == "" {
+			continue
+		}
+		v, ok := versions[tool]
+		if !ok {
+			if tv, err := Version(ctx, runs[i].Args[0]); err == nil {
+				v = tv.String()
+			}
+			versions[tool] = v
+		}
+		runs[i].ToolVersion = v
+	}
+
+	host, _ := os.Hostname()
+	return Bundle{
+		Created: time.Now().UTC(),
+		Host:    host,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Runs:    runs,
+	}
+}
+
+// Save writes the bundle to the named file as indented JSON.
+func (b Bundle) Save(name string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o600)
+}
+
+// LoadBundle reads the bundle written to the named file by Bundle.Save.
+func LoadBundle(name string) (Bundle, error) {
+	var b Bundle
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("sh: bundle %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// Verify reports how this machine differs from the one the bundle was
+// recorded on, in ways that may keep Replay from reproducing the runs:
+// another OS or architecture, tools that are missing or differ from the
+// recorded binaries, and input files that are missing or changed. The
+// error joins one error per difference, each matching ErrBundleDrift.
+func (b Bundle) Verify() error {
+	var errs []error
+	drift := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrBundleDrift}, args...)...))
+	}
+	if b.OS != runtime.GOOS || b.Arch != runtime.GOARCH {
+		drift("recorded on %s/%s, running on %s/%s", b.OS, b.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+
+	seen := make(map[string]bool)
+	for _, run := range b.Runs {
+		if run.ToolDigest != "" && !seen[run.Tool] {
+			seen[run.Tool] = true
+			sum := hashFile(run.Tool)
+			switch {
+			case sum == nil:
+				drift("tool %s is missing", run.Tool)
+			case hex.EncodeToString(sum) != run.ToolDigest:
+				drift("tool %s differs from the recorded binary", run.Tool)
+			}
+		}
+		for arg, digest := range run.Inputs {
+			p := arg
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(run.Dir, p)
+			}
+			sum := hashFile(p)
+			switch {
+			case sum == nil:
+				drift("input %s of %s is missing", p, run.Args[0])
+			case hex.EncodeToString(sum) != digest:
+				drift("input %s of %s has changed", p, run.Args[0])
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Replay reruns the runs of the bundle in order, with the recorded command
+// lines, environments and working directories, using the runner from ctx.
+// Every run is rerun even if one fails, since failures are usually what
+// the bundle was saved for; the error joins the errors of the failed runs.
+//
+// Redacted values cannot be replayed: variables recorded as [REDACTED]
+// take their value from the current environment, and Replay fails without
+// running anything if one is not set there. Redacted arguments fail the
+// same way. Call Verify first to find out whether the machine matches.
+func Replay(ctx context.Context, b Bundle) ([]Result, error) {
+	envs := make([]map[string]string, len(b.Runs))
+	for i, run := range b.Runs {
+		if len(run.Args) == 0 {
+			return nil, fmt.Errorf("sh: replay: run %d has no command line", i+1)
+		}
+		for _, arg := range run.Args {
+			if strings.Contains(arg, redacted) {
+				return nil, fmt.Errorf("sh: replay: %s: an argument was redacted", run.Args[0])
+			}
+		}
+		envs[i] = make(map[string]string, len(run.Env))
+		for _, kv := range run.Env {
+			name, value, _ := strings.Cut(kv, "=")
+			if strings.Contains(value, redacted) {
+				var ok bool
+				if value, ok = os.LookupEnv(name); !ok {
+					return nil, fmt.Errorf("sh: replay: %s: %s was redacted; set it in the environment to replay", run.Args[0], name)
+				}
+			}
+			envs[i][name] = value
+		}
+	}
+
+	results := make([]Result, 0, len(b.Runs))
+	var errs []error
+	for i, run := range b.Runs {
+		builder := FromContext(ctx).New(run.Args[0])
+		for _, arg := range run.Args[1:] {
+			builder.Arg(arg)
+		}
+		result, err := builder.Build(ctx).
+			WithEnvInherit(false).
+			WithEnvMap(envs[i]).
+			WithDir(run.Dir).
+			Run()
+		results = append(results, result)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sh: replay run %d: %w", i+1, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results, errors.Join(errs...)
+}
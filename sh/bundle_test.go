@@ -0,0 +1,86 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBundleRecordAndReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "input.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sh.NewBundleRecorder()
+	_, err := sh.New("sh").OptV("-c", `cat "$1"; echo "$API_TOKEN"; exit 3`).Arg("sh").Arg("input.txt").Build(ctx).
+		WithDir(dir).
+		WithEnv("GREETING", "hi").
+		WithSecretEnv("API_TOKEN", "s3cr3t").
+		WithMiddleware(rec.Middleware()).
+		Run()
+	if err == nil {
+		t.Fatal("Expected the command to fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "repro.json")
+	if err := rec.Bundle(ctx).Save(path); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("Expected the secret to be redacted in the bundle, got %s", data)
+	}
+
+	bundle, err := sh.LoadBundle(path)
+	if err != nil {
+		t.Fatalf("LoadBundle() failed: %v", err)
+	}
+	if len(bundle.Runs) != 1 {
+		t.Fatalf("Expected one run, got %d", len(bundle.Runs))
+	}
+	run := bundle.Runs[0]
+	if run.Dir != dir || run.ExitCode != 3 || run.ToolDigest == "" {
+		t.Errorf("Unexpected run %+v", run)
+	}
+	if !slices.Contains(run.Env, "GREETING=hi") || !slices.Contains(run.Env, "API_TOKEN=[REDACTED]") {
+		t.Errorf("Expected the environment to be recorded, got %v", run.Env)
+	}
+	if _, ok := run.Inputs["input.txt"]; !ok {
+		t.Errorf("Expected input.txt to be recorded as an input, got %v", run.Inputs)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Errorf("Verify() failed on the recording machine: %v", err)
+	}
+
+	if _, err := sh.Replay(ctx, bundle); err == nil || !strings.Contains(err.Error(), "API_TOKEN") {
+		t.Errorf("Expected Replay to ask for the redacted variable, got %v", err)
+	}
+
+	t.Setenv("API_TOKEN", "other")
+	results, err := sh.Replay(ctx, bundle)
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Fatalf("Expected the replayed run to fail the same way, got %v", err)
+	}
+	if got := string(results[0].Stdout()); got != "hello\nother\n" {
+		t.Errorf("Expected the replay to see the recorded input and environment, got %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "input.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Verify(); !errors.Is(err, sh.ErrBundleDrift) || !strings.Contains(err.Error(), "input.txt") {
+		t.Errorf("Expected Verify to report the changed input, got %v", err)
+	}
+}
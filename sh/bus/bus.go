@@ -0,0 +1,201 @@
+// Package bus publishes the lifecycle of commands run by package sh to
+// message queues, so that fleet-scale systems can process them elsewhere:
+// audit trails, dashboards, alerting on failures.
+//
+//	nats, err := bus.DialNATS(ctx, "localhost:4222")
+//	if err != nil {
+//		return err
+//	}
+//	defer nats.Close()
+//	defer nats.Flush(ctx)
+//	defer sh.Use(bus.Middleware(nats, "sh.commands"))()
+//
+// Queues are reached through the small Publisher interface. This package
+// speaks the NATS and Redis streams protocols itself, sending from a
+// background queue so that commands never wait for the server; Kafka and
+// other systems whose protocols need a full client plug in through
+// PublisherFunc.
+// Messages are encoded as JSON unless another Codec is given.
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// Publisher sends messages to a queue. Publish must be safe for concurrent
+// use.
+type Publisher interface {
+	// Publish sends data to subject: a NATS subject, a Redis stream key or
+	// a Kafka topic.
+	Publish(ctx context.Context, subject string, data []byte) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface, e.g. to
+// publish with a Kafka client:
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("broker:9092")}
+//	p := bus.PublisherFunc(func(ctx context.Context, topic string, data []byte) error {
+//		return w.WriteMessages(ctx, kafka.Message{Topic: topic, Value: data})
+//	})
+type PublisherFunc func(ctx context.Context, subject string, data []byte) error
+
+func (f PublisherFunc) Publish(ctx context.Context, subject string, data []byte) error {
+	return f(ctx, subject, data)
+}
+
+// Message types.
+const (
+	TypeStart = "start"
+	TypeExit  = "exit"
+)
+
+// SchemaVersion is the version of the Message format published. It
+// changes only for changes that could break consumers, such as renamed or
+// removed fields; new fields may be added within a version. The format is
+// described for consumers in other languages by the JSON Schema in Schema
+// and the protocol buffer definitions in Proto.
+const SchemaVersion = 1
+
+// Message is a lifecycle event of a command as published.
+type Message struct {
+	// SchemaVersion is the SchemaVersion of the publisher.
+	SchemaVersion int `json:"schema_version"`
+	// Type is TypeStart, published before the command starts, or TypeExit,
+	// published once it has finished.
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Host string    `json:"host,omitempty"`
+	// Args is the command line with secrets masked, see sh.WithSecret.
+	Args []string          `json:"args"`
+	Dir  string            `json:"dir,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+	// Name and Group are the labels given with sh.Builder.Name and
+	// sh.Builder.Group, if any.
+	Name  string `json:"name,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Pid, ExitCode, Duration and Error are set for TypeExit. ExitCode is
+	// -1 if the process did not exit normally.
+	Pid      int           `json:"pid,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Codec serializes messages for publishing.
+type Codec interface {
+	Encode(m Message) ([]byte, error)
+}
+
+// CodecFunc adapts a function to the Codec interface.
+type CodecFunc func(m Message) ([]byte, error)
+
+func (f CodecFunc) Encode(m Message) ([]byte, error) {
+	return f(m)
+}
+
+// JSON encodes messages as JSON objects. It is the default Codec.
+var JSON Codec = CodecFunc(func(m Message) ([]byte, error) {
+	return json.Marshal(m)
+})
+
+// Option configures Middleware.
+type Option func(*config)
+
+type config struct {
+	codec   Codec
+	timeout time.Duration
+	onError func(error)
+}
+
+// WithCodec encodes messages with c instead of JSON.
+func WithCodec(c Codec) Option {
+	return func(cfg *config) {
+		cfg.codec = c
+	}
+}
+
+// WithTimeout bounds how long publishing a message may take; the default
+// is five seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.timeout = d
+	}
+}
+
+// OnError is called with the errors of messages that could not be
+// published. By default they are logged with slog.
+func OnError(f func(error)) Option {
+	return func(cfg *config) {
+		cfg.onError = f
+	}
+}
+
+// Middleware returns middleware publishing a TypeStart and a TypeExit
+// message to subject for every command it wraps. Publishing failures do
+// not fail the commands; they are reported to the OnError function.
+func Middleware(p Publisher, subject string, opts ...Option) sh.Middleware {
+	cfg := config{
+		codec:   JSON,
+		timeout: 5 * time.Second,
+		onError: func(err error) { slog.Warn("bus: publish failed", "error", err) },
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	host, _ := os.Hostname()
+
+	publish := func(ctx context.Context, m Message) {
+		data, err := cfg.codec.Encode(m)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.timeout)
+			defer cancel()
+			err = p.Publish(ctx, subject, data)
+		}
+		if err != nil {
+			cfg.onError(err)
+		}
+	}
+
+	return func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			m := Message{
+				SchemaVersion: SchemaVersion,
+				Type:          TypeStart,
+				Time:          time.Now(),
+				Host:          host,
+				Args:          e.RedactedArgs(),
+				Dir:           e.Exec.Dir,
+				Meta:          e.Cmd.Meta(),
+				Group:         e.Cmd.Group(),
+			}
+			if name := e.Cmd.Name(); name != e.Exec.Args[0] {
+				m.Name = name
+			}
+			publish(ctx, m)
+
+			err := next(ctx, e)
+
+			m.Type = TypeExit
+			m.Duration = time.Since(m.Time)
+			m.Time = time.Now()
+			m.ExitCode = -1
+			if e.Exec.Process != nil {
+				m.Pid = e.Exec.Process.Pid
+			}
+			if e.Exec.ProcessState != nil {
+				m.ExitCode = e.Exec.ProcessState.ExitCode()
+			}
+			if err != nil {
+				m.Error = err.Error()
+			}
+			publish(ctx, m)
+			return err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bus_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/bus"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var messages []bus.Message
+	p := bus.PublisherFunc(func(ctx context.Context, subject string, data []byte) error {
+		if subject != "sh.commands" {
+			t.Errorf("Unexpected subject %q", subject)
+		}
+		var m bus.Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Errorf("Expected JSON, got %s", data)
+		}
+		mu.Lock()
+		messages = append(messages, m)
+		mu.Unlock()
+		return nil
+	})
+
+	sh.New("sh").OptV("-c", "exit 3").Arg("hunter2").Name("deploy-app").Group("release").Build(ctx).
+		WithSecret("hunter2").
+		WithMeta("job", "deploy").
+		WithMiddleware(bus.Middleware(p, "sh.commands")).
+		Run()
+
+	if len(messages) != 2 {
+		t.Fatalf("Expected a start and an exit message, got %+v", messages)
+	}
+	start, exit := messages[0], messages[1]
+	if start.Type != bus.TypeStart || exit.Type != bus.TypeExit {
+		t.Errorf("Unexpected message types %q and %q", start.Type, exit.Type)
+	}
+	if !slices.Equal(start.Args, []string{"sh", "-c", "exit 3", "[REDACTED]"}) || start.Meta["job"] != "deploy" ||
+		start.Name != "deploy-app" || start.Group != "release" {
+		t.Errorf("Unexpected start message %+v", start)
+	}
+	if exit.ExitCode != 3 || exit.Pid == 0 || exit.Error == "" {
+		t.Errorf("Unexpected exit message %+v", exit)
+	}
+}
+
+func TestMiddlewarePublishErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var errs []error
+	p := bus.PublisherFunc(func(context.Context, string, []byte) error {
+		return errors.New("queue down")
+	})
+	codec := bus.CodecFunc(func(m bus.Message) ([]byte, error) {
+		return []byte(m.Type), nil
+	})
+
+	_, err := sh.New("true").Build(ctx).
+		WithMiddleware(bus.Middleware(p, "sh", bus.WithCodec(codec), bus.OnError(func(err error) { errs = append(errs, err) }))).
+		Run()
+	if err != nil {
+		t.Errorf("Expected publishing failures not to fail the command, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected both failures to be reported, got %v", errs)
+	}
+}
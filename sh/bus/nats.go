@@ -0,0 +1,176 @@
+package bus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes to a NATS server over its client protocol. Publish only
+// queues the message: a goroutine sends the queued messages in order and
+// waits for the server to acknowledge each, so that a slow or stalled
+// server does not hold up the commands being published. Errors such as a
+// permission violation are reported to the function set with OnError. On
+// any error the connection is dropped and the next message dials again.
+type NATS struct {
+	addr    string
+	q       *queue
+	mu      sync.Mutex
+	conn    net.Conn // nil after an error until the next send
+	r       *bufio.Reader
+	timeout time.Duration
+	closed  bool
+}
+
+// DialNATS connects to the NATS server at addr, given as host:port.
+func DialNATS(ctx context.Context, addr string) (*NATS, error) {
+	n := &NATS{addr: addr, timeout: DefaultSendTimeout}
+	n.q = newQueue(n.send)
+	if err := n.dial(ctx); err != nil {
+		return nil, fmt.Errorf("bus: nats: %w", err)
+	}
+	return n, nil
+}
+
+// dial connects to the server. The caller must hold n.mu unless n is not
+// shared yet.
+func (n *NATS) dial(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	// The server greets with INFO; verbose mode stays off so that only
+	// PING answers need to be read
+	line, err := n.r.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if err == nil {
+		_, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"sh-bus\"}\r\n")
+	}
+	if err == nil {
+		err = n.flush()
+	}
+	if err != nil {
+		conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+// Timeout sets how long sending a message may take, connecting included;
+// the default is DefaultSendTimeout.
+func (n *NATS) Timeout(d time.Duration) *NATS {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.timeout = d
+	return n
+}
+
+// OnError sets the function called with the errors of messages that could
+// not be sent. By default they are logged with slog.
+func (n *NATS) OnError(f func(error)) *NATS {
+	n.q.setOnError(f)
+	return n
+}
+
+// Publish queues data to be sent to subject. It fails with ErrQueueFull
+// when the server falls too far behind.
+func (n *NATS) Publish(ctx context.Context, subject string, data []byte) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("bus: nats: invalid subject %q", subject)
+	}
+	if err := n.q.push(message{subject, data}); err != nil {
+		return fmt.Errorf("bus: nats: %w", err)
+	}
+	return nil
+}
+
+// Flush waits until the messages published so far have been sent or
+// failed, or ctx is done.
+func (n *NATS) Flush(ctx context.Context) error {
+	return n.q.flush(ctx)
+}
+
+// send sends m and waits for the server to process it.
+func (n *NATS) send(m message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return fmt.Errorf("bus: nats: %w", ErrClosed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	if n.conn == nil {
+		if err := n.dial(ctx); err != nil {
+			return fmt.Errorf("bus: nats: %w", err)
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	n.conn.SetDeadline(deadline)
+
+	msg := fmt.Appendf(nil, "PUB %s %d\r\n", m.subject, len(m.data))
+	msg = append(append(msg, m.data...), "\r\n"...)
+	_, err := n.conn.Write(msg)
+	if err == nil {
+		err = n.flush()
+	}
+	if err != nil {
+		// The connection may be stalled or out of step with the server
+		n.conn.Close()
+		n.conn = nil
+		return fmt.Errorf("bus: nats: %w", err)
+	}
+	n.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// flush sends a PING and reads until the PONG, which the server sends
+// once it has processed everything before it.
+func (n *NATS) flush() error {
+	if _, err := fmt.Fprint(n.conn, "PING\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := fmt.Fprint(n.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+	}
+}
+
+// Close closes the connection. Messages not sent yet are dropped; call
+// Flush first to wait for them.
+func (n *NATS) Close() error {
+	n.q.close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	if n.conn == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
@@ -0,0 +1,149 @@
+package bus_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/bus"
+)
+
+// fakeNATS accepts clients and sends the payloads they publish on the
+// returned channel. Publishing to the subject "denied" fails. With stall,
+// the first client is connected but its publishes are never acknowledged.
+func fakeNATS(t *testing.T, stall bool) (addr string, published <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveNATS(conn, ch, stall)
+			stall = false
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func serveNATS(conn net.Conn, published chan<- string, stall bool) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			if stall {
+				io.Copy(io.Discard, r)
+				return
+			}
+			var n int
+			fmt.Sscan(fields[2], &n)
+			payload := make([]byte, n+2)
+			io.ReadFull(r, payload)
+			if fields[1] == "denied" {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish to denied'\r\n")
+				continue
+			}
+			published <- fields[1] + " " + string(payload[:n])
+		}
+	}
+}
+
+func TestNATS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr, published := fakeNATS(t, false)
+	n, err := bus.DialNATS(ctx, addr)
+	if err != nil {
+		t.Fatalf("DialNATS failed: %v", err)
+	}
+	defer n.Close()
+	errs := make(chan error, 10)
+	n.OnError(func(err error) { errs <- err })
+
+	if err := n.Publish(ctx, "sh.commands", []byte(`{"type":"start"}`)); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if got := <-published; got != `sh.commands {"type":"start"}` {
+		t.Errorf("Unexpected message %q", got)
+	}
+
+	if err := n.Publish(ctx, "denied", []byte("x")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+	if err := n.Publish(ctx, "bad subject", nil); err == nil {
+		t.Error("Expected an invalid subject to be rejected")
+	}
+
+	n.Close()
+	if err := n.Publish(ctx, "sh.commands", nil); !errors.Is(err, bus.ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestNATSStalledServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr, published := fakeNATS(t, true)
+	n, err := bus.DialNATS(ctx, addr)
+	if err != nil {
+		t.Fatalf("DialNATS failed: %v", err)
+	}
+	defer n.Close()
+	errs := make(chan error, 10)
+	n.Timeout(100 * time.Millisecond).OnError(func(err error) { errs <- err })
+
+	// Publishing does not wait for the stalled server
+	start := time.Now()
+	for i := range 3 {
+		if err := n.Publish(ctx, "sh.commands", []byte{'0' + byte(i)}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected Publish not to wait for the server, took %v", elapsed)
+	}
+
+	// The stalled connection is dropped and the next message dials again
+	if err := <-errs; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the stalled send to time out, got %v", err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"sh.commands 1", "sh.commands 2"} {
+		if got := <-published; got != want {
+			t.Errorf("Expected %q on the new connection, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Publish when messages come in faster than the
+// server takes them and QueueSize messages are already waiting.
+var ErrQueueFull = errors.New("bus: queue full")
+
+// ErrClosed is returned by Publish after Close.
+var ErrClosed = errors.New("bus: publisher closed")
+
+// QueueSize is how many messages NATS and RedisStream hold while they
+// cannot be sent.
+const QueueSize = 1024
+
+// DefaultSendTimeout bounds how long NATS and RedisStream try to send a
+// message, connecting included, unless changed with their Timeout method.
+const DefaultSendTimeout = 5 * time.Second
+
+type message struct {
+	subject string
+	data    []byte
+}
+
+// queue sends messages in order from a goroutine of its own, so that
+// publishing never waits for a slow or stalled server. The goroutine runs
+// only while there are messages to send.
+type queue struct {
+	send    func(m message) error
+	mu      sync.Mutex
+	pending []message
+	onError func(error)
+	drained chan struct{} // closed once the sending goroutine has nothing left; nil when idle
+	closed  bool
+}
+
+func newQueue(send func(m message) error) *queue {
+	return &queue{
+		send:    send,
+		onError: func(err error) { slog.Warn("bus: publish failed", "error", err) },
+	}
+}
+
+// push queues m for sending.
+func (q *queue) push(m message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case q.closed:
+		return ErrClosed
+	case len(q.pending) >= QueueSize:
+		return ErrQueueFull
+	}
+	q.pending = append(q.pending, m)
+	if q.drained == nil {
+		q.drained = make(chan struct{})
+		go q.run(q.drained)
+	}
+	return nil
+}
+
+func (q *queue) run(drained chan struct{}) {
+	defer close(drained)
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.drained = nil
+			q.mu.Unlock()
+			return
+		}
+		m := q.pending[0]
+		q.pending = q.pending[1:]
+		onError := q.onError
+		q.mu.Unlock()
+
+		if err := q.send(m); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// setOnError sets the function failures to send are reported to.
+func (q *queue) setOnError(f func(error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onError = f
+}
+
+// flush waits until the messages queued so far have been sent or failed.
+func (q *queue) flush(ctx context.Context) error {
+	q.mu.Lock()
+	drained := q.drained
+	q.mu.Unlock()
+	if drained == nil {
+		return nil
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close makes push fail and drops the messages not sent yet.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.pending = nil
+}
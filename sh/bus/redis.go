@@ -0,0 +1,190 @@
+package bus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisStream publishes to Redis streams with XADD, storing each message in
+// the field "data" of an entry of the stream named by the subject. Like
+// NATS, Publish only queues the message for a goroutine sending them in
+// order, reporting errors to the function set with OnError, and the
+// connection is dropped on any error and dialed again for the next message.
+type RedisStream struct {
+	addr     string
+	password string
+	q        *queue
+	mu       sync.Mutex
+	conn     net.Conn // nil after an error until the next send
+	r        *bufio.Reader
+	maxLen   int
+	timeout  time.Duration
+	closed   bool
+}
+
+// DialRedis connects to the Redis server at addr, given as host:port,
+// authenticating with password unless it is empty.
+func DialRedis(ctx context.Context, addr, password string) (*RedisStream, error) {
+	s := &RedisStream{addr: addr, password: password, timeout: DefaultSendTimeout}
+	s.q = newQueue(s.send)
+	if err := s.dial(ctx); err != nil {
+		return nil, fmt.Errorf("bus: redis: %w", err)
+	}
+	return s, nil
+}
+
+// dial connects to the server and authenticates. The caller must hold s.mu
+// unless s is not shared yet.
+func (s *RedisStream) dial(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+	if s.password == "" {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if _, err := s.do("AUTH", s.password); err != nil {
+		conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// MaxLen caps the streams published to at about n entries, trimming the
+// oldest; streams grow without limit by default.
+func (s *RedisStream) MaxLen(n int) *RedisStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxLen = n
+	return s
+}
+
+// Timeout sets how long sending a message may take, connecting included;
+// the default is DefaultSendTimeout.
+func (s *RedisStream) Timeout(d time.Duration) *RedisStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = d
+	return s
+}
+
+// OnError sets the function called with the errors of messages that could
+// not be sent. By default they are logged with slog.
+func (s *RedisStream) OnError(f func(error)) *RedisStream {
+	s.q.setOnError(f)
+	return s
+}
+
+// Publish queues data to be appended to the stream named subject. It fails
+// with ErrQueueFull when the server falls too far behind.
+func (s *RedisStream) Publish(ctx context.Context, subject string, data []byte) error {
+	if err := s.q.push(message{subject, data}); err != nil {
+		return fmt.Errorf("bus: redis: %w", err)
+	}
+	return nil
+}
+
+// Flush waits until the messages published so far have been sent or
+// failed, or ctx is done.
+func (s *RedisStream) Flush(ctx context.Context) error {
+	return s.q.flush(ctx)
+}
+
+// send appends m to its stream.
+func (s *RedisStream) send(m message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("bus: redis: %w", ErrClosed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return fmt.Errorf("bus: redis: %w", err)
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	s.conn.SetDeadline(deadline)
+
+	args := []string{"XADD", m.subject}
+	if s.maxLen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.Itoa(s.maxLen))
+	}
+	args = append(args, "*", "data", string(m.data))
+	if _, err := s.do(args...); err != nil {
+		// The connection may be stalled or out of step with the server
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("bus: redis: %w", err)
+	}
+	s.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// do sends a command and returns its reply, which must be a simple or bulk
+// string. The caller must hold s.mu unless s is not shared yet.
+func (s *RedisStream) do(args ...string) (string, error) {
+	cmd := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd = fmt.Appendf(cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := s.conn.Write(cmd); err != nil {
+		return "", err
+	}
+
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return "", errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("unexpected reply %q", line)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
+
+// Close closes the connection. Messages not sent yet are dropped; call
+// Flush first to wait for them.
+func (s *RedisStream) Close() error {
+	s.q.close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
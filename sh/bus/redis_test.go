@@ -0,0 +1,142 @@
+package bus_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/bus"
+)
+
+// fakeRedis accepts clients and sends the commands they send on the
+// returned channel. It requires the password "secret". With stall, the
+// first client's commands after AUTH are never answered.
+func fakeRedis(t *testing.T, stall bool) (addr string, commands <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan []string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveRedis(conn, ch, stall)
+			stall = false
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func serveRedis(conn net.Conn, commands chan<- []string, stall bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ := r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			io.ReadFull(r, buf)
+			args[i] = string(buf[:size])
+		}
+		switch {
+		case args[0] == "AUTH" && args[1] != "secret":
+			commands <- args
+			fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+		case args[0] == "AUTH":
+			commands <- args
+			fmt.Fprint(conn, "+OK\r\n")
+		case stall:
+			io.Copy(io.Discard, r)
+			return
+		default:
+			commands <- args
+			fmt.Fprint(conn, "$15\r\n1700000000000-0\r\n")
+		}
+	}
+}
+
+func TestRedisStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr, commands := fakeRedis(t, false)
+	s, err := bus.DialRedis(ctx, addr, "secret")
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+	defer s.Close()
+	<-commands
+
+	if err := s.MaxLen(1000).Publish(ctx, "sh:commands", []byte(`{"type":"exit"}`)); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	want := []string{"XADD", "sh:commands", "MAXLEN", "~", "1000", "*", "data", `{"type":"exit"}`}
+	if got := <-commands; !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRedisStreamWrongPassword(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr, _ := fakeRedis(t, false)
+	_, err := bus.DialRedis(ctx, addr, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+}
+
+func TestRedisStreamStalledServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr, commands := fakeRedis(t, true)
+	s, err := bus.DialRedis(ctx, addr, "secret")
+	if err != nil {
+		t.Fatalf("DialRedis failed: %v", err)
+	}
+	defer s.Close()
+	<-commands
+	errs := make(chan error, 10)
+	s.Timeout(100 * time.Millisecond).OnError(func(err error) { errs <- err })
+
+	start := time.Now()
+	s.Publish(ctx, "sh:commands", []byte("lost"))
+	s.Publish(ctx, "sh:commands", []byte("sent"))
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected Publish not to wait for the server, took %v", elapsed)
+	}
+	if err := <-errs; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the stalled send to time out, got %v", err)
+	}
+
+	// The next message is sent over a new, authenticated connection
+	if got := <-commands; got[0] != "AUTH" {
+		t.Errorf("Expected the client to dial and authenticate again, got %q", got)
+	}
+	if got := <-commands; got[len(got)-1] != "sent" {
+		t.Errorf("Expected the next message on the new connection, got %q", got)
+	}
+}
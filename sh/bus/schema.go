@@ -0,0 +1,14 @@
+package bus
+
+import _ "embed"
+
+// Schema is the JSON Schema of the messages of the current SchemaVersion.
+//
+//go:embed schema/message.v1.schema.json
+var Schema []byte
+
+// Proto holds the protocol buffer definition of the messages of the current
+// SchemaVersion.
+//
+//go:embed schema/message.v1.proto
+var Proto []byte
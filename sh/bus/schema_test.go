@@ -0,0 +1,103 @@
+package bus_test
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/bus"
+)
+
+// messageFields returns the JSON names of the fields of bus.Message, and
+// which of them are always present.
+func messageFields() (names []string, required []string) {
+	typ := reflect.TypeFor[bus.Message]()
+	for i := range typ.NumField() {
+		name, opts, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	slices.Sort(names)
+	slices.Sort(required)
+	return names, required
+}
+
+func TestSchemaMatchesMessage(t *testing.T) {
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(bus.Schema, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+	names, required := messageFields()
+	if got := slices.Sorted(maps.Keys(schema.Properties)); !slices.Equal(got, names) {
+		t.Errorf("Schema properties %v do not match the fields of Message %v", got, names)
+	}
+	if slices.Sort(schema.Required); !slices.Equal(schema.Required, required) {
+		t.Errorf("Schema requires %v, but Message always has %v", schema.Required, required)
+	}
+	if !strings.Contains(string(bus.Schema), `"const": 1`) || bus.SchemaVersion != 1 {
+		t.Error("Expected the schema to pin the current SchemaVersion")
+	}
+}
+
+func TestProtoMatchesMessage(t *testing.T) {
+	field := regexp.MustCompile(`(?m)^\s+(?:repeated\s+)?[\w.<>, ]+\s+(\w+)\s*=\s*\d+;`)
+	var got []string
+	for _, m := range field.FindAllStringSubmatch(string(bus.Proto), -1) {
+		got = append(got, m[1])
+	}
+	slices.Sort(got)
+	if names, _ := messageFields(); !slices.Equal(got, names) {
+		t.Errorf("Proto fields %v do not match the fields of Message %v", got, names)
+	}
+}
+
+// TestMessageCompatibility guards the published format: the frozen sample
+// of version 1 must keep decoding, and encoding must produce its keys.
+func TestMessageCompatibility(t *testing.T) {
+	golden, err := os.ReadFile("testdata/message.v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m bus.Message
+	if err := json.Unmarshal(golden, &m); err != nil {
+		t.Fatalf("Cannot decode the version 1 sample: %v", err)
+	}
+	want := bus.Message{
+		SchemaVersion: 1,
+		Type:          bus.TypeExit,
+		Time:          time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Host:          "build-7",
+		Args:          []string{"git", "push", "[REDACTED]"},
+		Dir:           "/srv/repo",
+		Meta:          map[string]string{"job": "release"},
+		Pid:           4242,
+		ExitCode:      1,
+		Duration:      1500 * time.Millisecond,
+		Error:         "git: exit status 1",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Decoded %+v, want %+v", m, want)
+	}
+
+	encoded, err := bus.JSON.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotKeys, wantKeys map[string]any
+	json.Unmarshal(encoded, &gotKeys)
+	json.Unmarshal(golden, &wantKeys)
+	if !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Errorf("Encoding changed:\n got %s\nwant %s", encoded, golden)
+	}
+}
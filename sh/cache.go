@@ -0,0 +1,304 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Cache stores the outcome of successful command runs keyed by a hash of
+// the command line and its input files, so unchanged work can be skipped.
+type Cache interface {
+	// Get returns the entry stored under key, if any.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Put stores data under key.
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// cacheEntry is the serialized form of a cached Result.
+type cacheEntry struct {
+	Stdout   []byte `json:"stdout"`
+	Stderr   []byte `json:"stderr"`
+	ExitCode int    `json:"exit_code,omitempty"` // accepted with ExpectExit
+}
+
+// errUncachedStdin reports stdin whose content cannot be hashed without
+// consuming it, such as a pipe from another command.
+var errUncachedStdin = errors.New("sh: stdin of unknown content is not cached")
+
+// DirCache is a Cache storing entries as files in a local directory.
+type DirCache struct {
+	dir string
+}
+
+// NewDirCache returns a Cache storing entries in dir.
+func NewDirCache(dir string) *DirCache {
+	return &DirCache{dir: dir}
+}
+
+// Get implements Cache.
+func (c *DirCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+// Put implements Cache.
+func (c *DirCache) Put(_ context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, key), data, 0o644)
+}
+
+// HTTPCache is a Cache backed by a remote HTTP server, so several machines
+// such as CI runners can share results. Entries are read with GET and
+// written with PUT requests to the base URL followed by the key, which
+// works with plain WebDAV-style cache servers as well as object stores
+// fronted by an HTTP gateway.
+type HTTPCache struct {
+	// BaseURL is the URL entries are stored under, e.g.
+	// "https://cache.example.com/sh/".
+	BaseURL string
+	// Client is used for requests; http.DefaultClient if nil.
+	Client *http.Client
+	// Header is added to every request, e.g. for authorization.
+	Header http.Header
+}
+
+// NewHTTPCache returns an HTTPCache storing entries under baseURL. A
+// non-empty token is sent as a bearer token with every request.
+func NewHTTPCache(baseURL, token string) *HTTPCache {
+	c := &HTTPCache{BaseURL: baseURL, Header: make(http.Header)}
+	if token != "" {
+		c.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c
+}
+
+// Get implements Cache.
+func (c *HTTPCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("sh: cache GET %s: %s", key, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	return data, err == nil, err
+}
+
+// Put implements Cache.
+func (c *HTTPCache) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sh: cache PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (c *HTTPCache) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (cm *cmdImpl) WithCache(c Cache, inputs ...string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.cache = c
+	cm.cacheInputs = inputs
+	return cm
+}
+
+// cacheKey hashes the command line, working directory, the environment
+// variables set on the command, its stdin and the contents of the input
+// files. Paths are hashed relative to the current directory and the input
+// roots, and inherited variables are left out, so that other checkouts and
+// machines sharing the cache get the same keys.
+func (cm *cmdImpl) cacheKey() (string, error) {
+	h := sha256.New()
+	for _, item := range append([]string{cm.cmd, relativeDir(cm.dir)}, cm.args...) {
+		fmt.Fprintf(h, "%q\n", item)
+	}
+
+	if cm.stdin != nil {
+		stdin, err := cm.cacheStdin()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "stdin %d\n", len(stdin))
+		h.Write(stdin)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(cm.env)) {
+		fmt.Fprintf(h, "%q\n", key+"="+cm.env[key])
+	}
+	for _, key := range slices.Sorted(slices.Values(cm.envUnset)) {
+		fmt.Fprintf(h, "-%q\n", key)
+	}
+	fmt.Fprintf(h, "%t %t\n", cm.envNoInherit, cm.scrubEnv)
+
+	for _, input := range cm.cacheInputs {
+		root := input
+		if cm.dir != "" && !filepath.IsAbs(input) {
+			root = filepath.Join(cm.dir, input)
+		}
+		fmt.Fprintf(h, "%q\n", filepath.ToSlash(input))
+		if err := hashTree(h, root); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheStdin returns the data the command reads from stdin and replaces
+// stdin with a reader of the same data. Only readers holding their data in
+// memory, as set by WithStdinString and WithStdinBytes, are read; others
+// could block or yield different data on every run.
+func (cm *cmdImpl) cacheStdin() ([]byte, error) {
+	switch cm.stdin.(type) {
+	case *strings.Reader, *bytes.Reader:
+	default:
+		return nil, errUncachedStdin
+	}
+
+	data, err := io.ReadAll(cm.stdin)
+	if err != nil {
+		return nil, err
+	}
+	cm.stdin = bytes.NewReader(data)
+	return data, nil
+}
+
+// relativeDir returns dir relative to the current directory when it is
+// inside it, and dir itself otherwise.
+func relativeDir(dir string) string {
+	if dir == "" || !filepath.IsAbs(dir) {
+		return filepath.ToSlash(dir)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return dir
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil || !filepath.IsLocal(rel) {
+		return dir
+	}
+	return filepath.ToSlash(rel)
+}
+
+// hashTree writes the names, relative to root, and contents of all files
+// under root to w.
+func hashTree(w io.Writer, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%q\n", filepath.ToSlash(rel))
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// cachedEntry returns the cached outcome of the command, or nil if there
+// is none. Cache failures are treated as misses.
+func (cm *cmdImpl) cachedEntry() *cacheEntry {
+	key, err := cm.cacheKey()
+	if err != nil {
+		return nil
+	}
+	cm.cacheKeyHash = key
+
+	data, ok, err := cm.cache.Get(cm.ctx, key)
+	if err != nil || !ok {
+		return nil
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// replay writes the cached output to the writers of cmd, in place of
+// running it, and returns the error the command exited with.
+func (e *cacheEntry) replay(name string, cmd *exec.Cmd) error {
+	if cmd.Stdout != nil {
+		if _, err := cmd.Stdout.Write(e.Stdout); err != nil {
+			return err
+		}
+	}
+	if cmd.Stderr != nil {
+		if _, err := cmd.Stderr.Write(e.Stderr); err != nil {
+			return err
+		}
+	}
+	if e.ExitCode != 0 {
+		return &ExitError{Cmd: name, ExitCode: e.ExitCode}
+	}
+	return nil
+}
+
+// storeResult records a successful result in the cache. Failing to store
+// it only means the next run will not be skipped.
+func (cm *cmdImpl) storeResult(result *resultImpl) {
+	if cm.cacheKeyHash == "" {
+		return
+	}
+
+	data, err := json.Marshal(cacheEntry{Stdout: result.stdout, Stderr: result.stderr, ExitCode: result.exitCode})
+	if err == nil {
+		cm.cache.Put(cm.ctx, cm.cacheKeyHash, data)
+	}
+}
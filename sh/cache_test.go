@@ -0,0 +1,234 @@
+package sh_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// runCounted runs a command printing input.txt that appends to a run log, so
+// tests can tell real runs from cache hits.
+func runCounted(t *testing.T, ctx context.Context, dir string, cache sh.Cache) sh.Result {
+	t.Helper()
+
+	result, err := sh.New("sh").
+		OptV("-c", "echo run >> runs.log; cat input.txt").
+		Build(ctx).
+		WithDir(dir).
+		WithCache(cache, "input.txt").
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	return result
+}
+
+func countRuns(t *testing.T, dir string) int {
+	t.Helper()
+	data, _ := os.ReadFile(filepath.Join(dir, "runs.log"))
+	return strings.Count(string(data), "run")
+}
+
+func testCache(t *testing.T, cache sh.Cache) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	os.WriteFile(input, []byte("v1"), 0o644)
+
+	first := runCounted(t, ctx, dir, cache)
+	second := runCounted(t, ctx, dir, cache)
+
+	if countRuns(t, dir) != 1 {
+		t.Errorf("Expected unchanged inputs to skip the second run, got %d runs", countRuns(t, dir))
+	}
+	if first.Cached() || !second.Cached() {
+		t.Errorf("Expected only the second result to be cached, got %v and %v", first.Cached(), second.Cached())
+	}
+	if string(second.Stdout()) != "v1" {
+		t.Errorf("Expected cached stdout 'v1', got '%s'", second.Stdout())
+	}
+
+	os.WriteFile(input, []byte("v2"), 0o644)
+	third := runCounted(t, ctx, dir, cache)
+	if countRuns(t, dir) != 2 || third.Cached() {
+		t.Errorf("Expected changed inputs to run again, got %d runs", countRuns(t, dir))
+	}
+	if string(third.Stdout()) != "v2" {
+		t.Errorf("Expected stdout 'v2', got '%s'", third.Stdout())
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	testCache(t, sh.NewDirCache(t.TempDir()))
+}
+
+func TestHTTPCache(t *testing.T) {
+	var mu sync.Mutex
+	entries := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := entries[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodPut:
+			entries[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	testCache(t, sh.NewHTTPCache(server.URL+"/cache", "secret"))
+
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries on the server, got %d", len(entries))
+	}
+}
+
+func TestCacheKeySharedAcrossCheckouts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cache := sh.NewDirCache(t.TempDir())
+	run := func(checkout string) sh.Result {
+		t.Helper()
+		os.MkdirAll(filepath.Join(checkout, "src"), 0o755)
+		os.WriteFile(filepath.Join(checkout, "src", "main.c"), []byte("int main;"), 0o644)
+		if err := os.Chdir(checkout); err != nil {
+			t.Fatal(err)
+		}
+		result, err := sh.New("cat").Arg("src/main.c").Build(ctx).WithCache(cache, "src").Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Setenv("SH_CACHE_TEST", "first")
+	run(t.TempDir())
+	// Another checkout with the same files, run with another environment
+	t.Setenv("SH_CACHE_TEST", "second")
+	if result := run(t.TempDir()); !result.Cached() || string(result.Stdout()) != "int main;" {
+		t.Errorf("Expected another checkout to hit the cache, got cached=%v", result.Cached())
+	}
+}
+
+func TestCacheSkipsDigestedOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := sh.NewDirCache(t.TempDir())
+	for i := range 2 {
+		var out strings.Builder
+		result, err := sh.New("printf").Arg("payload").Build(ctx).
+			WithStdout(&out).
+			WithBinaryOutput(sha256.New()).
+			WithCache(cache).
+			Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Cached() || out.String() != "payload" {
+			t.Errorf("Run %d: expected a real run writing the output, got cached=%v and %q", i, result.Cached(), out.String())
+		}
+	}
+}
+
+func TestCacheKeyIncludesStdin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := sh.NewDirCache(t.TempDir())
+	run := func(stdin string) sh.Result {
+		t.Helper()
+		result, err := sh.New("cat").Build(ctx).WithStdinString(stdin).WithCache(cache).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	run("first")
+	if result := run("second"); result.Cached() || string(result.Stdout()) != "second" {
+		t.Errorf("Expected other stdin to run again, got cached=%v and '%s'", result.Cached(), result.Stdout())
+	}
+	if result := run("first"); !result.Cached() || string(result.Stdout()) != "first" {
+		t.Errorf("Expected the same stdin to hit the cache, got cached=%v and '%s'", result.Cached(), result.Stdout())
+	}
+
+	// Piped stdin cannot be hashed, so the command always runs
+	for range 2 {
+		result, err := sh.New("echo").Arg("piped").Build(ctx).
+			Pipe("cat").Build().
+			WithCache(cache).
+			Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Cached() {
+			t.Error("Expected a piped stage not to be cached")
+		}
+	}
+}
+
+func TestCacheReplaysExitCodeAndOutputs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := sh.NewDirCache(t.TempDir())
+	dir := t.TempDir()
+	for i := range 2 {
+		path := filepath.Join(dir, "out.txt")
+		os.Remove(path)
+		var lines []string
+		cmd := sh.New("sh").OptV("-c", "echo one; echo two; exit 1").Build(ctx).
+			ExpectExit(1).
+			WithStdoutFile(path).
+			WithCache(cache)
+		for line := range cmd.Lines() {
+			lines = append(lines, line)
+		}
+		result, err := cmd.Wait()
+		if err != nil {
+			t.Fatalf("Run %d failed: %v", i, err)
+		}
+		if result.Cached() != (i == 1) || result.ExitCode() != 1 {
+			t.Errorf("Run %d: expected cached=%v and exit code 1, got %v and %d", i, i == 1, result.Cached(), result.ExitCode())
+		}
+		if strings.Join(lines, ",") != "one,two" {
+			t.Errorf("Run %d: expected lines 'one,two', got %q", i, lines)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "one\ntwo\n" {
+			t.Errorf("Run %d: expected the output file to be written, got '%s'", i, data)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package sh
+
+// Capability is a Linux capability number as defined in
+// <linux/capability.h>.
+type Capability uintptr
+
+// Commonly used Linux capabilities.
+const (
+	CapChown          Capability = 0
+	CapDacOverride    Capability = 1
+	CapFowner         Capability = 3
+	CapKill           Capability = 5
+	CapSetgid         Capability = 6
+	CapSetuid         Capability = 7
+	CapNetBindService Capability = 10
+	CapNetAdmin       Capability = 12
+	CapNetRaw         Capability = 13
+	CapIpcLock        Capability = 14
+	CapSysChroot      Capability = 18
+	CapSysPtrace      Capability = 19
+	CapSysAdmin       Capability = 21
+	CapSysNice        Capability = 23
+	CapSysResource    Capability = 24
+	CapSysTime        Capability = 25
+)
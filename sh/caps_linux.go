@@ -0,0 +1,24 @@
+package sh
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func (cm *cmdImpl) WithAmbientCaps(caps ...Capability) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			for _, c := range caps {
+				cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, uintptr(c))
+			}
+			return nil
+		},
+	})
+	return cm
+}
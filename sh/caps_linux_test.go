@@ -0,0 +1,42 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithAmbientCaps(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("granting capabilities requires root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("grep").
+		Arg("CapAmb").
+		Arg("/proc/self/status").
+		Build(ctx).
+		WithDropPrivileges("nobody").
+		WithAmbientCaps(sh.CapNetBindService).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v: %s", err, result.Stderr())
+	}
+
+	// CapAmb is a hex bitmask of the ambient set
+	_, mask, _ := strings.Cut(strings.TrimSpace(string(result.Stdout())), "\t")
+	bits, err := strconv.ParseUint(mask, 16, 64)
+	if err != nil {
+		t.Fatalf("Unexpected CapAmb line: %s", result.Stdout())
+	}
+	if bits != 1<<sh.CapNetBindService {
+		t.Errorf("Expected only CAP_NET_BIND_SERVICE in the ambient set, got %#x", bits)
+	}
+}
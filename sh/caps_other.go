@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func (cm *cmdImpl) WithAmbientCaps(...Capability) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: ambient capabilities: %w", errors.ErrUnsupported)
+		},
+	})
+	return cm
+}
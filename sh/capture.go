@@ -0,0 +1,147 @@
+package sh
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// boundedBuffer keeps at most max bytes of what is written to it: the
+// first max bytes, or the last max bytes in tail mode, of the lines kept
+// by the sampler if set. Writes always succeed, so streaming to other
+// writers is not interrupted.
+//
+// It is safe for concurrent use: stdout and stderr may both write to the
+// combined capture, and the goroutines os/exec copies output with can
+// outlive Wait when a grandchild holds on to the pipes past WaitDelay.
+type boundedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	tail      bool
+	sampler   *lineSampler
+	truncated bool
+	closed    bool // taken a snapshot of; later writes are dropped
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return len(p), nil
+	}
+	if b.sampler != nil {
+		b.sampler.write(b, p)
+	} else {
+		b.store(p)
+	}
+	return len(p), nil
+}
+
+// snapshot ends the capture and returns a copy of what was captured,
+// including what the sampler still held back, and whether output was
+// dropped. The copy is never written to again, so Results built from it
+// can be shared freely.
+func (b *boundedBuffer) snapshot() (captured []byte, truncated bool) {
+	if b == nil {
+		return nil, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed && b.sampler != nil {
+		b.sampler.flush(b)
+	}
+	b.closed = true
+	return bytes.Clone(b.buf.Bytes()), b.truncated
+}
+
+func (b *boundedBuffer) store(p []byte) {
+	if !b.tail {
+		if room := b.max - b.buf.Len(); len(p) > room {
+			p = p[:max(room, 0)]
+			b.truncated = true
+		}
+		b.buf.Write(p)
+		return
+	}
+
+	if len(p) > b.max {
+		p = p[len(p)-b.max:]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	if drop := b.buf.Len() - b.max; drop > 0 {
+		// Buffer reuses the space of consumed bytes as it grows
+		b.buf.Next(drop)
+		b.truncated = true
+	}
+}
+
+// syncWriter serializes writes to w until it is closed, after which writes
+// are dropped and w may be read without further locking.
+type syncWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+func (s *syncWriter) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+func (cm *cmdImpl) WithMaxOutput(n int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.maxOutput = n
+	cm.tailOutput = false
+	return cm
+}
+
+func (cm *cmdImpl) WithTailCapture(n int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.maxOutput = n
+	cm.tailOutput = true
+	return cm
+}
+
+// captureWriters returns the writers capturing stdout and stderr into the
+// command's buffers, bounded if a limit is set and sampled with
+// WithOutputSampling, and the combined capture if WithCombinedOutput is set.
+func (cm *cmdImpl) captureWriters() (stdout, stderr, combined *boundedBuffer) {
+	limit := cm.maxOutput
+	if limit <= 0 {
+		limit = int(^uint(0) >> 1)
+	}
+	stdout = &boundedBuffer{max: limit, tail: cm.tailOutput}
+	stderr = &boundedBuffer{max: limit, tail: cm.tailOutput}
+	if cm.sampling != nil {
+		stdout.sampler = &lineSampler{sampling: cm.sampling}
+		stderr.sampler = &lineSampler{sampling: cm.sampling}
+	}
+	if cm.combined {
+		combined = &boundedBuffer{max: limit, tail: cm.tailOutput}
+	}
+	return stdout, stderr, combined
+}
+
+func (cm *cmdImpl) WithCombinedOutput() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.combined = true
+	return cm
+}
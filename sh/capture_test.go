@@ -0,0 +1,164 @@
+package sh_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithMaxOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var streamed bytes.Buffer
+	result, err := sh.New("seq").Arg("1000").Build(ctx).
+		WithMaxOutput(10).
+		WithStdout(&streamed).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Stdout()) != "1\n2\n3\n4\n5\n" {
+		t.Errorf("Expected the first 10 bytes, got %q", result.Stdout())
+	}
+	if !result.Truncated() {
+		t.Error("Expected the result to be truncated")
+	}
+	if streamed.Len() != int(result.StdoutSize()) || streamed.Len() < 1000 {
+		t.Errorf("Expected all output to be streamed, got %d of %d bytes", streamed.Len(), result.StdoutSize())
+	}
+}
+
+func TestCmdWithTailCapture(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").OptV("-c", "seq 100000; echo done >&2").Build(ctx).
+		WithTailCapture(13).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Stdout()) != "99999\n100000\n" {
+		t.Errorf("Expected the last 13 bytes, got %q", result.Stdout())
+	}
+	if string(result.Stderr()) != "done\n" || !result.Truncated() {
+		t.Errorf("Unexpected stderr %q or truncation %v", result.Stderr(), result.Truncated())
+	}
+
+	result, _ = sh.New("echo").Arg("short").Build(ctx).WithTailCapture(100).Run()
+	if result.Truncated() {
+		t.Error("Expected short output not to be truncated")
+	}
+}
+
+func TestCmdWithCombinedOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	script := "echo out1; sleep 0.05; echo err1 >&2; sleep 0.05; echo out2"
+	result, err := sh.New("sh").OptV("-c", script).Build(ctx).WithCombinedOutput().Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Combined()) != "out1\nerr1\nout2\n" {
+		t.Errorf("Expected interleaved output, got %q", result.Combined())
+	}
+	if string(result.Stdout()) != "out1\nout2\n" || string(result.Stderr()) != "err1\n" {
+		t.Errorf("Expected separate streams to be captured too, got %q and %q", result.Stdout(), result.Stderr())
+	}
+
+	result, _ = sh.New("echo").Build(ctx).Run()
+	if result.Combined() != nil {
+		t.Errorf("Expected no combined output by default, got %q", result.Combined())
+	}
+}
+
+func TestResultConcurrentAccess(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("20000").Build(ctx).
+		WithCombinedOutput().
+		WithStdout(io.Discard)
+	cmd.Start()
+
+	var wg sync.WaitGroup
+	sizes := make([]int, 8)
+	for i := range sizes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := cmd.Wait()
+			if err != nil {
+				t.Errorf("Wait() failed: %v", err)
+				return
+			}
+			sizes[i] = len(result.Stdout()) + len(result.Combined()) + len(result.Lines())
+		}()
+	}
+	wg.Wait()
+	for _, size := range sizes[1:] {
+		if size != sizes[0] {
+			t.Fatalf("Expected every caller to see the same result, got sizes %v", sizes)
+		}
+	}
+}
+
+func TestResultIsSnapshotAtExit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The background loop keeps the output pipes open after sh exits, so
+	// Wait gives up on it after WaitDelay while it is still writing
+	script := `i=0; while [ $i -lt 200 ]; do echo $i; i=$((i+1)); sleep 0.01; done & echo started`
+	result, _ := sh.New("sh").OptV("-c", script).Build(ctx).
+		WithTimeout(10 * time.Second).
+		WithCombinedOutput().
+		Run()
+
+	stdout := bytes.Clone(result.Stdout())
+	combined := bytes.Clone(result.Combined())
+	time.Sleep(200 * time.Millisecond)
+	if !bytes.Equal(result.Stdout(), stdout) || !bytes.Equal(result.Combined(), combined) {
+		t.Error("Expected the result not to change after the command returned")
+	}
+	if !bytes.Contains(stdout, []byte("started\n")) {
+		t.Errorf("Expected the output before exit to be captured, got %q", stdout)
+	}
+}
+
+func TestResultReaders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").Arg("-c").Arg("seq 1000; echo warn >&2").Build(ctx).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	scanner := bufio.NewScanner(result.StdoutReader())
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != 1000 {
+		t.Errorf("Expected 1000 lines read, got %d", lines)
+	}
+	// Every call starts over
+	if data, _ := io.ReadAll(result.StdoutReader()); !bytes.Equal(data, result.Stdout()) {
+		t.Error("Expected a new reader to read all of stdout")
+	}
+	if data, _ := io.ReadAll(result.StderrReader()); string(data) != "warn\n" {
+		t.Errorf("Expected stderr, got %q", data)
+	}
+	if _, ok := result.StdoutReader().(io.ReaderAt); !ok {
+		t.Error("Expected the reader to support random access")
+	}
+}
@@ -0,0 +1,281 @@
+package sh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAS is a content-addressed store for artifacts such as downloaded tools
+// or command outputs. Objects are stored once per distinct content under
+// their SHA-256 digest, and an index maps human-readable names to digests.
+//
+// The on-disk layout is:
+//
+//	objects/ab/cdef...   object content, named by digest
+//	index.json           name to digest mapping
+type CAS struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// GCStats reports what a garbage collection removed.
+type GCStats struct {
+	Removed    int
+	FreedBytes int64
+	Kept       int
+	KeptBytes  int64
+}
+
+// OpenCAS opens the store rooted at dir, creating it if needed.
+func OpenCAS(dir string) (*CAS, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	return &CAS{dir: dir}, nil
+}
+
+// Put stores the content read from r and returns its digest. Content that
+// is already stored is not written again.
+func (c *CAS) Put(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "objects"), ".put*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		return "", err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	path := c.objectPath(digest)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return digest, touch(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", err
+	}
+	return digest, os.Rename(tmp.Name(), path)
+}
+
+// PutFile stores the contents of the file at path and returns its digest.
+func (c *CAS) PutFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return c.Put(f)
+}
+
+// Open opens the object with the given digest for reading.
+func (c *CAS) Open(digest string) (*os.File, error) {
+	path, ok := c.Path(digest)
+	if !ok {
+		return nil, fmt.Errorf("sh: object %s: %w", digest, os.ErrNotExist)
+	}
+	return os.Open(path)
+}
+
+// Path returns the file path of the object with the given digest and marks
+// it as recently used.
+func (c *CAS) Path(digest string) (string, bool) {
+	if !validDigest(digest) {
+		return "", false
+	}
+
+	path := c.objectPath(digest)
+	if touch(path) != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// Has reports whether an object with the given digest is stored.
+func (c *CAS) Has(digest string) bool {
+	if !validDigest(digest) {
+		return false
+	}
+	_, err := os.Stat(c.objectPath(digest))
+	return err == nil
+}
+
+// Name records name as referring to the object with the given digest,
+// replacing any previous mapping.
+func (c *CAS) Name(name, digest string) error {
+	if !c.Has(digest) {
+		return fmt.Errorf("sh: object %s: %w", digest, os.ErrNotExist)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+	index[name] = digest
+	return c.writeIndex(index)
+}
+
+// Lookup returns the digest recorded for name.
+func (c *CAS) Lookup(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, err := c.readIndex()
+	if err != nil {
+		return "", false
+	}
+	digest, ok := index[name]
+	return digest, ok
+}
+
+// GC removes objects not used within maxAge and then evicts the least
+// recently used objects until the store is no larger than maxSize bytes.
+// A zero maxAge or maxSize disables the respective limit. Names referring
+// to removed objects are dropped from the index. Files of Puts still in
+// progress are left alone; those abandoned by a crash are removed once
+// older than maxAge.
+func (c *CAS) GC(maxAge time.Duration, maxSize int64) (GCStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type object struct {
+		path string
+		size int64
+		used time.Time
+	}
+
+	var objects []object
+	var total int64
+	err := filepath.WalkDir(filepath.Join(c.dir, "objects"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".put") {
+			if maxAge > 0 && time.Since(info.ModTime()) > maxAge {
+				return os.Remove(path)
+			}
+			return nil
+		}
+		objects = append(objects, object{path: path, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return GCStats{}, err
+	}
+
+	// Least recently used first
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].used.Before(objects[j].used)
+	})
+
+	var stats GCStats
+	removed := make(map[string]bool)
+	for _, o := range objects {
+		expired := maxAge > 0 && time.Since(o.used) > maxAge
+		oversize := maxSize > 0 && total > maxSize
+		if !expired && !oversize {
+			stats.Kept++
+			stats.KeptBytes += o.size
+			continue
+		}
+
+		if err := os.Remove(o.path); err != nil {
+			return stats, err
+		}
+		total -= o.size
+		stats.Removed++
+		stats.FreedBytes += o.size
+		removed[filepath.Base(filepath.Dir(o.path))+filepath.Base(o.path)] = true
+	}
+
+	if len(removed) == 0 {
+		return stats, nil
+	}
+
+	index, err := c.readIndex()
+	if err != nil {
+		return stats, err
+	}
+	for name, digest := range index {
+		if removed[digest] {
+			delete(index, name)
+		}
+	}
+	return stats, c.writeIndex(index)
+}
+
+// validDigest reports whether digest is a SHA-256 digest in lowercase hex,
+// as returned by Put, so that it cannot name a path outside the store.
+func validDigest(digest string) bool {
+	if len(digest) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range digest {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath returns the path of the object with digest, which must be
+// valid.
+func (c *CAS) objectPath(digest string) string {
+	return filepath.Join(c.dir, "objects", digest[:2], digest[2:])
+}
+
+func (c *CAS) readIndex() (map[string]string, error) {
+	index := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(c.dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return index, json.Unmarshal(data, &index)
+}
+
+func (c *CAS) writeIndex(index map[string]string) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, "index.json"), data, 0o644)
+}
+
+// touch marks path as used now.
+func touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
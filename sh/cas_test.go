@@ -0,0 +1,140 @@
+package sh_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCASPutDedupe(t *testing.T) {
+	store, err := sh.OpenCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenCAS failed: %v", err)
+	}
+
+	first, err := store.Put(strings.NewReader("artifact"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	second, err := store.Put(strings.NewReader("artifact"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if first != second {
+		t.Errorf("Expected identical content to share a digest, got %s and %s", first, second)
+	}
+
+	f, err := store.Open(first)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "artifact" {
+		t.Errorf("Expected 'artifact', got '%s'", data)
+	}
+
+	if err := store.Name("tool/v1", first); err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+	if digest, ok := store.Lookup("tool/v1"); !ok || digest != first {
+		t.Errorf("Expected lookup to return %s, got %s", first, digest)
+	}
+
+	if err := store.Name("missing", strings.Repeat("0", 64)); err == nil {
+		t.Error("Expected error naming a missing object")
+	}
+}
+
+func TestCASGC(t *testing.T) {
+	store, err := sh.OpenCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenCAS failed: %v", err)
+	}
+
+	old, _ := store.Put(strings.NewReader("old object"))
+	recent, _ := store.Put(strings.NewReader("recent object"))
+	store.Name("old", old)
+
+	// Pretend the old object has not been used for a day
+	path, _ := store.Path(old)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(path, yesterday, yesterday)
+
+	stats, err := store.GC(time.Hour, 0)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if stats.Removed != 1 || stats.Kept != 1 {
+		t.Errorf("Expected one object removed and one kept, got %+v", stats)
+	}
+	if store.Has(old) || !store.Has(recent) {
+		t.Error("Expected only the old object to be collected")
+	}
+	if _, ok := store.Lookup("old"); ok {
+		t.Error("Expected names of collected objects to be dropped")
+	}
+
+	// A size limit evicts until the store fits
+	store.Put(strings.NewReader("another object"))
+	stats, err = store.GC(0, 1)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if stats.Kept != 0 || stats.Removed != 2 {
+		t.Errorf("Expected all objects to be evicted, got %+v", stats)
+	}
+}
+
+func TestCASRejectsInvalidDigests(t *testing.T) {
+	store, err := sh.OpenCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenCAS failed: %v", err)
+	}
+	digest, _ := store.Put(strings.NewReader("artifact"))
+
+	for _, bad := range []string{"", "ab", "../../etc/passwd", strings.ToUpper(digest), digest[:63], digest + "0"} {
+		if store.Has(bad) {
+			t.Errorf("Expected Has(%q) to be false", bad)
+		}
+		if _, ok := store.Path(bad); ok {
+			t.Errorf("Expected Path(%q) to fail", bad)
+		}
+		if _, err := store.Open(bad); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected Open(%q) to fail with ErrNotExist, got %v", bad, err)
+		}
+	}
+}
+
+func TestCASGCKeepsPutsInProgress(t *testing.T) {
+	dir := t.TempDir()
+	store, err := sh.OpenCAS(dir)
+	if err != nil {
+		t.Fatalf("OpenCAS failed: %v", err)
+	}
+
+	inProgress := filepath.Join(dir, "objects", ".put123")
+	os.WriteFile(inProgress, []byte("partial"), 0o644)
+	if _, err := store.GC(time.Hour, 1); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if _, err := os.Stat(inProgress); err != nil {
+		t.Errorf("Expected a Put in progress to be kept, got %v", err)
+	}
+
+	// One left behind by a crash is removed once older than maxAge
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(inProgress, yesterday, yesterday)
+	if _, err := store.GC(time.Hour, 0); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if _, err := os.Stat(inProgress); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the abandoned file to be removed, got %v", err)
+	}
+}
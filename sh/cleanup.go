@@ -0,0 +1,90 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// CleanupStack collects teardown actions, such as removing temporary files,
+// releasing ports or stopping background commands, and runs them in the
+// reverse order they were added, like deferred calls. Its zero value is
+// ready to use.
+type CleanupStack struct {
+	mu    sync.Mutex
+	funcs []func() error
+	done  bool
+}
+
+// Add pushes f onto the stack. Once the stack has run, f runs right away
+// instead, so that work registered late is not leaked.
+func (s *CleanupStack) Add(f func() error) *CleanupStack {
+	s.mu.Lock()
+	if !s.done {
+		s.funcs = append(s.funcs, f)
+		s.mu.Unlock()
+		return s
+	}
+	s.mu.Unlock()
+	protect(f)
+	return s
+}
+
+// Run runs the actions added so far, most recent first. Every action runs,
+// even if an earlier one failed or panicked; Run returns their errors
+// joined, with panics turned into errors.
+func (s *CleanupStack) Run() error {
+	s.mu.Lock()
+	funcs := s.funcs
+	s.funcs = nil
+	s.done = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, f := range slices.Backward(funcs) {
+		if err := protect(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type cleanupKey struct{}
+
+// WithCleanup returns a copy of parent carrying a new CleanupStack, which
+// Cleanup returns for the context and those derived from it, and a
+// function running the stack. Deferring that function runs the actions
+// when the scope ends, panics included:
+//
+//	ctx, cleanup := sh.WithCleanup(ctx)
+//	defer cleanup()
+//
+//	fwd := sh.New("kubectl").Arg("port-forward").Arg("svc/db").Arg("5432").Build(ctx)
+//	fwd.Start()
+//	sh.Cleanup(ctx).Add(func() error { fwd.Cancel(); return nil })
+func WithCleanup(parent context.Context) (ctx context.Context, cleanup func() error) {
+	s := &CleanupStack{}
+	return context.WithValue(parent, cleanupKey{}, s), s.Run
+}
+
+// Cleanup returns the CleanupStack of the innermost WithCleanup scope of
+// ctx or, outside of one, that of the Runner FromContext returns, which
+// runs on Runner.Shutdown.
+func Cleanup(ctx context.Context) *CleanupStack {
+	if s, ok := ctx.Value(cleanupKey{}).(*CleanupStack); ok {
+		return s
+	}
+	return &FromContext(ctx).cleanups
+}
+
+// Shutdown runs the actions added to the runner's CleanupStack.
+func (r *Runner) Shutdown() error {
+	return r.cleanups.Run()
+}
+
+// Shutdown runs the actions added to the CleanupStack of the package-level
+// runner, for main to defer.
+func Shutdown() error {
+	return defaultRunner.Shutdown()
+}
@@ -0,0 +1,82 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCleanupRunsInReverseOrder(t *testing.T) {
+	ctx, cleanup := sh.WithCleanup(context.Background())
+
+	var order []int
+	for i := range 3 {
+		sh.Cleanup(ctx).Add(func() error {
+			order = append(order, i)
+			return nil
+		})
+	}
+	if err := cleanup(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(order) != 3 || order[0] != 2 || order[1] != 1 || order[2] != 0 {
+		t.Errorf("Expected LIFO order, got %v", order)
+	}
+
+	// Actions added after the scope ended run right away
+	ran := false
+	sh.Cleanup(ctx).Add(func() error { ran = true; return nil })
+	if !ran {
+		t.Error("Expected a late action to run right away")
+	}
+}
+
+func TestCleanupSurvivesFailures(t *testing.T) {
+	ctx, cleanup := sh.WithCleanup(context.Background())
+
+	errRelease := errors.New("port still in use")
+	ran := false
+	sh.Cleanup(ctx).
+		Add(func() error { ran = true; return nil }).
+		Add(func() error { return errRelease }).
+		Add(func() error { panic("boom") })
+
+	err := cleanup()
+	if !ran {
+		t.Error("Expected every action to run")
+	}
+	if !errors.Is(err, errRelease) || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the error and the panic to be reported, got %v", err)
+	}
+}
+
+func TestCleanupOnPanic(t *testing.T) {
+	ran := false
+	func() {
+		defer func() { recover() }()
+		ctx, cleanup := sh.WithCleanup(context.Background())
+		defer cleanup()
+		sh.Cleanup(ctx).Add(func() error { ran = true; return nil })
+		panic("failed halfway")
+	}()
+	if !ran {
+		t.Error("Expected the scope's actions to run on panic")
+	}
+}
+
+func TestCleanupRunnerShutdown(t *testing.T) {
+	r := sh.NewRunnerWithDefaults(sh.Defaults{})
+	ctx := sh.WithRunner(context.Background(), r)
+
+	ran := false
+	sh.Cleanup(ctx).Add(func() error { ran = true; return nil })
+	if ran {
+		t.Fatal("Expected the action to wait for Shutdown")
+	}
+	if err := r.Shutdown(); err != nil || !ran {
+		t.Errorf("Expected Shutdown to run the action, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// clipboardTool is a command line tool that copies or pastes the clipboard.
+type clipboardTool struct {
+	copy  []string
+	paste []string
+}
+
+// clipboardTools lists candidate tools per platform in order of preference.
+func clipboardTools(goos string) []clipboardTool {
+	switch goos {
+	case "darwin":
+		return []clipboardTool{{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}}}
+	case "windows":
+		return []clipboardTool{{
+			copy:  []string{"clip.exe"},
+			paste: []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+		}}
+	}
+
+	var tools []clipboardTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, clipboardTool{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}})
+	}
+	return append(tools,
+		clipboardTool{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}},
+		clipboardTool{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}},
+		// Windows Subsystem for Linux
+		clipboardTool{copy: []string{"clip.exe"}, paste: []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}},
+	)
+}
+
+// clipboardCommand returns a builder for the first installed tool that
+// copies to (paste false) or pastes from (paste true) the clipboard.
+func clipboardCommand(paste bool) (*Builder, error) {
+	for _, tool := range clipboardTools(runtime.GOOS) {
+		argv := tool.copy
+		if paste {
+			argv = tool.paste
+		}
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			continue
+		}
+
+		b := New(argv[0])
+		for _, arg := range argv[1:] {
+			b.Arg(arg)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("sh: no clipboard tool found: %w", errors.ErrUnsupported)
+}
+
+// ClipboardCopier returns a builder for the platform's clipboard copy tool
+// (pbcopy, wl-copy, xclip, xsel or clip.exe), which copies its stdin to the
+// clipboard. If none is installed the error matches errors.ErrUnsupported.
+func ClipboardCopier() (*Builder, error) {
+	return clipboardCommand(false)
+}
+
+// ClipboardPaster returns a builder for the platform's clipboard paste tool,
+// which writes the clipboard to its stdout. Use it as the first stage of a
+// pipeline to process the clipboard contents.
+func ClipboardPaster() (*Builder, error) {
+	return clipboardCommand(true)
+}
+
+// ToClipboard copies everything read from r to the clipboard.
+func ToClipboard(ctx context.Context, r io.Reader) error {
+	b, err := ClipboardCopier()
+	if err != nil {
+		return err
+	}
+	_, err = b.Build(ctx).WithStdin(r).Run()
+	return err
+}
+
+// FromClipboard returns the contents of the clipboard.
+func FromClipboard(ctx context.Context) ([]byte, error) {
+	b, err := ClipboardPaster()
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.Build(ctx).Run()
+	if err != nil {
+		return nil, err
+	}
+	return result.Stdout(), nil
+}
+
+// PipeToClipboard adds a pipeline stage after cmd that copies cmd's stdout
+// to the clipboard.
+func PipeToClipboard(cmd Cmd) (Cmd, error) {
+	b, err := ClipboardCopier()
+	if err != nil {
+		return nil, err
+	}
+
+	items := b.Items()
+	pb := cmd.Pipe(items[0])
+	for _, arg := range items[1:] {
+		pb.Arg(arg)
+	}
+	return pb.Build(), nil
+}
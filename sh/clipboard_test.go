@@ -0,0 +1,80 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// fakeClipboard installs an xclip stand-in on PATH backed by a file.
+func fakeClipboard(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store := filepath.Join(dir, "clipboard")
+	script := `#!/bin/sh
+if [ "$3" = "-o" ]; then exec cat ` + store + `; fi
+exec cat > ` + store + `
+`
+	if err := os.WriteFile(filepath.Join(dir, "xclip"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestClipboard(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("Uses a fake xclip")
+	}
+	fakeClipboard(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sh.ToClipboard(ctx, strings.NewReader("copied text")); err != nil {
+		t.Fatalf("ToClipboard failed: %v", err)
+	}
+	data, err := sh.FromClipboard(ctx)
+	if err != nil {
+		t.Fatalf("FromClipboard failed: %v", err)
+	}
+	if string(data) != "copied text" {
+		t.Errorf("Expected 'copied text', got %q", data)
+	}
+
+	cmd, err := sh.PipeToClipboard(sh.New("echo").Arg("from a pipeline").Build(ctx))
+	if err != nil {
+		t.Fatalf("PipeToClipboard failed: %v", err)
+	}
+	if _, err := cmd.Run(); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	paster, _ := sh.ClipboardPaster()
+	result, err := paster.Build(ctx).Pipe("tr").Arg("a-z").Arg("A-Z").Build().Run()
+	if err != nil {
+		t.Fatalf("Paste pipeline failed: %v", err)
+	}
+	if string(result.Stdout()) != "FROM A PIPELINE\n" {
+		t.Errorf("Expected the piped text, got %q", result.Stdout())
+	}
+}
+
+func TestClipboardUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("Platform tools are always present")
+	}
+	t.Setenv("PATH", t.TempDir())
+
+	if _, err := sh.FromClipboard(context.Background()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected errors.ErrUnsupported, got %v", err)
+	}
+}
@@ -3,41 +3,46 @@ package sh
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"iter"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/benoctopus/pkg/future"
 )
 
-var (
-	stdout io.Writer = os.Stdout
-	stderr io.Writer = os.Stderr
-	stdin  io.Reader = os.Stdin
-)
-
-// SetDefaultStdout sets the default stdout writer for all commands.
-// If w is nil, the default stdout is not changed.
+// SetDefaultStdout sets the default stdout writer for all commands not
+// built by a Runner. If w is nil, the default stdout is not changed.
 func SetDefaultStdout(w io.Writer) {
 	if w != nil {
-		stdout = w
+		defaultRunner.setStdout(w)
 	}
 }
 
-// SetDefaultStderr sets the default stderr writer for all commands.
-// If w is nil, the default stderr is not changed.
+// SetDefaultStderr sets the default stderr writer for all commands not
+// built by a Runner. If w is nil, the default stderr is not changed.
 func SetDefaultStderr(w io.Writer) {
 	if w != nil {
-		stderr = w
+		defaultRunner.setStderr(w)
 	}
 }
 
-// SetDefaultStdin sets the default stdin reader for all commands.
-// If r is nil, the default stdin is not changed.
+// SetDefaultStdin sets the default stdin reader for all commands not built
+// by a Runner. If r is nil, the default stdin is not changed.
 func SetDefaultStdin(r io.Reader) {
 	if r != nil {
-		stdin = r
+		defaultRunner.setStdin(r)
 	}
 }
 
@@ -54,18 +59,362 @@ type Cmd interface {
 	WithStdout(stdout io.Writer) Cmd
 	// WithStdin sets the stdin reader for the command.
 	WithStdin(stdin io.Reader) Cmd
-	// WithEnv sets an environment variable for the command.
+	// WithLogStream copies stdout and stderr to ls, for watchers following
+	// the output while the command runs, and closes ls once it finished.
+	WithLogStream(ls *LogStream) Cmd
+	// WithStdinString sets the command's stdin to s.
+	WithStdinString(s string) Cmd
+	// WithStdinBytes sets the command's stdin to b.
+	WithStdinBytes(b []byte) Cmd
+	// WithStdinJSON sets the command's stdin to the JSON encoding of v.
+	// Errors encoding v are returned when the command runs.
+	WithStdinJSON(v any) Cmd
+	// WithEnv sets an environment variable for the command. The command
+	// still inherits the rest of the environment.
 	WithEnv(key, value string) Cmd
+	// WithEnvMap sets all variables in env, like WithEnv.
+	WithEnvMap(env map[string]string) Cmd
+	// WithEnvFile sets the variables defined in the dotenv file at path,
+	// like WithEnv. Errors reading the file are returned when the command
+	// runs, as a StartError.
+	WithEnvFile(path string) Cmd
+	// WithoutEnv removes key from the command's environment, whether it is
+	// inherited or was set before.
+	WithoutEnv(key string) Cmd
+	// WithEnvInherit controls whether the command inherits the environment
+	// of the current process, which it does by default. Without
+	// inheritance only variables set on the command are passed.
+	WithEnvInherit(inherit bool) Cmd
+	// WithMeta attaches an arbitrary annotation such as a team, step name or
+	// cost center to the command. Metadata is not passed to the process; it
+	// is carried on the Result and on records written to a StateStore.
+	WithMeta(key, value string) Cmd
+	// Meta returns a copy of the metadata attached with WithMeta.
+	Meta() map[string]string
+	// Name returns the name given with Builder.Name, or the executable as
+	// given if there is none.
+	Name() string
+	// Group returns the group given with Builder.Group, if any.
+	Group() string
+	// WithLabeledOutput copies stdout and stderr line by line to the
+	// default streams, each line prefixed with the command's Name and
+	// Group, e.g. "release/migrate-db | ", so that the output of commands
+	// running side by side can be told apart.
+	WithLabeledOutput() Cmd
+	// WithScrubbedEnv starts the command from an empty environment that only
+	// keeps the host variables matching keep (path.Match patterns such as
+	// "LC_*"). Variables set with WithEnv are always passed.
+	WithScrubbedEnv(keep ...string) Cmd
+	// WithParentDeathSignal asks the kernel to deliver sig to the command if
+	// the current process dies, so children do not outlive a crashed parent.
+	// On Linux the goroutine waiting for the command holds an OS thread to
+	// itself until the command exits, since the kernel signals the command
+	// when the thread that started it ends. On Unix systems other than
+	// Linux a watchdog process started along with the command sends sig
+	// instead. Not supported on Windows, where the command fails to start.
+	WithParentDeathSignal(sig os.Signal) Cmd
+	// WithPidRegistry records the command's PID in r while it is running so
+	// a later run can reap it with PidRegistry.ReapOrphans after a crash.
+	WithPidRegistry(r *PidRegistry) Cmd
+	// WithPidFile writes the command's PID to path once it has started and
+	// removes the file when it exits. Starting fails with ErrAlreadyRunning
+	// if path names a process that is still running; stale files, and
+	// files naming a PID since reused by a newer process, are replaced.
+	// Concurrent starts are serialized with a lock on path+".lock", which
+	// is left in place.
+	WithPidFile(path string) Cmd
+	// WithDropPrivileges runs the command as the named user with that user's
+	// groups, a minimal environment (HOME, USER, LOGNAME, PATH plus variables
+	// set with WithEnv) and no inherited capabilities. The current process
+	// must be privileged to switch users. Not supported on Windows.
+	WithDropPrivileges(username string) Cmd
+	// WithElevation runs the command as administrator. On Unix it runs
+	// through sudo unless the process already is root, asking for the
+	// password up front; variables set with WithEnv are preserved where
+	// the sudo policy allows. On Windows the command is started with the
+	// UAC "runas" prompt, without a console window and without capturing
+	// output. If the user declines, the command fails with
+	// ErrElevationDenied.
+	WithElevation() Cmd
+	// WithOverlay runs the command over a copy-on-write view of dir: it
+	// sees and may change the files in dir, but its changes land in a
+	// temporary directory rather than dir itself and are reported by
+	// Result.Changes, to preview what a script would do. Other processes
+	// see dir unchanged. The view is an overlayfs mount in a private mount
+	// namespace, which needs root or, for other users, unprivileged user
+	// namespaces; the command then runs as root of a user namespace and
+	// can only change files owned by the current user and group. The
+	// mount(8) tool must be installed. Only supported on Linux.
+	WithOverlay(dir string) Cmd
+	// WithFSDiff snapshots the files under root, relative to the working
+	// directory, before and after the command runs and reports the paths
+	// it created, modified or deleted on Result.Changes, e.g. to audit
+	// what a tool touched. Unlike WithOverlay the changes are real and it
+	// works everywhere. Files count as modified when their contents, mode
+	// or link target changed, so files that were only touched are not
+	// reported; all files under root are read twice.
+	WithFSDiff(root string) Cmd
+	// WithNetworkLog records the remote hosts and ports the command and its
+	// descendants connect to over TCP or UDP, reported by
+	// Result.Connections, e.g. for egress audits of build tools. Sockets
+	// are sampled every few milliseconds from /proc, so connections opened
+	// and closed between samples can be missed; it is a record, not a
+	// firewall. Only supported on Linux.
+	WithNetworkLog() Cmd
+	// WithAmbientCaps grants the command the given Linux capabilities as
+	// ambient capabilities, e.g. CapNetBindService to let an unprivileged
+	// child bind ports below 1024. The current process must hold the
+	// capabilities itself. On other platforms the command fails to start.
+	WithAmbientCaps(caps ...Capability) Cmd
+	// WithTimeout stops the command if it is still running after d. The
+	// command then fails with an error matching context.DeadlineExceeded.
+	WithTimeout(d time.Duration) Cmd
+	// WithGracePeriod makes timeouts and Cancel stop the command gently:
+	// the stop signal (SIGTERM by default) is sent to the command's whole
+	// process group, and anything still running after d is killed. On
+	// Windows the group is sent a CTRL_BREAK event instead, which console
+	// programs handle like ^C.
+	WithGracePeriod(d time.Duration) Cmd
+	// WithStopSignal sets the signal sent to the command's process group
+	// when it times out or is cancelled, before the grace period starts.
+	// On Windows only os.Kill makes a difference, skipping CTRL_BREAK.
+	WithStopSignal(sig os.Signal) Cmd
+	// WithProcessGroup runs the command in its own process group, so
+	// Signal reaches the command and all of its children. The command then
+	// no longer receives signals sent to the terminal's foreground group,
+	// such as ^C; forward them with ForwardSignals. On Windows the command
+	// gets its own console process group, Signal(os.Interrupt) sends it a
+	// CTRL_BREAK event and Signal(os.Kill) terminates the command.
+	WithProcessGroup() Cmd
+	// Signal sends sig to the running command, or to its whole process
+	// group when WithProcessGroup is set. It returns ErrNotStarted before
+	// the command has started and os.ErrProcessDone once it has exited.
+	Signal(sig os.Signal) error
+	// WithDryRun skips running the command. Its Result succeeds and
+	// records the Invocation; see SetDryRun.
+	WithDryRun() Cmd
+	// WithMiddleware wraps every run of the command in mw, inside any
+	// middleware registered with Use.
+	WithMiddleware(mw ...Middleware) Cmd
+	// WithLogger logs the command's start, exit and cancellation to l,
+	// instead of the logger set with SetLogger. A nil l disables logging
+	// for the command.
+	WithLogger(l *slog.Logger, opts ...LogOption) Cmd
+	// WithSecret marks value, such as a token passed as an argument, as
+	// secret: wherever it appears it is shown as [REDACTED] by String, in
+	// logs, traces and spans, and with WithOutputRedaction in the captured
+	// output. The command itself still receives it.
+	WithSecret(value string) Cmd
+	// WithSecretEnv sets an environment variable like WithEnv and marks
+	// its value as secret like WithSecret.
+	WithSecretEnv(key, value string) Cmd
+	// WithOutputRedaction masks the values marked with WithSecret in the
+	// stdout, stderr and combined output captured in the Result, for
+	// commands that echo their credentials. Writers added with WithStdout
+	// and WithStderr, Lines and Events still receive the output as is.
+	WithOutputRedaction() Cmd
 	// WithDir sets the working directory for the command.
 	WithDir(dir string) Cmd
+	// WithBinaryOutput marks stdout as opaque binary data. When digest is
+	// non-nil stdout is not kept in memory at all: only its size and digest
+	// are recorded on the Result, while writers added with WithStdout still
+	// receive the full stream. Such commands always run, even when given a
+	// cache with WithCache, as there would be no output to replay.
+	WithBinaryOutput(digest hash.Hash) Cmd
+	// WithMaxOutput keeps only the first n bytes of stdout and of stderr
+	// in the Result; the rest is still written to writers added with
+	// WithStdout and WithStderr. Result.Truncated reports the loss.
+	WithMaxOutput(n int) Cmd
+	// WithTailCapture is like WithMaxOutput but keeps the last n bytes,
+	// as a rolling window over the output.
+	WithTailCapture(n int) Cmd
+	// WithOutputSampling bounds the captured output of noisy commands:
+	// after the first lines of stdout and of stderr (see SampleAfter), only
+	// every rate-th line is kept in the Result, plus all lines matching the
+	// keep pattern, which by default looks for errors and warnings (see
+	// SampleKeep). Writers added with WithStdout and WithStderr still
+	// receive every line. Result.Truncated reports that lines were dropped.
+	WithOutputSampling(rate int, opts ...SamplingOption) Cmd
+	// WithCombinedOutput additionally captures stdout and stderr
+	// interleaved in arrival order, as a terminal would show them; see
+	// Result.Combined.
+	WithCombinedOutput() Cmd
+	// WithReadBufferSize reads stdout and stderr from the child in chunks
+	// of up to n bytes instead of the 32 KiB os/exec uses, for children
+	// writing hundreds of megabytes per second, where the read loop is the
+	// bottleneck. It is experimental; the benchmarks in readbuffer_test.go
+	// show when it pays off.
+	WithReadBufferSize(n int) Cmd
+	// WithPipeBufferSize grows the kernel buffers of the pipes the command
+	// writes its stdout and stderr to, and reads from its upstream stage
+	// in a pipeline, to n bytes, and reads the output n bytes at a time
+	// like WithReadBufferSize. Larger pipes let fast producers run ahead
+	// with fewer context switches. Only Linux can resize pipes, as far as
+	// /proc/sys/fs/pipe-max-size allows, 1 MiB by default without
+	// privileges; elsewhere only the reads grow.
+	WithPipeBufferSize(n int) Cmd
+	// WithStdoutFile streams the command's stdout to the file at path in
+	// addition to any other writers. The file is created or truncated
+	// before the command starts and closed once it has exited; see
+	// WithAppend, WithFileMode and WithAtomicRename. With WithAllStages
+	// the stdout of every stage of the pipeline ending in the command goes
+	// to the file.
+	WithStdoutFile(path string, opts ...FileOption) Cmd
+	// WithStderrFile is like WithStdoutFile for the command's stderr, e.g.
+	// to keep a log of each build step.
+	WithStderrFile(path string, opts ...FileOption) Cmd
+	// WithStdoutAt writes the command's stdout to w starting at offset off,
+	// in addition to any other writers, e.g. into a region of a
+	// preallocated file or disk image.
+	WithStdoutAt(w io.WriterAt, off int64) Cmd
 	// WithInteractive configures the command for interactive use with default I/O.
+	// If stdin is a terminal, its state is saved and restored when the
+	// command exits, even if the command crashed in raw or no-echo mode;
+	// see SaveTerminal.
 	WithInteractive() Cmd
+	// WithControllingTerminal allocates a pseudo-terminal that becomes the
+	// command's controlling terminal (/dev/tty) while stdin, stdout and stderr
+	// keep their configured pipes. The terminal is bridged to tty; a nil tty
+	// uses the controlling terminal of the current process. A tty that is a
+	// terminal is switched to raw mode while the command runs, so prompts
+	// with echo off do not echo and ^C reaches the command. tty must have a
+	// SetReadDeadline method, as files and network connections do, so that
+	// reading it stops when the command exits; otherwise the command fails
+	// to start.
+	WithControllingTerminal(tty io.ReadWriter) Cmd
+	// WithPTY runs the command on a new pseudo-terminal, so it behaves as
+	// when started from an interactive shell: stdin, stdout and stderr are
+	// all the terminal and stderr is merged into stdout. Configured stdin is
+	// fed to the terminal; when it is itself a terminal (see
+	// WithInteractive) it is switched to raw mode and window size changes
+	// are forwarded while the command runs. Only supported on Linux.
+	WithPTY() Cmd
+	// WithPager shows the command's stdout through the user's pager when
+	// the default stdout is a terminal, the way git does: $PAGER is used,
+	// falling back to "less -R", and less exits right away if the output
+	// fits on one screen. Otherwise stdout is written to the default
+	// stdout. See Page.
+	WithPager() Cmd
+	// WithStateStore records the outcome and duration of every run of the
+	// command in s under key. An empty key defaults to the command's Name
+	// if it was given one, or else to its Fingerprint.
+	WithStateStore(s *StateStore, key string) Cmd
+	// WithAttempt marks the run as the nth attempt at the same work, for
+	// commands the caller retries. The StateStore records it, so that its
+	// Report can tell which commands only pass when retried.
+	WithAttempt(n int) Cmd
+	// WithApproval makes the command refuse to run with ErrNotApproved
+	// unless a Plan of it was approved with Approve, for destructive
+	// commands that a person should review first. The approval is used up
+	// once the command is about to start; dry runs and results replayed
+	// from a cache leave it.
+	WithApproval() Cmd
+	// Plan returns the signed intent to run the command, to be reviewed
+	// and passed to Approve. Changing the command afterwards invalidates
+	// the approval.
+	Plan() Plan
+	// WithCache skips running the command if an earlier successful run with
+	// the same command line, variables set with WithEnv, stdin and input
+	// files is recorded in c, replaying its output and exit code instead.
+	// Inputs are files or directories whose contents are hashed; successful
+	// runs are stored in c. Inherited variables are not part of the key.
+	// Only stdin set with WithStdinString or WithStdinBytes is hashed; a
+	// command reading any other stdin, such as a stage of a pipe, always
+	// runs.
+	WithCache(c Cache, inputs ...string) Cmd
+	// WithReadyPattern makes the command ready once a line of its stdout or
+	// stderr matches re, e.g. "listening on port".
+	WithReadyPattern(re *regexp.Regexp) Cmd
+	// WithReadyCheck makes the command ready once check succeeds. The check
+	// is polled at the given interval after the command has started.
+	WithReadyCheck(check func(ctx context.Context) error, interval time.Duration) Cmd
+	// Ready returns a channel that is closed once the command is ready.
+	// Without a ready pattern or check a command is ready as soon as it
+	// has started.
+	Ready() <-chan struct{}
+	// StartAfter delays running the command until upstream is ready,
+	// starting upstream if needed. If upstream exits without becoming ready
+	// the command fails with ErrNotReady.
+	StartAfter(upstream Cmd) Cmd
+	// Lines returns the command's stdout as a sequence of lines, delivered
+	// while the process is still running. Ranging over the sequence starts
+	// the command if it was not already. Trailing "\r" is removed. The
+	// sequence ends when the command exits; call Wait for the final
+	// Result. Lines must be called before the command is started.
+	// Commands in binary output mode yield no lines.
+	Lines() iter.Seq[string]
+	// StderrLines is like Lines for the command's stderr.
+	StderrLines() iter.Seq[string]
+	// ExtractAsync returns a Future resolved with the named capture groups
+	// of the first line of stdout or stderr matching re, while the command
+	// keeps running. It fails with ErrNoMatch if the command exits without
+	// a match. ExtractAsync must be called before the command is started
+	// and does not start it.
+	ExtractAsync(re *regexp.Regexp) future.Future[map[string]string]
+	// TryWait returns the command's Result and true if it has finished,
+	// or false without blocking while it is still running, for polling
+	// from an event loop. Wait returns the error without blocking once
+	// TryWait has succeeded. TryWait does not start the command.
+	TryWait() (Result, bool)
+	// Events returns a channel delivering what happens to the command for
+	// use in a select statement: EventStarted, a line event for every line
+	// of stdout and stderr and finally EventExited, after which the
+	// channel is closed. Events are queued rather than dropped, so a slow
+	// receiver never stalls the command, unless WithBackpressure says
+	// otherwise; receive until the channel is closed. Events must be
+	// called before the command is started and does not start it.
+	Events() <-chan Event
+	// WithBackpressure sets how consumers subscribed afterwards with Lines,
+	// StderrLines and Events cope with falling behind: up to buffer lines
+	// (DefaultLineBuffer if not positive) wait for the consumer, and then
+	// policy decides whether to block the command or drop lines. By
+	// default Lines blocks after 64 lines and Events queues without bound.
+	WithBackpressure(policy Backpressure, buffer int) Cmd
+	// WithStdoutSink writes stdout to w line by line from a separate
+	// goroutine, so that a slow w, such as a log shipper, affects the
+	// command only as policy says once buffer lines are waiting. All
+	// lines not dropped have been written when the command finishes; an
+	// error writing to w fails the command.
+	WithStdoutSink(w io.Writer, policy Backpressure, buffer int) Cmd
+	// WithStderrSink is like WithStdoutSink for the command's stderr.
+	WithStderrSink(w io.Writer, policy Backpressure, buffer int) Cmd
+	// ToExecCmd returns an unstarted exec.Cmd running the command with its
+	// context, arguments, working directory, environment, stdin and the
+	// writers added with WithStdout and WithStderr, for APIs that need an
+	// exec.Cmd. Everything else, such as capturing, middleware, hooks and
+	// any commands piped into it, is left out. See FromExecCmd for the
+	// other direction.
+	ToExecCmd() *exec.Cmd
 	// Pipe creates a pipe builder that will pipe this command's stdout
-	// to the stdin of the specified command.
+	// to the stdin of the specified command. Both commands run
+	// concurrently; see Result.PipeStatus for per-stage exit codes. The
+	// stdout of this command goes to the next one only, so its own
+	// Result.Stdout is empty unless it is bounded with WithMaxOutput or
+	// WithTailCapture, or given a cache with WithCache.
 	Pipe(cmd string) *PipeBuilder
+	// String renders the command as it would be typed in a POSIX shell,
+	// with arguments quoted where needed.
+	String() string
 	// Start begins the command execution asynchronously.
 	// Returns a Future that can be used to wait for completion.
 	Run() (Result, error)
+	// MustRun runs the command and returns its Result, panicking with an
+	// error that includes the command's stderr if it fails. It is meant
+	// for scripts and tests, where failure should stop everything.
+	MustRun() Result
+	// RunOk runs the command and reports whether it succeeded.
+	RunOk() bool
+	// ExpectExit makes the listed exit codes count as success besides 0,
+	// e.g. 1 for grep finding no match: Run, Wait and Result.Check return
+	// no error for them, while Result.ExitCode still reports the code.
+	ExpectExit(codes ...int) Cmd
+	// SuccessWhen makes the command fail unless check returns nil for its
+	// Result, for tools that exit with 0 after failing, e.g. by printing
+	// "ERROR:". Run, Wait and Result.Check then return an error matching
+	// ErrUnsuccessful and wrapping the one returned by check, so retries,
+	// Groups and everything else checking errors treat the run as failed.
+	// check only runs for commands that exited successfully.
+	SuccessWhen(check func(Result) error) Cmd
 }
 
 // Context is an alias for context.Context for convenience.
@@ -73,16 +422,63 @@ type Context = context.Context
 
 type cmdImpl struct {
 	parent       Cmd
+	pipeReader   *os.File                   // read end of the pipe from parent, if piped
+	pipeWriter   *os.File                   // write end of the pipe from parent, if piped
+	pipeClosers  []func(runErr error) error // run once every stage has exited
 	cmd          string
 	ctx          Context
 	args         []string
 	env          map[string]string
-	stdoutBuffer *bytes.Buffer
-	stderrBuffer *bytes.Buffer
-	stdout       io.Writer
-	stderr       io.Writer
+	meta         map[string]string
+	name         string // set with Builder.Name
+	group        string // set with Builder.Group
+	attempt      int    // set with WithAttempt
+	scrubEnv     bool
+	envNoInherit bool
+	envUnset     []string
+	envKeep      []string
+	stdout       io.Writer // additional stdout sinks, if any
+	stderr       io.Writer // additional stderr sinks, if any
 	stdin        io.Reader
 	dir          string
+	hooks        []execHook
+	binary       bool
+	digest       hash.Hash
+	upstreams    []Cmd
+	cache        Cache
+	cacheInputs  []string
+	cacheKeyHash string
+	readyGated   bool
+	ready        chan struct{}
+	readyOnce    sync.Once
+	timeout      time.Duration
+	gracePeriod  time.Duration
+	stopSignal   os.Signal
+	processGroup bool
+	dryRun       bool
+	pipeOut      *os.File // write end of the pipe to the command built with Pipe
+	middleware   []Middleware
+	runner       *Runner
+	maxOutput    int
+	tailOutput   bool
+	combined     bool
+	sampling     *sampling
+	backpressure Backpressure // for Lines, StderrLines and Events
+	lineBuffer   int
+	log          *logConfig
+	process      *os.Process
+	changes      []FileChange // recorded by WithOverlay
+	okCodes      []int        // exit codes accepted with ExpectExit
+	connections  []Connection // recorded by WithNetworkLog
+	secrets      []string     // marked with WithSecret, longest first
+	redactor     *strings.Replacer
+	redactOutput bool
+	success      []func(Result) error
+	arg0         string
+	buildErr     error
+	finally      []func()
+	approval     bool     // set by WithApproval
+	planTags     []string // options changing what runs, for planFingerprint
 
 	// Future implementation fields
 	result Result
@@ -93,22 +489,152 @@ type cmdImpl struct {
 	mu     sync.RWMutex
 }
 
+// execHook adjusts the underlying exec.Cmd before it is started and is
+// notified once it has started and exited. Any of the functions may be nil.
+// after is passed the error cmd.Wait returned; finished runs once every
+// after function has, with the final error of the command, which is nil
+// for exit codes accepted with ExpectExit and reports failed SuccessWhen
+// checks.
+type execHook struct {
+	before   func(cmd *exec.Cmd) error
+	started  func(cmd *exec.Cmd) error
+	after    func(runErr error) error
+	finished func(err error) error
+}
+
 // PipeBuilder is used to construct command pipes where the output
 // of one command becomes the input of another.
 type PipeBuilder struct {
-	from *cmdImpl
+	from      *cmdImpl
+	overrides []func(cm *cmdImpl)
+	tees      []io.Writer
 	*Builder
 }
 
 // Build constructs the piped command with the source command's stdout
-// connected to this command's stdin.
+// connected to this command's stdin. Both commands run concurrently and
+// output is streamed through an OS pipe as it is produced.
+//
+// Like the stages of a shell pipeline, the command inherits the working
+// directory and environment of the source command. Settings made with the
+// PipeBuilder's WithEnv, WithoutEnv and WithDir, or on the returned Cmd,
+// apply to this stage only.
 func (pb *PipeBuilder) Build() Cmd {
 	cm := pb.Builder.Build(pb.from.ctx).(*cmdImpl)
 	cm.parent = pb.from
+	cm.inheritEnv(pb.from)
+	for _, override := range pb.overrides {
+		override(cm)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		cm.hooks = append(cm.hooks, execHook{
+			before: func(*exec.Cmd) error { return err },
+		})
+		return cm
+	}
+
+	for _, tee := range pb.tees {
+		pb.from.WithStdout(&teeWriter{w: tee})
+	}
+	pb.from.mu.Lock()
+	pb.from.pipeOut = w
+	pb.from.mu.Unlock()
+	cm.stdin = r
+	cm.pipeReader = r
+	cm.pipeWriter = w
+	cm.hooks = append(cm.hooks, execHook{
+		// The child holds its own copy of the read end; dropping ours lets
+		// the source see a broken pipe if the child exits early
+		started: func(*exec.Cmd) error { return r.Close() },
+	})
 
 	return cm
 }
 
+// inheritEnv copies the working directory and environment settings of the
+// source stage src, along with its secrets, since variables set with
+// WithSecretEnv are passed on too.
+func (cm *cmdImpl) inheritEnv(src *cmdImpl) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	cm.dir = src.dir
+	cm.env = maps.Clone(src.env)
+	cm.envUnset = slices.Clone(src.envUnset)
+	cm.envNoInherit = src.envNoInherit
+	cm.scrubEnv = src.scrubEnv
+	cm.envKeep = slices.Clone(src.envKeep)
+	for _, secret := range src.secrets {
+		cm.addSecret(secret)
+	}
+	cm.redactOutput = cm.redactOutput || src.redactOutput
+}
+
+// WithEnv sets an environment variable for this stage only and returns the
+// PipeBuilder.
+func (pb *PipeBuilder) WithEnv(key, value string) *PipeBuilder {
+	pb.overrides = append(pb.overrides, func(cm *cmdImpl) { cm.setEnv(key, value) })
+	return pb
+}
+
+// WithoutEnv removes an environment variable inherited from the source stage
+// or the current process for this stage only and returns the PipeBuilder.
+func (pb *PipeBuilder) WithoutEnv(key string) *PipeBuilder {
+	pb.overrides = append(pb.overrides, func(cm *cmdImpl) { cm.WithoutEnv(key) })
+	return pb
+}
+
+// WithDir sets the working directory of this stage only and returns the
+// PipeBuilder.
+func (pb *PipeBuilder) WithDir(dir string) *PipeBuilder {
+	pb.overrides = append(pb.overrides, func(cm *cmdImpl) { cm.dir = dir })
+	return pb
+}
+
+// TeeTo copies the data flowing from the source command into this stage to
+// w as well, as it flows, e.g. to see what an intermediate stage of a long
+// pipeline produces while debugging it. Errors writing to w are ignored, so
+// that w cannot break the pipeline, but a slow w holds the data up.
+func (pb *PipeBuilder) TeeTo(w io.Writer) *PipeBuilder {
+	pb.tees = append(pb.tees, w)
+	return pb
+}
+
+// teeWriter writes to w until a write fails, then discards what follows.
+type teeWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if !t.failed {
+		if _, err := t.w.Write(p); err != nil {
+			t.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// OptIf adds a boolean flag to the pipe command if cond is true and returns the PipeBuilder.
+func (pb *PipeBuilder) OptIf(cond bool, flag string) *PipeBuilder {
+	pb.Builder.OptIf(cond, flag)
+	return pb
+}
+
+// OptVIf adds a flag with a value to the pipe command if cond is true and returns the PipeBuilder.
+func (pb *PipeBuilder) OptVIf(cond bool, flag string, value any) *PipeBuilder {
+	pb.Builder.OptVIf(cond, flag, value)
+	return pb
+}
+
+// ArgsFromStruct adds the options described by the tagged fields of v to the
+// pipe command and returns the PipeBuilder. See Builder.ArgsFromStruct.
+func (pb *PipeBuilder) ArgsFromStruct(v any) *PipeBuilder {
+	pb.Builder.ArgsFromStruct(v)
+	return pb
+}
+
 // OptB adds a boolean flag to the pipe command and returns the PipeBuilder.
 func (pb *PipeBuilder) OptB(flag string) *PipeBuilder {
 	pb.Builder.OptB(flag)
@@ -121,6 +647,24 @@ func (pb *PipeBuilder) OptV(flag string, value any) *PipeBuilder {
 	return pb
 }
 
+// OptVs repeats flag for each value on the pipe command and returns the PipeBuilder.
+func (pb *PipeBuilder) OptVs(flag string, values ...any) *PipeBuilder {
+	pb.Builder.OptVs(flag, values...)
+	return pb
+}
+
+// OptEq adds a flag=value option to the pipe command and returns the PipeBuilder.
+func (pb *PipeBuilder) OptEq(flag string, value any) *PipeBuilder {
+	pb.Builder.OptEq(flag, value)
+	return pb
+}
+
+// OptSep adds a flag with a sep-joined list to the pipe command and returns the PipeBuilder.
+func (pb *PipeBuilder) OptSep(flag, sep string, values ...any) *PipeBuilder {
+	pb.Builder.OptSep(flag, sep, values...)
+	return pb
+}
+
 // Arg adds a positional argument to the pipe command and returns the PipeBuilder.
 func (pb *PipeBuilder) Arg(value string) *PipeBuilder {
 	pb.Builder.Arg(value)
@@ -131,7 +675,8 @@ func (cm *cmdImpl) Pipe(cmd string) *PipeBuilder {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	b := New(cmd)
+	// Later stages share the runner of the first
+	b := cm.runner.New(cmd)
 
 	return &PipeBuilder{
 		from:    cm,
@@ -142,14 +687,22 @@ func (cm *cmdImpl) Pipe(cmd string) *PipeBuilder {
 func (cm *cmdImpl) WithStderr(stderr io.Writer) Cmd {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	cm.stderr = io.MultiWriter(cm.stderr, stderr)
+	cm.stderr = appendWriter(cm.stderr, stderr)
 	return cm
 }
 
 func (cm *cmdImpl) WithStdout(stdout io.Writer) Cmd {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	cm.stdout = io.MultiWriter(cm.stdout, stdout)
+	cm.stdout = appendWriter(cm.stdout, stdout)
+	return cm
+}
+
+func (cm *cmdImpl) WithBinaryOutput(digest hash.Hash) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.binary = true
+	cm.digest = digest
 	return cm
 }
 
@@ -163,10 +716,23 @@ func (cm *cmdImpl) WithStdin(stdin io.Reader) Cmd {
 func (cm *cmdImpl) WithInteractive() Cmd {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	cm.stdin = stdin
-	// Use MultiWriter to write to both the buffer and the default stdout/stderr
-	cm.stdout = io.MultiWriter(cm.stdoutBuffer, stdout)
-	cm.stderr = io.MultiWriter(cm.stderrBuffer, stderr)
+	// Output is still captured; the defaults are written in addition
+	cm.stdin, cm.stdout, cm.stderr = cm.runner.stdio()
+	cm.hooks = append(cm.hooks, terminalStateHook())
+	return cm
+}
+
+func (cm *cmdImpl) WithControllingTerminal(tty io.ReadWriter) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.hooks = append(cm.hooks, controllingTerminalHook(tty))
+	return cm
+}
+
+func (cm *cmdImpl) WithPTY() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.hooks = append(cm.hooks, ptyHook())
 	return cm
 }
 
@@ -180,29 +746,135 @@ func (cm *cmdImpl) WithDir(dir string) Cmd {
 func (cm *cmdImpl) WithEnv(key, value string) Cmd {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	if cm.env == nil {
-		cm.env = make(map[string]string)
-	}
-	cm.env[key] = value
+	cm.setEnv(key, value)
 	return cm
 }
 
 // Result represents the result of a command execution.
 // It provides access to the exit code and captured output.
+//
+// A Result is a snapshot taken when the command exits: output arriving
+// later, e.g. from a background process still holding on to the pipes, is
+// not added to it. It is safe for concurrent use, and the slices it returns
+// are shared between callers, so they must not be modified.
 type Result interface {
 	// ExitCode returns the exit code of the command.
 	// Returns 0 for successful execution, non-zero for errors.
 	ExitCode() int
-	// Stdout returns the captured stdout output as bytes.
+	// Check returns an *ExitError unless the command succeeded, where
+	// exit codes registered as OK for the tool with RegisterExitCodes,
+	// such as grep's 1 for no match, and codes accepted with ExpectExit
+	// count as success.
+	Check() error
+	// ExitMeaning describes the exit code, using the meanings registered
+	// for the tool with RegisterExitCodes.
+	ExitMeaning() string
+	// Extract returns the named capture groups of the first match of re in
+	// stdout or, failing that, stderr, e.g. (?P<version>\d+\.\d+). The
+	// output is matched as a whole, so use (?m) to anchor at lines. It
+	// returns ErrNoMatch if neither contains a match.
+	Extract(re *regexp.Regexp) (map[string]string, error)
+	// Stdout returns the captured stdout output as bytes. It is empty for
+	// a command piped into another one with Pipe, unless its capture is
+	// bounded with WithMaxOutput or WithTailCapture.
 	Stdout() []byte
 	// Stderr returns the captured stderr output as bytes.
 	Stderr() []byte
+	// StdoutReader returns a reader of the captured stdout, for APIs taking
+	// an io.Reader such as parsers and uploaders. It reads the captured
+	// data without copying it and also implements io.ReaderAt, io.Seeker
+	// and io.WriterTo. Each call returns a new reader.
+	StdoutReader() io.Reader
+	// StderrReader is like StdoutReader for the captured stderr.
+	StderrReader() io.Reader
+	// JSON decodes the captured stdout as JSON into v.
+	JSON(v any) error
+	// Lines returns the captured stdout split into lines, without line
+	// endings.
+	Lines() []string
+	// TrimmedString returns the captured stdout with leading and trailing
+	// white space removed, e.g. for a single value such as a commit hash.
+	TrimmedString() string
+	// Combined returns stdout and stderr interleaved in the order they
+	// arrived, or nil unless WithCombinedOutput was set.
+	Combined() []byte
+	// StdoutSize returns the number of bytes written to stdout, including
+	// output that was not kept in memory.
+	StdoutSize() int64
+	// Truncated reports whether captured stdout or stderr was cut short by
+	// WithMaxOutput or WithTailCapture, or thinned by WithOutputSampling.
+	Truncated() bool
+	// StdoutDigest returns the digest of stdout computed in binary output
+	// mode, or nil if no digest was requested.
+	StdoutDigest() []byte
+	// Cached reports whether the command was skipped and its result
+	// replayed from a Cache.
+	Cached() bool
+	// Meta returns the metadata attached to the command with WithMeta.
+	Meta() map[string]string
+	// PipeStatus returns the exit codes of every stage of a pipeline in
+	// order, ending with this command's. Commands that are not piped have
+	// a single entry.
+	PipeStatus() []int
+	// Pid returns the process ID the command ran as, or 0 if it never
+	// started.
+	Pid() int
+	// Signaled returns the signal that killed the process, distinguishing
+	// e.g. a crash (SIGSEGV) from an external kill (SIGKILL) or a graceful
+	// stop (SIGTERM). It reports false if the process exited normally.
+	Signaled() (os.Signal, bool)
+	// CoreDumped reports whether the process dumped core when a signal
+	// killed it.
+	CoreDumped() bool
+	// StartTime returns when the process was started. It is the zero time
+	// if the command never ran, e.g. because its result was cached.
+	StartTime() time.Time
+	// EndTime returns when the process exited.
+	EndTime() time.Time
+	// Duration returns how long the process ran.
+	Duration() time.Duration
+	// StdoutLines returns stdout split into lines.
+	StdoutLines() TextLines
+	// SortLines returns the lines of stdout sorted; see TextLines.Sort.
+	SortLines(opts ...SortOption) TextLines
+	// UniqueLines returns the lines of stdout without duplicates.
+	UniqueLines() TextLines
+	// FilterLines returns the lines of stdout matching re.
+	FilterLines(re *regexp.Regexp) TextLines
+	// Invocation returns the command line, environment and working
+	// directory the command ran with, or would have run with in dry-run
+	// mode.
+	Invocation() Invocation
+	// Changes returns the changes the command made to the directory given
+	// to WithOverlay or WithFSDiff, in path order, or nil without either.
+	Changes() []FileChange
+	// Connections returns the remote endpoints recorded by WithNetworkLog
+	// in the order they were first seen, or nil without it.
+	Connections() []Connection
 }
 
 type resultImpl struct {
-	exitCode int
-	stdout   []byte
-	stderr   []byte
+	name         string
+	exitCode     int
+	stdout       []byte
+	stderr       []byte
+	combined     []byte
+	stdoutSize   int64
+	stdoutDigest []byte
+	truncated    bool
+	cached       bool
+	meta         map[string]string
+	pipeStatus   []int
+	pid          int
+	signal       os.Signal
+	coreDumped   bool
+	startTime    time.Time
+	endTime      time.Time
+	invocation   Invocation
+	changes      []FileChange
+	okCodes      []int
+	failure      error // returned by a SuccessWhen check
+	connections  []Connection
 }
 
 func (r *resultImpl) ExitCode() int {
@@ -217,6 +889,118 @@ func (r *resultImpl) Stderr() []byte {
 	return r.stderr
 }
 
+func (r *resultImpl) StdoutReader() io.Reader {
+	return bytes.NewReader(r.stdout)
+}
+
+func (r *resultImpl) StderrReader() io.Reader {
+	return bytes.NewReader(r.stderr)
+}
+
+func (r *resultImpl) Combined() []byte {
+	return r.combined
+}
+
+func (r *resultImpl) StdoutSize() int64 {
+	return r.stdoutSize
+}
+
+func (r *resultImpl) Truncated() bool {
+	return r.truncated
+}
+
+func (r *resultImpl) StdoutDigest() []byte {
+	return r.stdoutDigest
+}
+
+func (r *resultImpl) Cached() bool {
+	return r.cached
+}
+
+func (r *resultImpl) Meta() map[string]string {
+	return r.meta
+}
+
+func (r *resultImpl) Pid() int {
+	return r.pid
+}
+
+func (r *resultImpl) Signaled() (os.Signal, bool) {
+	return r.signal, r.signal != nil
+}
+
+func (r *resultImpl) CoreDumped() bool {
+	return r.coreDumped
+}
+
+func (r *resultImpl) StartTime() time.Time {
+	return r.startTime
+}
+
+func (r *resultImpl) EndTime() time.Time {
+	return r.endTime
+}
+
+func (r *resultImpl) Duration() time.Duration {
+	return r.endTime.Sub(r.startTime)
+}
+
+func (r *resultImpl) Invocation() Invocation {
+	return r.invocation
+}
+
+func (r *resultImpl) StdoutLines() TextLines {
+	return SplitLines(r.stdout)
+}
+
+func (r *resultImpl) SortLines(opts ...SortOption) TextLines {
+	return r.StdoutLines().Sort(opts...)
+}
+
+func (r *resultImpl) UniqueLines() TextLines {
+	return r.StdoutLines().Unique()
+}
+
+func (r *resultImpl) FilterLines(re *regexp.Regexp) TextLines {
+	return r.StdoutLines().Filter(re)
+}
+
+// countingWriter counts the bytes written to it before passing them on.
+// The count may be read while output is still being written.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// appendWriter returns a writer writing to both w and extra, either of
+// which may be nil.
+func appendWriter(w, extra io.Writer) io.Writer {
+	if w == nil {
+		return extra
+	}
+	if extra == nil {
+		return w
+	}
+	return io.MultiWriter(w, extra)
+}
+
+// runFinally calls the functions that must run once the command has
+// finished, however execute returned.
+func (cm *cmdImpl) runFinally() {
+	cm.mu.RLock()
+	finally := cm.finally
+	cm.mu.RUnlock()
+	for _, f := range finally {
+		f()
+	}
+}
+
 // ------------------------------------------- Future impl --------------------------------------
 
 func (cm *cmdImpl) Start() future.Future[Result] {
@@ -225,7 +1009,7 @@ func (cm *cmdImpl) Start() future.Future[Result] {
 	}
 
 	cm.once.Do(func() {
-		go cm.execute()
+		goSafe(cm.execute)
 	})
 
 	return cm
@@ -242,19 +1026,13 @@ func (cm *cmdImpl) Cancel() {
 }
 
 func (cm *cmdImpl) Run() (Result, error) {
-	cm.execute()
-	return cm.result, cm.err
+	// Run on the caller's goroutine unless the command was already started
+	cm.once.Do(cm.execute)
+	return cm.Wait()
 }
 
 func (cm *cmdImpl) Wait() (Result, error) {
 	cm.Start()
-	if cm.parent != nil {
-		r, err := cm.parent.Wait()
-		if err != nil {
-			return r, err
-		}
-	}
-
 	<-cm.done
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
@@ -276,61 +1054,340 @@ func (cm *cmdImpl) IsDone() bool {
 
 func (cm *cmdImpl) execute() {
 	defer close(cm.done)
+	defer cm.runFinally()
+	defer cm.attachMeta()
 
-	// If this is a piped command, wait for parent to complete first
+	// Piped commands run concurrently with their parent
 	if cm.parent != nil {
-		_, err := cm.parent.Wait()
-		if err != nil {
+		cm.startPipe()
+		defer cm.finishPipe()
+	}
+	defer cm.recoverRun()
+
+	if cm.buildErr != nil {
+		cm.mu.Lock()
+		cm.result = &resultImpl{name: cm.cmd, exitCode: -1, stdout: []byte{}, stderr: []byte{}}
+		cm.err = cm.buildErr
+		cm.mu.Unlock()
+		return
+	}
+
+	if err := cm.awaitUpstreams(); err != nil {
+		cm.mu.Lock()
+		cm.result = &resultImpl{name: cm.cmd, exitCode: -1, stdout: []byte{}, stderr: []byte{}}
+		cm.err = err
+		cm.mu.Unlock()
+		return
+	}
+
+	ctx := cm.ctx
+	if cm.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cm.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, cm.cmd, cm.args...)
+	if cm.dir != "" {
+		cmd.Dir = cm.dir
+	}
+
+	if env := cm.environ(); env != nil {
+		cmd.Env = env
+	}
+
+	// Refused commands are refused in dry runs and from the cache too
+	if p := activePolicy.Load(); p != nil {
+		if err := p.check(cm, cmd); err != nil {
 			cm.mu.Lock()
-			cm.result = &resultImpl{exitCode: -1, stdout: []byte{}, stderr: []byte{}}
+			cm.result = &resultImpl{name: cm.cmd, exitCode: -1, stdout: []byte{}, stderr: []byte{}}
 			cm.err = err
 			cm.mu.Unlock()
 			return
 		}
-		// Now set stdin to parent's stdout buffer
-		cm.stdin = cm.parent.(*cmdImpl).stdoutBuffer
 	}
 
-	cmd := exec.CommandContext(cm.ctx, cm.cmd, cm.args...)
+	// An approval is only used up by a run that starts the command, not by
+	// dry runs, cached results or runs failing to get past the limits
+	approve := cm.approver()
 
-	if cm.dir != "" {
-		cmd.Dir = cm.dir
+	if cm.dryRun || dryRun.Load() {
+		cm.markReady()
+		result := cm.dryRunResult()
+		cm.mu.Lock()
+		cm.result = result
+		cm.mu.Unlock()
+		return
+	}
+
+	// Digested output is not kept, so there would be nothing to replay. A
+	// cached run goes through the usual writers, hooks and middleware, but
+	// never starts the process.
+	var replay *cacheEntry
+	if cm.cache != nil && cm.digest == nil {
+		replay = cm.cachedEntry()
+	}
+
+	if cm.stopSignal != nil || cm.gracePeriod > 0 || cm.timeout > 0 {
+		cm.killGroupOnCancel(cmd)
+	}
+	if sc := signalContextFrom(ctx); sc != nil {
+		defer sc.attach(cm, cmd)()
 	}
 
-	if len(cm.env) > 0 {
-		env := make([]string, 0, len(cm.env))
-		for k, v := range cm.env {
-			env = append(env, k+"="+v)
+	if replay == nil {
+		release, err := cm.acquireLimits(ctx)
+		if err != nil {
+			cm.mu.Lock()
+			cm.result = &resultImpl{name: cm.cmd, exitCode: -1, stdout: []byte{}, stderr: []byte{}}
+			cm.err = err
+			cm.mu.Unlock()
+			return
 		}
-		cmd.Env = env
+		defer release()
 	}
 
 	if cm.stdin != nil {
 		cmd.Stdin = cm.stdin
+		if _, isFile := cm.stdin.(*os.File); !isFile {
+			cmd.Stdin = safeReader{cm.stdin}
+		}
 	}
 
 	// Set up output capture
-	cmd.Stdout = cm.stdout
-	cmd.Stderr = cm.stderr
+	stdoutBuffer, stderrBuffer, combinedBuffer := cm.captureWriters()
+	var stdoutCapture io.Writer = stdoutBuffer
+	var digest *syncWriter
+	if cm.digest != nil {
+		digest = &syncWriter{w: cm.digest}
+		stdoutCapture = digest
+	} else if cm.pipeOut != nil && cm.cache == nil && cm.maxOutput <= 0 {
+		// The next stage consumes stdout; keeping a copy would hold the
+		// whole stream in memory unless it is bounded
+		stdoutCapture = io.Discard
+	}
+	stdoutCounter := &countingWriter{w: stdoutCapture}
+	cmd.Stdout = appendWriter(stdoutCounter, safeWriters(cm.stdout))
+	cmd.Stderr = appendWriter(stderrBuffer, safeWriters(cm.stderr))
+	if combinedBuffer != nil {
+		cmd.Stdout = appendWriter(cmd.Stdout, combinedBuffer)
+		cmd.Stderr = appendWriter(cmd.Stderr, combinedBuffer)
+	}
+	if cm.pipeOut != nil {
+		if cm.stdout == nil && stdoutCapture == io.Discard && combinedBuffer == nil {
+			// Nothing else reads stdout: hand the pipe to the child so the
+			// data never passes through this process
+			cmd.Stdout = cm.pipeOut
+		} else {
+			cmd.Stdout = appendWriter(cmd.Stdout, cm.pipeOut)
+		}
+	}
+
+	hooks := cm.hooks
+	if t := activeTracer.Load(); t != nil {
+		hooks = append([]execHook{t.hook(cm)}, hooks...)
+	}
+
+	var startTime time.Time
+	// settle turns the error of a run into the command's result and final
+	// error, applying ExpectExit and SuccessWhen. runHooked settles once
+	// the output is complete, for the finished hooks; what the middleware
+	// returns is settled again unless it is the same error.
+	var settledRaw error
+	var settledResult *resultImpl
+	var settledErr error
+	settle := func(err error) (*resultImpl, error) {
+		if settledResult != nil && err == settledRaw {
+			return settledResult, settledErr
+		}
+		settledRaw = err
+		settledResult, settledErr = cm.settle(ctx, cmd, err, startTime, stdoutBuffer, stderrBuffer, combinedBuffer, stdoutCounter, digest)
+		settledResult.cached = replay != nil
+		return settledResult, settledErr
+	}
+
+	core := func(_ context.Context, e *Execution) error {
+		return cm.runHooked(e.Exec, hooks, replay, func(err error) error {
+			_, err = settle(err)
+			return err
+		})
+	}
+	if executor := cm.runner.executor(); executor != nil && replay == nil {
+		core = executor
+	}
+	if approve != nil && replay == nil {
+		start := core
+		core = func(ctx context.Context, e *Execution) error {
+			if err := approve(); err != nil {
+				return err
+			}
+			return start(ctx, e)
+		}
+	}
+	run := cm.wrapRun(cm.logRun(core))
+
+	startTime = time.Now()
+	result, err := settle(run(ctx, &Execution{Cmd: cm, Exec: cmd}))
+	exitCode := result.exitCode
+	// Hooks such as WithOverlay record these once the command exited
+	cm.mu.RLock()
+	result.changes = cm.changes
+	result.connections = cm.connections
+	cm.mu.RUnlock()
+	if replay == nil {
+		cm.runner.observe(cm.label(), exitCode, result.endTime.Sub(result.startTime), err)
+		if cm.cache != nil && cm.digest == nil && err == nil && !result.truncated {
+			cm.storeResult(result)
+		}
+	}
+
+	cm.mu.Lock()
+	cm.result = result
+	cm.err = err
+	cm.mu.Unlock()
+}
+
+// settle builds the result of a run of cmd that returned err, started at
+// startTime, and the command's final error, which is nil for exit codes
+// accepted with ExpectExit and set when a SuccessWhen check fails.
+func (cm *cmdImpl) settle(ctx context.Context, cmd *exec.Cmd, err error, startTime time.Time,
+	stdoutBuffer, stderrBuffer, combinedBuffer *boundedBuffer, stdoutCounter *countingWriter, digest *syncWriter) (*resultImpl, error) {
+	endTime := time.Now()
+	// Snapshot the captures now: the goroutines copying output may still
+	// be writing if a grandchild kept the pipes open past WaitDelay
+	stdout, stdoutTruncated := stdoutBuffer.snapshot()
+	stderr, stderrTruncated := stderrBuffer.snapshot()
+	combined, combinedTruncated := combinedBuffer.snapshot()
+	digest.close()
 
-	err := cmd.Run()
 	exitCode := 0
 	if err != nil {
+		var shExitErr *ExitError
 		if exitError, ok := err.(*exec.ExitError); ok {
 			exitCode = exitError.ExitCode()
+			err = newExitError(cm.cmd, exitError)
+		} else if errors.As(err, &shExitErr) {
+			// Reported by an Executor that does not start real processes
+			exitCode = shExitErr.ExitCode
 		} else {
 			exitCode = -1
 		}
+		if cm.timeout > 0 && ctx.Err() == context.DeadlineExceeded && cm.ctx.Err() == nil {
+			err = fmt.Errorf("sh: timed out after %v: %w: %w", cm.timeout, context.DeadlineExceeded, err)
+		}
+	}
+	if cm.expectedExit(exitCode, err) {
+		err = nil
 	}
 
 	result := &resultImpl{
-		exitCode: exitCode,
-		stdout:   cm.stdoutBuffer.Bytes(),
-		stderr:   cm.stderrBuffer.Bytes(),
+		name:       cm.cmd,
+		exitCode:   exitCode,
+		stdout:     stdout,
+		stderr:     stderr,
+		combined:   combined,
+		stdoutSize: stdoutCounter.n.Load(),
+		truncated:  stdoutTruncated || stderrTruncated || combinedTruncated,
+		startTime:  startTime,
+		endTime:    endTime,
+		okCodes:    cm.okCodes,
+	}
+	if cm.redactOutput {
+		result.stdout = cm.redactBytes(result.stdout)
+		result.stderr = cm.redactBytes(result.stderr)
+		result.combined = cm.redactBytes(result.combined)
+	}
+	if cmd.Process != nil {
+		result.pid = cmd.Process.Pid
+	}
+	if cmd.ProcessState != nil {
+		result.signal = exitSignal(cmd.ProcessState)
+		result.coreDumped = coreDumped(cmd.ProcessState)
+	}
+	result.invocation = Invocation{Args: cmd.Args, Env: cmd.Env, Dir: cmd.Dir}
+	if cm.digest != nil {
+		result.stdoutDigest = cm.digest.Sum(nil)
+	}
+	if err == nil {
+		err = cm.checkSuccess(result)
+	}
+	return result, err
+}
+
+// runHooked runs cmd surrounded by the given exec hooks. The after
+// functions run in reverse registration order, followed by the finished
+// functions, which are passed the final error of the run as returned by
+// settle. Hook errors are reported only when the command itself succeeded.
+// A non-nil replay is written to the command's writers instead of starting
+// it, skipping the started hooks.
+func (cm *cmdImpl) runHooked(cmd *exec.Cmd, hooks []execHook, replay *cacheEntry, settle func(error) error) (err error) {
+	prepared := 0
+	defer func() {
+		for i := prepared - 1; i >= 0; i-- {
+			if after := hooks[i].after; after != nil {
+				if hookErr := after(err); err == nil {
+					err = hookErr
+				}
+			}
+		}
+		if prepared == 0 {
+			return
+		}
+
+		final := settle(err)
+		for i := prepared - 1; i >= 0; i-- {
+			if finished := hooks[i].finished; finished != nil {
+				if hookErr := finished(final); final == nil && hookErr != nil {
+					err, final = hookErr, hookErr
+				}
+			}
+		}
+	}()
+
+	for _, hook := range hooks {
+		if hook.before != nil {
+			if err := hook.before(cmd); err != nil {
+				var startErr *StartError
+				if !errors.As(err, &startErr) {
+					err = &StartError{Cmd: cm.cmd, Stage: StageSetup, Err: err}
+				}
+				return err
+			}
+		}
+		prepared++
 	}
 
+	if replay != nil {
+		cm.markReady()
+		return replay.replay(cm.cmd, cmd)
+	}
+
+	if err := checkStart(cm.cmd, cmd); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return startError(cm.cmd, err)
+	}
+	untrack := trackRunning(cmd)
+	defer untrack()
+
 	cm.mu.Lock()
-	cm.result = result
-	cm.err = err
+	cm.process = cmd.Process
 	cm.mu.Unlock()
+
+	for _, hook := range hooks {
+		if hook.started != nil {
+			if err := hook.started(cmd); err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				return err
+			}
+		}
+	}
+
+	if !cm.readyGated {
+		cm.markReady()
+	}
+
+	return cmd.Wait()
 }
@@ -1,7 +1,9 @@
 package sh_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"strings"
 	"testing"
 	"time"
@@ -375,6 +377,53 @@ func TestOptVWithDifferentTypes(t *testing.T) {
 	}
 }
 
+func TestOptionStyles(t *testing.T) {
+	// Test repeated, joined and list-valued options
+	builder := sh.New("docker").
+		OptVs("-v", "/a:/a", "/b:/b").
+		OptEq("--format", "json").
+		OptSep("--cap-add", ",", "NET_ADMIN", "SYS_TIME").
+		OptSep("--empty", ",").
+		OptVs("--none")
+
+	items := builder.Items()
+	expected := []string{
+		"docker",
+		"-v", "/a:/a",
+		"-v", "/b:/b",
+		"--format=json",
+		"--cap-add", "NET_ADMIN,SYS_TIME",
+	}
+
+	if len(items) != len(expected) {
+		t.Fatalf("Expected %d items, got %d: %v", len(expected), len(items), items)
+	}
+
+	for i, item := range items {
+		if item != expected[i] {
+			t.Errorf("Expected item %d to be '%s', got '%s'", i, expected[i], item)
+		}
+	}
+
+	sub := sh.New("docker").SubCommand("run").OptEq("--memory", 512).OptVs("-e", "A=1", "B=2")
+	if got := strings.Join(sub.Items(), " "); got != "docker run --memory=512 -e A=1 -e B=2" {
+		t.Errorf("Unexpected subcommand items: %s", got)
+	}
+}
+
+func TestConditionalOptions(t *testing.T) {
+	verbose, dryRun := true, false
+	builder := sh.New("rsync").
+		OptIf(verbose, "-v").
+		OptIf(dryRun, "--dry-run").
+		OptVIf(verbose, "--log-file", "/tmp/rsync.log").
+		OptVIf(dryRun, "--itemize", "all")
+
+	if got := strings.Join(builder.Items(), " "); got != "rsync -v --log-file /tmp/rsync.log" {
+		t.Errorf("Unexpected items: %s", got)
+	}
+}
+
 func TestComplexSubCommand(t *testing.T) {
 	// Test complex subcommand with multiple options
 	builder := sh.New("git")
@@ -730,3 +779,60 @@ func TestCmdRunWithPipe(t *testing.T) {
 		t.Errorf("Expected '3', got '%s'", output)
 	}
 }
+
+// TestCmdWithBinaryOutput tests that digest mode records size and hash without buffering
+func TestCmdWithBinaryOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf strings.Builder
+	cmd := sh.New("printf").
+		Arg("binary payload").
+		Build(ctx).
+		WithStdout(&buf).
+		WithBinaryOutput(sha256.New())
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	if len(result.Stdout()) != 0 {
+		t.Errorf("Expected stdout not to be buffered, got %d bytes", len(result.Stdout()))
+	}
+
+	if result.StdoutSize() != int64(len("binary payload")) {
+		t.Errorf("Expected size %d, got %d", len("binary payload"), result.StdoutSize())
+	}
+
+	expected := sha256.Sum256([]byte("binary payload"))
+	if !bytes.Equal(result.StdoutDigest(), expected[:]) {
+		t.Errorf("Expected digest %x, got %x", expected, result.StdoutDigest())
+	}
+
+	// User supplied writers still receive the full stream
+	if buf.String() != "binary payload" {
+		t.Errorf("Expected 'binary payload' in buffer, got '%s'", buf.String())
+	}
+}
+
+func TestCmdRunAfterStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("echo").Arg("once").Build(ctx)
+	cmd.Start()
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := result.TrimmedString(); got != "once" {
+		t.Errorf("Expected the started run's output, got %q", got)
+	}
+
+	// Running again returns the same result
+	again, err := cmd.Run()
+	if err != nil || again != result {
+		t.Errorf("Expected the same result, got %v, %v", again, err)
+	}
+}
@@ -1,9 +1,11 @@
 package sh
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 type execResult struct{}
@@ -17,13 +19,29 @@ type CmdComponent interface {
 	Parent() CmdComponent
 }
 
+// Argv is anything that renders to a full command line, such as a *Builder
+// or a *SubCmd.
+type Argv interface {
+	// Items returns the command name followed by its arguments.
+	Items() []string
+}
+
 // ---------------------------------------- cmd builder ------------------------------------
 
 // Builder provides a fluent interface for constructing commands.
 // It supports adding options, arguments, and subcommands.
 type Builder struct {
-	Cmd        string
-	components []CmdComponent
+	Cmd         string
+	components  []CmdComponent
+	runner      *Runner
+	description string
+	resolve     func(cmd string) (string, error)
+	name        string    // set with Name
+	arg0        string    // set with Arg0
+	group       string    // set with Group
+	subs        []*SubCmd // subcommands created with SubCommand, for Tree
+	cmdShell    bool      // built by NewCmdShell
+	err         error     // of an invalid option, reported by Build
 }
 
 // Items returns all command components as a slice of strings.
@@ -47,15 +65,39 @@ func (b *Builder) Parent() *Builder {
 	return nil
 }
 
+// Clone returns a deep copy of the builder. Options added to the copy do not
+// affect the original and the other way around. Subcommands created from the
+// original are not part of the copy's Tree.
+func (b *Builder) Clone() *Builder {
+	if b == nil {
+		return nil
+	}
+	return &Builder{
+		Cmd:         b.Cmd,
+		components:  slices.Clone(b.components),
+		runner:      b.runner,
+		description: b.description,
+		name:        b.name,
+		group:       b.group,
+		arg0:        b.arg0,
+		resolve:     b.resolve,
+		cmdShell:    b.cmdShell,
+		err:         b.err,
+	}
+}
+
 // SubCommand creates a subcommand builder with the specified name.
 // The subcommand will be executed as part of the parent command.
 // For example: git.SubCommand("status") creates "git status".
 func (b *Builder) SubCommand(name string) *SubCmd {
 	subCmd := &SubCmd{
-		Builder: &Builder{Cmd: name},
+		Builder: &Builder{Cmd: name, runner: b.runner},
 		parent:  b,
 	}
 
+	// Keep one subcommand per name so repeated calls do not pile up
+	b.subs = slices.DeleteFunc(b.subs, func(s *SubCmd) bool { return s.Cmd == name })
+	b.subs = append(b.subs, subCmd)
 	return subCmd
 }
 
@@ -92,6 +134,69 @@ func (s *Builder) OptV(flag string, value any) *Builder {
 	return s
 }
 
+// OptVs adds flag once per value, for flags that may be repeated.
+// For example: OptVs("-v", "a", "b") adds "-v a -v b" to the command.
+func (s *Builder) OptVs(flag string, values ...any) *Builder {
+	for _, value := range values {
+		s.OptV(flag, value)
+	}
+	return s
+}
+
+// OptEq adds a flag with its value joined by an equals sign.
+// For example: OptEq("--output", "json") adds "--output=json" to the command.
+func (s *Builder) OptEq(flag string, value any) *Builder {
+	// Skip empty flags
+	if flag == "" {
+		return s
+	}
+
+	opt := &Opt{
+		Key:   flag,
+		Value: value,
+		Join:  "=",
+	}
+
+	s.components = append(s.components, opt)
+	return s
+}
+
+// OptSep adds a flag whose value is a list joined by sep.
+// For example: OptSep("--ports", ",", 80, 443) adds "--ports 80,443" to the command.
+// Nothing is added for an empty list.
+func (s *Builder) OptSep(flag, sep string, values ...any) *Builder {
+	if len(values) == 0 {
+		return s
+	}
+
+	return s.OptV(flag, strings.Join(stringify(values), sep))
+}
+
+// stringify formats each value as OptV would.
+func stringify(values []any) []string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = fmt.Sprintf("%v", value)
+	}
+	return items
+}
+
+// OptIf adds a boolean flag to the command if cond is true.
+func (s *Builder) OptIf(cond bool, flag string) *Builder {
+	if !cond {
+		return s
+	}
+	return s.OptB(flag)
+}
+
+// OptVIf adds a flag with a value to the command if cond is true.
+func (s *Builder) OptVIf(cond bool, flag string, value any) *Builder {
+	if !cond {
+		return s
+	}
+	return s.OptV(flag, value)
+}
+
 // Arg adds a positional argument to the command.
 // Arguments are added in the order they are specified.
 func (s *Builder) Arg(value string) *Builder {
@@ -145,12 +250,60 @@ func (s *SubCmd) OptV(flag string, value any) *SubCmd {
 	return s
 }
 
+// OptVs repeats flag for each value on the subcommand and returns the SubCmd.
+func (s *SubCmd) OptVs(flag string, values ...any) *SubCmd {
+	s.Builder.OptVs(flag, values...)
+	return s
+}
+
+// OptEq adds a flag=value option to the subcommand and returns the SubCmd.
+func (s *SubCmd) OptEq(flag string, value any) *SubCmd {
+	s.Builder.OptEq(flag, value)
+	return s
+}
+
+// OptSep adds a flag with a sep-joined list to the subcommand and returns the SubCmd.
+func (s *SubCmd) OptSep(flag, sep string, values ...any) *SubCmd {
+	s.Builder.OptSep(flag, sep, values...)
+	return s
+}
+
+// OptIf adds a boolean flag to the subcommand if cond is true and returns the SubCmd.
+func (s *SubCmd) OptIf(cond bool, flag string) *SubCmd {
+	s.Builder.OptIf(cond, flag)
+	return s
+}
+
+// OptVIf adds a flag with a value to the subcommand if cond is true and returns the SubCmd.
+func (s *SubCmd) OptVIf(cond bool, flag string, value any) *SubCmd {
+	s.Builder.OptVIf(cond, flag, value)
+	return s
+}
+
+// ArgsFromStruct adds the options described by the tagged fields of v to the
+// subcommand and returns the SubCmd. See Builder.ArgsFromStruct.
+func (s *SubCmd) ArgsFromStruct(v any) *SubCmd {
+	s.Builder.ArgsFromStruct(v)
+	return s
+}
+
 // Arg adds a positional argument to the subcommand and returns the SubCmd.
 func (s *SubCmd) Arg(value string) *SubCmd {
 	s.Builder.Arg(value)
 	return s
 }
 
+// Clone returns a deep copy of the subcommand and its parent builder.
+func (s *SubCmd) Clone() *SubCmd {
+	if s == nil {
+		return nil
+	}
+	return &SubCmd{
+		Builder: s.Builder.Clone(),
+		parent:  s.parent.Clone(),
+	}
+}
+
 // Parent returns the parent builder that this subcommand belongs to.
 func (s *SubCmd) Parent() *Builder {
 	return s.parent
@@ -163,12 +316,18 @@ func (s *SubCmd) Parent() *Builder {
 type Opt struct {
 	Key   string
 	Value any
+	// Join, if set, joins the key and value into a single argument, as
+	// in "--key=value".
+	Join string
 }
 
 // Items returns the string representation of this option.
 // For boolean flags, returns just the key. For key-value pairs,
-// returns both the key and formatted value.
+// returns both the key and formatted value, or a single joined item.
 func (o *Opt) Items() []string {
+	if o.Value != nil && o.Join != "" {
+		return []string{fmt.Sprintf("%s%s%v", o.Key, o.Join, o.Value)}
+	}
 	if o.Value != nil {
 		return []string{o.Key, fmt.Sprintf("%v", o.Value)}
 	}
@@ -203,37 +362,101 @@ func (o *Arg) Parent() CmdComponent {
 
 // ------------------------------------------ Build method ----------------------------------
 
+// ErrNoCommand is the error of the *StartError returned for commands built
+// without a command to run.
+var ErrNoCommand = errors.New("sh: no command specified")
+
 // Build constructs a Cmd from the builder configuration.
 // The returned Cmd can be started asynchronously and supports cancellation
 // through the provided context.
+//
+// Build does not panic: a builder without a command or with an invalid
+// option, such as a non-struct given to ArgsFromStruct, builds a command
+// failing with a *StartError when it runs. Use MustBuild to panic instead.
 func (b *Builder) Build(ctx context.Context) Cmd {
-	args := b.Items()
-	if len(args) == 0 {
-		panic("no command specified")
+	cm, _ := b.build(ctx)
+	return cm
+}
+
+// MustBuild is like Build but panics if the builder is invalid, for
+// builders written out in code, where that is a programming error.
+func (b *Builder) MustBuild(ctx context.Context) Cmd {
+	cm, err := b.build(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return cm
+}
+
+// build builds the command, returning the error it fails with if the
+// builder is invalid.
+func (b *Builder) build(ctx context.Context) (*cmdImpl, error) {
+	if b == nil {
+		b = &Builder{}
+	}
+	args := applyShims(b.Items())
+	var buildErr error
+	switch {
+	case len(args) == 0 || args[0] == "":
+		args = []string{""}
+		buildErr = &StartError{Stage: StageLookPath, Err: ErrNoCommand}
+	case b.err != nil:
+		buildErr = &StartError{Cmd: args[0], Stage: StageSetup, Err: b.err}
 	}
 
 	cmd := args[0]
 	cmdArgs := args[1:]
+	if b.resolve != nil && buildErr == nil {
+		if bin, err := b.resolve(cmd); err != nil {
+			buildErr = &StartError{Cmd: cmd, Stage: StageLookPath, Err: err}
+		} else {
+			cmd = bin
+		}
+	}
 
 	childCtx, cancel := context.WithCancel(ctx)
 
-	stdoutBuffer := bytes.NewBuffer(nil)
-	stderrBuffer := bytes.NewBuffer(nil)
-
-	return &cmdImpl{
-		cmd:          cmd,
-		ctx:          childCtx,
-		args:         cmdArgs,
-		env:          make(map[string]string),
-		dir:          "",
-		stdoutBuffer: stdoutBuffer,
-		stderrBuffer: stderrBuffer,
-		stdout:       stdoutBuffer,
-		stderr:       stderrBuffer,
-		stdin:        nil,
-		done:         make(chan any),
-		cancel:       cancel,
+	cm := &cmdImpl{
+		cmd:      cmd,
+		name:     b.name,
+		group:    b.group,
+		ctx:      childCtx,
+		args:     cmdArgs,
+		env:      make(map[string]string),
+		dir:      "",
+		stdin:    nil,
+		done:     make(chan any),
+		ready:    make(chan struct{}),
+		cancel:   cancel,
+		arg0:     b.arg0,
+		buildErr: buildErr,
+	}
+	if s := scrubDefault.Load(); s != nil {
+		cm.scrubEnv, cm.envKeep = true, s.keep
+	}
+
+	runner := b.runner
+	if runner == nil {
+		runner = defaultRunner
+	}
+	if b.arg0 != "" {
+		cm.hooks = append(cm.hooks, arg0Hook(b.arg0))
+	}
+	var secrets []*secretFile
+	for _, component := range b.components {
+		if s, ok := component.(*secretFile); ok {
+			secrets = append(secrets, s)
+			cm.addSecret(s.value)
+		}
+	}
+	if secrets != nil {
+		cm.hooks = append(cm.hooks, secretFilesHook(secrets))
+	}
+	if b.cmdShell {
+		cm.hooks = append(cm.hooks, cmdShellHook())
 	}
+	runner.apply(cm)
+	return cm, buildErr
 }
 
 // New creates a new command builder with the specified command name.
@@ -0,0 +1,426 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+// ErrRestartBudgetExceeded is returned by Compose.Wait and Supervisor.Wait
+// when a service failed more often than its restart budget allows.
+var ErrRestartBudgetExceeded = errors.New("sh: service exceeded its restart budget")
+
+// Compose runs a group of long-running services that depend on each other.
+// Services are started in dependency order, each one only after the
+// services it depends on are ready (see Cmd.Ready).
+//
+// When a service exits on its own it forms a failure domain together with
+// the services depending on it: those are stopped, the failed service is
+// restarted and its dependents are started again, while unrelated services
+// keep running. A service that fails more often than the restart budget
+// brings the whole group down.
+type Compose struct {
+	services    map[string]*service
+	names       []string // in registration order
+	maxRestarts int
+	backoff     backoff.Strategy
+
+	mu       sync.Mutex // guards done and the commands of services
+	ctx      context.Context
+	cancel   context.CancelFunc
+	order    []string // in dependency order
+	events   chan exitEvent
+	restarts chan restartRequest
+	done     chan struct{} // set by Start; err is final once it is closed
+	err      error
+}
+
+type service struct {
+	name     string
+	build    func(ctx context.Context) Cmd
+	deps     []string
+	cmd      Cmd
+	restarts int
+	delay    time.Duration // last restart delay
+}
+
+type exitEvent struct {
+	name string
+	cmd  Cmd
+	err  error
+}
+
+type restartRequest struct {
+	name   string
+	result chan error
+}
+
+// NewCompose returns an empty service group with a restart budget of three
+// restarts per service.
+func NewCompose() *Compose {
+	return &Compose{
+		services:    make(map[string]*service),
+		maxRestarts: 3,
+	}
+}
+
+// Service registers a service. build is called every time the service is
+// (re)started and must return a new, unstarted command built with ctx.
+func (c *Compose) Service(name string, build func(ctx context.Context) Cmd, dependsOn ...string) *Compose {
+	if _, ok := c.services[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.services[name] = &service{name: name, build: build, deps: dependsOn}
+	return c
+}
+
+// MaxRestarts sets how many times each service may be restarted after
+// failing before the whole group is stopped.
+func (c *Compose) MaxRestarts(n int) *Compose {
+	c.maxRestarts = n
+	return c
+}
+
+// RestartBackoff delays restarting a failed service as s dictates, where
+// the attempt number counts the service's restarts. Without a strategy
+// failed services are restarted immediately. Other services keep running
+// while a restart is delayed.
+func (c *Compose) RestartBackoff(s backoff.Strategy) *Compose {
+	c.backoff = s
+	return c
+}
+
+// Start starts all services in dependency order and returns once every
+// service is ready. If a service cannot be started the services started so
+// far are stopped again. A group can only be started once.
+func (c *Compose) Start(ctx context.Context) error {
+	// Setting the fields under the lock makes them visible to Restart,
+	// Stop and Wait called from other goroutines once they see done
+	c.mu.Lock()
+	if c.done != nil {
+		c.mu.Unlock()
+		return errors.New("sh: compose group already started")
+	}
+	order, err := c.resolveOrder()
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.order = order
+	c.events = make(chan exitEvent)
+	c.restarts = make(chan restartRequest)
+	done := make(chan struct{})
+	c.done = done
+	c.mu.Unlock()
+
+	for _, name := range order {
+		if err := c.startService(name); err != nil {
+			c.stopServices(order)
+			c.cancel()
+			c.err = err
+			close(done)
+			return err
+		}
+	}
+
+	goSafe(c.supervise)
+	return nil
+}
+
+// Restart restarts the named service together with every service that
+// depends on it, leaving all other services running. It fails with
+// ErrNotStarted before Start. If a service fails to start again, the
+// whole group is stopped and Wait returns the same error.
+func (c *Compose) Restart(name string) error {
+	if _, ok := c.services[name]; !ok {
+		return fmt.Errorf("sh: unknown service %q", name)
+	}
+	done := c.started()
+	if done == nil {
+		return ErrNotStarted
+	}
+
+	req := restartRequest{name: name, result: make(chan error, 1)}
+	select {
+	case c.restarts <- req:
+		return <-req.result
+	case <-done:
+		return errors.New("sh: compose group is not running")
+	}
+}
+
+// Stop stops all services in reverse dependency order and waits for them to
+// exit.
+func (c *Compose) Stop() error {
+	done := c.started()
+	if done == nil {
+		return nil
+	}
+
+	c.cancel()
+	<-done
+	if errors.Is(c.err, context.Canceled) {
+		return nil
+	}
+	return c.err
+}
+
+// Wait blocks until the group has stopped, either through Stop, the
+// cancellation of its context or a service exceeding its restart budget.
+// It fails with ErrNotStarted before Start.
+func (c *Compose) Wait() error {
+	done := c.started()
+	if done == nil {
+		return ErrNotStarted
+	}
+	<-done
+	return c.err
+}
+
+// started returns the channel closed once the group has stopped, or nil
+// before Start.
+func (c *Compose) started() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Running returns the current command of the named service, or nil if the
+// service is not running.
+func (c *Compose) Running(name string) Cmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.services[name]; ok {
+		return s.cmd
+	}
+	return nil
+}
+
+// delayedRestart is a restart of a failed service waiting out its backoff.
+type delayedRestart struct {
+	name  string
+	timer *time.Timer
+}
+
+// supervise handles service exits and restart requests until the group is
+// stopped.
+func (c *Compose) supervise() {
+	defer close(c.done)
+
+	// Restarts waiting out their backoff, by service; the timers send
+	// themselves on due so that stale ones can be told apart
+	delayed := make(map[string]*delayedRestart)
+	due := make(chan *delayedRestart)
+	defer func() {
+		for _, d := range delayed {
+			d.timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case ev := <-c.events:
+			s := c.services[ev.name]
+			if s.cmd != ev.cmd || c.ctx.Err() != nil {
+				continue // an instance we are stopping ourselves
+			}
+
+			s.restarts++
+			if s.restarts > c.maxRestarts {
+				c.err = fmt.Errorf("%w: %s: %v", ErrRestartBudgetExceeded, ev.name, ev.err)
+				c.stopServices(c.order)
+				c.cancel()
+				return
+			}
+			if c.backoff != nil {
+				s.delay = c.backoff.Delay(s.restarts, s.delay)
+			}
+			logRetry(ev.cmd, s.restarts, s.delay, ev.err)
+			if s.delay > 0 {
+				d := &delayedRestart{name: ev.name}
+				d.timer = time.AfterFunc(s.delay, func() {
+					select {
+					case due <- d:
+					case <-c.done:
+					}
+				})
+				delayed[ev.name] = d
+				continue
+			}
+			if err := c.restartDomain(ev.name); err != nil {
+				c.err = err
+				c.stopServices(c.order)
+				c.cancel()
+				return
+			}
+		case d := <-due:
+			if delayed[d.name] != d {
+				continue // superseded by Restart
+			}
+			delete(delayed, d.name)
+			if err := c.restartDomain(d.name); err != nil {
+				c.err = err
+				c.stopServices(c.order)
+				c.cancel()
+				return
+			}
+		case req := <-c.restarts:
+			if d, ok := delayed[req.name]; ok {
+				d.timer.Stop()
+				delete(delayed, req.name)
+			}
+			err := c.restartDomain(req.name)
+			req.result <- err
+			if err != nil {
+				// Like a failed automatic restart, this leaves the
+				// domain down, so bring the whole group down
+				c.err = err
+				c.stopServices(c.order)
+				c.cancel()
+				return
+			}
+		case <-c.ctx.Done():
+			c.stopServices(c.order)
+			c.err = c.ctx.Err()
+			return
+		}
+	}
+}
+
+// restartDomain restarts name and all services depending on it.
+func (c *Compose) restartDomain(name string) error {
+	domain := c.dependents(name)
+	c.stopServices(domain)
+
+	for _, n := range domain {
+		if err := c.startService(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startService starts a single service and waits until it is ready.
+func (c *Compose) startService(name string) error {
+	s := c.services[name]
+	cmd, err := buildSafely(c.ctx, s.build)
+	if err != nil {
+		return fmt.Errorf("sh: service %s: %w", name, err)
+	}
+
+	c.mu.Lock()
+	s.cmd = cmd
+	c.mu.Unlock()
+
+	cmd.Start()
+	goSafe(func() {
+		_, err := cmd.Wait()
+		select {
+		case c.events <- exitEvent{name: name, cmd: cmd, err: err}:
+		case <-c.done:
+		}
+	})
+
+	select {
+	case <-cmd.Ready():
+		return nil
+	case <-cmd.Done():
+		select {
+		case <-cmd.Ready():
+			return nil
+		default:
+		}
+		_, err := cmd.Wait()
+		return fmt.Errorf("sh: service %s exited before becoming ready: %v", name, err)
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+// stopServices stops the given services in reverse order.
+func (c *Compose) stopServices(names []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		s := c.services[names[i]]
+
+		c.mu.Lock()
+		cmd := s.cmd
+		s.cmd = nil
+		c.mu.Unlock()
+
+		if cmd != nil {
+			cmd.Cancel()
+			<-cmd.Done()
+		}
+	}
+}
+
+// dependents returns name followed by every service depending on it,
+// directly or transitively, in dependency order.
+func (c *Compose) dependents(name string) []string {
+	affected := map[string]bool{name: true}
+	var domain []string
+
+	for _, n := range c.order {
+		if !affected[n] {
+			for _, dep := range c.services[n].deps {
+				if affected[dep] {
+					affected[n] = true
+					break
+				}
+			}
+		}
+		if affected[n] {
+			domain = append(domain, n)
+		}
+	}
+	return domain
+}
+
+// resolveOrder returns the services sorted so that every service comes
+// after its dependencies.
+func (c *Compose) resolveOrder() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var order []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		s, ok := c.services[name]
+		if !ok {
+			return fmt.Errorf("sh: unknown service %q", name)
+		}
+
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("sh: dependency cycle involving service %q", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range s.deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range c.names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,279 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+// longRunning returns a service that becomes ready immediately and runs until
+// it is stopped.
+func longRunning(ctx context.Context) sh.Cmd {
+	return sh.New("sh").
+		OptV("-c", "echo ready; exec sleep 10").
+		Build(ctx).
+		WithReadyPattern(regexp.MustCompile("ready"))
+}
+
+func TestComposeRestartDomain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group := sh.NewCompose().
+		Service("app", longRunning, "db").
+		Service("db", longRunning).
+		Service("cache", longRunning)
+
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer group.Stop()
+
+	db, app, cache := group.Running("db"), group.Running("app"), group.Running("cache")
+	if db == nil || app == nil || cache == nil {
+		t.Fatal("Expected all services to be running")
+	}
+
+	if err := group.Restart("db"); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+
+	if group.Running("db") == db || group.Running("app") == app {
+		t.Error("Expected db and its dependent app to be restarted")
+	}
+	if group.Running("cache") != cache {
+		t.Error("Expected unrelated cache service to keep running")
+	}
+	if !db.IsDone() || !app.IsDone() {
+		t.Error("Expected previous db and app instances to be stopped")
+	}
+
+	if err := group.Stop(); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+	if group.Running("cache") != nil || !cache.IsDone() {
+		t.Error("Expected all services to be stopped")
+	}
+}
+
+func TestComposeRestartBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	crashing := func(ctx context.Context) sh.Cmd {
+		return sh.New("sh").OptV("-c", "sleep 0.02; exit 1").Build(ctx)
+	}
+
+	group := sh.NewCompose().
+		MaxRestarts(2).
+		Service("stable", longRunning).
+		Service("crashing", crashing)
+
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	stable := group.Running("stable")
+
+	err := group.Wait()
+	if !errors.Is(err, sh.ErrRestartBudgetExceeded) {
+		t.Errorf("Expected ErrRestartBudgetExceeded, got %v", err)
+	}
+	if !stable.IsDone() {
+		t.Error("Expected the whole group to be stopped")
+	}
+}
+
+func TestComposeRestartBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var starts []time.Time
+	crashing := func(ctx context.Context) sh.Cmd {
+		starts = append(starts, time.Now())
+		return sh.New("false").Build(ctx)
+	}
+
+	group := sh.NewCompose().
+		MaxRestarts(2).
+		RestartBackoff(backoff.Exponential{Base: 50 * time.Millisecond}).
+		Service("crashing", crashing)
+
+	// Without a ready check the command is ready once it has started
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := group.Wait(); !errors.Is(err, sh.ErrRestartBudgetExceeded) {
+		t.Errorf("Expected ErrRestartBudgetExceeded, got %v", err)
+	}
+
+	if len(starts) != 3 {
+		t.Fatalf("Expected 3 starts, got %d", len(starts))
+	}
+	if gap := starts[1].Sub(starts[0]); gap < 50*time.Millisecond {
+		t.Errorf("Expected the first restart after at least 50ms, got %v", gap)
+	}
+	if gap := starts[2].Sub(starts[1]); gap < 100*time.Millisecond {
+		t.Errorf("Expected the second restart after at least 100ms, got %v", gap)
+	}
+}
+
+func TestComposeRestartDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	starts := 0
+	crashOnce := func(ctx context.Context) sh.Cmd {
+		mu.Lock()
+		defer mu.Unlock()
+		starts++
+		if starts == 1 {
+			return sh.New("false").Build(ctx)
+		}
+		return longRunning(ctx)
+	}
+
+	group := sh.NewCompose().
+		RestartBackoff(backoff.Constant(time.Minute)).
+		Service("flaky", crashOnce)
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	first := group.Running("flaky")
+	first.Wait()
+
+	// Neither a manual restart nor Stop waits for the pending backoff
+	start := time.Now()
+	if err := group.Restart("flaky"); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	if cmd := group.Running("flaky"); cmd == nil || cmd == first {
+		t.Error("Expected the service to be restarted")
+	}
+	if err := group.Stop(); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected Restart and Stop not to wait for the backoff, took %v", elapsed)
+	}
+}
+
+func TestComposeNotStarted(t *testing.T) {
+	group := sh.NewCompose().Service("app", longRunning)
+	if err := group.Restart("app"); !errors.Is(err, sh.ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted from Restart, got %v", err)
+	}
+	if err := group.Wait(); !errors.Is(err, sh.ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted from Wait, got %v", err)
+	}
+	if err := group.Stop(); err != nil {
+		t.Errorf("Expected Stop to do nothing, got %v", err)
+	}
+}
+
+func TestComposeWaitDuringStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Run with -race: Wait may be called while Start is still running
+	group := sh.NewCompose().Service("app", longRunning)
+	waited := make(chan error, 1)
+	go func() {
+		for {
+			if err := group.Wait(); !errors.Is(err, sh.ErrNotStarted) {
+				waited <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := group.Stop(); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+	if err := <-waited; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Wait to report the stop, got %v", err)
+	}
+}
+
+func TestComposeStartTwice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group := sh.NewCompose().Service("app", longRunning)
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer group.Stop()
+
+	app := group.Running("app")
+	if err := group.Start(ctx); err == nil {
+		t.Error("Expected a second Start to fail")
+	}
+	if group.Running("app") != app || app.IsDone() {
+		t.Error("Expected a second Start to leave the running services alone")
+	}
+}
+
+func TestComposeRestartFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// db starts the first time only
+	builds := 0
+	db := func(ctx context.Context) sh.Cmd {
+		builds++
+		if builds > 1 {
+			return sh.New("false").Build(ctx).WithReadyPattern(regexp.MustCompile("ready"))
+		}
+		return longRunning(ctx)
+	}
+	group := sh.NewCompose().
+		Service("app", longRunning, "db").
+		Service("db", db).
+		Service("cache", longRunning)
+	if err := group.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer group.Stop()
+
+	cache := group.Running("cache")
+	err := group.Restart("db")
+	if err == nil {
+		t.Fatal("Expected Restart to fail")
+	}
+
+	// The group must not keep running with db and app down
+	if waitErr := group.Wait(); waitErr == nil || waitErr.Error() != err.Error() {
+		t.Errorf("Expected Wait to report %v, got %v", err, waitErr)
+	}
+	if !cache.IsDone() {
+		t.Error("Expected the whole group to be stopped")
+	}
+}
+
+func TestComposeDependencyErrors(t *testing.T) {
+	ctx := context.Background()
+
+	err := sh.NewCompose().Service("app", longRunning, "missing").Start(ctx)
+	if err == nil {
+		t.Error("Expected error for unknown dependency")
+	}
+
+	err = sh.NewCompose().
+		Service("a", longRunning, "b").
+		Service("b", longRunning, "a").
+		Start(ctx)
+	if err == nil {
+		t.Error("Expected error for dependency cycle")
+	}
+}
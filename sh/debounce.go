@@ -0,0 +1,94 @@
+package sh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benoctopus/pkg/future"
+)
+
+// debounced holds the runs waiting out their window, by key.
+var debounced struct {
+	sync.Mutex
+	pending map[string]*debounce
+}
+
+// debounce is a run waiting out its window.
+type debounce struct {
+	timer   *time.Timer
+	build   func(ctx context.Context) Cmd
+	promise *future.Promise[Result]
+}
+
+// Debounce coalesces bursts of requests to run a command, such as a file
+// watcher firing for every file a build touches, into a single run once no
+// request with the same key has come in for window. The command is built by
+// the build of the latest request, so the latest parameters win, and every
+// request of the burst gets the same Future:
+//
+//	watcher.OnChange(func(path string) {
+//		sh.Debounce("rebuild", 200*time.Millisecond, func(ctx context.Context) sh.Cmd {
+//			return sh.New("make").Arg("build").Build(ctx)
+//		})
+//	})
+//
+// Cancelling the Future before the window ends drops the run; cancelling it
+// afterwards cancels the running command.
+func Debounce(key string, window time.Duration, build func(ctx context.Context) Cmd) future.Future[Result] {
+	debounced.Lock()
+	defer debounced.Unlock()
+	if debounced.pending == nil {
+		debounced.pending = make(map[string]*debounce)
+	}
+
+	d := debounced.pending[key]
+	if d == nil || d.promise.Future().IsDone() {
+		d = &debounce{promise: future.NewPromise[Result]()}
+		debounced.pending[key] = d
+		d.timer = time.AfterFunc(window, func() { d.fire(key) })
+	} else {
+		d.timer.Reset(window)
+	}
+	d.build = build
+	return d.promise.Future()
+}
+
+// fire runs the command once the window has passed. A timer reset after it
+// had already fired calls fire again, which does nothing.
+func (d *debounce) fire(key string) {
+	debounced.Lock()
+	if debounced.pending[key] != d {
+		debounced.Unlock()
+		return
+	}
+	delete(debounced.pending, key)
+	build := d.build
+	debounced.Unlock()
+
+	fu := d.promise.Future()
+	if fu.IsDone() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	goSafe(func() {
+		select {
+		case <-fu.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	cmd, err := buildSafely(ctx, build)
+	if err != nil {
+		d.promise.Reject(err)
+		return
+	}
+	result, err := cmd.Run()
+	if err != nil {
+		d.promise.Reject(err)
+		return
+	}
+	d.promise.Resolve(result)
+}
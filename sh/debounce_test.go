@@ -0,0 +1,72 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/future"
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestDebounceCoalesces(t *testing.T) {
+	var builds atomic.Int32
+	var fus []future.Future[sh.Result]
+	for i := range 5 {
+		fus = append(fus, sh.Debounce(t.Name(), 50*time.Millisecond, func(ctx context.Context) sh.Cmd {
+			builds.Add(1)
+			return sh.New("echo").Arg(strconv.Itoa(i)).Build(ctx)
+		}))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, fu := range fus {
+		result, err := future.WaitTimeout(5*time.Second, fu)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got := result.TrimmedString(); got != "4" {
+			t.Errorf("Expected the latest request to win, got %q", got)
+		}
+	}
+	if got := builds.Load(); got != 1 {
+		t.Errorf("Expected a single run, got %d", got)
+	}
+}
+
+func TestDebounceRunsAgainAfterWindow(t *testing.T) {
+	build := func(ctx context.Context) sh.Cmd {
+		return sh.New("true").Build(ctx)
+	}
+	first := sh.Debounce(t.Name(), 10*time.Millisecond, build)
+	if _, err := future.WaitTimeout(5*time.Second, first); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second := sh.Debounce(t.Name(), 10*time.Millisecond, build)
+	if second == first {
+		t.Error("Expected a new Future once the previous run finished")
+	}
+	if _, err := future.WaitTimeout(5*time.Second, second); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestDebounceCancelDropsRun(t *testing.T) {
+	var builds atomic.Int32
+	fu := sh.Debounce(t.Name(), 20*time.Millisecond, func(ctx context.Context) sh.Cmd {
+		builds.Add(1)
+		return sh.New("true").Build(ctx)
+	})
+	fu.Cancel()
+
+	if _, err := fu.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := builds.Load(); got != 0 {
+		t.Errorf("Expected the run to be dropped, got %d builds", got)
+	}
+}
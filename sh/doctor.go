@@ -0,0 +1,156 @@
+package sh
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DoctorReport describes process-management problems found by Doctor.
+type DoctorReport struct {
+	// Zombies lists defunct children of the current process that have
+	// exited but were never waited for.
+	Zombies []ProcessInfo
+	// LongRunning lists commands started by this package that have been
+	// running for longer than the configured threshold.
+	LongRunning []RunningCommand
+	// LeakedPTYs lists pseudo-terminal descriptors held open by the current
+	// process that do not belong to a running command.
+	LeakedPTYs []string
+}
+
+// Healthy reports whether no problems were found.
+func (r DoctorReport) Healthy() bool {
+	return len(r.Zombies) == 0 && len(r.LongRunning) == 0 && len(r.LeakedPTYs) == 0
+}
+
+// ProcessInfo identifies a process in the process table.
+type ProcessInfo struct {
+	Pid     int
+	Command string
+}
+
+// RunningCommand is a command started by this package that has not exited.
+type RunningCommand struct {
+	Pid     int
+	Args    []string
+	Started time.Time
+	Elapsed time.Duration
+}
+
+// DoctorOption configures Doctor.
+type DoctorOption func(*doctorConfig)
+
+type doctorConfig struct {
+	maxRuntime time.Duration
+}
+
+// MaxRuntime sets how long a command may run before Doctor reports it as
+// long-running. The default is one hour.
+func MaxRuntime(d time.Duration) DoctorOption {
+	return func(c *doctorConfig) {
+		c.maxRuntime = d
+	}
+}
+
+// Doctor inspects the current process for defunct children, commands
+// running longer than expected and leaked pseudo-terminals. It is a
+// debugging aid for services that spawn many commands. Defunct children
+// and leaked pseudo-terminals are only detected on Linux.
+func Doctor(ctx context.Context, opts ...DoctorOption) (DoctorReport, error) {
+	cfg := doctorConfig{maxRuntime: time.Hour}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var report DoctorReport
+	for _, rc := range runningCommands() {
+		if rc.Elapsed > cfg.maxRuntime {
+			report.LongRunning = append(report.LongRunning, rc)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	zombies, err := defunctChildren()
+	if err != nil {
+		return report, err
+	}
+	report.Zombies = zombies
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	leaked, err := leakedPTYs()
+	if err != nil {
+		return report, err
+	}
+	report.LeakedPTYs = leaked
+
+	return report, nil
+}
+
+// tracker records the commands and pseudo-terminals currently in use.
+var tracker = struct {
+	sync.Mutex
+	cmds map[*exec.Cmd]time.Time
+	ptys map[uintptr]bool
+}{
+	cmds: make(map[*exec.Cmd]time.Time),
+	ptys: make(map[uintptr]bool),
+}
+
+// trackRunning records cmd as running until the returned function is called.
+func trackRunning(cmd *exec.Cmd) (untrack func()) {
+	tracker.Lock()
+	tracker.cmds[cmd] = time.Now()
+	tracker.Unlock()
+
+	return func() {
+		tracker.Lock()
+		delete(tracker.cmds, cmd)
+		tracker.Unlock()
+	}
+}
+
+func runningCommands() []RunningCommand {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	cmds := make([]RunningCommand, 0, len(tracker.cmds))
+	for cmd, started := range tracker.cmds {
+		cmds = append(cmds, RunningCommand{
+			Pid:     cmd.Process.Pid,
+			Args:    slices.Clone(cmd.Args),
+			Started: started,
+			Elapsed: time.Since(started),
+		})
+	}
+	slices.SortFunc(cmds, func(a, b RunningCommand) int {
+		return a.Started.Compare(b.Started)
+	})
+	return cmds
+}
+
+// trackPTY marks the descriptor fd as a pseudo-terminal in use by a command.
+func trackPTY(fd uintptr) {
+	tracker.Lock()
+	tracker.ptys[fd] = true
+	tracker.Unlock()
+}
+
+// releasePTY must be called before the descriptor fd is closed.
+func releasePTY(fd uintptr) {
+	tracker.Lock()
+	delete(tracker.ptys, fd)
+	tracker.Unlock()
+}
+
+func ptyInUse(fd uintptr) bool {
+	tracker.Lock()
+	defer tracker.Unlock()
+	return tracker.ptys[fd]
+}
@@ -0,0 +1,87 @@
+package sh
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defunctChildren scans /proc for zombie processes whose parent is the
+// current process.
+func defunctChildren() ([]ProcessInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	var zombies []ProcessInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		comm, fields, ok := readProcStat(pid)
+		if !ok || len(fields) < 2 || fields[0] != "Z" {
+			continue
+		}
+		if ppid, _ := strconv.Atoi(fields[1]); ppid != self {
+			continue
+		}
+
+		zombies = append(zombies, ProcessInfo{Pid: pid, Command: comm})
+	}
+	return zombies, nil
+}
+
+// readProcStat reads /proc/<pid>/stat and returns the command name and the
+// fields after it, starting with the state. It reports false if the process
+// is gone.
+func readProcStat(pid int) (comm string, fields []string, ok bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", nil, false
+	}
+
+	// Format: pid (comm) state ppid ...; comm may contain spaces and
+	// parentheses, so split at the last ')'.
+	stat := string(data)
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return "", nil, false
+	}
+	return stat[open+1 : end], strings.Fields(stat[end+1:]), true
+}
+
+// leakedPTYs lists descriptors of the current process beyond stdio that
+// refer to pseudo-terminals not in use by a running command.
+func leakedPTYs() ([]string, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil, err
+	}
+
+	var leaked []string
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil || fd <= 2 {
+			continue
+		}
+
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+		if target != "/dev/ptmx" && !strings.HasPrefix(target, "/dev/pts/") {
+			continue
+		}
+		if ptyInUse(uintptr(fd)) {
+			continue
+		}
+
+		leaked = append(leaked, "fd "+entry.Name()+" -> "+target)
+	}
+	return leaked, nil
+}
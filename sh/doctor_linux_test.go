@@ -0,0 +1,68 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestDoctor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A child that is never waited for becomes a zombie
+	proc, err := os.StartProcess("/bin/true", []string{"true"}, &os.ProcAttr{})
+	if err != nil {
+		t.Fatalf("StartProcess failed: %v", err)
+	}
+	defer proc.Wait()
+
+	// A pseudo-terminal opened outside of any command
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("No pseudo-terminals available: %v", err)
+	}
+	defer ptmx.Close()
+
+	cmd := sh.New("sleep").Arg("10").Build(ctx)
+	cmd.Start()
+	defer cmd.Cancel()
+	time.Sleep(200 * time.Millisecond)
+
+	report, err := sh.Doctor(ctx, sh.MaxRuntime(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+	if report.Healthy() {
+		t.Fatal("Expected problems to be reported")
+	}
+
+	var zombie bool
+	for _, z := range report.Zombies {
+		zombie = zombie || z.Pid == proc.Pid
+	}
+	if !zombie {
+		t.Errorf("Expected pid %d among zombies, got %+v", proc.Pid, report.Zombies)
+	}
+
+	if len(report.LongRunning) != 1 || report.LongRunning[0].Args[0] != "sleep" {
+		t.Errorf("Expected the sleep command to be long-running, got %+v", report.LongRunning)
+	}
+
+	var leaked bool
+	for _, l := range report.LeakedPTYs {
+		leaked = leaked || strings.HasSuffix(l, "/dev/ptmx")
+	}
+	if !leaked {
+		t.Errorf("Expected the open /dev/ptmx to be reported, got %v", report.LeakedPTYs)
+	}
+
+	report, _ = sh.Doctor(ctx)
+	if len(report.LongRunning) != 0 {
+		t.Errorf("Expected no long-running commands with the default threshold, got %+v", report.LongRunning)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sh
+
+// defunctChildren is only implemented on Linux.
+func defunctChildren() ([]ProcessInfo, error) {
+	return nil, nil
+}
+
+// leakedPTYs is only implemented on Linux.
+func leakedPTYs() ([]string, error) {
+	return nil, nil
+}
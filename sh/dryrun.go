@@ -0,0 +1,76 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Invocation describes how a command was, or in dry-run mode would have
+// been, executed.
+type Invocation struct {
+	// Args holds the command name followed by its arguments.
+	Args []string
+	// Env is the environment passed to the command; nil means the
+	// environment of the current process is inherited.
+	Env []string
+	// Dir is the working directory; empty means the current directory.
+	Dir string
+	// DryRun is true if the command was not actually run.
+	DryRun bool
+}
+
+var (
+	dryRun   atomic.Bool
+	dryRunMu sync.Mutex
+	dryRunW  io.Writer
+)
+
+// SetDryRun turns dry-run mode on or off for all commands, e.g. to
+// implement a --dry-run flag. In dry-run mode commands are not executed;
+// they succeed with empty output and their Result records the Invocation.
+func SetDryRun(enabled bool) {
+	dryRun.Store(enabled)
+}
+
+// SetDryRunOutput makes commands skipped in dry-run mode print their
+// shell-quoted command line to w. A nil w disables printing.
+func SetDryRunOutput(w io.Writer) {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	dryRunW = w
+}
+
+func (cm *cmdImpl) WithDryRun() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.dryRun = true
+	return cm
+}
+
+// dryRunResult reports the command without running it.
+func (cm *cmdImpl) dryRunResult() *resultImpl {
+	dryRunMu.Lock()
+	// Pipelines are printed as a whole by their last stage
+	if dryRunW != nil && cm.pipeOut == nil {
+		line := cm.String()
+		if cm.dir != "" {
+			line = "(cd " + quote(cm.dir) + " && " + line + ")"
+		}
+		fmt.Fprintln(dryRunW, line)
+	}
+	dryRunMu.Unlock()
+
+	return &resultImpl{
+		name:   cm.cmd,
+		stdout: []byte{},
+		stderr: []byte{},
+		invocation: Invocation{
+			Args:   append([]string{cm.cmd}, cm.args...),
+			Env:    cm.environ(),
+			Dir:    cm.dir,
+			DryRun: true,
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	sh.SetDryRun(true)
+	sh.SetDryRunOutput(&out)
+	defer sh.SetDryRun(false)
+	defer sh.SetDryRunOutput(nil)
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	result, err := sh.New("touch").Arg(marker).Build(ctx).WithEnv("MODE", "a b").WithDir("/tmp").Run()
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Expected the command not to run")
+	}
+
+	inv := result.Invocation()
+	if !inv.DryRun || !slices.Equal(inv.Args, []string{"touch", marker}) || inv.Dir != "/tmp" {
+		t.Errorf("Unexpected invocation: %+v", inv)
+	}
+	if !slices.Contains(inv.Env, "MODE=a b") {
+		t.Errorf("Expected the environment to be recorded, got %v", inv.Env)
+	}
+
+	sh.New("echo").Arg("hi").Build(ctx).Pipe("wc").OptB("-l").Build().Run()
+
+	want := "(cd /tmp && MODE='a b' touch " + marker + ")\necho hi | wc -l\n"
+	if out.String() != want {
+		t.Errorf("Unexpected dry-run output:\n got %q\nwant %q", out.String(), want)
+	}
+}
+
+func TestCmdWithDryRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("false").Build(ctx).WithDryRun().Run()
+	if err != nil || result.ExitCode() != 0 || !result.Invocation().DryRun {
+		t.Errorf("Expected a successful dry run, got %v", err)
+	}
+
+	result, err = sh.New("echo").Arg("real").Build(ctx).Run()
+	if err != nil || result.Invocation().DryRun || string(result.Stdout()) != "real\n" {
+		t.Errorf("Expected other commands to run, got %q, %v", result.Stdout(), err)
+	}
+	if inv := result.Invocation(); len(inv.Args) != 2 || inv.Args[1] != "real" {
+		t.Errorf("Expected the invocation of a real run, got %+v", inv)
+	}
+}
@@ -0,0 +1,7 @@
+package sh
+
+import "errors"
+
+// ErrElevationDenied is returned by commands run WithElevation when the user
+// declines the elevation prompt or may not run commands as administrator.
+var ErrElevationDenied = errors.New("sh: elevation denied")
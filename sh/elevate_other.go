@@ -0,0 +1,21 @@
+//go:build !unix && !windows
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func (cm *cmdImpl) WithElevation() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: elevation: %w", errors.ErrUnsupported)
+		},
+	})
+	return cm
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package sh
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+func (cm *cmdImpl) WithElevation() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.planTags = append(cm.planTags, "elevation")
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			if os.Geteuid() == 0 || cmd.Err != nil {
+				return nil
+			}
+			sudo, err := exec.LookPath("sudo")
+			if err != nil {
+				return fmt.Errorf("sh: elevation: %w", err)
+			}
+
+			// Prompt up front so that a refusal is told apart from the
+			// command failing
+			var stderr bytes.Buffer
+			validate := exec.CommandContext(cm.ctx, sudo, "-v")
+			validate.Stderr = &stderr
+			if err := validate.Run(); err != nil {
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					return fmt.Errorf("%w: %s", ErrElevationDenied, msg)
+				}
+				return fmt.Errorf("%w: %w", ErrElevationDenied, err)
+			}
+
+			args := []string{sudo, "-n"}
+			cm.mu.Lock()
+			if len(cm.env) > 0 {
+				keys := slices.Sorted(maps.Keys(cm.env))
+				args = append(args, "--preserve-env="+strings.Join(keys, ","))
+			}
+			cm.mu.Unlock()
+			args = append(args, "--", cmd.Path)
+			cmd.Args = append(args, cmd.Args[1:]...)
+			cmd.Path = sudo
+			return nil
+		},
+	})
+	return cm
+}
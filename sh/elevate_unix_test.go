@@ -0,0 +1,51 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithElevation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if os.Geteuid() == 0 {
+		// Already root: the command runs as is
+		result, err := sh.New("id").Arg("-u").Build(ctx).WithElevation().Run()
+		if err != nil {
+			t.Fatalf("Command failed: %v", err)
+		}
+		if got := strings.TrimSpace(string(result.Stdout())); got != "0" {
+			t.Errorf("Expected uid 0, got %q", got)
+		}
+		return
+	}
+
+	// A sudo that records its arguments and refuses validation
+	// when asked to
+	fakeTool(t, "sudo", `
+[ "$1" = -v ] && { [ -n "$DENY" ] && { echo "Sorry, user may not run sudo" >&2; exit 1; }; exit 0; }
+printf '%s\n' "$*"`)
+
+	result, err := sh.New("whoami").Build(ctx).WithEnv("A", "1").WithElevation().Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if got := string(result.Stdout()); !strings.HasPrefix(got, "-n --preserve-env=A -- /") || !strings.HasSuffix(got, "/whoami\n") {
+		t.Errorf("Unexpected sudo invocation: %q", got)
+	}
+
+	t.Setenv("DENY", "1")
+	_, err = sh.New("whoami").Build(ctx).WithElevation().Run()
+	if !errors.Is(err, sh.ErrElevationDenied) || !strings.Contains(err.Error(), "may not run sudo") {
+		t.Errorf("Expected ErrElevationDenied, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build windows
+
+package sh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var procShellExecuteExW = syscall.NewLazyDLL("shell32.dll").NewProc("ShellExecuteExW")
+
+const (
+	seeMaskNoCloseProcess = 0x00000040
+	seeMaskNoAsync        = 0x00000100
+	swHide                = 0
+	errorCancelled        = syscall.Errno(1223)
+)
+
+// shellExecuteInfo is SHELLEXECUTEINFOW.
+type shellExecuteInfo struct {
+	cbSize       uint32
+	fMask        uint32
+	hwnd         uintptr
+	lpVerb       *uint16
+	lpFile       *uint16
+	lpParameters *uint16
+	lpDirectory  *uint16
+	nShow        int32
+	hInstApp     uintptr
+	lpIDList     uintptr
+	lpClass      *uint16
+	hkeyClass    uintptr
+	dwHotKey     uint32
+	hIcon        uintptr
+	hProcess     syscall.Handle
+}
+
+func (cm *cmdImpl) WithElevation() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.planTags = append(cm.planTags, "elevation")
+
+	// The elevated process is started by the shell rather than os/exec,
+	// so it replaces the innermost RunFunc.
+	cm.middleware = append(cm.middleware, func(RunFunc) RunFunc {
+		return func(ctx context.Context, e *Execution) error {
+			if e.Exec.Err != nil {
+				return &StartError{Cmd: cm.cmd, Stage: StageLookPath, Err: e.Exec.Err}
+			}
+			code, err := runElevated(ctx, e.Exec.Path, e.Exec.Args[1:], e.Exec.Dir)
+			if err != nil {
+				return &StartError{Cmd: cm.cmd, Stage: StageExec, Err: err}
+			}
+			if code != 0 {
+				return &ExitError{Cmd: cm.cmd, ExitCode: code}
+			}
+			return nil
+		}
+	})
+	return cm
+}
+
+// runElevated starts path with the "runas" verb, which shows the UAC prompt,
+// and waits for it to exit.
+func runElevated(ctx context.Context, path string, args []string, dir string) (int, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = syscall.EscapeArg(arg)
+	}
+
+	info := shellExecuteInfo{
+		fMask:        seeMaskNoCloseProcess | seeMaskNoAsync,
+		lpVerb:       syscall.StringToUTF16Ptr("runas"),
+		lpFile:       syscall.StringToUTF16Ptr(path),
+		lpParameters: syscall.StringToUTF16Ptr(strings.Join(quoted, " ")),
+		nShow:        swHide,
+	}
+	info.cbSize = uint32(unsafe.Sizeof(info))
+	if dir != "" {
+		info.lpDirectory = syscall.StringToUTF16Ptr(dir)
+	}
+
+	if ok, _, err := procShellExecuteExW.Call(uintptr(unsafe.Pointer(&info))); ok == 0 {
+		if err == errorCancelled {
+			return -1, fmt.Errorf("%w: %w", ErrElevationDenied, err)
+		}
+		return -1, err
+	}
+	defer syscall.CloseHandle(info.hProcess)
+
+	for {
+		event, err := syscall.WaitForSingleObject(info.hProcess, 100)
+		if err != nil {
+			return -1, err
+		}
+		if event == syscall.WAIT_OBJECT_0 {
+			break
+		}
+		if ctx.Err() != nil {
+			syscall.TerminateProcess(info.hProcess, 1)
+			return -1, ctx.Err()
+		}
+	}
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(info.hProcess, &code); err != nil {
+		return -1, err
+	}
+	return int(code), nil
+}
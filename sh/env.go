@@ -0,0 +1,264 @@
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// scrubDefault is the default set with ScrubDefaultEnv, if any. Newly
+// built commands start from a scrubbed environment keeping its variables.
+var scrubDefault atomic.Pointer[scrubConfig]
+
+// scrubConfig lists the variables kept when scrubbing the environment. It is
+// never modified once stored in scrubDefault.
+type scrubConfig struct {
+	keep []string
+}
+
+// EnvSnapshot is a copy of the process environment taken by SnapshotEnv.
+type EnvSnapshot struct {
+	vars []string
+}
+
+// SnapshotEnv captures the current process environment so it can be put
+// back with RestoreEnv, e.g. at the end of a test.
+func SnapshotEnv() EnvSnapshot {
+	return EnvSnapshot{vars: os.Environ()}
+}
+
+// RestoreEnv replaces the process environment with the snapshot's contents.
+func RestoreEnv(s EnvSnapshot) error {
+	os.Clearenv()
+	for _, kv := range s.vars {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrubDefaultEnv makes commands built after the call inherit only the host
+// environment variables matching keep, as if WithScrubbedEnv(keep...) had
+// been called on each of them. The returned function restores the previous
+// default. It is safe to call while other goroutines build commands.
+func ScrubDefaultEnv(keep ...string) (restore func()) {
+	prev := scrubDefault.Swap(&scrubConfig{keep: slices.Clone(keep)})
+
+	return func() {
+		scrubDefault.Store(prev)
+	}
+}
+
+func (cm *cmdImpl) WithScrubbedEnv(keep ...string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.scrubEnv = true
+	cm.envKeep = keep
+	return cm
+}
+
+func (cm *cmdImpl) WithEnvInherit(inherit bool) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.envNoInherit = !inherit
+	if inherit {
+		cm.scrubEnv = false
+	}
+	return cm
+}
+
+func (cm *cmdImpl) WithEnvMap(env map[string]string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for k, v := range env {
+		cm.setEnv(k, v)
+	}
+	return cm
+}
+
+func (cm *cmdImpl) WithEnvFile(path string) Cmd {
+	env, err := readEnvFile(path)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if err != nil {
+		cm.hooks = append(cm.hooks, execHook{
+			before: func(*exec.Cmd) error {
+				return &StartError{Cmd: cm.cmd, Stage: StageEnv, Err: err}
+			},
+		})
+		return cm
+	}
+	for _, kv := range env {
+		cm.setEnv(kv[0], kv[1])
+	}
+	return cm
+}
+
+func (cm *cmdImpl) WithoutEnv(key string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.env, key)
+	if !slices.Contains(cm.envUnset, key) {
+		cm.envUnset = append(cm.envUnset, key)
+	}
+	return cm
+}
+
+// setEnv sets a variable, undoing an earlier WithoutEnv. The caller must
+// hold cm.mu.
+func (cm *cmdImpl) setEnv(key, value string) {
+	if cm.env == nil {
+		cm.env = make(map[string]string)
+	}
+	cm.env[key] = value
+	cm.envUnset = slices.DeleteFunc(cm.envUnset, func(k string) bool { return k == key })
+}
+
+// environ returns the environment for the child process, or nil if it should
+// inherit the environment of the current process unchanged.
+func (cm *cmdImpl) environ() []string {
+	if !cm.scrubEnv && !cm.envNoInherit && len(cm.env) == 0 && len(cm.envUnset) == 0 {
+		return nil
+	}
+
+	var env []string
+	switch {
+	case cm.envNoInherit:
+	case cm.scrubEnv:
+		env = filterEnv(os.Environ(), cm.envKeep)
+	default:
+		env = os.Environ()
+	}
+	env = slices.DeleteFunc(env, func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		_, set := cm.env[name]
+		return set || slices.Contains(cm.envUnset, name)
+	})
+	for _, k := range slices.Sorted(maps.Keys(cm.env)) {
+		env = append(env, k+"="+cm.env[k])
+	}
+	return env
+}
+
+// readEnvFile parses a dotenv file: KEY=VALUE lines, optionally prefixed
+// with "export", with # comments. Single-quoted values are taken literally,
+// double-quoted values may contain \n, \t, \" and \\ escapes and span
+// lines. Variables are not expanded. It returns the pairs in file order.
+func readEnvFile(name string) ([][2]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env [][2]string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid line %q", name, lineNo, line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated quote", name, lineNo)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			// Double-quoted values may continue on the following lines
+			for !closedQuote(value) && scanner.Scan() {
+				lineNo++
+				value += "\n" + scanner.Text()
+			}
+			if !closedQuote(value) {
+				return nil, fmt.Errorf("%s:%d: unterminated quote", name, lineNo)
+			}
+			value = unescapeDouble(value[1:])
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env = append(env, [2]string{key, value})
+	}
+	return env, scanner.Err()
+}
+
+// closedQuote reports whether the double-quoted value starting s has its
+// closing quote.
+func closedQuote(s string) bool {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeDouble returns the contents of a double-quoted value up to its
+// closing quote, with escapes resolved.
+func unescapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			break
+		}
+		if c == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			default:
+				c = s[i]
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// filterEnv returns the entries of env whose names match one of the keep
+// patterns. Patterns use path.Match syntax, so "LC_*" keeps all locale
+// variables.
+func filterEnv(env []string, keep []string) []string {
+	kept := make([]string, 0, len(keep))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range keep {
+			if ok, _ := path.Match(pattern, name); ok {
+				kept = append(kept, kv)
+				break
+			}
+		}
+	}
+	return kept
+}
@@ -0,0 +1,187 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestSnapshotRestoreEnv(t *testing.T) {
+	snapshot := sh.SnapshotEnv()
+	defer sh.RestoreEnv(snapshot)
+
+	os.Setenv("SH_SNAPSHOT_ADDED", "1")
+	os.Unsetenv("PATH")
+
+	if err := sh.RestoreEnv(snapshot); err != nil {
+		t.Fatalf("RestoreEnv failed: %v", err)
+	}
+
+	if _, ok := os.LookupEnv("SH_SNAPSHOT_ADDED"); ok {
+		t.Error("Expected SH_SNAPSHOT_ADDED to be removed by RestoreEnv")
+	}
+	if os.Getenv("PATH") == "" {
+		t.Error("Expected PATH to be restored by RestoreEnv")
+	}
+}
+
+func TestCmdWithScrubbedEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Setenv("SH_SECRET_TOKEN", "hunter2")
+	t.Setenv("SH_KEEP_ME", "kept")
+
+	result, err := sh.New("env").
+		Build(ctx).
+		WithScrubbedEnv("SH_KEEP_*").
+		WithEnv("SH_EXPLICIT", "set").
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	output := string(result.Stdout())
+	if strings.Contains(output, "SH_SECRET_TOKEN") {
+		t.Errorf("Expected SH_SECRET_TOKEN to be scrubbed, got: %s", output)
+	}
+	if !strings.Contains(output, "SH_KEEP_ME=kept") {
+		t.Errorf("Expected SH_KEEP_ME to be kept, got: %s", output)
+	}
+	if !strings.Contains(output, "SH_EXPLICIT=set") {
+		t.Errorf("Expected SH_EXPLICIT to be set, got: %s", output)
+	}
+}
+
+func TestScrubDefaultEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Setenv("SH_SECRET_TOKEN", "hunter2")
+
+	restore := sh.ScrubDefaultEnv("PATH")
+	scrubbed := sh.New("env").Build(ctx)
+	restore()
+	inherited := sh.New("env").Build(ctx)
+
+	result, err := scrubbed.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if strings.Contains(string(result.Stdout()), "SH_SECRET_TOKEN") {
+		t.Errorf("Expected SH_SECRET_TOKEN to be scrubbed, got: %s", result.Stdout())
+	}
+
+	result, err = inherited.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if !strings.Contains(string(result.Stdout()), "SH_SECRET_TOKEN=hunter2") {
+		t.Errorf("Expected SH_SECRET_TOKEN after restore, got: %s", result.Stdout())
+	}
+}
+
+func TestScrubDefaultEnvConcurrentBuilds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Run with -race: toggling the default must not race with builds
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				sh.New("env").Build(ctx)
+			}
+		}()
+	}
+	for range 100 {
+		sh.ScrubDefaultEnv("PATH")()
+	}
+	wg.Wait()
+}
+
+func TestCmdWithEnvInherits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Setenv("SH_INHERITED", "yes")
+	t.Setenv("SH_REMOVED", "yes")
+
+	result, err := sh.New("env").
+		Build(ctx).
+		WithEnv("SH_EXPLICIT", "set").
+		WithEnvMap(map[string]string{"SH_A": "1", "SH_B": "2"}).
+		WithoutEnv("SH_REMOVED").
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	output := string(result.Stdout())
+	for _, want := range []string{"PATH=", "SH_INHERITED=yes", "SH_EXPLICIT=set", "SH_A=1", "SH_B=2"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %s in the environment, got: %s", want, output)
+		}
+	}
+	if strings.Contains(output, "SH_REMOVED") {
+		t.Errorf("Expected SH_REMOVED to be removed, got: %s", output)
+	}
+
+	result, err = sh.New("/usr/bin/env").Build(ctx).WithEnvInherit(false).WithEnv("ONLY", "me").Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if got := string(result.Stdout()); got != "ONLY=me\n" {
+		t.Errorf("Expected only the explicit variable, got: %q", got)
+	}
+}
+
+func TestCmdWithEnvFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# comment
+export SH_PLAIN=plain value # trailing comment
+SH_SINGLE='literal $HOME \n'
+SH_DOUBLE="tab\there \"quoted\""
+SH_MULTI="line1
+line2"
+SH_EMPTY=
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sh.New("env").Build(ctx).WithEnvFile(path).WithEnv("SH_PLAIN", "override").Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	output := string(result.Stdout())
+	for _, want := range []string{
+		"SH_PLAIN=override\n",
+		`SH_SINGLE=literal $HOME \n` + "\n",
+		"SH_DOUBLE=tab\there \"quoted\"\n",
+		"SH_MULTI=line1\nline2\n",
+		"SH_EMPTY=\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in the environment, got: %s", want, output)
+		}
+	}
+
+	_, err = sh.New("true").Build(ctx).WithEnvFile(filepath.Join(t.TempDir(), "missing")).Run()
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) || startErr.Stage != sh.StageEnv {
+		t.Errorf("Expected an env StartError, got %v", err)
+	}
+}
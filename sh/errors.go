@@ -0,0 +1,62 @@
+package sh
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"syscall"
+)
+
+// Exit codes used by POSIX shells when a command cannot be run.
+const (
+	exitNotExecutable = 126
+	exitNotFound      = 127
+)
+
+// The matchers below classify errors returned by commands using error
+// values, errno and exit codes rather than message text, so they behave the
+// same on systems with localized error messages.
+
+// IsNotFound reports whether err means the command or a file it needed does
+// not exist, including a shell exiting with status 127.
+func IsNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) ||
+		errors.Is(err, fs.ErrNotExist) ||
+		exitCodeIs(err, exitNotFound)
+}
+
+// IsPermissionDenied reports whether err is caused by missing permissions
+// (EACCES or EPERM).
+func IsPermissionDenied(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotExecutable reports whether err means the command exists but cannot
+// be executed: it lacks execute permission, is not a valid executable for
+// this system, or a shell exited with status 126.
+func IsNotExecutable(err error) bool {
+	return errors.Is(err, syscall.EACCES) ||
+		errors.Is(err, syscall.ENOEXEC) ||
+		exitCodeIs(err, exitNotExecutable)
+}
+
+// ExitCode returns the exit code carried by err and whether err is an exit
+// error at all. Commands killed by a signal report -1. Both *ExitError,
+// including those reported by an Executor, a cached run or Result.Check,
+// and *exec.ExitError are recognized.
+func ExitCode(err error) (int, bool) {
+	var shExitErr *ExitError
+	if errors.As(err, &shExitErr) {
+		return shExitErr.ExitCode, true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	return exitErr.ExitCode(), true
+}
+
+func exitCodeIs(err error, code int) bool {
+	got, ok := ExitCode(err)
+	return ok && got == code
+}
@@ -0,0 +1,76 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/shtest"
+)
+
+func TestErrorMatchers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Force a locale that would localize messages where supported
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+
+	_, err := sh.New("definitely-not-a-command-xyz").Build(ctx).Run()
+	if !sh.IsNotFound(err) {
+		t.Errorf("Expected IsNotFound for a missing command, got %v", err)
+	}
+
+	script := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sh.New(script).Build(ctx).Run()
+	if !sh.IsNotExecutable(err) || !sh.IsPermissionDenied(err) {
+		t.Errorf("Expected IsNotExecutable and IsPermissionDenied for a non-executable file, got %v", err)
+	}
+	if sh.IsNotFound(err) {
+		t.Errorf("Did not expect IsNotFound for an existing file, got %v", err)
+	}
+
+	_, err = sh.New("sh").OptV("-c", "exit 127").Build(ctx).Run()
+	if !sh.IsNotFound(err) {
+		t.Errorf("Expected IsNotFound for shell status 127, got %v", err)
+	}
+	if code, ok := sh.ExitCode(err); !ok || code != 127 {
+		t.Errorf("Expected exit code 127, got %d", code)
+	}
+
+	_, err = sh.New("sh").OptV("-c", "exit 1").Build(ctx).Run()
+	if sh.IsNotFound(err) || sh.IsNotExecutable(err) || sh.IsPermissionDenied(err) {
+		t.Errorf("Did not expect a plain failure to match, got %v", err)
+	}
+	if _, ok := sh.ExitCode(nil); ok {
+		t.Error("Expected no exit code for a nil error")
+	}
+}
+
+func TestErrorMatchersFakeExit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fake := shtest.New()
+	fake.Expect("deploy", shtest.Rest).Exit(127)
+	fake.Expect("install", shtest.Rest).Exit(126)
+	fake.Install(t)
+
+	_, err := sh.New("deploy").Build(ctx).Run()
+	if code, ok := sh.ExitCode(err); !ok || code != 127 {
+		t.Errorf("Expected exit code 127 from a faked run, got %d, %v", code, ok)
+	}
+	if !sh.IsNotFound(err) {
+		t.Errorf("Expected IsNotFound for faked status 127, got %v", err)
+	}
+
+	_, err = sh.New("install").Build(ctx).Run()
+	if !sh.IsNotExecutable(err) {
+		t.Errorf("Expected IsNotExecutable for faked status 126, got %v", err)
+	}
+}
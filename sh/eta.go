@@ -0,0 +1,168 @@
+package sh
+
+import (
+	"fmt"
+	"time"
+)
+
+// etaSmoothing is the weight exponential smoothing gives the most recent
+// duration of an item when estimating how long its next run takes.
+const etaSmoothing = 0.3
+
+// BatchProgress reports how far a batch has got, before and after each
+// item runs.
+type BatchProgress struct {
+	// Done counts the items completed, including those skipped because a
+	// previous run completed them, out of Total.
+	Done  int
+	Total int
+	// Current is the key of the item about to run, or empty once it has
+	// finished.
+	Current string
+	// Elapsed is the time since the batch started.
+	Elapsed time.Duration
+	// Remaining estimates how long the items left take, Current included.
+	// It is only meaningful if Estimated is true.
+	Remaining time.Duration
+	// Estimated is false while nothing is known about the duration of some
+	// item left: it has no history and no item has finished in this run.
+	Estimated bool
+}
+
+// String renders the progress on one line, e.g. "3/10 build-web, about
+// 2m10s left".
+func (p BatchProgress) String() string {
+	s := fmt.Sprintf("%d/%d", p.Done, p.Total)
+	if p.Current != "" {
+		s += " " + p.Current
+	}
+	if p.Estimated && p.Done < p.Total {
+		s += fmt.Sprintf(", about %v left", p.Remaining.Round(time.Second))
+	}
+	return s
+}
+
+// History records the duration of every item run in store, under the
+// item's key, and uses the durations recorded by earlier runs to estimate
+// the time remaining reported to OnProgress. Recent runs weigh more than
+// older ones, so the estimate follows items getting slower or faster.
+func (b *Batch) History(store *StateStore) *Batch {
+	b.history = store
+	return b
+}
+
+// OnProgress calls f before and after each item runs, from the goroutine
+// calling Run.
+func (b *Batch) OnProgress(f func(BatchProgress)) *Batch {
+	b.onProgress = f
+	return b
+}
+
+func (b *Batch) progress(p BatchProgress) {
+	if b.onProgress != nil {
+		b.onProgress(p)
+	}
+}
+
+// batchETA tracks the progress of a batch run and estimates its end.
+type batchETA struct {
+	pending   []string                 // keys of the items left, in order
+	estimates map[string]time.Duration // from the history, by key
+	observed  time.Duration            // smoothed durations of this run
+	total     int
+	done      int
+	began     time.Time
+	current   string
+	started   time.Time // of the current item
+}
+
+// newETA prepares the estimate for the items of b not in done, reading
+// their past durations from the history, if any.
+func (b *Batch) newETA(done map[string]bool) *batchETA {
+	e := &batchETA{total: len(b.items), estimates: make(map[string]time.Duration), began: time.Now()}
+	for _, item := range b.items {
+		if done[item.key] {
+			e.done++
+			continue
+		}
+		e.pending = append(e.pending, item.key)
+		if b.history == nil {
+			continue
+		}
+		records, err := b.history.Query(StateQuery{Key: item.key})
+		if err != nil {
+			continue // the estimate is best effort
+		}
+		var durations []time.Duration
+		for _, rec := range records {
+			if !rec.Failed() {
+				durations = append(durations, rec.Duration)
+			}
+		}
+		if len(durations) > 0 {
+			e.estimates[item.key] = smoothDurations(durations)
+		}
+	}
+	return e
+}
+
+// smoothDurations returns the exponentially smoothed value of durations,
+// given oldest first.
+func smoothDurations(durations []time.Duration) time.Duration {
+	s := float64(durations[0])
+	for _, d := range durations[1:] {
+		s = etaSmoothing*float64(d) + (1-etaSmoothing)*s
+	}
+	return time.Duration(s)
+}
+
+func (e *batchETA) start(key string) BatchProgress {
+	e.current = key
+	e.started = time.Now()
+	return e.snapshot()
+}
+
+func (e *batchETA) finish(key string) BatchProgress {
+	d := time.Since(e.started)
+	if e.observed == 0 {
+		e.observed = d
+	} else {
+		e.observed = smoothDurations([]time.Duration{e.observed, d})
+	}
+	e.done++
+	e.current = ""
+	for i, k := range e.pending {
+		if k == key {
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			break
+		}
+	}
+	return e.snapshot()
+}
+
+func (e *batchETA) snapshot() BatchProgress {
+	p := BatchProgress{
+		Done:      e.done,
+		Total:     e.total,
+		Current:   e.current,
+		Elapsed:   time.Since(e.began),
+		Estimated: true,
+	}
+	for _, key := range e.pending {
+		d, ok := e.estimates[key]
+		if !ok {
+			// Items without history are expected to take as long as those
+			// of this run did
+			d, ok = e.observed, e.observed > 0
+		}
+		if !ok {
+			p.Estimated = false
+			continue
+		}
+		if key == e.current {
+			d = max(d-time.Since(e.started), 0)
+		}
+		p.Remaining += d
+	}
+	return p
+}
@@ -0,0 +1,59 @@
+package sh_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBatchProgressETA(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	store, err := sh.OpenStateStore(filepath.Join(dir, "state.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+	// The smoothed estimate leans towards the recent run: 0.3*40 + 0.7*10
+	// minutes; the failed run is ignored
+	for _, d := range []time.Duration{10 * time.Minute, 40 * time.Minute} {
+		store.Record(sh.RunRecord{Key: "slow", Duration: d})
+	}
+	store.Record(sh.RunRecord{Key: "slow", Duration: time.Hour, ExitCode: 1})
+
+	var progress []sh.BatchProgress
+	_, err = sh.NewBatch(filepath.Join(dir, "batch.checkpoint")).
+		Add("fresh", func(ctx context.Context) sh.Cmd { return sh.New("true").Build(ctx) }).
+		Add("slow", func(ctx context.Context) sh.Cmd { return sh.New("true").Build(ctx) }).
+		History(store).
+		OnProgress(func(p sh.BatchProgress) { progress = append(progress, p) }).
+		Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(progress) != 4 {
+		t.Fatalf("Expected progress before and after each item, got %+v", progress)
+	}
+	if first := progress[0]; first.Estimated || first.Current != "fresh" || first.Total != 2 {
+		t.Errorf("Expected no estimate before anything is known about fresh, got %+v", first)
+	}
+	if p := progress[2]; !p.Estimated || p.Done != 1 || p.Remaining < 18*time.Minute || p.Remaining > 19*time.Minute {
+		t.Errorf("Expected about 19m left for slow, got %+v", p)
+	}
+	if progress[2].String() != "1/2 slow, about 19m0s left" {
+		t.Errorf("Unexpected rendering %q", progress[2])
+	}
+	if last := progress[3]; last.Done != 2 || last.Remaining != 0 || last.String() != "2/2" {
+		t.Errorf("Expected the batch to be done, got %+v", last)
+	}
+
+	stats, _ := store.Stats("fresh")
+	if stats.Runs != 1 {
+		t.Errorf("Expected the run of fresh to be recorded, got %+v", stats)
+	}
+}
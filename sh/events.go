@@ -0,0 +1,223 @@
+package sh
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+)
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// EventStarted is sent once the process has started.
+	EventStarted EventKind = iota
+	// EventStdout carries a line of stdout.
+	EventStdout
+	// EventStderr carries a line of stderr.
+	EventStderr
+	// EventExited is the last event, sent once the command has finished.
+	EventExited
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStarted:
+		return "started"
+	case EventStdout:
+		return "stdout"
+	case EventStderr:
+		return "stderr"
+	case EventExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// Event is something that happened to a running command, as delivered by
+// Cmd.Events.
+type Event struct {
+	Kind EventKind
+	// Pid is the process ID, for EventStarted.
+	Pid int
+	// Line is a line of output without its newline, for EventStdout and
+	// EventStderr.
+	Line string
+	// Result and Err are what Wait returns, for EventExited.
+	Result Result
+	Err    error
+}
+
+func (cm *cmdImpl) TryWait() (Result, bool) {
+	if !cm.IsDone() {
+		return nil, false
+	}
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.result, true
+}
+
+func (cm *cmdImpl) Events() <-chan Event {
+	cm.mu.Lock()
+	q := newEventQueue(cm.backpressure, cm.lineBuffer)
+	stdout := &eventWriter{kind: EventStdout, q: q}
+	stderr := &eventWriter{kind: EventStderr, q: q}
+	if !cm.binary {
+		cm.stdout = appendWriter(cm.stdout, stdout)
+	}
+	cm.stderr = appendWriter(cm.stderr, stderr)
+	cm.hooks = append(cm.hooks, execHook{
+		started: func(cmd *exec.Cmd) error {
+			q.start(Event{Kind: EventStarted, Pid: cmd.Process.Pid})
+			return nil
+		},
+	})
+	done := cm.done
+	cm.mu.Unlock()
+
+	goSafe(func() {
+		<-done
+		stdout.flush()
+		stderr.flush()
+		cm.mu.RLock()
+		q.push(Event{Kind: EventExited, Result: cm.result, Err: cm.err})
+		cm.mu.RUnlock()
+		q.close()
+	})
+	return q.out
+}
+
+// eventQueue delivers events on out in order. Without a limit it never
+// blocks the command on a slow receiver; with one, output events beyond the
+// limit are handled as policy says. Events are held back until the started
+// event so that it always comes first.
+type eventQueue struct {
+	mu      sync.Mutex
+	space   *sync.Cond // signalled when pending shrinks
+	pending []Event
+	policy  Backpressure
+	limit   int
+	started bool
+	closed  bool
+	wake    chan struct{}
+	out     chan Event
+}
+
+func newEventQueue(policy Backpressure, limit int) *eventQueue {
+	q := &eventQueue{policy: policy, limit: limit, wake: make(chan struct{}, 1), out: make(chan Event)}
+	q.space = sync.NewCond(&q.mu)
+	goSafe(q.pump)
+	return q
+}
+
+// push queues e. The started and exited events are never dropped or held
+// up.
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	output := e.Kind == EventStdout || e.Kind == EventStderr
+	for output && q.limit > 0 && q.queuedLines() >= q.limit {
+		switch q.policy {
+		case BackpressureDropNewest:
+			q.mu.Unlock()
+			return
+		case BackpressureDropOldest:
+			i := len(q.pending) - q.queuedLines()
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		default:
+			q.space.Wait()
+		}
+	}
+	q.pending = append(q.pending, e)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// queuedLines returns the number of output events pending, which is all
+// but a leading started event.
+func (q *eventQueue) queuedLines() int {
+	if len(q.pending) > 0 && q.pending[0].Kind == EventStarted {
+		return len(q.pending) - 1
+	}
+	return len(q.pending)
+}
+
+// start queues e ahead of any output received so far and releases the
+// queue.
+func (q *eventQueue) start(e Event) {
+	q.mu.Lock()
+	q.pending = append([]Event{e}, q.pending...)
+	q.started = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close releases the queue and closes out once all events are delivered.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) pump() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 || !q.started && !q.closed {
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				close(q.out)
+				return
+			}
+			<-q.wake
+			continue
+		}
+		e := q.pending[0]
+		q.pending = q.pending[1:]
+		q.space.Broadcast()
+		q.mu.Unlock()
+
+		q.out <- e
+	}
+}
+
+// eventWriter queues each line written to it as an event of kind.
+type eventWriter struct {
+	kind    EventKind
+	q       *eventQueue
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.q.push(Event{Kind: w.kind, Line: string(bytes.TrimSuffix(w.partial[:i], []byte("\r")))})
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush queues a trailing line without a newline.
+func (w *eventWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.q.push(Event{Kind: w.kind, Line: string(w.partial)})
+		w.partial = nil
+	}
+}
@@ -0,0 +1,124 @@
+package sh_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdTryWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stdin, release := io.Pipe()
+	cmd := sh.New("sh").OptV("-c", "read line; exit 3").Build(ctx).WithStdin(stdin)
+
+	if _, ok := cmd.TryWait(); ok {
+		t.Fatal("Expected TryWait to fail before the command started")
+	}
+	cmd.Start()
+	if _, ok := cmd.TryWait(); ok {
+		t.Fatal("Expected TryWait to fail while the command is running")
+	}
+	release.Close()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			result, ok := cmd.TryWait()
+			if !ok {
+				continue
+			}
+			if result.ExitCode() != 3 {
+				t.Errorf("Expected exit code 3, got %d", result.ExitCode())
+			}
+			if _, err := cmd.Wait(); err == nil {
+				t.Error("Expected Wait to report the failure")
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("Command did not finish")
+		}
+	}
+}
+
+func TestCmdEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").OptV("-c", "echo one; echo oops >&2; printf two; exit 2").Build(ctx)
+	events := cmd.Events()
+	cmd.Start()
+
+	var got []sh.Event
+	for open := true; open; {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				open = false
+				break
+			}
+			got = append(got, e)
+		case <-ctx.Done():
+			t.Fatal("Events were not closed")
+		}
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("Expected 5 events, got %+v", got)
+	}
+	if got[0].Kind != sh.EventStarted || got[0].Pid <= 0 {
+		t.Errorf("Expected a started event with a pid first, got %+v", got[0])
+	}
+
+	var stdout, stderr []string
+	for _, e := range got[1:4] {
+		switch e.Kind {
+		case sh.EventStdout:
+			stdout = append(stdout, e.Line)
+		case sh.EventStderr:
+			stderr = append(stderr, e.Line)
+		default:
+			t.Errorf("Expected a line event, got %v", e.Kind)
+		}
+	}
+	if len(stdout) != 2 || stdout[0] != "one" || stdout[1] != "two" {
+		t.Errorf("Unexpected stdout lines %q", stdout)
+	}
+	if len(stderr) != 1 || stderr[0] != "oops" {
+		t.Errorf("Unexpected stderr lines %q", stderr)
+	}
+
+	exited := got[4]
+	if exited.Kind != sh.EventExited || exited.Err == nil || exited.Result.ExitCode() != 2 {
+		t.Errorf("Expected an exited event with exit code 2, got %+v", exited)
+	}
+}
+
+func TestCmdEventsSlowReceiver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("1").Arg("10000").Build(ctx)
+	events := cmd.Events()
+
+	// The command finishes although nobody is receiving yet
+	if _, err := cmd.Wait(); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	lines := 0
+	for e := range events {
+		if e.Kind == sh.EventStdout {
+			lines++
+		}
+	}
+	if lines != 10000 {
+		t.Errorf("Expected 10000 lines, got %d", lines)
+	}
+}
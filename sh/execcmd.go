@@ -0,0 +1,94 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// FromExecCmd adopts an unstarted exec.Cmd, so that code building exec.Cmds
+// can move to this package one call site at a time while gaining Results,
+// pipes and the rest. The command runs with c's path, arguments, working
+// directory and environment, reads c.Stdin and copies its output to
+// c.Stdout and c.Stderr besides capturing it; SysProcAttr, ExtraFiles and
+// WaitDelay are carried over as well.
+//
+// The context of exec.CommandContext cannot be recovered, so the command
+// is bound to ctx instead, and c.Cancel, which may refer to c itself, is
+// replaced by this package's cancellation:
+//
+//	c := exec.Command("git", "status", "--short")
+//	c.Dir = repo
+//	result, err := sh.FromExecCmd(ctx, c).Run()
+func FromExecCmd(ctx context.Context, c *exec.Cmd) Cmd {
+	name := c.Path
+	var args []string
+	if len(c.Args) > 0 {
+		// Keep the name as given, e.g. "git" rather than /usr/bin/git, for
+		// String and logs; the path is restored before the command starts
+		name, args = c.Args[0], c.Args[1:]
+	}
+
+	b := FromContext(ctx).New(name)
+	for _, arg := range args {
+		b.Arg(arg)
+	}
+	cm := b.Build(ctx).(*cmdImpl)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if c.Dir != "" {
+		cm.dir = c.Dir
+	}
+	if c.Env != nil {
+		cm.envNoInherit = true
+		for _, kv := range c.Env {
+			if key, value, ok := strings.Cut(kv, "="); ok {
+				cm.setEnv(key, value)
+			}
+		}
+	}
+	if c.Stdin != nil {
+		cm.stdin = c.Stdin
+	}
+	cm.stdout = appendWriter(cm.stdout, c.Stdout)
+	cm.stderr = appendWriter(cm.stderr, c.Stderr)
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			if c.Process != nil {
+				return errors.New("exec: already started")
+			}
+			if c.Err == nil && c.Path != "" {
+				cmd.Path = c.Path
+			}
+			if c.SysProcAttr != nil {
+				cmd.SysProcAttr = c.SysProcAttr
+			}
+			cmd.ExtraFiles = c.ExtraFiles
+			if c.WaitDelay > 0 {
+				cmd.WaitDelay = c.WaitDelay
+			}
+			return nil
+		},
+	})
+	return cm
+}
+
+func (cm *cmdImpl) ToExecCmd() *exec.Cmd {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	c := exec.CommandContext(cm.ctx, cm.cmd, cm.args...)
+	if cm.arg0 != "" {
+		c.Args[0] = cm.arg0
+	}
+	c.Dir = cm.dir
+	c.Env = cm.environ()
+	c.Stdin = cm.stdin
+	c.Stdout = cm.stdout
+	if cm.pipeOut != nil {
+		c.Stdout = appendWriter(c.Stdout, cm.pipeOut)
+	}
+	c.Stderr = cm.stderr
+	return c
+}
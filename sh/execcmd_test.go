@@ -0,0 +1,68 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestFromExecCmd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	var stdout bytes.Buffer
+	c := exec.Command("sh", "-c", `read line; echo "$line in $PWD with $GREETING"`)
+	c.Dir = dir
+	c.Env = []string{"GREETING=hello", "PATH=/usr/bin:/bin"}
+	c.Stdin = strings.NewReader("input\n")
+	c.Stdout = &stdout
+
+	cmd := sh.FromExecCmd(ctx, c)
+	if got := cmd.String(); !strings.HasPrefix(got, "GREETING=hello PATH=/usr/bin:/bin sh -c ") {
+		t.Errorf("Expected the command line of the exec.Cmd, got %q", got)
+	}
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "input in " + dir + " with hello"
+	if result.TrimmedString() != want {
+		t.Errorf("Expected %q captured, got %q", want, result.TrimmedString())
+	}
+	if strings.TrimSpace(stdout.String()) != want {
+		t.Errorf("Expected the output copied to the exec.Cmd's stdout, got %q", stdout.String())
+	}
+}
+
+func TestFromExecCmdNotFound(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := sh.FromExecCmd(ctx, exec.Command("no-such-command-sh-test")).Run(); err == nil {
+		t.Error("Expected a missing executable to fail")
+	}
+}
+
+func TestToExecCmd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	c := sh.New("sh").Arg("-c").Arg(`echo "$GREETING from $PWD"`).Build(ctx).
+		WithDir(dir).
+		WithEnv("GREETING", "hello").
+		ToExecCmd()
+	out, err := c.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello from " + dir + "\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
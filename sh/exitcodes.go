@@ -0,0 +1,91 @@
+package sh
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ExitCodeInfo describes what an exit code means for a particular tool.
+type ExitCodeInfo struct {
+	// OK reports whether the code is a successful outcome, such as grep
+	// finding no match, rather than a failure.
+	OK bool
+	// Meaning is a short description, e.g. "no match".
+	Meaning string
+}
+
+var (
+	exitCodesMu sync.RWMutex
+	// exitCodes maps tool names to their documented non-zero exit codes.
+	exitCodes = map[string]map[int]ExitCodeInfo{
+		"grep":      {1: {OK: true, Meaning: "no match"}, 2: {Meaning: "error"}},
+		"egrep":     {1: {OK: true, Meaning: "no match"}, 2: {Meaning: "error"}},
+		"fgrep":     {1: {OK: true, Meaning: "no match"}, 2: {Meaning: "error"}},
+		"rg":        {1: {OK: true, Meaning: "no match"}, 2: {Meaning: "error"}},
+		"diff":      {1: {OK: true, Meaning: "differences found"}, 2: {Meaning: "error"}},
+		"cmp":       {1: {OK: true, Meaning: "files differ"}, 2: {Meaning: "error"}},
+		"terraform": {2: {OK: true, Meaning: "changes present"}},
+		"tofu":      {2: {OK: true, Meaning: "changes present"}},
+		"timeout":   {124: {Meaning: "timed out"}},
+	}
+)
+
+// RegisterExitCodes records the meaning of exit codes of tool, the base
+// name of its executable, adding to or replacing those already known. The
+// package knows the codes of common tools such as grep (1: no match), diff
+// (1: differences found) and terraform (2: changes present with
+// -detailed-exitcode).
+func RegisterExitCodes(tool string, codes map[int]ExitCodeInfo) {
+	exitCodesMu.Lock()
+	defer exitCodesMu.Unlock()
+	if exitCodes[tool] == nil {
+		exitCodes[tool] = make(map[int]ExitCodeInfo, len(codes))
+	}
+	for code, info := range codes {
+		exitCodes[tool][code] = info
+	}
+}
+
+// LookupExitCode returns the registered meaning of code for tool.
+func LookupExitCode(tool string, code int) (ExitCodeInfo, bool) {
+	exitCodesMu.RLock()
+	defer exitCodesMu.RUnlock()
+	info, ok := exitCodes[toolName(tool)][code]
+	return info, ok
+}
+
+// toolName returns the name a command is registered under: the base name
+// of its executable without a Windows extension.
+func toolName(cmd string) string {
+	name := filepath.Base(cmd)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (r *resultImpl) Check() error {
+	if r.failure != nil {
+		return r.failure
+	}
+	if r.exitCode == 0 || slices.Contains(r.okCodes, r.exitCode) {
+		return nil
+	}
+	if info, ok := LookupExitCode(r.name, r.exitCode); ok && info.OK {
+		return nil
+	}
+	return &ExitError{Cmd: r.name, ExitCode: r.exitCode, Signal: r.signal, CoreDumped: r.coreDumped}
+}
+
+func (r *resultImpl) ExitMeaning() string {
+	if r.exitCode == 0 {
+		return "success"
+	}
+	if info, ok := LookupExitCode(r.name, r.exitCode); ok {
+		return info.Meaning
+	}
+	if r.signal != nil {
+		return fmt.Sprintf("killed by %v", r.signal)
+	}
+	return fmt.Sprintf("exit status %d", r.exitCode)
+}
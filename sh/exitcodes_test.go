@@ -0,0 +1,47 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestResultCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, _ := sh.New("grep").Arg("needle").Build(ctx).WithStdin(strings.NewReader("haystack\n")).Run()
+	if result.ExitCode() != 1 {
+		t.Fatalf("Expected grep to exit 1, got %d", result.ExitCode())
+	}
+	if err := result.Check(); err != nil {
+		t.Errorf("Expected no match to be OK for grep, got %v", err)
+	}
+	if result.ExitMeaning() != "no match" {
+		t.Errorf("Unexpected meaning %q", result.ExitMeaning())
+	}
+
+	result, _ = sh.New("false").Build(ctx).Run()
+	var exitErr *sh.ExitError
+	if err := result.Check(); !errors.As(err, &exitErr) || exitErr.ExitCode != 1 {
+		t.Errorf("Expected an exit error for false, got %v", err)
+	} else if err.Error() != "false: exit status 1" {
+		t.Errorf("Unexpected message %q", err)
+	}
+
+	tool := filepath.Join(t.TempDir(), "sh-partial-tool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\nexit 3\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sh.RegisterExitCodes("sh-partial-tool", map[int]sh.ExitCodeInfo{3: {OK: true, Meaning: "partial"}})
+	result, _ = sh.New(tool).Build(ctx).Run()
+	if err := result.Check(); err != nil || result.ExitMeaning() != "partial" {
+		t.Errorf("Expected the registered code to be OK, got %v (%s)", err, result.ExitMeaning())
+	}
+}
@@ -0,0 +1,52 @@
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ExitError is returned when a command ran but did not exit successfully.
+// It wraps the *exec.ExitError from os/exec.
+type ExitError struct {
+	// Cmd is the name of the command.
+	Cmd string
+	// ExitCode is the exit code, or -1 if the process was killed by a
+	// signal.
+	ExitCode int
+	// Signal is the signal that killed the process, or nil.
+	Signal os.Signal
+	// CoreDumped reports whether the process dumped core when it was
+	// killed, as it does for crashes such as SIGSEGV on most systems.
+	CoreDumped bool
+	// Err is the underlying error. It is nil for errors returned by
+	// Result.Check.
+	Err *exec.ExitError
+}
+
+func newExitError(name string, err *exec.ExitError) *ExitError {
+	return &ExitError{
+		Cmd:        name,
+		ExitCode:   err.ExitCode(),
+		Signal:     exitSignal(err.ProcessState),
+		CoreDumped: coreDumped(err.ProcessState),
+		Err:        err,
+	}
+}
+
+func (e *ExitError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %v", e.Cmd, e.Err)
+	case e.Signal != nil:
+		return fmt.Sprintf("%s: signal: %v", e.Cmd, e.Signal)
+	}
+	return fmt.Sprintf("%s: exit status %d", e.Cmd, e.ExitCode)
+}
+
+func (e *ExitError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package sh
+
+import "os"
+
+// exitSignal always returns nil: processes are not killed by signals on
+// this platform.
+func exitSignal(*os.ProcessState) os.Signal {
+	return nil
+}
+
+// coreDumped always returns false on this platform.
+func coreDumped(*os.ProcessState) bool {
+	return false
+}
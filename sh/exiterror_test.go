@@ -0,0 +1,84 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestResultTiming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := time.Now()
+	result, err := sh.New("sleep").Arg("0.1").Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	if result.Pid() <= 0 {
+		t.Errorf("Expected a pid, got %d", result.Pid())
+	}
+	if result.StartTime().Before(before) || result.EndTime().Before(result.StartTime()) {
+		t.Errorf("Unexpected times: start %v, end %v", result.StartTime(), result.EndTime())
+	}
+	if d := result.Duration(); d < 100*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Expected a duration of about 100ms, got %v", d)
+	}
+}
+
+func TestExitError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sh.New("sh").OptV("-c", "exit 3").Build(ctx).Run()
+
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Expected *sh.ExitError, got %T", err)
+	}
+	if exitErr.ExitCode != 3 || exitErr.Signal != nil || exitErr.Cmd != "sh" {
+		t.Errorf("Unexpected exit error: %+v", exitErr)
+	}
+
+	// The os/exec error is still reachable
+	var execErr *exec.ExitError
+	if !errors.As(err, &execErr) {
+		t.Error("Expected the error to wrap *exec.ExitError")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	_, err = sh.New("sh").OptV("-c", "kill -TERM $$").Build(ctx).Run()
+	if !errors.As(err, &exitErr) || exitErr.Signal != syscall.SIGTERM || exitErr.ExitCode != -1 {
+		t.Errorf("Expected termination by SIGTERM, got %v", err)
+	}
+}
+
+func TestResultSignaled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("processes are not killed by signals on Windows")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, _ := sh.New("true").Build(ctx).Run()
+	if sig, ok := result.Signaled(); ok {
+		t.Errorf("Expected a normal exit, got signal %v", sig)
+	}
+
+	result, _ = sh.New("sh").OptV("-c", "kill -KILL $$").Build(ctx).Run()
+	if sig, ok := result.Signaled(); !ok || sig != syscall.SIGKILL {
+		t.Errorf("Expected SIGKILL, got %v", sig)
+	}
+	if result.CoreDumped() {
+		t.Error("Expected SIGKILL not to dump core")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package sh
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitSignal returns the signal that terminated the process, if any.
+func exitSignal(state *os.ProcessState) os.Signal {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal()
+	}
+	return nil
+}
+
+// coreDumped reports whether the process dumped core when it was killed.
+func coreDumped(state *os.ProcessState) bool {
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	return ok && ws.CoreDump()
+}
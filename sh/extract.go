@@ -0,0 +1,135 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/benoctopus/pkg/future"
+)
+
+// ErrNoMatch is returned when output does not contain a pattern passed to
+// Extract or ExtractAsync.
+var ErrNoMatch = errors.New("sh: pattern not found in output")
+
+func (r *resultImpl) Extract(re *regexp.Regexp) (map[string]string, error) {
+	for _, out := range [][]byte{r.stdout, r.stderr} {
+		if m := re.FindSubmatch(out); m != nil {
+			return namedGroups(re, m), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoMatch, re)
+}
+
+func (cm *cmdImpl) ExtractAsync(re *regexp.Regexp) future.Future[map[string]string] {
+	m := &extractMatch{found: make(chan struct{})}
+	stdout := &extractWriter{re: re, match: m}
+	stderr := &extractWriter{re: re, match: m}
+
+	cm.mu.Lock()
+	cm.stdout = appendWriter(cm.stdout, stdout)
+	cm.stderr = appendWriter(cm.stderr, stderr)
+	done := cm.done
+	cm.mu.Unlock()
+
+	return future.Start(context.Background(), func(ctx context.Context) (map[string]string, error) {
+		select {
+		case <-m.found:
+			return m.groups, nil
+		case <-done:
+			// A last line without a newline may still match
+			stdout.flush()
+			stderr.flush()
+			select {
+			case <-m.found:
+				return m.groups, nil
+			default:
+				return nil, fmt.Errorf("%w: %s", ErrNoMatch, re)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+// namedGroups maps the names of the capture groups of re to their values in
+// the match m. Unnamed groups are left out.
+func namedGroups(re *regexp.Regexp, m [][]byte) map[string]string {
+	groups := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if i > 0 && name != "" {
+			groups[name] = string(m[i])
+		}
+	}
+	return groups
+}
+
+// extractMatch records the first match of an ExtractAsync pattern in either
+// output stream.
+type extractMatch struct {
+	once   sync.Once
+	groups map[string]string
+	found  chan struct{}
+}
+
+func (m *extractMatch) set(groups map[string]string) {
+	m.once.Do(func() {
+		m.groups = groups
+		close(m.found)
+	})
+}
+
+// extractWriter matches a pattern against each complete line written to it.
+type extractWriter struct {
+	re      *regexp.Regexp
+	match   *extractMatch
+	mu      sync.Mutex
+	partial []byte
+	done    bool
+}
+
+func (w *extractWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return len(p), nil
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.partial[:i], []byte("\r"))
+		w.partial = w.partial[i+1:]
+		if w.matchLine(line) {
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// flush matches a trailing line without a newline.
+func (w *extractWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.done && len(w.partial) > 0 {
+		w.matchLine(w.partial)
+	}
+}
+
+// matchLine reports whether line matched, after which the writer ignores
+// further output. The caller must hold w.mu.
+func (w *extractWriter) matchLine(line []byte) bool {
+	m := w.re.FindSubmatch(line)
+	if m == nil {
+		return false
+	}
+	w.match.set(namedGroups(w.re, m))
+	w.done, w.partial = true, nil
+	return true
+}
@@ -0,0 +1,87 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestResultExtract(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").
+		OptV("-c", "echo 'tool version 2.41.0 (build abc123)'; echo 'id=42' >&2").
+		Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	got, err := result.Extract(regexp.MustCompile(`version (?P<major>\d+)\.(?P<minor>\d+)\.\d+ \(build (?P<build>\w+)\)`))
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if len(got) != 3 || got["major"] != "2" || got["minor"] != "41" || got["build"] != "abc123" {
+		t.Errorf("Unexpected groups: %v", got)
+	}
+
+	// Stderr is searched when stdout has no match
+	got, err = result.Extract(regexp.MustCompile(`id=(?P<id>\d+)`))
+	if err != nil || got["id"] != "42" {
+		t.Errorf("Expected id from stderr, got %v, %v", got, err)
+	}
+
+	_, err = result.Extract(regexp.MustCompile(`url=(?P<url>\S+)`))
+	if !errors.Is(err, sh.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestCmdExtractAsync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").
+		OptV("-c", "echo starting; sleep 0.05; echo 'listening on http://127.0.0.1:8123' >&2; exec sleep 10").
+		Build(ctx)
+	url := cmd.ExtractAsync(regexp.MustCompile(`listening on (?P<url>\S+)`))
+	cmd.Start()
+	defer func() {
+		cmd.Cancel()
+		cmd.Wait()
+	}()
+
+	start := time.Now()
+	got, err := url.Wait()
+	if err != nil {
+		t.Fatalf("ExtractAsync failed: %v", err)
+	}
+	if got["url"] != "http://127.0.0.1:8123" {
+		t.Errorf("Unexpected groups: %v", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the match before the command exited, took %v", elapsed)
+	}
+}
+
+func TestCmdExtractAsyncNoMatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("printf").Arg("ready: yes").Build(ctx)
+	ready := cmd.ExtractAsync(regexp.MustCompile(`ready: (?P<state>\w+)`))
+	missing := cmd.ExtractAsync(regexp.MustCompile(`token=(?P<token>\w+)`))
+	cmd.Run()
+
+	// A final line without a newline is matched too
+	if got, err := ready.Wait(); err != nil || got["state"] != "yes" {
+		t.Errorf("Expected the unterminated line to match, got %v, %v", got, err)
+	}
+	if _, err := missing.Wait(); !errors.Is(err, sh.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// FileOption configures how command output is written to a file.
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	direct    bool
+	fsync     bool
+	atomic    bool
+	append    bool
+	allStages bool
+	perm      os.FileMode
+}
+
+// WithDirectWrite hands the file to the command as its stdout or stderr, so
+// that output goes straight from the command to the file in the kernel
+// without being copied through this process, for redirecting large streams
+// to disk. The output is then not captured in the Result, and writers added
+// with WithStdout or WithStderr and middleware do not see it.
+func WithDirectWrite() FileOption {
+	return func(o *fileOptions) {
+		o.direct = true
+	}
+}
+
+// WithFsync flushes the file to stable storage before it is closed and,
+// with WithAtomicRename, the rename to its directory.
+func WithFsync() FileOption {
+	return func(o *fileOptions) {
+		o.fsync = true
+	}
+}
+
+// WithAtomicRename writes output to a temporary file in the target directory
+// and renames it over the target only if the command succeeds. On failure the
+// temporary file is removed and the target is left untouched.
+func WithAtomicRename() FileOption {
+	return func(o *fileOptions) {
+		o.atomic = true
+	}
+}
+
+// WithFileMode sets the permission bits of the created file. The default is
+// 0644.
+func WithFileMode(perm os.FileMode) FileOption {
+	return func(o *fileOptions) {
+		o.perm = perm
+	}
+}
+
+// WithAppend appends to the file instead of truncating it, e.g. to collect
+// the output of several runs in one log. It cannot be combined with
+// WithAtomicRename.
+func WithAppend() FileOption {
+	return func(o *fileOptions) {
+		o.append = true
+	}
+}
+
+// WithAllStages writes the output of every stage of the pipeline ending in
+// the command to the file, not just the command's own. The file is opened
+// once, before the first stage starts, and closed after the last stage has
+// exited.
+func WithAllStages() FileOption {
+	return func(o *fileOptions) {
+		o.allStages = true
+	}
+}
+
+func (cm *cmdImpl) WithStdoutFile(path string, opts ...FileOption) Cmd {
+	return cm.outputToFile(path, false, opts)
+}
+
+func (cm *cmdImpl) WithStderrFile(path string, opts ...FileOption) Cmd {
+	return cm.outputToFile(path, true, opts)
+}
+
+func (cm *cmdImpl) WithStdoutAt(w io.WriterAt, off int64) Cmd {
+	return cm.WithStdout(io.NewOffsetWriter(w, off))
+}
+
+func (cm *cmdImpl) outputToFile(path string, stderr bool, opts []FileOption) Cmd {
+	o := fileOptions{perm: 0o644}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !o.allStages || cm.parent == nil {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		cm.hooks = append(cm.hooks, outputFileHook(path, stderr, o))
+		return cm
+	}
+
+	// Every stage writes to one file, closed by the last stage once the
+	// whole pipeline has exited
+	f := &sharedFile{path: path, opts: o}
+	for stage := cm; stage != nil; {
+		stage.mu.Lock()
+		stage.hooks = append(stage.hooks, f.hook(stderr))
+		if stage == cm {
+			stage.pipeClosers = append(stage.pipeClosers, f.close)
+		}
+		next, _ := stage.parent.(*cmdImpl)
+		stage.mu.Unlock()
+		stage = next
+	}
+	return cm
+}
+
+// outputFileHook returns a hook streaming the command's stdout, or stderr,
+// to path.
+func outputFileHook(path string, stderr bool, o fileOptions) execHook {
+	var f *os.File
+
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			var err error
+			if f, err = openOutputFile(path, o); err != nil {
+				return err
+			}
+			if o.direct {
+				redirectOutput(cmd, f, stderr)
+				return nil
+			}
+			teeOutput(cmd, f, stderr)
+			return nil
+		},
+		finished: func(err error) error {
+			return closeOutputFile(f, path, o, err)
+		},
+	}
+}
+
+// sharedFile is an output file shared by the stages of a pipeline.
+type sharedFile struct {
+	path string
+	opts fileOptions
+
+	once sync.Once
+	f    *os.File
+	err  error
+}
+
+func (s *sharedFile) hook(stderr bool) execHook {
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			s.once.Do(func() {
+				s.f, s.err = openOutputFile(s.path, s.opts)
+			})
+			if s.err != nil {
+				return s.err
+			}
+			if s.opts.direct {
+				redirectOutput(cmd, s.f, stderr)
+				return nil
+			}
+			teeOutput(cmd, s.f, stderr)
+			return nil
+		},
+	}
+}
+
+// close closes the file once no stage writes to it anymore.
+func (s *sharedFile) close(runErr error) error {
+	if s.f == nil {
+		return nil
+	}
+	return closeOutputFile(s.f, s.path, s.opts, runErr)
+}
+
+func teeOutput(cmd *exec.Cmd, w io.Writer, stderr bool) {
+	if stderr {
+		cmd.Stderr = appendWriter(cmd.Stderr, w)
+	} else {
+		cmd.Stdout = appendWriter(cmd.Stdout, w)
+	}
+}
+
+// redirectOutput makes the child write its stdout, or stderr, to f itself.
+func redirectOutput(cmd *exec.Cmd, f *os.File, stderr bool) {
+	if stderr {
+		cmd.Stderr = f
+	} else {
+		cmd.Stdout = f
+	}
+}
+
+// openOutputFile opens path for writing output, or a temporary file next to
+// it for WithAtomicRename.
+func openOutputFile(path string, o fileOptions) (f *os.File, err error) {
+	switch {
+	case o.atomic && o.append:
+		return nil, fmt.Errorf("sh: %s: cannot append with an atomic rename", path)
+	case o.atomic:
+		f, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+		if err == nil {
+			err = f.Chmod(o.perm)
+		}
+	case o.append:
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, o.perm)
+	default:
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, o.perm)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// closeOutputFile closes f once the command has exited with runErr, and
+// moves it into place for WithAtomicRename if the command succeeded.
+func closeOutputFile(f *os.File, path string, o fileOptions, runErr error) error {
+	var err error
+	if o.fsync && runErr == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+
+	if !o.atomic {
+		return err
+	}
+	if runErr != nil || err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if o.fsync {
+		// The rename is only durable once the directory is
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// syncDir flushes the directory entries of dir to stable storage. Windows
+// cannot sync directories and needs no such step.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
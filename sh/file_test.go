@@ -0,0 +1,302 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestWithStdoutFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+	_, err := sh.New("printf").
+		Arg("file output").
+		Build(ctx).
+		WithStdoutFile(path, sh.WithFsync(), sh.WithFileMode(0o600)).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if string(data) != "file output" {
+		t.Errorf("Expected 'file output', got '%s'", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat output file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestWithStdoutFileAtomicRename(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing command must leave the target untouched
+	_, err := sh.New("sh").
+		OptV("-c", "printf partial; exit 3").
+		Build(ctx).
+		WithStdoutFile(path, sh.WithAtomicRename()).
+		Run()
+	if err == nil {
+		t.Fatal("Expected error from failing command")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "original" {
+		t.Errorf("Expected target to be untouched, got '%s'", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected temporary file to be removed, found %d entries", len(entries))
+	}
+
+	// A successful command replaces the target
+	_, err = sh.New("printf").
+		Arg("replaced").
+		Build(ctx).
+		WithStdoutFile(path, sh.WithAtomicRename(), sh.WithFsync()).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	data, _ = os.ReadFile(path)
+	if string(data) != "replaced" {
+		t.Errorf("Expected 'replaced', got '%s'", data)
+	}
+}
+
+func TestWithStdoutAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(path, []byte("header:________:trailer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	result, err := sh.New("printf").Arg("payload!").Build(ctx).WithStdoutAt(f, 7).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Stdout()) != "payload!" {
+		t.Errorf("Expected stdout to be captured as well, got %q", result.Stdout())
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "header:payload!:trailer" {
+		t.Errorf("Expected the output written at offset 7, got %q", data)
+	}
+}
+
+func TestWithStderrFileAppend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "build.log")
+	for _, step := range []string{"one", "two"} {
+		_, err := sh.New("sh").
+			OptV("-c", "echo "+step+" >&2").
+			Build(ctx).
+			WithStderrFile(path, sh.WithAppend()).
+			Run()
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("Expected both steps in the log, got %q", data)
+	}
+
+	// Truncating is the default
+	_, err = sh.New("sh").OptV("-c", "echo three >&2").Build(ctx).WithStderrFile(path).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "three\n" {
+		t.Errorf("Expected %q, got %q", "three\n", data)
+	}
+}
+
+func TestWithStdoutFileAppendAtomic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+	_, err := sh.New("true").
+		Build(ctx).
+		WithStdoutFile(path, sh.WithAppend(), sh.WithAtomicRename()).
+		Run()
+	if err == nil {
+		t.Fatal("Expected an error combining WithAppend and WithAtomicRename")
+	}
+}
+
+func TestWithStderrFilePipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	first, second, all := filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log"), filepath.Join(dir, "all.log")
+
+	result, err := sh.New("sh").
+		OptV("-c", "echo data; echo first >&2").
+		Build(ctx).
+		WithStderrFile(first).
+		Pipe("sh").OptV("-c", "cat; echo second >&2").Build().
+		WithStderrFile(second).
+		WithStderrFile(all, sh.WithAllStages()).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if got := string(result.Stdout()); got != "data\n" {
+		t.Errorf("Expected stdout %q, got %q", "data\n", got)
+	}
+
+	for path, want := range map[string][]string{
+		first:  {"first\n"},
+		second: {"second\n"},
+		all:    {"first\nsecond\n", "second\nfirst\n"},
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(want, string(data)) {
+			t.Errorf("%s: expected one of %q, got %q", filepath.Base(path), want, data)
+		}
+	}
+}
+
+func TestWithStdoutFileAllStagesAtomic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+
+	// The file is only moved into place once every stage has succeeded
+	_, err := sh.New("sh").
+		OptV("-c", "echo a; exit 2").
+		Build(ctx).
+		Pipe("cat").Build().
+		WithStdoutFile(path, sh.WithAllStages(), sh.WithAtomicRename()).
+		Run()
+	if err == nil {
+		t.Fatal("Expected the failing first stage to fail the pipeline")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no files, found %d entries", len(entries))
+	}
+
+	_, err = sh.New("echo").
+		Arg("a").
+		Build(ctx).
+		Pipe("cat").Build().
+		WithStdoutFile(path, sh.WithAllStages(), sh.WithAtomicRename()).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "a\na\n" {
+		t.Errorf("Expected the output of both stages, got %q", data)
+	}
+}
+
+func TestWithStdoutFileDirectWrite(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+	result, err := sh.New("sh").Arg("-c").Arg("seq 3; echo warn >&2").Build(ctx).
+		WithStdoutFile(path, sh.WithDirectWrite(), sh.WithAtomicRename()).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1\n2\n3\n" {
+		t.Errorf("Expected the output in the file, got %q", data)
+	}
+	if len(result.Stdout()) != 0 || string(result.Stderr()) != "warn\n" {
+		t.Errorf("Expected only stderr captured, got %q and %q", result.Stdout(), result.Stderr())
+	}
+}
+
+func TestWithStdoutFileAtomicRenameSuccessWhen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(path, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exiting 0 is not enough when a SuccessWhen check rejects the run
+	_, err := sh.New("printf").Arg("ERROR").Build(ctx).
+		WithStdoutFile(path, sh.WithAtomicRename()).
+		SuccessWhen(func(r sh.Result) error {
+			if strings.Contains(string(r.Stdout()), "ERROR") {
+				return errors.New("reported an error")
+			}
+			return nil
+		}).
+		Run()
+	if !errors.Is(err, sh.ErrUnsuccessful) {
+		t.Fatalf("Expected ErrUnsuccessful, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "original" {
+		t.Errorf("Expected the rejected output not to replace the target, got '%s'", data)
+	}
+
+	// An exit code accepted with ExpectExit does replace it
+	_, err = sh.New("sh").OptV("-c", "printf accepted; exit 1").Build(ctx).
+		WithStdoutFile(path, sh.WithAtomicRename()).
+		ExpectExit(1).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "accepted" {
+		t.Errorf("Expected 'accepted', got '%s'", data)
+	}
+}
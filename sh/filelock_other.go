@@ -0,0 +1,15 @@
+//go:build !unix
+
+package sh
+
+import "sync"
+
+var fileLocks sync.Map // path → *sync.Mutex
+
+// lockFile locks path and returns a function releasing it. Without flock
+// the lock only excludes other goroutines of the current process.
+func lockFile(path string) (func(), error) {
+	mu, _ := fileLocks.LoadOrStore(path, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock, nil
+}
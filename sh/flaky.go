@@ -0,0 +1,102 @@
+package sh
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"time"
+)
+
+func (cm *cmdImpl) WithAttempt(n int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.attempt = n
+	return cm
+}
+
+// Report summarizes the history of the commands recorded in a StateStore,
+// e.g. to find the flaky steps of a CI pipeline. It is built with
+// StateStore.Report.
+type Report struct {
+	// Commands holds one entry per key, in key order.
+	Commands []CommandReport
+}
+
+// CommandReport summarizes the recorded runs of one key. A run is a first
+// attempt together with the retries that followed it, see WithAttempt.
+type CommandReport struct {
+	Key string
+	// Args is the command line of the most recent run.
+	Args []string
+	Runs int
+	// Failures counts the runs whose last attempt failed.
+	Failures int
+	// PassedOnRetry counts the runs that failed at first but whose last
+	// attempt succeeded.
+	PassedOnRetry int
+}
+
+// FlakeRate returns the fraction of runs that only passed on retry.
+func (c CommandReport) FlakeRate() float64 {
+	if c.Runs == 0 {
+		return 0
+	}
+	return float64(c.PassedOnRetry) / float64(c.Runs)
+}
+
+// FlakyCommands returns the commands that passed on retry in more than
+// threshold of their runs, given as a fraction (0.05 for 5%), most flaky
+// first.
+func (r Report) FlakyCommands(threshold float64) []CommandReport {
+	var flaky []CommandReport
+	for _, c := range r.Commands {
+		if c.PassedOnRetry > 0 && c.FlakeRate() > threshold {
+			flaky = append(flaky, c)
+		}
+	}
+	slices.SortStableFunc(flaky, func(a, b CommandReport) int {
+		return cmp.Compare(b.FlakeRate(), a.FlakeRate())
+	})
+	return flaky
+}
+
+// Report summarizes the runs recorded since the given time. Attempts are
+// grouped into runs by their attempt numbers: each first attempt starts a
+// new run of its key, and the retries recorded after it belong to it.
+func (s *StateStore) Report(since time.Time) (Report, error) {
+	records, err := s.Query(StateQuery{Since: since})
+	if err != nil {
+		return Report{}, err
+	}
+
+	type run struct {
+		firstFailed bool
+		lastFailed  bool
+	}
+	runs := make(map[string][]run)
+	args := make(map[string][]string)
+	for _, rec := range records {
+		args[rec.Key] = rec.Args
+		keyRuns := runs[rec.Key]
+		if rec.Attempt <= 1 || len(keyRuns) == 0 {
+			keyRuns = append(keyRuns, run{firstFailed: rec.Failed()})
+		}
+		keyRuns[len(keyRuns)-1].lastFailed = rec.Failed()
+		runs[rec.Key] = keyRuns
+	}
+
+	var report Report
+	for _, key := range slices.Sorted(maps.Keys(runs)) {
+		c := CommandReport{Key: key, Args: args[key], Runs: len(runs[key])}
+		for _, r := range runs[key] {
+			switch {
+			case r.lastFailed:
+				c.Failures++
+			case r.firstFailed:
+				c.PassedOnRetry++
+			}
+		}
+		report.Commands = append(report.Commands, c)
+	}
+	return report, nil
+}
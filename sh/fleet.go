@@ -0,0 +1,240 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// ErrRolloutAborted is reported for hosts that were skipped because an
+// earlier rollout wave failed.
+var ErrRolloutAborted = errors.New("sh: rollout aborted after failure in an earlier wave")
+
+// Fleet runs the same command on many remote hosts over ssh.
+type Fleet struct {
+	remotes []Remote
+}
+
+// HostResult is the outcome of running a command on a single host.
+type HostResult struct {
+	Host   string
+	Result Result
+	Err    error
+}
+
+// FleetOption configures a Fleet run.
+type FleetOption func(*fleetOptions)
+
+type fleetOptions struct {
+	concurrency int
+	output      io.Writer
+	errOutput   io.Writer
+	canary      int
+	percents    []int
+}
+
+// Concurrency limits how many hosts run the command at the same time.
+func Concurrency(n int) FleetOption {
+	return func(o *fleetOptions) {
+		o.concurrency = n
+	}
+}
+
+// FleetOutput streams the stdout and stderr of every host to w and errW, one
+// line at a time with each line prefixed by the host name. Either writer may
+// be nil.
+func FleetOutput(w, errW io.Writer) FleetOption {
+	return func(o *fleetOptions) {
+		o.output = w
+		o.errOutput = errW
+	}
+}
+
+// Canary runs the command on the first n hosts on their own before moving
+// on to the rest. If any canary host fails the remaining hosts are skipped.
+func Canary(n int) FleetOption {
+	return func(o *fleetOptions) {
+		o.canary = n
+	}
+}
+
+// Rollout runs the command in waves reaching the given cumulative
+// percentages of hosts, e.g. Rollout(10, 50, 100). A wave only starts once
+// the previous one has succeeded on every host.
+func Rollout(percents ...int) FleetOption {
+	return func(o *fleetOptions) {
+		o.percents = percents
+	}
+}
+
+// NewFleet returns a Fleet of the given hosts, each in the form accepted by
+// NewRemote.
+func NewFleet(hosts ...string) *Fleet {
+	remotes := make([]Remote, len(hosts))
+	for i, host := range hosts {
+		remotes[i] = NewRemote(host)
+	}
+	return &Fleet{remotes: remotes}
+}
+
+// NewFleetOf returns a Fleet of the given remotes.
+func NewFleetOf(remotes ...Remote) *Fleet {
+	return &Fleet{remotes: remotes}
+}
+
+// Hosts returns the remotes in the fleet.
+func (f *Fleet) Hosts() []Remote {
+	return f.remotes
+}
+
+// Close shuts down the shared connections of all hosts using Multiplex.
+func (f *Fleet) Close(ctx context.Context) error {
+	var errs []error
+	for _, r := range f.remotes {
+		errs = append(errs, r.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Run executes the command described by b on every host and returns the
+// per-host results in host order. The returned error joins the errors of all
+// hosts that failed.
+func (f *Fleet) Run(ctx context.Context, b Argv, opts ...FleetOption) ([]HostResult, error) {
+	o := fleetOptions{concurrency: len(f.remotes)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = 1
+	}
+
+	results := make([]HostResult, len(f.remotes))
+	var outMu sync.Mutex
+
+	start := 0
+	for _, end := range f.waves(o) {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, o.concurrency)
+
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			results[i] = HostResult{Host: f.remotes[i].Name()}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer catchPanic(&results[i].Err)
+				results[i] = f.runHost(ctx, f.remotes[i], b, &o, &outMu)
+			}()
+		}
+		wg.Wait()
+
+		failed := false
+		for i := start; i < end; i++ {
+			failed = failed || results[i].Err != nil
+		}
+		start = end
+
+		if failed {
+			for i := end; i < len(f.remotes); i++ {
+				results[i] = HostResult{Host: f.remotes[i].Name(), Err: ErrRolloutAborted}
+			}
+			break
+		}
+	}
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrRolloutAborted) {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Host, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+func (f *Fleet) runHost(ctx context.Context, remote Remote, b Argv, o *fleetOptions, outMu *sync.Mutex) HostResult {
+	if len(remote.Vars) > 0 {
+		expanded, err := expandVars(b, remote.Vars)
+		if err != nil {
+			return HostResult{Host: remote.Name(), Err: err}
+		}
+		b = expanded
+	}
+	cmd := remote.Command(b).Build(ctx)
+
+	var flush []*prefixWriter
+	if o.output != nil {
+		w := newPrefixWriter(outMu, o.output, remote.Name()+" | ")
+		cmd.WithStdout(w)
+		flush = append(flush, w)
+	}
+	if o.errOutput != nil {
+		w := newPrefixWriter(outMu, o.errOutput, remote.Name()+" | ")
+		cmd.WithStderr(w)
+		flush = append(flush, w)
+	}
+
+	result, err := cmd.Run()
+	for _, w := range flush {
+		w.Flush()
+	}
+
+	return HostResult{Host: remote.Name(), Result: result, Err: err}
+}
+
+// waves returns the exclusive end index of each rollout wave.
+func (f *Fleet) waves(o fleetOptions) []int {
+	n := len(f.remotes)
+	var ends []int
+
+	if o.canary > 0 && o.canary < n {
+		ends = append(ends, o.canary)
+	}
+	for _, p := range o.percents {
+		end := (n*p + 99) / 100
+		if end > n {
+			end = n
+		}
+		if len(ends) == 0 || end > ends[len(ends)-1] {
+			ends = append(ends, end)
+		}
+	}
+	if len(ends) == 0 || ends[len(ends)-1] < n {
+		ends = append(ends, n)
+	}
+	return ends
+}
+
+// expandVars executes each argument of b as a text/template with vars as
+// its data.
+func expandVars(b Argv, vars map[string]string) (Argv, error) {
+	items := b.Items()
+	expanded := make([]string, len(items))
+
+	for i, item := range items {
+		if !strings.Contains(item, "{{") {
+			expanded[i] = item
+			continue
+		}
+
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(item)
+		if err != nil {
+			return nil, err
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return nil, err
+		}
+		expanded[i] = buf.String()
+	}
+
+	cmd := New(expanded[0])
+	for _, arg := range expanded[1:] {
+		cmd.Arg(arg)
+	}
+	return cmd, nil
+}
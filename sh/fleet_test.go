@@ -0,0 +1,63 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestFleetRun(t *testing.T) {
+	fakeSSH(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var out strings.Builder
+	results, err := sh.NewFleet("web1", "web2", "web3").Run(ctx,
+		sh.New("sh").OptV("-c", `echo "hello from $FAKE_SSH_HOST"`),
+		sh.Concurrency(2),
+		sh.FleetOutput(&out, nil),
+	)
+	if err != nil {
+		t.Fatalf("Fleet run failed: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for _, r := range results {
+		expected := "hello from " + r.Host
+		if strings.TrimSpace(string(r.Result.Stdout())) != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, r.Result.Stdout())
+		}
+		if !strings.Contains(out.String(), r.Host+" | "+expected+"\n") {
+			t.Errorf("Expected labeled output for %s, got:\n%s", r.Host, out.String())
+		}
+	}
+}
+
+func TestFleetCanaryAbort(t *testing.T) {
+	fakeSSH(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.NewFleet("bad", "web2", "web3").Run(ctx,
+		sh.New("sh").OptV("-c", `test "$FAKE_SSH_HOST" != bad`),
+		sh.Canary(1),
+	)
+	if err == nil {
+		t.Fatal("Expected error from failing canary")
+	}
+	if !strings.Contains(err.Error(), "bad") {
+		t.Errorf("Expected error to name the failing host, got %v", err)
+	}
+
+	for _, r := range results[1:] {
+		if !errors.Is(r.Err, sh.ErrRolloutAborted) {
+			t.Errorf("Expected %s to be skipped, got %v", r.Host, r.Err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sh
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Format renders durations, sizes and rates for reports and logs.
+type Format int
+
+const (
+	// FormatHuman renders values for people, e.g. "1m32s", "4.2 MiB" and
+	// "1.5 MiB/s".
+	FormatHuman Format = iota
+	// FormatMachine renders stable, unit-free values that are easy to
+	// parse: durations in seconds, sizes in bytes and rates in bytes per
+	// second.
+	FormatMachine
+)
+
+// Duration formats d. Human durations are rounded to a precision that
+// depends on their magnitude.
+func (f Format) Duration(d time.Duration) string {
+	if f == FormatMachine {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+
+	switch abs := max(d, -d); {
+	case abs < time.Millisecond:
+		return d.String()
+	case abs < time.Second:
+		return d.Round(time.Millisecond).String()
+	case abs < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	case abs < time.Hour:
+		return d.Round(time.Second).String()
+	default:
+		// Drop the seconds of long durations: "2h3m" rather than "2h3m0s"
+		return trimZeroSeconds(d.Round(time.Minute).String())
+	}
+}
+
+// Size formats a number of bytes using binary units.
+func (f Format) Size(n int64) string {
+	if f == FormatMachine {
+		return strconv.FormatInt(n, 10)
+	}
+	return humanBytes(float64(n)) + "B"
+}
+
+// Rate formats n bytes transferred over d as bytes per second.
+func (f Format) Rate(n int64, d time.Duration) string {
+	var perSecond float64
+	if d > 0 {
+		perSecond = float64(n) / d.Seconds()
+	}
+	if f == FormatMachine {
+		return strconv.FormatFloat(perSecond, 'f', 0, 64)
+	}
+	return humanBytes(perSecond) + "B/s"
+}
+
+// humanBytes renders n with a binary unit prefix and a trailing space,
+// e.g. "4.2 Mi" or "512 ".
+func humanBytes(n float64) string {
+	const units = "KMGTPE"
+
+	if n > -1024 && n < 1024 {
+		return fmt.Sprintf("%.0f ", n)
+	}
+	i := -1
+	for (n <= -1024 || n >= 1024) && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ci", n, units[i])
+}
+
+func trimZeroSeconds(s string) string {
+	if len(s) > 3 && s[len(s)-3:] == "m0s" {
+		s = s[:len(s)-2]
+	}
+	if len(s) > 3 && s[len(s)-3:] == "h0m" {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// String summarizes the stats for people, e.g.
+// "12 runs, 1 failed, mean 1m32s".
+func (s RunStats) String() string {
+	return fmt.Sprintf("%d runs, %d failed, mean %s", s.Runs, s.Failures, FormatHuman.Duration(s.MeanDuration))
+}
@@ -0,0 +1,67 @@
+package sh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d       time.Duration
+		human   string
+		machine string
+	}{
+		{250 * time.Microsecond, "250µs", "0.00025"},
+		{1234567 * time.Microsecond, "1.2s", "1.234567"},
+		{92*time.Second + 400*time.Millisecond, "1m32s", "92.4"},
+		{2*time.Hour + 3*time.Minute + 10*time.Second, "2h3m", "7390"},
+		{3 * time.Hour, "3h", "10800"},
+	}
+
+	for _, tt := range tests {
+		if got := sh.FormatHuman.Duration(tt.d); got != tt.human {
+			t.Errorf("FormatHuman.Duration(%v) = %q, want %q", tt.d, got, tt.human)
+		}
+		if got := sh.FormatMachine.Duration(tt.d); got != tt.machine {
+			t.Errorf("FormatMachine.Duration(%v) = %q, want %q", tt.d, got, tt.machine)
+		}
+	}
+}
+
+func TestFormatSizeAndRate(t *testing.T) {
+	tests := []struct {
+		n       int64
+		human   string
+		machine string
+	}{
+		{512, "512 B", "512"},
+		{4404019, "4.2 MiB", "4404019"},
+		{3 << 30, "3.0 GiB", "3221225472"},
+	}
+
+	for _, tt := range tests {
+		if got := sh.FormatHuman.Size(tt.n); got != tt.human {
+			t.Errorf("FormatHuman.Size(%d) = %q, want %q", tt.n, got, tt.human)
+		}
+		if got := sh.FormatMachine.Size(tt.n); got != tt.machine {
+			t.Errorf("FormatMachine.Size(%d) = %q, want %q", tt.n, got, tt.machine)
+		}
+	}
+
+	if got := sh.FormatHuman.Rate(3<<20, 2*time.Second); got != "1.5 MiB/s" {
+		t.Errorf("Expected 1.5 MiB/s, got %q", got)
+	}
+	if got := sh.FormatMachine.Rate(3000, 2*time.Second); got != "1500" {
+		t.Errorf("Expected 1500, got %q", got)
+	}
+	if got := sh.FormatHuman.Rate(100, 0); got != "0 B/s" {
+		t.Errorf("Expected 0 B/s for zero duration, got %q", got)
+	}
+
+	stats := sh.RunStats{Runs: 12, Failures: 1, MeanDuration: 92 * time.Second}
+	if got := stats.String(); got != "12 runs, 1 failed, mean 1m32s" {
+		t.Errorf("Unexpected stats summary: %q", got)
+	}
+}
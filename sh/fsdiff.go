@@ -0,0 +1,143 @@
+package sh
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+func (cm *cmdImpl) WithFSDiff(root string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var dir string
+	var before map[string]fileState
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			dir = root
+			if cmd.Dir != "" && !filepath.IsAbs(root) {
+				dir = filepath.Join(cmd.Dir, root)
+			}
+			var err error
+			if before, err = snapshotTree(dir); err != nil {
+				return fmt.Errorf("sh: fs diff: %w", err)
+			}
+			return nil
+		},
+		after: func(error) error {
+			after, err := snapshotTree(dir)
+			if err != nil {
+				return fmt.Errorf("sh: fs diff: %w", err)
+			}
+			cm.mu.Lock()
+			cm.changes = diffTrees(before, after)
+			cm.mu.Unlock()
+			return nil
+		},
+	})
+	return cm
+}
+
+// fileState is what snapshotTree records about a file to tell whether it
+// changed.
+type fileState struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	// sum is the SHA-256 of a regular file's contents, unless the file
+	// could not be read; target is a symlink's target.
+	sum    []byte
+	target string
+}
+
+// changed reports whether the file changed from s to o. Files that cannot
+// be read are compared by size and modification time.
+func (s fileState) changed(o fileState) bool {
+	switch {
+	case s.mode != o.mode || s.target != o.target:
+		return true
+	case s.sum != nil && o.sum != nil:
+		return string(s.sum) != string(o.sum)
+	case s.mode.IsRegular():
+		return s.size != o.size || !s.modTime.Equal(o.modTime)
+	}
+	return false
+}
+
+// snapshotTree records the state of every file and directory under root,
+// keyed by slash-separated relative path. A missing root is empty.
+func snapshotTree(root string) (map[string]fileState, error) {
+	states := make(map[string]fileState)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		s := fileState{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+		switch {
+		case s.mode.IsRegular():
+			s.sum = hashFile(path)
+		case s.mode&fs.ModeSymlink != 0:
+			s.target, _ = os.Readlink(path)
+		}
+		states[filepath.ToSlash(rel)] = s
+		return nil
+	})
+	return states, err
+}
+
+// hashFile returns the SHA-256 of the file at path, or nil if it cannot be
+// read.
+func hashFile(path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// diffTrees lists the differences between two snapshots in path order.
+// Files below an added or deleted directory are listed as well.
+func diffTrees(before, after map[string]fileState) []FileChange {
+	var changes []FileChange
+	for path, a := range after {
+		b, existed := before[path]
+		switch {
+		case !existed:
+			changes = append(changes, FileChange{Path: path, Kind: ChangeAdded, Dir: a.mode.IsDir()})
+		case b.changed(a):
+			changes = append(changes, FileChange{Path: path, Kind: ChangeModified, Dir: a.mode.IsDir()})
+		}
+	}
+	for path, b := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Kind: ChangeDeleted, Dir: b.mode.IsDir()})
+		}
+	}
+	slices.SortFunc(changes, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
@@ -0,0 +1,69 @@
+package sh_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithFSDiff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "out", "old"), 0o755)
+	for name, content := range map[string]string{
+		"out/a":     "a\n",
+		"out/same":  "same\n",
+		"out/mode":  "x\n",
+		"out/old/f": "f\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := sh.New("sh").
+		OptV("-c", "echo b >> out/a; touch out/same; chmod 755 out/mode; rm -r out/old; mkdir out/new; echo n > out/new/n").
+		Build(ctx).WithDir(dir).WithFSDiff("out").Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v: %s", err, result.Stderr())
+	}
+
+	var got []string
+	for _, c := range result.Changes() {
+		got = append(got, fmt.Sprintf("%s %s dir=%t", c.Kind, c.Path, c.Dir))
+	}
+	want := []string{
+		"modified a dir=false",
+		"modified mode dir=false",
+		"added new dir=true",
+		"added new/n dir=false",
+		"deleted old dir=true",
+		"deleted old/f dir=false",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Unexpected changes:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestCmdWithFSDiffNewRoot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	root := filepath.Join(t.TempDir(), "build")
+	result, err := sh.New("sh").OptV("-c", "mkdir -p \"$0\" && touch \"$0/bin\"").Arg(root).
+		Build(ctx).WithFSDiff(root).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	changes := result.Changes()
+	if len(changes) != 1 || changes[0].Path != "bin" || changes[0].Kind != sh.ChangeAdded {
+		t.Errorf("Expected bin to be added, got %+v", changes)
+	}
+}
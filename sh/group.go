@@ -0,0 +1,189 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Group runs a set of commands concurrently with bounded parallelism, e.g.
+// fetching many repositories at once, and collects their results.
+type Group struct {
+	limit    int
+	failFast bool
+	keys     []string
+	cmds     map[string]Cmd
+	err      error // set by Add, returned by Run
+}
+
+// ErrDuplicateKey is returned by Group.Run when a key was passed to Add
+// more than once.
+var ErrDuplicateKey = errors.New("sh: duplicate group key")
+
+// GroupError summarizes the failures of a Group run.
+type GroupError struct {
+	// Failed maps the keys of failed commands to their errors.
+	Failed map[string]error
+	// Skipped lists the keys of commands not started because the group
+	// failed fast or its context was cancelled.
+	Skipped []string
+}
+
+func (e *GroupError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sh: %d command(s) failed", len(e.Failed))
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&b, ", %d skipped", len(e.Skipped))
+	}
+	for _, key := range sortedKeys(e.Failed) {
+		fmt.Fprintf(&b, "\n\t%s: %v", key, e.Failed[key])
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed commands.
+func (e *GroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, key := range sortedKeys(e.Failed) {
+		errs = append(errs, e.Failed[key])
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]error) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// NewGroup returns an empty group without a parallelism limit that runs
+// all commands even if some fail.
+func NewGroup() *Group {
+	return &Group{cmds: make(map[string]Cmd)}
+}
+
+// Limit runs at most n commands at the same time. A non-positive n means
+// no limit.
+func (g *Group) Limit(n int) *Group {
+	g.limit = n
+	return g
+}
+
+// FailFast stops the group at the first failure: running commands are
+// cancelled and the remaining ones are not started.
+func (g *Group) FailFast() *Group {
+	g.failFast = true
+	return g
+}
+
+// Add adds an unstarted command identified by key. An empty key defaults to
+// the command's name given with Builder.Name, or else the command line;
+// commands whose default keys collide are told apart by a suffix, as in
+// "git pull", "git pull#2". Adding a key given explicitly a second time
+// makes Run fail with ErrDuplicateKey without running any command.
+func (g *Group) Add(key string, cmd Cmd) *Group {
+	if key == "" {
+		key = cmd.String()
+		if cm, ok := cmd.(*cmdImpl); ok && cm.name != "" {
+			key = cm.name
+		}
+		for base, n := key, 2; g.cmds[key] != nil; n++ {
+			key = fmt.Sprintf("%s#%d", base, n)
+		}
+	}
+	if _, ok := g.cmds[key]; ok {
+		if g.err == nil {
+			g.err = fmt.Errorf("%w: %q", ErrDuplicateKey, key)
+		}
+		return g
+	}
+	g.keys = append(g.keys, key)
+	g.cmds[key] = cmd
+	return g
+}
+
+// Run starts the commands in the order they were added, keeping at most
+// Limit of them running, and waits for all started commands to finish. It
+// returns the results keyed like the commands, and a *GroupError if any
+// command failed or was skipped.
+func (g *Group) Run(ctx context.Context) (map[string]Result, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := g.limit
+	if limit <= 0 || limit > len(g.keys) {
+		limit = len(g.keys)
+	}
+	slots := make(chan struct{}, max(limit, 1))
+
+	var mu sync.Mutex
+	results := make(map[string]Result, len(g.keys))
+	groupErr := &GroupError{Failed: make(map[string]error)}
+	var running []Cmd
+	var wg sync.WaitGroup
+
+	for i, key := range g.keys {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			mu.Lock()
+			groupErr.Skipped = append(groupErr.Skipped, g.keys[i:]...)
+			mu.Unlock()
+			break
+		}
+
+		cmd := g.cmds[key]
+		mu.Lock()
+		running = append(running, cmd)
+		mu.Unlock()
+		cmd.Start()
+
+		wg.Add(1)
+		goSafe(func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := cmd.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if result != nil {
+				results[key] = result
+			}
+			if err != nil {
+				groupErr.Failed[key] = err
+				if g.failFast {
+					cancel()
+				}
+			}
+		})
+	}
+
+	// Commands are bound to the context they were built with, so
+	// cancellation has to reach them explicitly
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cmd := range running {
+			cmd.Cancel()
+		}
+	})
+	wg.Wait()
+	stop()
+
+	if len(groupErr.Failed) > 0 || len(groupErr.Skipped) > 0 {
+		return results, groupErr
+	}
+	return results, nil
+}
@@ -0,0 +1,111 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestGroupLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var running, peak atomic.Int32
+	track := func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			defer running.Add(-1)
+			return next(ctx, e)
+		}
+	}
+
+	group := sh.NewGroup().Limit(3)
+	for i := range 10 {
+		group.Add("job"+strconv.Itoa(i), sh.New("sleep").Arg("0.05").Build(ctx).WithMiddleware(track))
+	}
+	group.Add("", sh.New("sh").OptV("-c", "exit 2").Build(ctx))
+
+	results, err := group.Run(ctx)
+	var groupErr *sh.GroupError
+	if !errors.As(err, &groupErr) || len(groupErr.Failed) != 1 || len(groupErr.Skipped) != 0 {
+		t.Fatalf("Expected a single failure, got %v", err)
+	}
+	if _, ok := groupErr.Failed["sh -c 'exit 2'"]; !ok {
+		t.Errorf("Expected the failure to be keyed by command line, got %v", groupErr.Failed)
+	}
+	if len(results) != 11 || results["job3"].ExitCode() != 0 {
+		t.Errorf("Expected results of all commands, got %d", len(results))
+	}
+	if p := peak.Load(); p > 3 || p < 2 {
+		t.Errorf("Expected at most 3 concurrent commands, got %d", p)
+	}
+}
+
+func TestGroupFailFast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group := sh.NewGroup().Limit(2).FailFast().
+		Add("fail", sh.New("false").Build(ctx)).
+		Add("slow", sh.New("sleep").Arg("5").Build(ctx)).
+		Add("never", sh.New("true").Build(ctx))
+
+	start := time.Now()
+	_, err := group.Run(ctx)
+	if time.Since(start) > 2*time.Second {
+		t.Error("Expected the running command to be cancelled")
+	}
+
+	var groupErr *sh.GroupError
+	if !errors.As(err, &groupErr) {
+		t.Fatalf("Expected a GroupError, got %v", err)
+	}
+	if _, ok := groupErr.Failed["fail"]; !ok {
+		t.Errorf("Expected fail to be reported, got %v", groupErr)
+	}
+	if len(groupErr.Skipped) != 1 || groupErr.Skipped[0] != "never" {
+		t.Errorf("Expected never to be skipped, got %v", groupErr.Skipped)
+	}
+}
+
+func TestGroupKeys(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.NewGroup().
+		Add("", sh.New("echo").Arg("a").Build(ctx)).
+		Add("", sh.New("echo").Arg("a").Build(ctx)).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results["echo a"] == nil || results["echo a#2"] == nil {
+		t.Errorf("Expected colliding default keys to be suffixed, got %v", results)
+	}
+
+	ran := false
+	_, err = sh.NewGroup().
+		Add("build", sh.New("true").Build(ctx)).
+		Add("build", sh.New("true").Build(ctx).WithMiddleware(func(next sh.RunFunc) sh.RunFunc {
+			ran = true
+			return next
+		})).
+		Run(ctx)
+	if !errors.Is(err, sh.ErrDuplicateKey) {
+		t.Errorf("Expected ErrDuplicateKey, got %v", err)
+	}
+	if ran {
+		t.Error("Expected no command to run")
+	}
+}
@@ -0,0 +1,306 @@
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Inventory is a set of hosts organized in groups, loaded from an
+// Ansible-style INI inventory, or the YAML equivalent described at
+// ParseInventoryYAML:
+//
+//	[web]
+//	web1 ansible_host=10.0.0.1 role=primary
+//	web2:2222
+//
+//	[web:vars]
+//	ansible_user=deploy
+//
+//	[prod:children]
+//	web
+//
+// The ansible_host, ansible_user and ansible_port variables configure the
+// connection; all other variables are available to command templates run
+// through the resulting Fleet.
+type Inventory struct {
+	hosts     []string
+	hostVars  map[string]map[string]string
+	groups    map[string][]string // group name to direct hosts
+	children  map[string][]string // group name to child groups
+	groupVars map[string]map[string]string
+}
+
+// LoadInventory reads the inventory file at path, which is parsed with
+// ParseInventoryYAML if its name ends in ".yml" or ".yaml" and as INI
+// otherwise. Errors name the file and line.
+func LoadInventory(path string) (*Inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	parse := ParseInventory
+	if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
+		parse = ParseInventoryYAML
+	}
+	inv, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inv, nil
+}
+
+// ParseInventory parses an INI inventory from r.
+func ParseInventory(r io.Reader) (*Inventory, error) {
+	inv := &Inventory{
+		hostVars:  make(map[string]map[string]string),
+		groups:    make(map[string][]string),
+		children:  make(map[string][]string),
+		groupVars: make(map[string]map[string]string),
+	}
+
+	group, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section %q", lineNo, line)
+			}
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			if kind != "" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: unknown section type %q", lineNo, kind)
+			}
+			if _, ok := inv.groups[group]; !ok {
+				inv.groups[group] = nil
+			}
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNo, line)
+			}
+			k, v = strings.TrimSpace(k), unquoteVar(strings.TrimSpace(v))
+			if err := inv.setGroupVar(group, k, v); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		case "children":
+			inv.children[group] = append(inv.children[group], line)
+		default:
+			if err := inv.addHostLine(group, line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func (inv *Inventory) addHostLine(group, line string) error {
+	fields := strings.Fields(line)
+	host := fields[0]
+	if err := inv.addHost(group, host); err != nil {
+		return err
+	}
+
+	for _, field := range fields[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value after host %s, got %q", host, field)
+		}
+		if err := inv.setHostVar(host, k, unquoteVar(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addHost adds host to group, and to the inventory if it is new.
+func (inv *Inventory) addHost(group, host string) error {
+	if err := checkHost(host); err != nil {
+		return err
+	}
+
+	if _, ok := inv.hostVars[host]; !ok {
+		inv.hosts = append(inv.hosts, host)
+		inv.hostVars[host] = make(map[string]string)
+	}
+	if _, ok := inv.groups[group]; !ok {
+		inv.groups[group] = nil
+	}
+	if !slices.Contains(inv.groups[group], host) {
+		inv.groups[group] = append(inv.groups[group], host)
+	}
+	return nil
+}
+
+func (inv *Inventory) setHostVar(host, k, v string) error {
+	if err := checkInventoryVar(k, v); err != nil {
+		return err
+	}
+	inv.hostVars[host][k] = v
+	return nil
+}
+
+func (inv *Inventory) setGroupVar(group, k, v string) error {
+	if err := checkInventoryVar(k, v); err != nil {
+		return err
+	}
+	if inv.groupVars[group] == nil {
+		inv.groupVars[group] = make(map[string]string)
+	}
+	inv.groupVars[group][k] = v
+	return nil
+}
+
+// checkInventoryVar returns an error for connection variables that would
+// not connect where the inventory says.
+func checkInventoryVar(k, v string) error {
+	switch k {
+	case "ansible_host":
+		return checkHost(v)
+	case "ansible_port":
+		if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid ansible_port %q", v)
+		}
+	}
+	return nil
+}
+
+// Groups returns the sorted names of all groups in the inventory.
+func (inv *Inventory) Groups() []string {
+	return slices.Sorted(maps.Keys(inv.groups))
+}
+
+// Fleet returns a Fleet of every host in the inventory.
+func (inv *Inventory) Fleet() *Fleet {
+	return inv.fleet(inv.hosts)
+}
+
+// Group returns a Fleet of the hosts in the named group, including those of
+// its child groups. The fleet is empty if the group does not exist.
+func (inv *Inventory) Group(name string) *Fleet {
+	if name == "all" {
+		return inv.Fleet()
+	}
+
+	member := make(map[string]bool)
+	inv.collect(name, member, make(map[string]bool))
+
+	var hosts []string
+	for _, host := range inv.hosts {
+		if member[host] {
+			hosts = append(hosts, host)
+		}
+	}
+	return inv.fleet(hosts)
+}
+
+// collect adds the hosts of group and its children to member.
+func (inv *Inventory) collect(group string, member, seen map[string]bool) {
+	if seen[group] {
+		return
+	}
+	seen[group] = true
+
+	for _, host := range inv.groups[group] {
+		member[host] = true
+	}
+	for _, child := range inv.children[group] {
+		inv.collect(child, member, seen)
+	}
+}
+
+func (inv *Inventory) fleet(hosts []string) *Fleet {
+	remotes := make([]Remote, len(hosts))
+	for i, host := range hosts {
+		remotes[i] = inv.remote(host)
+	}
+	return NewFleetOf(remotes...)
+}
+
+// remote returns the Remote for host with its variables resolved. Host
+// variables take precedence over those of its groups, which take
+// precedence over those of their parent groups and "all".
+func (inv *Inventory) remote(host string) Remote {
+	vars := make(map[string]string)
+	for k, v := range inv.groupVars["all"] {
+		vars[k] = v
+	}
+	for _, group := range inv.groupsOf(host) {
+		for k, v := range inv.groupVars[group] {
+			vars[k] = v
+		}
+	}
+	for k, v := range inv.hostVars[host] {
+		vars[k] = v
+	}
+
+	r := NewRemote(host)
+	r.Alias = r.Host
+	if h, ok := vars["ansible_host"]; ok {
+		r.Host = h
+	}
+	if u, ok := vars["ansible_user"]; ok {
+		r.User = u
+	}
+	if p, ok := vars["ansible_port"]; ok {
+		r.Port, _ = strconv.Atoi(p)
+	}
+	r.Vars = vars
+	return r
+}
+
+// groupsOf returns the groups containing host, ordered from the most
+// general ancestor group to the group listing the host directly.
+func (inv *Inventory) groupsOf(host string) []string {
+	var ordered []string
+	visited := make(map[string]bool)
+
+	var visit func(group string)
+	visit = func(group string) {
+		if visited[group] {
+			return
+		}
+		visited[group] = true
+		for _, parent := range slices.Sorted(maps.Keys(inv.children)) {
+			if slices.Contains(inv.children[parent], group) {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, group)
+	}
+
+	for _, group := range inv.Groups() {
+		if slices.Contains(inv.groups[group], host) {
+			visit(group)
+		}
+	}
+	return ordered
+}
+
+// unquoteVar strips matching single or double quotes around v.
+func unquoteVar(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
@@ -0,0 +1,193 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+const testInventory = `
+# production hosts
+[web]
+web1 ansible_host=10.0.0.1 role=primary
+web2:2222 role=replica
+
+[db]
+db1 ansible_user=postgres
+
+[web:vars]
+ansible_user=deploy
+
+[prod:children]
+web
+db
+
+[all:vars]
+env=production
+`
+
+func TestParseInventory(t *testing.T) {
+	inv, err := sh.ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("ParseInventory failed: %v", err)
+	}
+
+	web := inv.Group("web").Hosts()
+	if len(web) != 2 {
+		t.Fatalf("Expected 2 web hosts, got %d", len(web))
+	}
+
+	if web[0].Name() != "web1" || web[0].Host != "10.0.0.1" || web[0].User != "deploy" {
+		t.Errorf("Unexpected web1 remote: %+v", web[0])
+	}
+	if web[1].Host != "web2" || web[1].Port != 2222 {
+		t.Errorf("Unexpected web2 remote: %+v", web[1])
+	}
+	if web[1].Vars["env"] != "production" || web[1].Vars["role"] != "replica" {
+		t.Errorf("Unexpected web2 vars: %v", web[1].Vars)
+	}
+
+	prod := inv.Group("prod").Hosts()
+	if len(prod) != 3 {
+		t.Errorf("Expected child groups to be included in prod, got %d hosts", len(prod))
+	}
+	if prod[2].User != "postgres" {
+		t.Errorf("Expected db1 user 'postgres', got '%s'", prod[2].User)
+	}
+
+	if len(inv.Group("missing").Hosts()) != 0 {
+		t.Error("Expected unknown group to be empty")
+	}
+}
+
+func TestInventoryFleetTemplating(t *testing.T) {
+	fakeSSH(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inv, err := sh.ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("ParseInventory failed: %v", err)
+	}
+
+	results, err := inv.Group("web").Run(ctx, sh.New("echo").Arg("{{.env}}-{{.role}}"))
+	if err != nil {
+		t.Fatalf("Fleet run failed: %v", err)
+	}
+
+	for i, expected := range []string{"production-primary", "production-replica"} {
+		if output := strings.TrimSpace(string(results[i].Result.Stdout())); output != expected {
+			t.Errorf("Expected '%s' on %s, got '%s'", expected, results[i].Host, output)
+		}
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	for _, input := range []string{
+		"[web\nweb1",
+		"[web:hosts]\nweb1",
+		"[web]\nweb1 role",
+		"[web:vars]\nrole",
+		"[web]\n-oProxyCommand=id",
+		"[web]\nweb1 ansible_host=-oProxyCommand=id",
+		"[web:vars]\nansible_host=-oProxyCommand=id",
+		"[web]\nweb1 ansible_port=ssh",
+		"[web:vars]\nansible_port=0",
+	} {
+		if _, err := sh.ParseInventory(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error parsing %q", input)
+		}
+	}
+}
+
+const testYAMLInventory = `---
+# production hosts
+all:
+  vars:
+    env: production
+  children:
+    prod:
+      children:
+        web:
+        db:
+    web:
+      hosts:
+        web1:
+          ansible_host: 10.0.0.1
+          role: primary  # the writer
+        web2:
+          ansible_port: 2222
+          role: 'replica'
+      vars:
+        ansible_user: deploy
+    db:
+      hosts:
+        db1:
+          ansible_user: "postgres"
+`
+
+func TestParseInventoryYAML(t *testing.T) {
+	inv, err := sh.ParseInventoryYAML(strings.NewReader(testYAMLInventory))
+	if err != nil {
+		t.Fatalf("ParseInventoryYAML failed: %v", err)
+	}
+
+	web := inv.Group("web").Hosts()
+	if len(web) != 2 {
+		t.Fatalf("Expected 2 web hosts, got %d", len(web))
+	}
+	if web[0].Name() != "web1" || web[0].Host != "10.0.0.1" || web[0].User != "deploy" {
+		t.Errorf("Unexpected web1 remote: %+v", web[0])
+	}
+	if web[1].Host != "web2" || web[1].Port != 2222 {
+		t.Errorf("Unexpected web2 remote: %+v", web[1])
+	}
+	if web[0].Vars["role"] != "primary" || web[1].Vars["env"] != "production" || web[1].Vars["role"] != "replica" {
+		t.Errorf("Unexpected vars: %v, %v", web[0].Vars, web[1].Vars)
+	}
+
+	prod := inv.Group("prod").Hosts()
+	if len(prod) != 3 || prod[2].User != "postgres" {
+		t.Errorf("Expected child groups to be included in prod, got %+v", prod)
+	}
+}
+
+func TestParseInventoryYAMLErrors(t *testing.T) {
+	for _, input := range []string{
+		"all:\n  hosts:\n    - web1",
+		"all:\n  hosts: [web1]",
+		"all:\n  hosts:\n    web1: up",
+		"all:\n  servers:\n    web1:",
+		"all:\n  hosts:\n    web1:\n   web2:",
+		"all:\n  hosts:\n    -oProxyCommand=id:",
+		"all:\n  hosts:\n    web1:\n      ansible_port: ssh",
+		"all:\n  vars:\n    ansible_port: 70000",
+		"all:\n  vars:\n    env: production\n    env: staging",
+	} {
+		if _, err := sh.ParseInventoryYAML(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error parsing %q", input)
+		}
+	}
+}
+
+func TestLoadInventoryErrorLocation(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"hosts.ini": "[web]\nweb1\nweb2 ansible_port=22x\n",
+		"hosts.yml": "all:\n  hosts:\n    web2:\n      ansible_port: 22x\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := sh.LoadInventory(path)
+		if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "line") || !strings.Contains(err.Error(), "ansible_port") {
+			t.Errorf("Expected an ansible_port error naming %s and the line, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseInventoryYAML parses a YAML inventory from r, in the layout Ansible
+// uses:
+//
+//	all:
+//	  vars:
+//	    env: production
+//	  children:
+//	    web:
+//	      hosts:
+//	        web1:
+//	          ansible_host: 10.0.0.1
+//	        web2:
+//	      vars:
+//	        ansible_user: deploy
+//
+// Only the block mappings and scalars such inventories consist of are
+// supported; sequences, flow collections, anchors and multi-line scalars
+// are rejected.
+func ParseInventoryYAML(r io.Reader) (*Inventory, error) {
+	root, err := parseYAMLMapping(r)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{
+		hostVars:  make(map[string]map[string]string),
+		groups:    make(map[string][]string),
+		children:  make(map[string][]string),
+		groupVars: make(map[string]map[string]string),
+	}
+	for _, name := range root.keys {
+		if err := inv.addYAMLGroup(name, root.items[name]); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// addYAMLGroup adds the group name described by node, and its children.
+func (inv *Inventory) addYAMLGroup(name string, node *yamlNode) error {
+	if _, ok := inv.groups[name]; !ok {
+		inv.groups[name] = nil
+	}
+	if node.isNull() {
+		return nil
+	}
+	if !node.isMapping() {
+		return fmt.Errorf("line %d: expected hosts, vars or children of group %s", node.line, name)
+	}
+
+	for _, key := range node.keys {
+		section := node.items[key]
+		if section.isNull() {
+			continue
+		}
+		if !section.isMapping() {
+			return fmt.Errorf("line %d: expected a mapping for %s of group %s", section.line, key, name)
+		}
+
+		switch key {
+		case "hosts":
+			// Hosts listed right under "all" belong to no other group
+			group := name
+			if name == "all" {
+				group = "ungrouped"
+			}
+			for _, host := range section.keys {
+				if err := inv.addYAMLHost(group, host, section.items[host]); err != nil {
+					return err
+				}
+			}
+		case "vars":
+			for _, k := range section.keys {
+				v := section.items[k]
+				if v.isMapping() {
+					return fmt.Errorf("line %d: expected a value for %s", v.line, k)
+				}
+				if err := inv.setGroupVar(name, k, v.value); err != nil {
+					return fmt.Errorf("line %d: %w", v.line, err)
+				}
+			}
+		case "children":
+			for _, child := range section.keys {
+				inv.children[name] = append(inv.children[name], child)
+				if err := inv.addYAMLGroup(child, section.items[child]); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("line %d: unknown key %q in group %s", section.line, key, name)
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) addYAMLHost(group, host string, node *yamlNode) error {
+	if err := inv.addHost(group, host); err != nil {
+		return fmt.Errorf("line %d: %w", node.line, err)
+	}
+	if node.isNull() {
+		return nil
+	}
+	if !node.isMapping() {
+		return fmt.Errorf("line %d: expected the variables of host %s", node.line, host)
+	}
+
+	for _, k := range node.keys {
+		v := node.items[k]
+		if v.isMapping() {
+			return fmt.Errorf("line %d: expected a value for %s", v.line, k)
+		}
+		if err := inv.setHostVar(host, k, v.value); err != nil {
+			return fmt.Errorf("line %d: %w", v.line, err)
+		}
+	}
+	return nil
+}
+
+// yamlNode is a YAML mapping or scalar. A mapping without keys stands for
+// an empty value, as in "web1:".
+type yamlNode struct {
+	line   int
+	value  string
+	scalar bool
+	keys   []string // in document order
+	items  map[string]*yamlNode
+	indent int // of the mapping's keys, -1 until the first one
+}
+
+func (n *yamlNode) isMapping() bool { return !n.scalar && len(n.keys) > 0 }
+func (n *yamlNode) isNull() bool    { return !n.scalar && len(n.keys) == 0 }
+
+// parseYAMLMapping parses a YAML document made of nested block mappings
+// with scalar values.
+func parseYAMLMapping(r io.Reader) (*yamlNode, error) {
+	type level struct {
+		indent int
+		node   *yamlNode
+	}
+	root := &yamlNode{items: make(map[string]*yamlNode), indent: -1}
+	stack := []level{{indent: -1, node: root}}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		raw := scanner.Text()
+		line := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(line)
+		line = strings.TrimRight(stripYAMLComment(line), " \t")
+		if line == "" || (indent == 0 && (line == "---" || line == "...")) {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", lineNo)
+		}
+		if line == "-" || strings.HasPrefix(line, "- ") {
+			return nil, fmt.Errorf("line %d: YAML sequences are not supported in inventories", lineNo)
+		}
+
+		for stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1].node
+		if parent.scalar {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNo)
+		}
+		if parent.indent < 0 {
+			parent.indent = indent
+		} else if parent.indent != indent {
+			return nil, fmt.Errorf("line %d: inconsistent indentation", lineNo)
+		}
+
+		key, rest, err := splitYAMLKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if _, dup := parent.items[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, key)
+		}
+
+		node := &yamlNode{line: lineNo, items: make(map[string]*yamlNode), indent: -1}
+		if rest != "" {
+			if node.value, err = yamlScalar(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			node.scalar = true
+		}
+		parent.keys = append(parent.keys, key)
+		parent.items[key] = node
+		stack = append(stack, level{indent: indent, node: node})
+	}
+	return root, scanner.Err()
+}
+
+// stripYAMLComment removes a comment, which starts with a "#" at the start
+// of line or after white space, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitYAMLKey splits "key: value" or "key:" into the key and the rest.
+func splitYAMLKey(line string) (key, rest string, err error) {
+	if line[0] == '"' || line[0] == '\'' {
+		end := strings.IndexByte(line[1:], line[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quoted key %s", line)
+		}
+		key, rest = line[1:end+1], line[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("expected key: value, got %q", line)
+		}
+		return key, strings.TrimSpace(rest[1:]), nil
+	}
+
+	if i := strings.Index(line, ": "); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+2:]), nil
+	}
+	if strings.HasSuffix(line, ":") {
+		return line[:len(line)-1], "", nil
+	}
+	return "", "", fmt.Errorf("expected key: value, got %q", line)
+}
+
+// yamlScalar returns the value of the plain or quoted scalar s.
+func yamlScalar(s string) (string, error) {
+	switch s[0] {
+	case '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("malformed double-quoted value %s", s)
+		}
+		return v, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", fmt.Errorf("malformed single-quoted value %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '[', '{', '&', '*', '!', '|', '>':
+		return "", fmt.Errorf("unsupported YAML value %s", s)
+	}
+	if s == "~" || s == "null" {
+		return "", nil
+	}
+	return s, nil
+}
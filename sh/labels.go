@@ -0,0 +1,68 @@
+package sh
+
+import (
+	"context"
+	"sync"
+)
+
+// Name labels the commands built by b with a name describing their intent,
+// such as "migrate-db", shown instead of the executable by tracing, logging,
+// metrics, StateStore records, labeled output and Group results.
+func (b *Builder) Name(name string) *Builder {
+	b.name = name
+	return b
+}
+
+// Group labels the commands built by b as part of a larger unit of work,
+// such as "release", shown alongside their Name.
+func (b *Builder) Group(group string) *Builder {
+	b.group = group
+	return b
+}
+
+func (cm *cmdImpl) Name() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if cm.name != "" {
+		return cm.name
+	}
+	return cm.cmd
+}
+
+func (cm *cmdImpl) Group() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.group
+}
+
+// label returns the name of the command qualified by its group, e.g.
+// "release/migrate-db", for places with room for a single name.
+func (cm *cmdImpl) label() string {
+	if group := cm.Group(); group != "" {
+		return group + "/" + cm.Name()
+	}
+	return cm.Name()
+}
+
+// labeledOutputMu keeps the lines of commands run WithLabeledOutput from
+// interleaving.
+var labeledOutputMu sync.Mutex
+
+func (cm *cmdImpl) WithLabeledOutput() Cmd {
+	prefix := cm.label() + " | "
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	_, stdout, stderr := cm.runner.stdio()
+	out := newPrefixWriter(&labeledOutputMu, stdout, prefix)
+	errOut := newPrefixWriter(&labeledOutputMu, stderr, prefix)
+	cm.stdout = appendWriter(cm.stdout, out)
+	cm.stderr = appendWriter(cm.stderr, errOut)
+	cm.middleware = append(cm.middleware, func(next RunFunc) RunFunc {
+		return func(ctx context.Context, e *Execution) error {
+			defer errOut.Flush()
+			defer out.Flush()
+			return next(ctx, e)
+		}
+	})
+	return cm
+}
@@ -0,0 +1,118 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBuilderNameAndGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("psql").Arg("-f").Arg("up.sql").Name("migrate-db").Group("release").Build(ctx)
+	if cmd.Name() != "migrate-db" || cmd.Group() != "release" {
+		t.Errorf("Expected the labels, got %q and %q", cmd.Name(), cmd.Group())
+	}
+	if cmd := sh.New("psql").Build(ctx); cmd.Name() != "psql" || cmd.Group() != "" {
+		t.Errorf("Expected unlabeled commands to be named after the executable, got %q and %q", cmd.Name(), cmd.Group())
+	}
+}
+
+func TestLabelsFlowIntoObservability(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tracer := &fakeTracer{}
+	metrics := &recordingMetrics{}
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{
+		Metrics:    metrics,
+		Middleware: []sh.Middleware{sh.TracingMiddleware(tracer)},
+	})
+
+	_, err = runner.New("echo").Arg("migrated").Name("migrate-db").Group("release").Build(ctx).
+		WithStateStore(store, "").
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := tracer.spans[0]
+	if span.name != "exec migrate-db" || span.attrs["sh.name"] != "migrate-db" || span.attrs["sh.group"] != "release" {
+		t.Errorf("Expected the span to carry the labels, got %q %v", span.name, span.attrs)
+	}
+	if !slices.Equal(metrics.names, []string{"release/migrate-db"}) {
+		t.Errorf("Expected metrics under the group and name, got %v", metrics.names)
+	}
+	records, err := store.Query(sh.StateQuery{Key: "migrate-db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Group != "release" {
+		t.Errorf("Expected the run recorded under its name, got %+v", records)
+	}
+}
+
+func TestWithLabeledOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Stdout: &stdout, Stderr: &stderr})
+	result, err := runner.New("sh").Arg("-c").Arg("echo one; echo two; echo oops >&2; printf tail").
+		Name("build").Group("release").Build(ctx).
+		WithLabeledOutput().
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "release/build | one\nrelease/build | two\nrelease/build | tail\n"
+	if stdout.String() != want {
+		t.Errorf("Expected labeled stdout %q, got %q", want, stdout.String())
+	}
+	if stderr.String() != "release/build | oops\n" {
+		t.Errorf("Expected labeled stderr, got %q", stderr.String())
+	}
+	if result.TrimmedString() != "one\ntwo\ntail" {
+		t.Errorf("Expected the output to be captured unlabeled, got %q", result.TrimmedString())
+	}
+}
+
+func TestGroupKeysByName(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.NewGroup().
+		Add("", sh.New("echo").Arg("a").Name("first").Build(ctx)).
+		Add("", sh.New("echo").Arg("b").Build(ctx)).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := results["first"]; !ok {
+		t.Errorf("Expected named commands keyed by name, got %v", keys(results))
+	}
+	if _, ok := results["echo b"]; !ok {
+		t.Errorf("Expected unnamed commands keyed by command line, got %v", keys(results))
+	}
+}
+
+func keys(results map[string]sh.Result) string {
+	var ks []string
+	for k := range results {
+		ks = append(ks, k)
+	}
+	slices.Sort(ks)
+	return strings.Join(ks, ", ")
+}
@@ -0,0 +1,234 @@
+package sh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// limiter bounds how many processes run at once and how often they start.
+type limiter struct {
+	slots chan struct{} // nil if concurrency is unlimited
+	rate  float64       // starts per second, 0 if unlimited
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  LimitStats
+}
+
+// LimitStats describes the queue of commands held back by the limits set
+// with SetMaxConcurrentProcesses and SetRateLimit, or those of a Runner.
+type LimitStats struct {
+	// Running is how many commands have started and not finished yet.
+	Running int
+	// Waiting is how many commands are waiting to start.
+	Waiting int
+	// Started is how many commands were let through, and Waited how many
+	// of them had to wait.
+	Started int64
+	Waited  int64
+	// WaitTime is the time all commands spent waiting, and MaxWait the
+	// longest any one of them waited.
+	WaitTime time.Duration
+	MaxWait  time.Duration
+}
+
+// WaitMetrics may be implemented by the Metrics of a Runner to be told how
+// long each command waited for the limits to let it start, for commands
+// that had to wait.
+type WaitMetrics interface {
+	ObserveWait(name string, wait time.Duration)
+}
+
+// newLimiter returns a limiter, or nil if neither limit is set.
+func newLimiter(maxProcesses int, rate float64, burst int) *limiter {
+	if maxProcesses <= 0 && rate <= 0 {
+		return nil
+	}
+	l := &limiter{burst: max(burst, 1)}
+	if maxProcesses > 0 {
+		l.slots = make(chan struct{}, maxProcesses)
+	}
+	if rate > 0 {
+		l.rate = rate
+		l.tokens = float64(l.burst)
+		l.last = time.Now()
+	}
+	return l
+}
+
+// acquire waits for a free slot and then for the rate limit to allow
+// another start. The returned function frees the slot.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	l.mu.Lock()
+	l.stats.Waiting++
+	l.mu.Unlock()
+	defer func() {
+		wait := time.Since(start)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.stats.Waiting--
+		if err != nil {
+			return
+		}
+		l.stats.Running++
+		l.stats.Started++
+		if wait >= minWait {
+			l.stats.Waited++
+			l.stats.WaitTime += wait
+			l.stats.MaxWait = max(l.stats.MaxWait, wait)
+		}
+		free := release
+		var once sync.Once
+		release = func() {
+			once.Do(func() {
+				free()
+				l.mu.Lock()
+				l.stats.Running--
+				l.mu.Unlock()
+			})
+		}
+	}()
+
+	release = func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = func() { <-l.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err := l.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// minWait is how long acquiring must take to count as waiting, rather than
+// as the overhead of checking the limits.
+const minWait = time.Millisecond
+
+// Stats returns the statistics of the limiter, or zero ones if l is nil.
+func (l *limiter) Stats() LimitStats {
+	if l == nil {
+		return LimitStats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// wait takes a token from the bucket, waiting until one is available.
+func (l *limiter) wait(ctx context.Context) error {
+	if l.rate == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	l.last = now
+	// Reserve the token even if it is not there yet, so waiters are
+	// served in order
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+var (
+	globalLimitsMu sync.Mutex
+	globalMax      int
+	globalRate     float64
+	globalBurst    int
+	// globalLimiter enforces the limits set with SetMaxConcurrentProcesses
+	// and SetRateLimit, if any.
+	globalLimiter atomic.Pointer[limiter]
+)
+
+// SetMaxConcurrentProcesses limits how many commands run at once across the
+// whole program, including those of Runners with limits of their own.
+// Commands started beyond the limit wait for a running one to exit, or
+// fail with their context's error if it is done first. A pipeline counts
+// as one command. A non-positive n removes the limit; commands already
+// running do not count against a new limit.
+func SetMaxConcurrentProcesses(n int) {
+	globalLimitsMu.Lock()
+	defer globalLimitsMu.Unlock()
+	globalMax = n
+	globalLimiter.Store(newLimiter(globalMax, globalRate, globalBurst))
+}
+
+// SetRateLimit limits how many commands start per second across the whole
+// program, allowing bursts of up to burst commands at once. Commands wait
+// for their turn like with SetMaxConcurrentProcesses. A non-positive
+// perSecond removes the limit.
+func SetRateLimit(perSecond float64, burst int) {
+	globalLimitsMu.Lock()
+	defer globalLimitsMu.Unlock()
+	globalRate = perSecond
+	globalBurst = burst
+	globalLimiter.Store(newLimiter(globalMax, globalRate, globalBurst))
+}
+
+// Limits returns the statistics of the limits set with
+// SetMaxConcurrentProcesses and SetRateLimit since they were last set, for
+// exporting queue lengths and wait times. They are zero without limits.
+func Limits() LimitStats {
+	return globalLimiter.Load().Stats()
+}
+
+// acquireLimits waits until the package-level limits and those of the
+// command's runner allow it to start. The returned function marks the
+// command as finished.
+func (cm *cmdImpl) acquireLimits(ctx context.Context) (release func(), err error) {
+	// A pipeline counts once, for its last stage
+	if cm.pipeOut != nil {
+		return func() {}, nil
+	}
+
+	// The runner's limits come first, so that commands waiting for them do
+	// not hold on to a slot of the program's
+	start := time.Now()
+	releaseRunner, err := cm.runner.limits.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("sh: %s: waiting to start: %w", cm.cmd, err)
+	}
+	releaseGlobal, err := globalLimiter.Load().acquire(ctx)
+	if err != nil {
+		releaseRunner()
+		return nil, fmt.Errorf("sh: %s: waiting to start: %w", cm.cmd, err)
+	}
+	if wait := time.Since(start); wait >= minWait {
+		cm.runner.observeWait(cm.label(), wait)
+	}
+	return func() {
+		releaseRunner()
+		releaseGlobal()
+	}, nil
+}
@@ -0,0 +1,199 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// concurrency returns middleware recording the highest number of commands
+// running at once in peak.
+func concurrency(peak *atomic.Int32) sh.Middleware {
+	var running atomic.Int32
+	return func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			return next(ctx, e)
+		}
+	}
+}
+
+func TestRunnerMaxProcesses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var peak atomic.Int32
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{
+		MaxProcesses: 2,
+		Middleware:   []sh.Middleware{concurrency(&peak)},
+	})
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := runner.New("sleep").Arg("0.05").Build(ctx).Run(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("Expected at most 2 commands at once, got %d", got)
+	}
+}
+
+func TestSetMaxConcurrentProcesses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sh.SetMaxConcurrentProcesses(1)
+	defer sh.SetMaxConcurrentProcesses(0)
+
+	long := sh.New("sh").OptV("-c", "exec sleep 5").Build(ctx)
+	long.Start()
+	defer func() {
+		long.Cancel()
+		long.Wait()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Queued behind the running command until its context gives up
+	queuedCtx, queuedCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer queuedCancel()
+	start := time.Now()
+	_, err := sh.New("true").Build(queuedCtx).Run()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the queued command to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the queued command to give up with its context, took %v", elapsed)
+	}
+}
+
+func TestMaxProcessesPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A pipeline counts once, so it does not wait for itself
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{MaxProcesses: 1})
+	result, err := runner.New("echo").Arg("hello").Build(ctx).
+		Pipe("tr").Arg("a-z").Arg("A-Z").Build().
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(result.Stdout()); got != "HELLO\n" {
+		t.Errorf("Expected HELLO, got %q", got)
+	}
+}
+
+func TestRunnerRateLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{RateLimit: 20, RateBurst: 2})
+	start := time.Now()
+	for range 6 {
+		if _, err := runner.New("true").Build(ctx).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two start right away, the other four 50ms apart
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Expected starts to be spread out, took only %v", elapsed)
+	}
+}
+
+type waitMetrics struct {
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (m *waitMetrics) ObserveRun(string, int, time.Duration, error) {}
+
+func (m *waitMetrics) ObserveWait(name string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.waits = append(m.waits, wait)
+}
+
+func TestRunnerLimitStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metrics := &waitMetrics{}
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{MaxProcesses: 1, Metrics: metrics})
+
+	// waitFor polls the statistics rather than timing the commands, which
+	// is unreliable on a loaded machine
+	waitFor := func(what string, ok func(sh.LimitStats) bool) {
+		t.Helper()
+		for !ok(runner.Limits()) {
+			if ctx.Err() != nil {
+				t.Fatalf("Timed out waiting for %s, got %+v", what, runner.Limits())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The first command holds the only slot until its stdin is closed
+	r, w := io.Pipe()
+	errs := make(chan error, 3)
+	go func() {
+		_, err := runner.New("cat").Build(ctx).WithStdin(r).Run()
+		errs <- err
+	}()
+	waitFor("the first command to run", func(s sh.LimitStats) bool { return s.Running == 1 })
+
+	for range 2 {
+		go func() {
+			_, err := runner.New("true").Build(ctx).Run()
+			errs <- err
+		}()
+	}
+	waitFor("two commands to wait", func(s sh.LimitStats) bool { return s.Waiting == 2 })
+	const held = 20 * time.Millisecond
+	time.Sleep(held)
+	w.Close()
+
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	stats := runner.Limits()
+	if stats.Running != 0 || stats.Waiting != 0 || stats.Started != 3 {
+		t.Errorf("Expected 3 commands started and none left, got %+v", stats)
+	}
+	if stats.Waited != 2 || stats.MaxWait < held || stats.WaitTime < stats.MaxWait {
+		t.Errorf("Expected 2 commands to wait at least %v, got %+v", held, stats)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.waits) != 2 {
+		t.Errorf("Expected the 2 waits observed, got %v", metrics.waits)
+	}
+}
+
+func TestLimitsWithoutLimit(t *testing.T) {
+	if stats := sh.Limits(); stats != (sh.LimitStats{}) {
+		t.Errorf("Expected no statistics without limits, got %+v", stats)
+	}
+}
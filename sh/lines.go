@@ -0,0 +1,113 @@
+package sh
+
+import (
+	"bytes"
+	"iter"
+	"sync"
+)
+
+func (cm *cmdImpl) Lines() iter.Seq[string] {
+	return cm.streamLines(true)
+}
+
+func (cm *cmdImpl) StderrLines() iter.Seq[string] {
+	return cm.streamLines(false)
+}
+
+// streamLines attaches a lineWriter to stdout or stderr and returns a
+// sequence of the lines written to it, which starts the command.
+func (cm *cmdImpl) streamLines(toStdout bool) iter.Seq[string] {
+	cm.mu.Lock()
+	buffer := cm.lineBuffer
+	if buffer == 0 {
+		buffer = 64
+	}
+	lw := newLineWriter(cm.backpressure, buffer)
+	binary := cm.binary && toStdout
+	if !binary {
+		if toStdout {
+			cm.stdout = appendWriter(cm.stdout, lw)
+		} else {
+			cm.stderr = appendWriter(cm.stderr, lw)
+		}
+	}
+	cm.mu.Unlock()
+
+	var once sync.Once
+	return func(yield func(string) bool) {
+		once.Do(func() {
+			cm.Start()
+			goSafe(func() {
+				<-cm.done
+				lw.close()
+			})
+		})
+		// Let the command finish unhindered if the caller stops early
+		defer lw.detach()
+
+		for line := range lw.lines {
+			if !yield(line) {
+				return
+			}
+		}
+	}
+}
+
+// lineWriter splits the bytes written to it into lines and sends them on
+// lines. Once lines is full, writes block until the consumer catches up or
+// detaches, or drop lines, as policy says.
+type lineWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	lines   chan string
+	policy  Backpressure
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func newLineWriter(policy Backpressure, buffer int) *lineWriter {
+	return &lineWriter{
+		lines:  make(chan string, buffer),
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.partial = append(lw.partial, p...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lw.send(string(bytes.TrimSuffix(lw.partial[:i], []byte("\r"))))
+		lw.partial = lw.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (lw *lineWriter) send(line string) {
+	deliver(lw.lines, line, lw.policy, lw.stop)
+}
+
+// close flushes a trailing line without a newline and ends the sequence.
+func (lw *lineWriter) close() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if len(lw.partial) > 0 {
+		lw.send(string(lw.partial))
+		lw.partial = nil
+	}
+	close(lw.lines)
+}
+
+// detach stops delivering lines to the consumer.
+func (lw *lineWriter) detach() {
+	lw.once.Do(func() {
+		close(lw.stop)
+	})
+}
@@ -0,0 +1,107 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdLines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").
+		OptV("-c", `echo one; sleep 0.2; printf 'two\r\nthree'`).
+		Build(ctx)
+
+	var lines []string
+	var firstAt time.Duration
+	start := time.Now()
+	for line := range cmd.Lines() {
+		if len(lines) == 0 {
+			firstAt = time.Since(start)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 3 || lines[0] != "one" || lines[1] != "two" || lines[2] != "three" {
+		t.Fatalf("Unexpected lines: %q", lines)
+	}
+	if firstAt >= 200*time.Millisecond {
+		t.Errorf("Expected the first line before the command finished, got it after %v", firstAt)
+	}
+
+	result, err := cmd.Wait()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if string(result.Stdout()) != "one\ntwo\r\nthree" {
+		t.Errorf("Expected full stdout on the result, got %q", result.Stdout())
+	}
+}
+
+func TestCmdLinesStartsOnRange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "started")
+	cmd := sh.New("sh").OptV("-c", `touch "$0"; echo done`).Arg(path).Build(ctx)
+	lines := cmd.Lines()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the command not to start before ranging over Lines, got %v", err)
+	}
+
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if len(got) != 1 || got[0] != "done" {
+		t.Errorf("Unexpected lines: %q", got)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the command to have run: %v", err)
+	}
+}
+
+func TestCmdLinesBreak(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("1").Arg("10000").Build(ctx)
+	for line := range cmd.Lines() {
+		if line == "3" {
+			break
+		}
+	}
+
+	// Stopping early must not stall the command
+	result, err := cmd.Wait()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if result.StdoutSize() == 0 {
+		t.Error("Expected stdout to be captured after the consumer stopped")
+	}
+}
+
+func TestCmdStderrLines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").OptV("-c", "echo out; echo err >&2").Build(ctx)
+
+	var lines []string
+	for line := range cmd.StderrLines() {
+		lines = append(lines, line)
+	}
+	if len(lines) != 1 || lines[0] != "err" {
+		t.Errorf("Expected only stderr lines, got %q", lines)
+	}
+}
@@ -0,0 +1,206 @@
+package sh
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// LogOption configures what command lifecycle logs reveal.
+type LogOption func(*logConfig)
+
+type logConfig struct {
+	logger      *slog.Logger
+	redactArgs  []*regexp.Regexp
+	redactFlags []string
+	redactEnv   []string
+}
+
+// defaultRedactEnv are the variable name patterns whose values are never
+// logged.
+var defaultRedactEnv = []string{"*TOKEN*", "*SECRET*", "*PASSWORD*", "*KEY*", "*CREDENTIAL*"}
+
+var defaultLog atomic.Pointer[logConfig]
+
+// RedactArgs replaces every argument matching re with [REDACTED] in logs.
+func RedactArgs(re *regexp.Regexp) LogOption {
+	return func(c *logConfig) {
+		c.redactArgs = append(c.redactArgs, re)
+	}
+}
+
+// RedactFlags hides the values of flags in logs, both when passed as a
+// separate argument ("--password x") and inline ("--password=x").
+func RedactFlags(flags ...string) LogOption {
+	return func(c *logConfig) {
+		c.redactFlags = append(c.redactFlags, flags...)
+	}
+}
+
+// RedactEnv hides the values of environment variables whose names match
+// one of patterns, in path.Match syntax. Variables named like tokens,
+// secrets, passwords, keys and credentials are always hidden.
+func RedactEnv(patterns ...string) LogOption {
+	return func(c *logConfig) {
+		c.redactEnv = append(c.redactEnv, patterns...)
+	}
+}
+
+// SetLogger sets the logger receiving lifecycle events of commands that do
+// not have their own logger set with WithLogger. A nil l disables logging,
+// which is the default.
+func SetLogger(l *slog.Logger, opts ...LogOption) {
+	if l == nil {
+		defaultLog.Store(nil)
+		return
+	}
+	defaultLog.Store(newLogConfig(l, opts))
+}
+
+func (cm *cmdImpl) WithLogger(l *slog.Logger, opts ...LogOption) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.log = newLogConfig(l, opts)
+	return cm
+}
+
+func newLogConfig(l *slog.Logger, opts []LogOption) *logConfig {
+	c := &logConfig{logger: l, redactEnv: slices.Clone(defaultRedactEnv)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// logConfig returns the command's logging configuration, or nil if
+// nothing is logged.
+func (cm *cmdImpl) logConfig() *logConfig {
+	if cm.log != nil {
+		if cm.log.logger == nil {
+			return nil
+		}
+		return cm.log
+	}
+	return defaultLog.Load()
+}
+
+// logRun wraps run to log the start and end of the execution.
+func (cm *cmdImpl) logRun(run RunFunc) RunFunc {
+	c := cm.logConfig()
+	if c == nil {
+		return run
+	}
+
+	return func(ctx context.Context, e *Execution) error {
+		dir := e.Exec.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		attrs := []any{
+			slog.Any("argv", c.argv(e.RedactedArgs())),
+			slog.String("cwd", dir),
+		}
+		if cm.name != "" {
+			attrs = append(attrs, slog.String("name", cm.name))
+		}
+		if cm.group != "" {
+			attrs = append(attrs, slog.String("group", cm.group))
+		}
+		if len(cm.env) > 0 {
+			attrs = append(attrs, c.env(cm, cm.env))
+		}
+		c.logger.DebugContext(ctx, "sh: start", attrs...)
+
+		start := time.Now()
+		err := run(ctx, e)
+		attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+		if e.Exec.Process != nil {
+			attrs = append(attrs, slog.Int("pid", e.Exec.Process.Pid))
+		}
+		if e.Exec.ProcessState != nil {
+			attrs = append(attrs, slog.Int("exit_code", e.Exec.ProcessState.ExitCode()))
+		}
+
+		switch {
+		case err == nil:
+			c.logger.InfoContext(ctx, "sh: exit", attrs...)
+		case ctx.Err() != nil:
+			attrs = append(attrs, slog.String("reason", context.Cause(ctx).Error()))
+			c.logger.WarnContext(ctx, "sh: cancel", attrs...)
+		default:
+			attrs = append(attrs, slog.String("error", err.Error()))
+			c.logger.WarnContext(ctx, "sh: exit", attrs...)
+		}
+		return err
+	}
+}
+
+// logRetry logs that cmd failed and is run again after delay.
+func logRetry(cmd Cmd, attempt int, delay time.Duration, err error) {
+	cm, ok := cmd.(*cmdImpl)
+	if !ok {
+		return
+	}
+	c := cm.logConfig()
+	if c == nil {
+		return
+	}
+
+	attrs := []any{
+		slog.Any("argv", c.argv(cm.redactAll(append([]string{cm.cmd}, cm.args...)))),
+		slog.Int("attempt", attempt),
+		slog.Duration("delay", delay),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	c.logger.Warn("sh: retry", attrs...)
+}
+
+// argv returns args with sensitive values redacted.
+func (c *logConfig) argv(args []string) []string {
+	out := slices.Clone(args)
+	for i, arg := range out {
+		if i > 0 && slices.Contains(c.redactFlags, args[i-1]) {
+			out[i] = redacted
+			continue
+		}
+		if flag, _, ok := strings.Cut(arg, "="); ok && slices.Contains(c.redactFlags, flag) {
+			out[i] = flag + "=" + redacted
+			continue
+		}
+		for _, re := range c.redactArgs {
+			if re.MatchString(arg) {
+				out[i] = redacted
+				break
+			}
+		}
+	}
+	return out
+}
+
+// env returns the variables of cm as a log group with sensitive values
+// redacted.
+func (c *logConfig) env(cm *cmdImpl, env map[string]string) slog.Attr {
+	attrs := make([]any, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		value := cm.redact(env[name])
+		for _, pattern := range c.redactEnv {
+			if ok, _ := path.Match(pattern, strings.ToUpper(name)); ok {
+				value = redacted
+				break
+			}
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group("env", attrs...)
+}
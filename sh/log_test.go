@@ -0,0 +1,87 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Invalid log record: %v", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := sh.New("sh").OptV("-c", "exit 2").OptV("--password", "hunter2").Arg("token=abc").Build(ctx).
+		WithEnv("API_TOKEN", "secret").
+		WithEnv("REGION", "eu").
+		WithLogger(logger, sh.RedactFlags("--password"), sh.RedactArgs(regexp.MustCompile(`^token=`))).
+		Run()
+	if err == nil {
+		t.Fatal("Expected the command to fail")
+	}
+
+	records := decodeLogs(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("Expected start and exit records, got %v", records)
+	}
+	start, exit := records[0], records[1]
+	if start["msg"] != "sh: start" || exit["msg"] != "sh: exit" || exit["level"] != "WARN" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+
+	argv, _ := json.Marshal(start["argv"])
+	if string(argv) != `["sh","-c","exit 2","--password","[REDACTED]","[REDACTED]"]` {
+		t.Errorf("Expected redacted argv, got %s", argv)
+	}
+	env, _ := json.Marshal(start["env"])
+	if string(env) != `{"API_TOKEN":"[REDACTED]","REGION":"eu"}` {
+		t.Errorf("Expected redacted env, got %s", env)
+	}
+	if exit["exit_code"] != float64(2) || exit["duration"] == nil || exit["cwd"] == "" {
+		t.Errorf("Expected exit code, duration and cwd, got %v", exit)
+	}
+}
+
+func TestSetLoggerCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	sh.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer sh.SetLogger(nil)
+
+	cmd := sh.New("sleep").Arg("5").Build(ctx)
+	cmd.Start()
+	time.Sleep(50 * time.Millisecond)
+	cmd.Cancel()
+	cmd.Wait()
+
+	sh.New("true").Build(ctx).WithLogger(nil).Run()
+
+	records := decodeLogs(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "sh: cancel" {
+		t.Errorf("Expected a single cancel record, got %v", records)
+	}
+}
@@ -0,0 +1,178 @@
+package sh
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// LogChunk is a piece of the output of a command followed with a LogStream.
+type LogChunk struct {
+	// Stream is EventStdout or EventStderr.
+	Stream EventKind
+	// Offset is the position of the chunk's first byte in the output of
+	// both streams together.
+	Offset int64
+	Data   []byte
+}
+
+// LogStream keeps the recent output of a command so that any number of
+// watchers can follow it while it runs, each from an offset of its own:
+// a watcher that lost its connection resumes where it left off, and a new
+// one can start at the beginning or at the end. It is the building block
+// for serving live logs, e.g. over HTTP to a web UI.
+//
+//	logs := sh.NewLogStream(1 << 20)
+//	cmd := sh.New("make").Build(ctx).WithLogStream(logs)
+//	cmd.Start()
+//	for chunk := range logs.Watch(ctx, 0) {
+//		os.Stdout.Write(chunk.Data)
+//	}
+type LogStream struct {
+	mu      sync.Mutex
+	chunks  []LogChunk
+	start   int64 // offset of the first byte kept
+	end     int64 // offset after the last byte written
+	limit   int
+	closed  bool
+	changed chan struct{} // closed and replaced on every write
+}
+
+// NewLogStream returns a LogStream keeping the last limit bytes of output,
+// or all of it for a non-positive limit.
+func NewLogStream(limit int) *LogStream {
+	return &LogStream{limit: limit, changed: make(chan struct{})}
+}
+
+func (cm *cmdImpl) WithLogStream(ls *LogStream) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.stdout = appendWriter(cm.stdout, logStreamWriter{ls, EventStdout})
+	cm.stderr = appendWriter(cm.stderr, logStreamWriter{ls, EventStderr})
+	cm.finally = append(cm.finally, ls.Close)
+	return cm
+}
+
+type logStreamWriter struct {
+	ls     *LogStream
+	stream EventKind
+}
+
+func (w logStreamWriter) Write(p []byte) (int, error) {
+	w.ls.write(w.stream, p)
+	return len(p), nil
+}
+
+func (ls *LogStream) write(stream EventKind, p []byte) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed || len(p) == 0 {
+		return
+	}
+	ls.chunks = append(ls.chunks, LogChunk{Stream: stream, Offset: ls.end, Data: append([]byte(nil), p...)})
+	ls.end += int64(len(p))
+	if ls.limit > 0 {
+		for excess := ls.end - ls.start - int64(ls.limit); excess > 0; excess = ls.end - ls.start - int64(ls.limit) {
+			first := &ls.chunks[0]
+			if n := int64(len(first.Data)); excess >= n {
+				ls.chunks = ls.chunks[1:]
+				ls.start += n
+				continue
+			}
+			first.Data = first.Data[excess:]
+			first.Offset += excess
+			ls.start += excess
+		}
+	}
+	close(ls.changed)
+	ls.changed = make(chan struct{})
+}
+
+// Close ends the stream, letting watchers finish once they have read
+// everything. Streams passed to WithLogStream are closed when the command
+// has finished.
+func (ls *LogStream) Close() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if !ls.closed {
+		ls.closed = true
+		close(ls.changed)
+	}
+}
+
+// Offset returns the offset after the last byte written, where a watcher
+// only interested in new output starts.
+func (ls *LogStream) Offset() int64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.end
+}
+
+// Read returns the chunks kept from offset on, the first one cut to start
+// at offset, and whether the stream is closed. Output before offset that
+// is no longer kept is skipped, which the Offset of the first chunk shows.
+func (ls *LogStream) Read(offset int64) (chunks []LogChunk, closed bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, c := range ls.chunks {
+		end := c.Offset + int64(len(c.Data))
+		if end <= offset {
+			continue
+		}
+		if c.Offset < offset {
+			c = LogChunk{Stream: c.Stream, Offset: offset, Data: c.Data[offset-c.Offset:]}
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, ls.closed
+}
+
+// Watch returns a channel delivering the output from offset on as it is
+// written. The channel is closed once the stream is closed and everything
+// was delivered, or when ctx is done.
+func (ls *LogStream) Watch(ctx context.Context, offset int64) <-chan LogChunk {
+	ch := make(chan LogChunk)
+	goSafe(func() {
+		defer close(ch)
+		for {
+			ls.mu.Lock()
+			changed := ls.changed
+			ls.mu.Unlock()
+
+			chunks, closed := ls.Read(offset)
+			for _, c := range chunks {
+				select {
+				case ch <- c:
+				case <-ctx.Done():
+					return
+				}
+				offset = c.Offset + int64(len(c.Data))
+			}
+			if closed {
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return ch
+}
+
+// Reader returns a reader of the output of both streams from offset on,
+// blocking for more until the stream is closed, for serving the output as
+// a plain stream. Cancel ctx when done with a reader not read to the end.
+func (ls *LogStream) Reader(ctx context.Context, offset int64) io.Reader {
+	pr, pw := io.Pipe()
+	goSafe(func() {
+		for c := range ls.Watch(ctx, offset) {
+			if _, err := pw.Write(c.Data); err != nil {
+				return
+			}
+		}
+		pw.CloseWithError(ctx.Err())
+	})
+	return pr
+}
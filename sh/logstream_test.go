@@ -0,0 +1,89 @@
+package sh_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestLogStreamWatchers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	logs := sh.NewLogStream(0)
+	cmd := sh.New("sh").OptV("-c", "echo one; sleep 0.1; echo two >&2; sleep 0.1; echo three").Build(ctx).
+		WithLogStream(logs)
+	cmd.Start()
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 3)
+	for i := range outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sb strings.Builder
+			for chunk := range logs.Watch(ctx, 0) {
+				sb.Write(chunk.Data)
+			}
+			outputs[i] = sb.String()
+		}()
+	}
+	wg.Wait()
+	if _, err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	for _, out := range outputs {
+		if out != "one\ntwo\nthree\n" {
+			t.Errorf("Expected every watcher to see all output, got %q", out)
+		}
+	}
+
+	// A watcher resuming at an offset gets the rest, with its stream
+	chunks, closed := logs.Read(6)
+	if !closed || len(chunks) != 2 || string(chunks[0].Data) != "o\n" || chunks[0].Stream != sh.EventStderr || chunks[0].Offset != 6 {
+		t.Errorf("Unexpected chunks from offset 6: %+v (closed %v)", chunks, closed)
+	}
+	data, err := io.ReadAll(logs.Reader(ctx, 8))
+	if err != nil || string(data) != "three\n" {
+		t.Errorf("Expected the reader to return the rest, got %q, %v", data, err)
+	}
+}
+
+func TestLogStreamLimit(t *testing.T) {
+	logs := sh.NewLogStream(10)
+	_, err := sh.New("printf").Arg(`aaaa\nbbbb\ncccc\n`).Build(context.Background()).
+		WithLogStream(logs).
+		Run()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	chunks, _ := logs.Read(0)
+	if len(chunks) != 1 || chunks[0].Offset != 5 || string(chunks[0].Data) != "bbbb\ncccc\n" {
+		t.Errorf("Expected only the last 10 bytes to be kept, got %+v", chunks)
+	}
+	if logs.Offset() != 15 {
+		t.Errorf("Expected offset 15, got %d", logs.Offset())
+	}
+}
+
+func TestLogStreamClosedWithoutRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, cmd := range []sh.Cmd{
+		sh.New("echo").Build(ctx).WithDryRun(),
+		sh.New("echo").Build(ctx).WithApproval(),
+		sh.New("").Build(ctx),
+	} {
+		logs := sh.NewLogStream(0)
+		cmd.WithLogStream(logs).Run()
+		if _, closed := logs.Read(0); !closed {
+			t.Errorf("Expected the stream of %q to be closed", cmd)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package sh
+
+import "maps"
+
+func (cm *cmdImpl) WithMeta(key, value string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.meta == nil {
+		cm.meta = make(map[string]string)
+	}
+	cm.meta[key] = value
+	return cm
+}
+
+func (cm *cmdImpl) Meta() map[string]string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return maps.Clone(cm.meta)
+}
+
+// attachMeta copies the command's metadata onto its result.
+func (cm *cmdImpl) attachMeta() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if r, ok := cm.result.(*resultImpl); ok && len(cm.meta) > 0 {
+		r.meta = maps.Clone(cm.meta)
+	}
+}
@@ -0,0 +1,47 @@
+package sh_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithMeta(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+
+	cmd := sh.New("true").Build(ctx).
+		WithMeta("team", "infra").
+		WithMeta("step", "deploy").
+		WithStateStore(store, "deploy")
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if meta := result.Meta(); meta["team"] != "infra" || meta["step"] != "deploy" {
+		t.Errorf("Expected metadata on the result, got %v", meta)
+	}
+
+	// The returned map is a copy
+	cmd.Meta()["team"] = "other"
+	if cmd.Meta()["team"] != "infra" {
+		t.Error("Expected Meta to return a copy")
+	}
+
+	last, ok, err := store.Last("deploy")
+	if err != nil || !ok {
+		t.Fatalf("Expected a state record: %v", err)
+	}
+	if last.Meta["team"] != "infra" {
+		t.Errorf("Expected metadata on the state record, got %v", last.Meta)
+	}
+}
@@ -0,0 +1,77 @@
+package sh
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"sync"
+)
+
+// Execution is a single run of a command as seen by middleware.
+type Execution struct {
+	// Cmd is the command being run.
+	Cmd Cmd
+	// Exec is the underlying os/exec command. Middleware may adjust it,
+	// e.g. its environment, before calling the next RunFunc; after next
+	// returns, Exec.Process and Exec.ProcessState describe the process.
+	Exec *exec.Cmd
+}
+
+// RunFunc starts an execution and waits for it to finish.
+type RunFunc func(ctx context.Context, e *Execution) error
+
+// Middleware wraps a RunFunc to add cross-cutting behavior such as logging,
+// metrics or auditing around every command execution. It must call next to
+// run the command, unless it decides to fail the execution without running
+// it.
+type Middleware func(next RunFunc) RunFunc
+
+var (
+	middlewareMu sync.RWMutex
+	middleware   []*Middleware
+)
+
+// Use registers middleware wrapping every command run by this package.
+// Middleware registered first is outermost. The returned function removes
+// the middleware again.
+func Use(mw ...Middleware) (remove func()) {
+	ptrs := make([]*Middleware, len(mw))
+	for i := range mw {
+		ptrs[i] = &mw[i]
+	}
+
+	middlewareMu.Lock()
+	middleware = append(middleware, ptrs...)
+	middlewareMu.Unlock()
+
+	return func() {
+		middlewareMu.Lock()
+		defer middlewareMu.Unlock()
+		middleware = slices.DeleteFunc(middleware, func(m *Middleware) bool {
+			return slices.Contains(ptrs, m)
+		})
+	}
+}
+
+func (cm *cmdImpl) WithMiddleware(mw ...Middleware) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.middleware = append(cm.middleware, mw...)
+	return cm
+}
+
+// wrapRun wraps run in the global, the runner's and the command's
+// middleware.
+func (cm *cmdImpl) wrapRun(run RunFunc) RunFunc {
+	for i := len(cm.middleware) - 1; i >= 0; i-- {
+		run = cm.middleware[i](run)
+	}
+	run = cm.runner.wrap(run)
+
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		run = (*middleware[i])(run)
+	}
+	return run
+}
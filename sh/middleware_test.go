@@ -0,0 +1,83 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var log []string
+	logging := func(name string) sh.Middleware {
+		return func(next sh.RunFunc) sh.RunFunc {
+			return func(ctx context.Context, e *sh.Execution) error {
+				log = append(log, name+" before "+e.Cmd.String())
+				err := next(ctx, e)
+				log = append(log, name+" after "+e.Exec.ProcessState.String())
+				return err
+			}
+		}
+	}
+
+	remove := sh.Use(logging("outer"), logging("inner"))
+
+	// Middleware may adjust the process before it starts
+	env := func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			e.Exec.Env = append(e.Exec.Environ(), "INJECTED=yes")
+			return next(ctx, e)
+		}
+	}
+
+	result, err := sh.New("sh").OptV("-c", "echo $INJECTED").Build(ctx).WithMiddleware(env).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if string(result.Stdout()) != "yes\n" {
+		t.Errorf("Expected the injected variable, got %q", result.Stdout())
+	}
+
+	want := []string{
+		"outer before sh -c 'echo $INJECTED'",
+		"inner before sh -c 'echo $INJECTED'",
+		"inner after exit status 0",
+		"outer after exit status 0",
+	}
+	if strings.Join(log, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected middleware order:\n%s", strings.Join(log, "\n"))
+	}
+
+	remove()
+	log = nil
+	sh.New("true").Build(ctx).Run()
+	if len(log) != 0 {
+		t.Errorf("Expected removed middleware not to run, got %v", log)
+	}
+}
+
+func TestMiddlewareCanDeny(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errDenied := errors.New("denied by policy")
+	deny := func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			if e.Exec.Args[0] == "rm" {
+				return errDenied
+			}
+			return next(ctx, e)
+		}
+	}
+
+	_, err := sh.New("rm").Arg("-rf").Arg("/nonexistent").Build(ctx).WithMiddleware(deny).Run()
+	if !errors.Is(err, errDenied) {
+		t.Errorf("Expected the middleware error, got %v", err)
+	}
+}
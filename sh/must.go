@@ -0,0 +1,73 @@
+package sh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrUnsuccessful is returned for commands that exited successfully but
+// failed a check added with SuccessWhen.
+var ErrUnsuccessful = errors.New("sh: command did not succeed")
+
+func (cm *cmdImpl) MustRun() Result {
+	result, err := cm.Run()
+	if err != nil {
+		panic(describeFailure(cm, result, err))
+	}
+	return result
+}
+
+// describeFailure returns err naming the command and followed by its
+// stderr, if it wrote any, for errors meant to be read by people.
+func describeFailure(cmd Cmd, result Result, err error) error {
+	if result != nil {
+		if stderr := bytes.TrimSpace(result.Stderr()); len(stderr) > 0 {
+			return fmt.Errorf("sh: %s: %w\n%s", cmd, err, stderr)
+		}
+	}
+	return fmt.Errorf("sh: %s: %w", cmd, err)
+}
+
+func (cm *cmdImpl) RunOk() bool {
+	_, err := cm.Run()
+	return err == nil
+}
+
+func (cm *cmdImpl) ExpectExit(codes ...int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.okCodes = append(cm.okCodes, codes...)
+	return cm
+}
+
+func (cm *cmdImpl) SuccessWhen(check func(Result) error) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.success = append(cm.success, check)
+	return cm
+}
+
+// checkSuccess runs the SuccessWhen checks on the result of a successful
+// run, recording the first failure in the result.
+func (cm *cmdImpl) checkSuccess(result *resultImpl) error {
+	cm.mu.RLock()
+	checks := cm.success
+	cm.mu.RUnlock()
+	for _, check := range checks {
+		if err := check(result); err != nil {
+			result.failure = fmt.Errorf("%w: %s: %w", ErrUnsuccessful, cm.cmd, err)
+			return result.failure
+		}
+	}
+	return nil
+}
+
+// expectedExit reports whether err only reports an exit code accepted with
+// ExpectExit.
+func (cm *cmdImpl) expectedExit(exitCode int, err error) bool {
+	var exitErr *ExitError
+	return err != nil && errors.As(err, &exitErr) && exitErr.Signal == nil &&
+		slices.Contains(cm.okCodes, exitCode)
+}
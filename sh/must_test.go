@@ -0,0 +1,106 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdMustRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := sh.New("echo").Arg("hello").Build(ctx).MustRun()
+	if result.TrimmedString() != "hello" {
+		t.Errorf("Expected hello, got %q", result.TrimmedString())
+	}
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok {
+			t.Fatal("Expected MustRun to panic with an error")
+		}
+		var exitErr *sh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+			t.Errorf("Expected the exit error to be wrapped, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "disk full") {
+			t.Errorf("Expected stderr in the panic, got %q", err)
+		}
+	}()
+	sh.New("sh").OptV("-c", "echo disk full >&2; exit 3").Build(ctx).MustRun()
+}
+
+func TestCmdRunOk(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !sh.New("true").Build(ctx).RunOk() {
+		t.Error("Expected true to succeed")
+	}
+	if sh.New("false").Build(ctx).RunOk() {
+		t.Error("Expected false to fail")
+	}
+}
+
+func TestCmdExpectExit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").OptV("-c", "exit 1").Build(ctx).ExpectExit(1, 2).Run()
+	if err != nil {
+		t.Fatalf("Expected exit code 1 to count as success, got %v", err)
+	}
+	if result.ExitCode() != 1 || result.Check() != nil {
+		t.Errorf("Expected exit code 1 and a passing Check, got %d, %v", result.ExitCode(), result.Check())
+	}
+
+	result, err = sh.New("sh").OptV("-c", "exit 3").Build(ctx).ExpectExit(1, 2).Run()
+	if err == nil || result.Check() == nil {
+		t.Error("Expected unlisted exit codes to fail")
+	}
+
+	// Commands killed by a signal still fail
+	_, err = sh.New("sh").OptV("-c", "kill -KILL $$").Build(ctx).ExpectExit(-1).Run()
+	if err == nil {
+		t.Error("Expected a killed command to fail")
+	}
+}
+
+func TestCmdSuccessWhen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	noErrors := func(r sh.Result) error {
+		if _, msg, ok := strings.Cut(string(r.Stdout()), "ERROR: "); ok {
+			return errors.New(strings.TrimSpace(msg))
+		}
+		return nil
+	}
+
+	result, err := sh.New("echo").Arg("ERROR: license expired").Build(ctx).SuccessWhen(noErrors).Run()
+	if !errors.Is(err, sh.ErrUnsuccessful) {
+		t.Fatalf("Expected ErrUnsuccessful, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "license expired") {
+		t.Errorf("Expected the error of the check, got %v", err)
+	}
+	if result.ExitCode() != 0 || !errors.Is(result.Check(), sh.ErrUnsuccessful) {
+		t.Errorf("Expected exit code 0 and a failing Check, got %d, %v", result.ExitCode(), result.Check())
+	}
+
+	if _, err := sh.New("echo").Arg("all good").Build(ctx).SuccessWhen(noErrors).Run(); err != nil {
+		t.Errorf("Expected output without errors to pass, got %v", err)
+	}
+
+	// Failed commands keep their exit error
+	var exitErr *sh.ExitError
+	_, err = sh.New("sh").OptV("-c", "exit 2").Build(ctx).SuccessWhen(noErrors).Run()
+	if !errors.As(err, &exitErr) || errors.Is(err, sh.ErrUnsuccessful) {
+		t.Errorf("Expected the exit error, got %v", err)
+	}
+}
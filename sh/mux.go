@@ -0,0 +1,224 @@
+package sh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mux routes the lines of one input, typically the program's own stdin, to
+// the stdin of one of several running commands at a time, for process
+// dashboards where the user talks to one service and switches to another,
+// like a small tmux:
+//
+//	mux := sh.NewMux(os.Stdin)
+//	compose := sh.NewCompose().
+//		Service("db", func(ctx context.Context) sh.Cmd {
+//			return sh.New("psql").Build(ctx).WithStdin(mux.Input("db"))
+//		}).
+//		Service("app", func(ctx context.Context) sh.Cmd {
+//			return sh.New("./app").Build(ctx).WithStdin(mux.Input("app"))
+//		}, "db")
+//	go mux.Run(ctx)
+//
+// A line starting with the escape sequence, Ctrl-A by default, switches the
+// target to the command named by the rest of the line instead of being
+// routed, e.g. "\x01app".
+type Mux struct {
+	in       io.Reader
+	escape   string
+	onSwitch func(target string, err error)
+
+	mu      sync.Mutex
+	inputs  map[string]*muxInput
+	names   []string // in the order inputs were first attached
+	target  string
+	stopped bool
+}
+
+// NewMux returns a Mux reading lines from in.
+func NewMux(in io.Reader) *Mux {
+	return &Mux{in: in, escape: "\x01", inputs: make(map[string]*muxInput)}
+}
+
+// muxBuffer is how many lines a command may fall behind before the lines
+// routed to it are dropped.
+const muxBuffer = 64
+
+// muxInput feeds the lines routed to a command into its stdin.
+type muxInput struct {
+	pw    *io.PipeWriter
+	lines chan string
+}
+
+func newMuxInput(pw *io.PipeWriter) *muxInput {
+	in := &muxInput{pw: pw, lines: make(chan string, muxBuffer)}
+	goSafe(func() {
+		defer pw.Close()
+		for line := range in.lines {
+			if _, err := pw.Write([]byte(line)); err != nil {
+				break
+			}
+		}
+		for range in.lines {
+		}
+	})
+	return in
+}
+
+// close ends the command's input once the lines routed to it so far have
+// been read. The caller must hold the Mux's lock.
+func (in *muxInput) close() {
+	close(in.lines)
+}
+
+// discard ends the command's input right away, for a command replaced by
+// a new one that may no longer read. The caller must hold the Mux's lock.
+func (in *muxInput) discard() {
+	close(in.lines)
+	in.pw.Close()
+}
+
+// Escape sets the sequence starting lines that switch targets.
+func (m *Mux) Escape(seq string) *Mux {
+	m.escape = seq
+	return m
+}
+
+// OnSwitch calls f whenever a line of input asks to switch targets, with
+// the new target or the error that kept the Mux from switching, e.g. to
+// show the active target in a status line.
+func (m *Mux) OnSwitch(f func(target string, err error)) *Mux {
+	m.onSwitch = f
+	return m
+}
+
+// Input returns the stdin for the command called name. The first command
+// attached becomes the target. Calling Input again for the same name, for
+// a restarted command, ends the previous input.
+func (m *Mux) Input(name string) io.Reader {
+	pr, pw := io.Pipe()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		pw.Close()
+		return pr
+	}
+	if old, ok := m.inputs[name]; ok {
+		old.discard()
+	} else {
+		m.names = append(m.names, name)
+	}
+	m.inputs[name] = newMuxInput(pw)
+	if m.target == "" {
+		m.target = name
+	}
+	return pr
+}
+
+// Targets returns the names of the commands attached, in the order they
+// were first attached.
+func (m *Mux) Targets() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.names)
+}
+
+// Target returns the name of the command input is routed to.
+func (m *Mux) Target() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.target
+}
+
+// Switch routes input to the command called name from now on.
+func (m *Mux) Switch(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.inputs[name]; !ok {
+		return fmt.Errorf("sh: mux: no command %q attached", name)
+	}
+	m.target = name
+	return nil
+}
+
+// Run routes the lines of input until it ends or ctx is done, then ends the
+// input of every command. Lines for a command that has fallen behind by
+// more than 64 lines, e.g. because it stopped reading, are dropped rather
+// than holding up the others.
+func (m *Mux) Run(ctx context.Context) error {
+	defer m.stop()
+
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	goSafe(func() {
+		r := bufio.NewReader(safeReader{m.in})
+		for {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+		}
+	})
+
+	for {
+		select {
+		case line := <-lines:
+			m.route(line)
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// route handles a line of input.
+func (m *Mux) route(line string) {
+	if name, ok := strings.CutPrefix(line, m.escape); ok && m.escape != "" {
+		name = strings.TrimSpace(name)
+		err := m.Switch(name)
+		if m.onSwitch != nil {
+			m.onSwitch(name, err)
+		}
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if in := m.inputs[m.target]; in != nil && !m.stopped {
+		select {
+		case in.lines <- line:
+		default:
+		}
+	}
+}
+
+// stop ends the input of every command.
+func (m *Mux) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.stopped = true
+	for _, in := range m.inputs {
+		in.close()
+	}
+}
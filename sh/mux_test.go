@@ -0,0 +1,95 @@
+package sh_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestMux(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	var mu sync.Mutex
+	var switches []string
+	mux := sh.NewMux(pr).OnSwitch(func(target string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			switches = append(switches, "error")
+			return
+		}
+		switches = append(switches, target)
+	})
+
+	a := sh.New("cat").Build(ctx).WithStdin(mux.Input("a"))
+	b := sh.New("cat").Build(ctx).WithStdin(mux.Input("b"))
+	a.Start()
+	b.Start()
+	if mux.Target() != "a" {
+		t.Errorf("Expected the first command attached to be the target, got %q", mux.Target())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- mux.Run(ctx) }()
+	io.WriteString(pw, "to a\n\x01b\nto b\n\x01nope\nalso b\n\x01a\nagain a\n")
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	resultA, err := a.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultB, err := b.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resultA.Stdout()); got != "to a\nagain a\n" {
+		t.Errorf("Unexpected input of a: %q", got)
+	}
+	if got := string(resultB.Stdout()); got != "to b\nalso b\n" {
+		t.Errorf("Unexpected input of b: %q", got)
+	}
+	if got := strings.Join(switches, ","); got != "b,error,a" {
+		t.Errorf("Unexpected switches: %s", got)
+	}
+	if got := strings.Join(mux.Targets(), ","); got != "a,b" {
+		t.Errorf("Unexpected targets: %s", got)
+	}
+}
+
+func TestMuxRestartedCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	mux := sh.NewMux(pr).Escape("::")
+	first := sh.New("cat").Build(ctx).WithStdin(mux.Input("svc"))
+	first.Start()
+	go mux.Run(ctx)
+
+	// A new input for the same name ends the old one, as for a restart
+	second := sh.New("cat").Build(ctx).WithStdin(mux.Input("svc"))
+	second.Start()
+	if result, err := first.Wait(); err != nil || len(result.Stdout()) != 0 {
+		t.Fatalf("Expected the first command to see the end of its input, got %v", err)
+	}
+
+	io.WriteString(pw, "hello\n")
+	pw.Close()
+	result, err := second.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout()) != "hello\n" {
+		t.Errorf("Expected the restarted command to get the input, got %q", result.Stdout())
+	}
+}
@@ -0,0 +1,24 @@
+package sh
+
+import (
+	"net/netip"
+	"time"
+)
+
+// Connection is a remote endpoint a command or one of its descendants
+// talked to, as recorded by WithNetworkLog.
+type Connection struct {
+	// Network is "tcp" or "udp".
+	Network string
+	// Remote is the remote address. Host names are not known, only the
+	// addresses they resolved to.
+	Remote netip.AddrPort
+	// Pid is the process the connection was first seen in.
+	Pid int
+	// Seen is when the connection was first seen.
+	Seen time.Time
+}
+
+func (r *resultImpl) Connections() []Connection {
+	return r.connections
+}
@@ -0,0 +1,221 @@
+package sh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// netPollInterval is how often WithNetworkLog looks at the sockets of the
+// command's processes.
+const netPollInterval = 20 * time.Millisecond
+
+func (cm *cmdImpl) WithNetworkLog() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var mon *netMonitor
+	cm.hooks = append(cm.hooks, execHook{
+		started: func(cmd *exec.Cmd) error {
+			mon = startNetMonitor(cmd.Process.Pid)
+			return nil
+		},
+		after: func(error) error {
+			if mon == nil {
+				return nil
+			}
+			conns := mon.stop()
+			cm.mu.Lock()
+			cm.connections = conns
+			cm.mu.Unlock()
+			return nil
+		},
+	})
+	return cm
+}
+
+// netMonitor polls the sockets of a process tree for remote endpoints.
+type netMonitor struct {
+	root int
+	quit chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	conns []Connection
+	seen  map[connKey]bool
+}
+
+type connKey struct {
+	network, remote string
+}
+
+func startNetMonitor(root int) *netMonitor {
+	m := &netMonitor{
+		root: root,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+		seen: make(map[connKey]bool),
+	}
+	goSafe(m.run)
+	return m
+}
+
+func (m *netMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(netPollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.poll()
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// stop ends polling and returns the connections seen, in order.
+func (m *netMonitor) stop() []Connection {
+	close(m.quit)
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conns
+}
+
+// poll records the connected sockets held by the process tree.
+func (m *netMonitor) poll() {
+	// Socket inodes of the tree, and a process per network namespace to
+	// read the socket tables of
+	owners := make(map[string]int)
+	namespaces := make(map[string]int)
+	for _, pid := range processTree(m.root) {
+		dir := filepath.Join("/proc", strconv.Itoa(pid))
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if inode, ok := strings.CutPrefix(target, "socket:["); err == nil && ok {
+				owners[strings.TrimSuffix(inode, "]")] = pid
+			}
+		}
+		if ns, err := os.Readlink(filepath.Join(dir, "ns", "net")); err == nil {
+			namespaces[ns] = pid
+		}
+	}
+	if len(owners) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, pid := range namespaces {
+		for _, table := range []string{"tcp", "tcp6", "udp", "udp6"} {
+			network := strings.TrimSuffix(table, "6")
+			readSocketTable(filepath.Join("/proc", strconv.Itoa(pid), "net", table), func(remote, inode string) {
+				owner, ok := owners[inode]
+				if !ok {
+					return
+				}
+				m.record(Connection{Network: network, Pid: owner, Seen: now}, remote)
+			})
+		}
+	}
+}
+
+func (m *netMonitor) record(c Connection, remote string) {
+	addr, ok := parseSocketAddr(remote)
+	if !ok {
+		return
+	}
+	c.Remote = addr
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := connKey{c.Network, addr.String()}
+	if !m.seen[key] {
+		m.seen[key] = true
+		m.conns = append(m.conns, c)
+	}
+}
+
+// readSocketTable calls fn with the remote address and inode of every
+// socket listed in a /proc/net/{tcp,udp}[6] table.
+func readSocketTable(path string, fn func(remote, inode string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 9 {
+			fn(fields[2], fields[9])
+		}
+	}
+}
+
+// processTree returns root and all its descendants.
+func processTree(root int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []int{root}
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if _, fields, ok := readProcStat(pid); ok && len(fields) > 1 {
+			ppid, _ := strconv.Atoi(fields[1])
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+
+	tree := []int{root}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
+
+// parseSocketAddr parses an address such as "0100007F:1F90" from a socket
+// table, where the address is printed as 32-bit words in host byte order.
+// It reports false for unconnected sockets.
+func parseSocketAddr(s string) (netip.AddrPort, bool) {
+	host, portHex, ok := strings.Cut(s, ":")
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if !ok || err != nil || port == 0 || (len(host) != 8 && len(host) != 32) {
+		return netip.AddrPort{}, false
+	}
+
+	b := make([]byte, len(host)/2)
+	for i := 0; i < len(b); i += 4 {
+		word, err := strconv.ParseUint(host[2*i:2*i+8], 16, 32)
+		if err != nil {
+			return netip.AddrPort{}, false
+		}
+		binary.NativeEndian.PutUint32(b[i:], uint32(word))
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	addr = addr.Unmap()
+	if addr.IsUnspecified() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), true
+}
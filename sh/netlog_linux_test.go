@@ -0,0 +1,64 @@
+package sh_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithNetworkLog(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := netip.MustParseAddrPort(ln.Addr().String())
+
+	script := "exec 3<>/dev/tcp/127.0.0.1/" + strconv.Itoa(int(addr.Port())) + "; sleep 0.3"
+	result, err := sh.New("bash").OptV("-c", script).Build(ctx).WithNetworkLog().Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v: %s", err, result.Stderr())
+	}
+
+	conns := result.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %+v", conns)
+	}
+	if c := conns[0]; c.Network != "tcp" || c.Remote != addr || c.Pid == 0 || c.Seen.IsZero() {
+		t.Errorf("Expected tcp connection to %s, got %+v", addr, c)
+	}
+}
+
+func TestCmdWithoutNetworkLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("true").Build(ctx).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conns := result.Connections(); conns != nil {
+		t.Errorf("Expected no connections, got %+v", conns)
+	}
+}
@@ -0,0 +1,169 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Escalation is a step in stopping the commands of a NotifyContext after
+// the first signal.
+type Escalation struct {
+	// After is how long after the first signal the step is taken.
+	After time.Duration
+	// Signal is sent to the commands still running, or to their process
+	// groups with WithProcessGroup. os.Kill kills them.
+	Signal os.Signal
+}
+
+// DefaultEscalation asks commands to stop with SIGTERM (a CTRL_BREAK event
+// on Windows) right away and kills them after ten seconds.
+var DefaultEscalation = []Escalation{
+	{After: 0, Signal: defaultStopSignal},
+	{After: 10 * time.Second, Signal: os.Kill},
+}
+
+// ErrSignalled is the cause of a NotifyContext cancelled by a signal.
+var ErrSignalled = errors.New("sh: signal received")
+
+// NotifyContext is like signal.NotifyContext, standardizing the behaviour
+// of ^C for programs running commands: the first of the signals (os.Interrupt
+// if none are given) cancels the returned context and stops the running
+// commands built with it gracefully, following DefaultEscalation; a second
+// signal kills them at once. Calling stop releases the signals and cancels
+// the context, which kills the commands as cancelling any context does.
+func NotifyContext(parent context.Context, signals ...os.Signal) (ctx context.Context, stop context.CancelFunc) {
+	return NotifyContextSchedule(parent, DefaultEscalation, signals...)
+}
+
+// NotifyContextSchedule is NotifyContext with its own escalation schedule.
+func NotifyContextSchedule(parent context.Context, schedule []Escalation, signals ...os.Signal) (ctx context.Context, stop context.CancelFunc) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt}
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	sc := &signalContext{
+		schedule: schedule,
+		cmds:     make(map[*cmdImpl]bool),
+		ch:       make(chan os.Signal, 1),
+		done:     make(chan struct{}),
+	}
+	ctx = context.WithValue(ctx, signalContextKey{}, sc)
+	sc.ctx = ctx
+
+	signal.Notify(sc.ch, signals...)
+	goSafe(func() { sc.watch(cancel) })
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sc.ch)
+			close(sc.done)
+			sc.stopTimers()
+			cancel(context.Canceled)
+		})
+	}
+}
+
+type signalContextKey struct{}
+
+// signalContext tracks the running commands of a NotifyContext.
+type signalContext struct {
+	ctx      context.Context
+	schedule []Escalation
+	ch       chan os.Signal
+	done     chan struct{}
+
+	mu     sync.Mutex
+	cmds   map[*cmdImpl]bool
+	timers []*time.Timer
+}
+
+func (sc *signalContext) watch(cancel context.CancelCauseFunc) {
+	select {
+	case <-sc.ch:
+	case <-sc.done:
+		return
+	}
+
+	sc.mu.Lock()
+	// Cancel under the lock so that commands starting now see the context
+	// as done rather than slipping past the schedule
+	cancel(ErrSignalled)
+	for _, step := range sc.schedule {
+		sc.timers = append(sc.timers, time.AfterFunc(step.After, func() {
+			sc.signalAll(step.Signal)
+		}))
+	}
+	sc.mu.Unlock()
+
+	select {
+	case <-sc.ch:
+		sc.stopTimers()
+		sc.signalAll(os.Kill)
+	case <-sc.done:
+	}
+}
+
+// signalled reports whether the context was cancelled by a signal.
+func (sc *signalContext) signalled() bool {
+	return errors.Is(context.Cause(sc.ctx), ErrSignalled)
+}
+
+func (sc *signalContext) signalAll(sig os.Signal) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for cm := range sc.cmds {
+		if err := cm.Signal(sig); err != nil && sig != os.Kill {
+			// Signals the platform cannot deliver end in killing
+			cm.Signal(os.Kill)
+		}
+	}
+}
+
+func (sc *signalContext) stopTimers() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, t := range sc.timers {
+		t.Stop()
+	}
+}
+
+// attach makes the context stop cmd by the schedule rather than killing it
+// when cancelled by a signal, and tracks it until the returned function is
+// called.
+func (sc *signalContext) attach(cm *cmdImpl, cmd *exec.Cmd) (detach func()) {
+	prev := cmd.Cancel
+	cmd.Cancel = func() error {
+		if sc.signalled() {
+			return nil
+		}
+		if prev != nil {
+			return prev()
+		}
+		return cmd.Process.Kill()
+	}
+	// Give the schedule time to run before os/exec gives up on the command
+	for _, step := range sc.schedule {
+		cmd.WaitDelay = max(cmd.WaitDelay, step.After+time.Second)
+	}
+
+	sc.mu.Lock()
+	sc.cmds[cm] = true
+	sc.mu.Unlock()
+	return func() {
+		sc.mu.Lock()
+		delete(sc.cmds, cm)
+		sc.mu.Unlock()
+	}
+}
+
+// signalContextFrom returns the NotifyContext ctx derives from, if any.
+func signalContextFrom(ctx context.Context) *signalContext {
+	sc, _ := ctx.Value(signalContextKey{}).(*signalContext)
+	return sc
+}
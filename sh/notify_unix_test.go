@@ -0,0 +1,101 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// loopScript prints ready and then loops until stopped, running trap on
+// SIGTERM.
+func loopScript(trap string) string {
+	return `trap '` + trap + `' TERM; echo ready; while :; do sleep 0.05; done`
+}
+
+// signalOn sends sig to the current process whenever the command prints
+// one of the given lines, and returns its output.
+func signalOn(t *testing.T, cmd sh.Cmd, sig syscall.Signal, on ...string) []string {
+	t.Helper()
+	var lines []string
+	for line := range cmd.Lines() {
+		lines = append(lines, line)
+		if !slices.Contains(on, line) {
+			continue
+		}
+		if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lines
+}
+
+func TestNotifyContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ctx, stop := sh.NotifyContextSchedule(parent, []sh.Escalation{{Signal: syscall.SIGTERM}}, syscall.SIGUSR1)
+	defer stop()
+
+	cmd := sh.New("sh").OptV("-c", loopScript("echo stopping; exit 3")).Build(ctx)
+	lines := signalOn(t, cmd, syscall.SIGUSR1, "ready")
+
+	result, _ := cmd.Wait()
+	if got := strings.Join(lines, ","); got != "ready,stopping" {
+		t.Errorf("Expected the command to stop gracefully, got %q", got)
+	}
+	if result.ExitCode() != 3 {
+		t.Errorf("Expected exit code 3 from the trap, got %d", result.ExitCode())
+	}
+	if !errors.Is(context.Cause(ctx), sh.ErrSignalled) {
+		t.Errorf("Expected the context to be cancelled by the signal, got %v", context.Cause(ctx))
+	}
+}
+
+func TestNotifyContextSecondSignalKills(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	schedule := []sh.Escalation{{Signal: syscall.SIGTERM}, {After: time.Minute, Signal: syscall.SIGKILL}}
+	ctx, stop := sh.NotifyContextSchedule(parent, schedule, syscall.SIGUSR1)
+	defer stop()
+
+	// The command ignores SIGTERM; the second signal kills it
+	start := time.Now()
+	cmd := sh.New("sh").OptV("-c", loopScript("echo ignored")).Build(ctx)
+	lines := signalOn(t, cmd, syscall.SIGUSR1, "ready", "ignored")
+
+	result, _ := cmd.Wait()
+	if got := strings.Join(lines, ","); got != "ready,ignored" {
+		t.Errorf("Unexpected output %q", got)
+	}
+	if result.ExitCode() == 0 || time.Since(start) > 4*time.Second {
+		t.Errorf("Expected the command to be killed at once, got exit code %d after %v", result.ExitCode(), time.Since(start))
+	}
+}
+
+func TestNotifyContextStop(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ctx, stop := sh.NotifyContext(parent, syscall.SIGUSR1)
+	cmd := sh.New("sh").OptV("-c", loopScript("echo stopping; exit 3")).Build(ctx)
+	for line := range cmd.Lines() {
+		if line == "ready" {
+			stop()
+		}
+	}
+
+	// Without a signal the command is killed as for any cancelled context
+	result, _ := cmd.Wait()
+	if result.ExitCode() == 3 || !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("Expected the command to be killed, got exit code %d, %v", result.ExitCode(), ctx.Err())
+	}
+}
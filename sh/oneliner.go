@@ -0,0 +1,140 @@
+package sh
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Output runs name with args and returns its stdout with leading and
+// trailing white space removed, for the common case of reading a single
+// value:
+//
+//	head, err := sh.Output(ctx, "git", "rev-parse", "HEAD")
+//
+// The command is built with the runner from ctx, so it gets the same
+// defaults, middleware and policy as one built with FromContext(ctx).New.
+// On failure the error includes the command's stderr.
+func Output(ctx context.Context, name string, args ...string) (string, error) {
+	result, err := runOneLiner(oneLiner(ctx, name, args))
+	if err != nil {
+		return "", err
+	}
+	return result.TrimmedString(), nil
+}
+
+// OutputLines runs name with args like Output and returns the lines of its
+// stdout.
+func OutputLines(ctx context.Context, name string, args ...string) ([]string, error) {
+	result, err := runOneLiner(oneLiner(ctx, name, args))
+	if err != nil {
+		return nil, err
+	}
+	return result.Lines(), nil
+}
+
+// Exec runs name with args like Output, passing its stdout and stderr on
+// to those of the runner from ctx, by default the process's own, as a
+// shell script would.
+func Exec(ctx context.Context, name string, args ...string) error {
+	cmd := oneLiner(ctx, name, args)
+	d := FromContext(ctx).Defaults()
+	_, err := runOneLiner(cmd.WithStdout(d.Stdout).WithStderr(d.Stderr))
+	return err
+}
+
+// Quiet runs name with args like Output, discarding its output unless it
+// fails, in which case the error includes its stderr.
+func Quiet(ctx context.Context, name string, args ...string) error {
+	_, err := runOneLiner(oneLiner(ctx, name, args))
+	return err
+}
+
+func oneLiner(ctx context.Context, name string, args []string) Cmd {
+	b := FromContext(ctx).New(name)
+	for _, arg := range args {
+		b.Arg(arg)
+	}
+	return b.Build(ctx)
+}
+
+func runOneLiner(cmd Cmd) (Result, error) {
+	result, err := cmd.Run()
+	if err != nil {
+		return result, describeFailure(cmd, result, err)
+	}
+	return result, nil
+}
+
+// Safety selects how RunString treats a command line.
+type Safety int
+
+const (
+	// SafetyStrict runs command lines the way Parse reads them, joined into
+	// a pipeline at unquoted "|", without a shell. Lines using any other
+	// shell feature, such as expansions, globs, redirections, comments,
+	// assignments or "&&", are rejected.
+	SafetyStrict Safety = iota
+	// SafetyPermissive runs command lines like SafetyStrict when possible
+	// and passes the others to sh -c, or to cmd.exe on Windows, for code
+	// moving off exec.Command("sh", "-c", line) that still relies on the
+	// shell for some of its lines.
+	SafetyPermissive
+)
+
+// RunString runs a shell-like command line such as "grep -r TODO ./src |
+// wc -l" and returns the result of its last stage:
+//
+//	result, err := sh.RunString(ctx, "grep -r TODO ./src | wc -l", sh.SafetyStrict)
+//
+// The commands are built with the runner from ctx like those of Output,
+// and on failure the error includes the stderr of the last stage.
+func RunString(ctx context.Context, line string, safety Safety) (Result, error) {
+	cmd, err := parsePipeline(ctx, line)
+	if err != nil {
+		if safety != SafetyPermissive {
+			return nil, err
+		}
+		cmd = shellCommand(ctx, line)
+	}
+	return runOneLiner(cmd)
+}
+
+// parsePipeline builds the pipeline of line, each stage parsed like Parse.
+func parsePipeline(ctx context.Context, line string) (Cmd, error) {
+	stages, err := splitPipeline(line)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd Cmd
+	for _, stage := range stages {
+		words, err := splitWords(stage)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("sh: parse %q: empty command in pipeline", line)
+		}
+		if cmd == nil {
+			cmd = oneLiner(ctx, words[0], words[1:])
+			continue
+		}
+		pb := cmd.Pipe(words[0])
+		for _, word := range words[1:] {
+			pb.Arg(word)
+		}
+		cmd = pb.Build()
+	}
+	return cmd, nil
+}
+
+// shellCommand returns a command running line with the system shell.
+func shellCommand(ctx context.Context, line string) Cmd {
+	if runtime.GOOS == "windows" {
+		b := NewCmdShell(line)
+		b.runner = FromContext(ctx)
+		return b.Build(ctx)
+	}
+	return oneLiner(ctx, "sh", []string{"-c", line})
+}
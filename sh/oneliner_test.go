@@ -0,0 +1,129 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := sh.Output(ctx, "echo", "  hello world  ")
+	if err != nil || out != "hello world" {
+		t.Errorf("Expected trimmed stdout, got %q, %v", out, err)
+	}
+
+	lines, err := sh.OutputLines(ctx, "printf", `a\nb\n`)
+	if err != nil || !slices.Equal(lines, []string{"a", "b"}) {
+		t.Errorf("Expected two lines, got %q, %v", lines, err)
+	}
+
+	_, err = sh.Output(ctx, "sh", "-c", "echo oops >&2; exit 2")
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 2 {
+		t.Errorf("Expected an exit error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected the error to include stderr, got %v", err)
+	}
+}
+
+func TestExecAndQuiet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Stdout: &stdout, Stderr: &stderr, Env: map[string]string{"NAME": "runner"}})
+	ctx = sh.WithRunner(ctx, runner)
+
+	if err := sh.Exec(ctx, "sh", "-c", `echo "hi $NAME"; echo warn >&2`); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if stdout.String() != "hi runner\n" || stderr.String() != "warn\n" {
+		t.Errorf("Expected output on the runner's streams, got %q and %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if err := sh.Quiet(ctx, "echo", "hidden"); err != nil {
+		t.Fatalf("Quiet failed: %v", err)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("Expected no output, got %q and %q", stdout.String(), stderr.String())
+	}
+	if err := sh.Quiet(ctx, "false"); err == nil {
+		t.Error("Expected Quiet to report the failure")
+	}
+}
+
+func TestRunStringStrict(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.RunString(ctx, `printf 'a TODO\nb\nc TODO|x\n' | grep TODO | wc -l`, sh.SafetyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TrimmedString() != "2" {
+		t.Errorf("Expected the pipeline to count 2 lines, got %q", result.TrimmedString())
+	}
+
+	for _, line := range []string{"echo $HOME", "true && echo yes", "true || echo no", "echo a > out", "echo a |", "| wc -l", "ls *.go", "echo ~", "FOO=1 env", "echo hi # comment"} {
+		if _, err := sh.RunString(ctx, line, sh.SafetyStrict); err == nil {
+			t.Errorf("Expected %q to be rejected at SafetyStrict", line)
+		}
+	}
+}
+
+func TestRunStringPermissive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var argv [][]string
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Middleware: []sh.Middleware{
+		func(next sh.RunFunc) sh.RunFunc {
+			return func(ctx context.Context, e *sh.Execution) error {
+				argv = append(argv, e.Exec.Args)
+				return next(ctx, e)
+			}
+		},
+	}})
+	ctx = sh.WithRunner(ctx, runner)
+
+	result, err := sh.RunString(ctx, `X=shell; echo "$X" && echo done`, sh.SafetyPermissive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout()) != "shell\ndone\n" {
+		t.Errorf("Expected the line to run in a shell, got %q", result.Stdout())
+	}
+	if _, err := sh.RunString(ctx, "echo parsed", sh.SafetyPermissive); err != nil {
+		t.Fatal(err)
+	}
+	if len(argv) != 2 || argv[0][0] != "sh" || argv[1][0] != "echo" {
+		t.Errorf("Expected only the line needing a shell to use one, got %v", argv)
+	}
+
+	result, err = sh.RunString(ctx, "FOO=1 env", sh.SafetyPermissive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(result.Lines(), "FOO=1") {
+		t.Errorf("Expected the assignment to reach the shell, got %q", result.Stdout())
+	}
+	result, err = sh.RunString(ctx, "echo hi # comment", sh.SafetyPermissive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout()) != "hi\n" {
+		t.Errorf("Expected the shell to drop the comment, got %q", result.Stdout())
+	}
+}
@@ -0,0 +1,60 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Open opens a file, directory or URL in the user's default application,
+// e.g. a web browser for URLs. It uses open on macOS, the URL protocol
+// handler on Windows and xdg-open elsewhere, and returns once the launcher
+// has handed off the target. If no launcher is installed the error matches
+// errors.ErrUnsupported; a missing file matches fs.ErrNotExist.
+func Open(ctx context.Context, target string) error {
+	name, args := opener(runtime.GOOS)
+
+	// Keep paths starting with a dash from being read as options
+	if strings.HasPrefix(target, "-") {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+
+	b := New(name)
+	for _, arg := range append(args, target) {
+		b.Arg(arg)
+	}
+
+	_, err := b.Build(ctx).Run()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, exec.ErrNotFound):
+		return fmt.Errorf("sh: open %s: %s not found: %w", target, name, errors.ErrUnsupported)
+	case name == "xdg-open" && exitCodeIs(err, 2):
+		return fmt.Errorf("sh: open %s: %w", target, fs.ErrNotExist)
+	case name == "xdg-open" && exitCodeIs(err, 3):
+		return fmt.Errorf("sh: open %s: no application found: %w", target, errors.ErrUnsupported)
+	default:
+		return fmt.Errorf("sh: open %s: %w", target, err)
+	}
+}
+
+// opener returns the launcher command for the operating system goos.
+func opener(goos string) (name string, args []string) {
+	switch goos {
+	case "darwin":
+		return "open", nil
+	case "windows":
+		// Unlike "cmd /c start" this needs no quoting of the target
+		return "rundll32", []string{"url.dll,FileProtocolHandler"}
+	default:
+		return "xdg-open", nil
+	}
+}
@@ -0,0 +1,53 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestOpen(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("Uses a fake xdg-open")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "opened")
+	script := `#!/bin/sh
+case "$1" in
+missing) exit 2 ;;
+esac
+printf '%s\n' "$1" >> ` + log + `
+`
+	if err := os.WriteFile(filepath.Join(dir, "xdg-open"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	if err := sh.Open(ctx, "https://example.com/?q=a b&c"); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := os.ReadFile(log)
+	if string(data) != "https://example.com/?q=a b&c\n" {
+		t.Errorf("Expected the URL to be passed verbatim, got %q", data)
+	}
+
+	if err := sh.Open(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if err := sh.Open(ctx, "file.txt"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected errors.ErrUnsupported without a launcher, got %v", err)
+	}
+}
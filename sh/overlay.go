@@ -0,0 +1,44 @@
+package sh
+
+// ChangeKind says how a file was changed by a command run with WithOverlay
+// or WithFSDiff.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a file or directory that did not exist before.
+	ChangeAdded ChangeKind = iota
+	// ChangeModified is an existing file whose contents or metadata
+	// changed, or a directory whose contents were replaced as a whole.
+	ChangeModified
+	// ChangeDeleted is a file or directory that was removed.
+	ChangeDeleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// FileChange is a change a command made to the directory it ran over with
+// WithOverlay or watched with WithFSDiff.
+type FileChange struct {
+	// Path is slash-separated and relative to the overlaid directory.
+	Path string
+	Kind ChangeKind
+	Dir  bool
+	// Content is the new content of an added or modified regular file
+	// under WithOverlay. It is nil for WithFSDiff, whose changes are on
+	// disk.
+	Content []byte
+}
+
+func (r *resultImpl) Changes() []FileChange {
+	return r.changes
+}
@@ -0,0 +1,211 @@
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// overlayScript mounts the overlay over the directory in the command's
+// private mount namespace, moves into the merged view if the working
+// directory is inside it, reports success on a pipe and runs the command.
+const overlayScript = `mount --make-rprivate / &&
+mount -t overlay overlay -o "$1" "$2" &&
+cd "$(pwd -P)" &&
+printf x >&"$3" &&
+eval "exec $3>&-" &&
+shift 3 &&
+exec "$@"`
+
+func (cm *cmdImpl) WithOverlay(dir string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.planTags = append(cm.planTags, "overlay="+dir)
+
+	var lower, tmp string
+	var mounted, report *os.File
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) (err error) {
+			if cmd.Err != nil {
+				return nil
+			}
+			defer func() {
+				if err != nil {
+					err = fmt.Errorf("sh: overlay: %w", err)
+				}
+			}()
+			if lower, err = filepath.Abs(dir); err != nil {
+				return err
+			}
+			if lower, err = filepath.EvalSymlinks(lower); err != nil {
+				return err
+			}
+			shell, err := exec.LookPath("sh")
+			if err != nil {
+				return err
+			}
+
+			if tmp, err = os.MkdirTemp("", "sh-overlay-"); err != nil {
+				return err
+			}
+			upper, work := filepath.Join(tmp, "upper"), filepath.Join(tmp, "work")
+			err = errors.Join(os.Mkdir(upper, 0o700), os.Mkdir(work, 0o700))
+			if err == nil {
+				mounted, report, err = os.Pipe()
+			}
+			if err != nil {
+				os.RemoveAll(tmp)
+				tmp = ""
+				return err
+			}
+			fd := 3 + len(cmd.ExtraFiles)
+			cmd.ExtraFiles = append(cmd.ExtraFiles, report)
+
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+			opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+			if uid := os.Geteuid(); uid != 0 {
+				cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+				cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+				cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+				// Unprivileged overlays keep their metadata in user xattrs
+				opts = "userxattr," + opts
+			}
+
+			args := []string{"sh", "-c", overlayScript, "sh", opts, lower, strconv.Itoa(fd), cmd.Path}
+			cmd.Args = append(args, cmd.Args[1:]...)
+			cmd.Path = shell
+			return nil
+		},
+		started: func(*exec.Cmd) error {
+			if mounted == nil {
+				return nil
+			}
+			// Drop our copy of the write end so that the read below ends
+			// if the script fails before reporting success
+			report.Close()
+			if n, _ := mounted.Read(make([]byte, 1)); n == 0 {
+				return &StartError{Cmd: cm.cmd, Stage: StageSetup, Err: errors.New("sh: overlay: cannot mount the overlay")}
+			}
+			return nil
+		},
+		after: func(runErr error) error {
+			if tmp == "" {
+				return nil
+			}
+			defer os.RemoveAll(tmp)
+			mounted.Close()
+			report.Close()
+			var startErr *StartError
+			if errors.As(runErr, &startErr) {
+				return nil
+			}
+
+			changes, err := overlayChanges(lower, filepath.Join(tmp, "upper"))
+			cm.mu.Lock()
+			cm.changes = changes
+			cm.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("sh: overlay: %w", err)
+			}
+			return nil
+		},
+	})
+	return cm
+}
+
+// overlayChanges lists the changes recorded in the upper directory of an
+// overlay over lower.
+func overlayChanges(lower, upper string) ([]FileChange, error) {
+	var changes []FileChange
+	err := filepath.WalkDir(upper, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == upper {
+			return err
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		lowerInfo, statErr := os.Lstat(filepath.Join(lower, rel))
+		existed := statErr == nil
+		change := FileChange{Path: filepath.ToSlash(rel), Kind: ChangeAdded, Dir: d.IsDir()}
+		if existed {
+			change.Kind = ChangeModified
+		}
+
+		switch {
+		case isWhiteout(info):
+			change.Kind = ChangeDeleted
+			change.Dir = existed && lowerInfo.IsDir()
+		case d.IsDir():
+			// Directories are copied up when anything below them changes;
+			// they only count as changed if new or replaced
+			if existed && !isOpaque(path) {
+				return nil
+			}
+			if existed {
+				deleted, err := replacedEntries(filepath.Join(lower, rel), path, change.Path)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, deleted...)
+			}
+		case info.Mode().IsRegular():
+			if change.Content, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	slices.SortFunc(changes, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, err
+}
+
+// isWhiteout reports whether info describes an overlayfs whiteout, which
+// marks a deleted file: a character device with device number 0/0.
+func isWhiteout(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&fs.ModeCharDevice != 0 && st.Rdev == 0
+}
+
+// isOpaque reports whether the upper directory path replaces the lower
+// directory of the same name rather than being merged with it.
+func isOpaque(path string) bool {
+	for _, attr := range []string{"trusted.overlay.opaque", "user.overlay.opaque"} {
+		buf := make([]byte, 1)
+		if n, err := syscall.Getxattr(path, attr, buf); err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// replacedEntries reports the entries of the lower directory of an opaque
+// upper directory that are gone from it as deleted.
+func replacedEntries(lower, upper, rel string) ([]FileChange, error) {
+	entries, err := os.ReadDir(lower)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []FileChange
+	for _, e := range entries {
+		if _, err := os.Lstat(filepath.Join(upper, e.Name())); errors.Is(err, os.ErrNotExist) {
+			deleted = append(deleted, FileChange{Path: rel + "/" + e.Name(), Kind: ChangeDeleted, Dir: e.IsDir()})
+		}
+	}
+	return deleted, nil
+}
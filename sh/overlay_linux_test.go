@@ -0,0 +1,76 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithOverlay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "a\n", "gone": "", "d/x": "x\n"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := sh.New("sh").
+		OptV("-c", "echo b >> a; rm gone; rm -r d; mkdir d; echo y > d/y; echo new > n").
+		Build(ctx).WithDir(dir).WithOverlay(dir).Run()
+	var startErr *sh.StartError
+	if errors.As(err, &startErr) && startErr.Stage == sh.StageSetup {
+		t.Skipf("overlay not available: %v: %s", err, result.Stderr())
+	}
+	if err != nil {
+		t.Fatalf("Command failed: %v: %s", err, result.Stderr())
+	}
+
+	var got []string
+	for _, c := range result.Changes() {
+		got = append(got, fmt.Sprintf("%s %s dir=%t %q", c.Kind, c.Path, c.Dir, c.Content))
+	}
+	want := []string{
+		`modified a dir=false "a\nb\n"`,
+		`modified d dir=true ""`,
+		`deleted d/x dir=false ""`,
+		`added d/y dir=false "y\n"`,
+		`deleted gone dir=false ""`,
+		`added n dir=false "new\n"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Unexpected changes:\n got %q\nwant %q", got, want)
+	}
+
+	// The directory itself is untouched
+	if content, _ := os.ReadFile(filepath.Join(dir, "a")); string(content) != "a\n" {
+		t.Errorf("Expected a to be unchanged, got %q", content)
+	}
+	for _, name := range []string{"gone", "d/x"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to still exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "n")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected n not to be created, got %v", err)
+	}
+}
+
+func TestCmdWithoutOverlayHasNoChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("true").Build(ctx).Run()
+	if err != nil || result.Changes() != nil {
+		t.Errorf("Expected no changes, got %v, %v", result.Changes(), err)
+	}
+}
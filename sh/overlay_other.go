@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func (cm *cmdImpl) WithOverlay(string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: overlay: %w", errors.ErrUnsupported)
+		},
+	})
+	return cm
+}
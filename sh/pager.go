@@ -0,0 +1,133 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func (cm *cmdImpl) WithPager() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var pager *exec.Cmd
+	var pagerIn io.WriteCloser
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			var err error
+			pager, pagerIn, err = startPager(cm.ctx, cm.runner)
+			if err != nil {
+				return err
+			}
+			if pager == nil {
+				_, stdout, _ := cm.runner.stdio()
+				cmd.Stdout = appendWriter(cmd.Stdout, stdout)
+				return nil
+			}
+			// Keep running if the user quits the pager early
+			cmd.Stdout = appendWriter(cmd.Stdout, &discardOnError{w: pagerIn})
+			return nil
+		},
+		after: func(error) error {
+			if pager == nil {
+				return nil
+			}
+			pagerIn.Close()
+			// Quitting the pager early is not an error of the command
+			pager.Wait()
+			return nil
+		},
+	})
+	return cm
+}
+
+// Page shows the contents of r through the user's pager, like git does for
+// long output: $PAGER is used, falling back to "less -R", and less is told
+// to exit right away if the output fits on one screen. If the default
+// stdout is not a terminal r is copied to it unchanged.
+func Page(ctx context.Context, r io.Reader) error {
+	pager, pagerIn, err := startPager(ctx, defaultRunner)
+	if err != nil {
+		return err
+	}
+	if pager == nil {
+		_, stdout, _ := defaultRunner.stdio()
+		_, err := io.Copy(stdout, r)
+		return err
+	}
+
+	_, err = io.Copy(pagerIn, r)
+	pagerIn.Close()
+	pager.Wait()
+
+	// The user quitting before reading everything is fine
+	if err != nil && !isBrokenPipe(err) {
+		return err
+	}
+	return nil
+}
+
+// startPager starts the pager on the runner's default stdout and returns it
+// with a writer to its input. It returns a nil pager when stdout is not a terminal
+// or paging is disabled with PAGER=cat or an empty PAGER.
+func startPager(ctx context.Context, r *Runner) (*exec.Cmd, io.WriteCloser, error) {
+	_, stdout, stderr := r.stdio()
+	out, ok := stdout.(*os.File)
+	if !ok || !IsTerminal(out) {
+		return nil, nil, nil
+	}
+
+	argv := []string{"less", "-R"}
+	if p, set := os.LookupEnv("PAGER"); set {
+		words, err := splitWords(p)
+		if err != nil {
+			// Leave anything fancier to the shell
+			words = []string{"/bin/sh", "-c", p}
+		}
+		argv = words
+	}
+	if len(argv) == 0 || argv[0] == "cat" {
+		return nil, nil, nil
+	}
+
+	pager := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	pager.Stdout = out
+	pager.Stderr = stderr
+	pager.Env = os.Environ()
+	if _, set := os.LookupEnv("LESS"); !set {
+		// Quit if one screen, keep colors, do not clear the screen
+		pager.Env = append(pager.Env, "LESS=FRX")
+	}
+
+	in, err := pager.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pager.Start(); err != nil {
+		return nil, nil, err
+	}
+	return pager, in, nil
+}
+
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed)
+}
+
+// discardOnError writes to w until a write fails and discards everything
+// after that.
+type discardOnError struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *discardOnError) Write(p []byte) (int, error) {
+	if !d.failed {
+		if _, err := d.w.Write(p); err != nil {
+			d.failed = true
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,39 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPagerWithoutTerminal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without a terminal the pager is skipped and output goes to stdout
+	var out bytes.Buffer
+	sh.SetDefaultStdout(&out)
+	defer sh.SetDefaultStdout(os.Stdout)
+	t.Setenv("PAGER", "false")
+
+	result, err := sh.New("printf").Arg("one\ntwo\n").Build(ctx).WithPager().Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if out.String() != "one\ntwo\n" || string(result.Stdout()) != "one\ntwo\n" {
+		t.Errorf("Expected output on stdout and in the result, got %q and %q", out.String(), result.Stdout())
+	}
+
+	out.Reset()
+	if err := sh.Page(ctx, strings.NewReader("paged\n")); err != nil {
+		t.Fatalf("Page failed: %v", err)
+	}
+	if out.String() != "paged\n" {
+		t.Errorf("Expected Page to copy to stdout, got %q", out.String())
+	}
+}
@@ -0,0 +1,160 @@
+package sh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageOption configures Paginate.
+type PageOption func(*pageOptions)
+
+type pageOptions struct {
+	tokenFlag  string
+	tokenField string
+	pageFlag   string
+	firstPage  int
+	itemsField string
+	maxPages   int
+}
+
+// PageToken passes the token found at field of each page's JSON output to
+// the next invocation as flag, e.g. PageToken("--starting-token",
+// "NextToken") for the AWS CLI. Pagination ends when the field is missing
+// or empty.
+func PageToken(flag, field string) PageOption {
+	return func(o *pageOptions) {
+		o.tokenFlag, o.tokenField = flag, field
+	}
+}
+
+// PageNumber passes increasing page numbers starting at first as flag,
+// e.g. PageNumber("--page", 1). Pagination ends with the first page
+// without items.
+func PageNumber(flag string, first int) PageOption {
+	return func(o *pageOptions) {
+		o.pageFlag, o.firstPage = flag, first
+	}
+}
+
+// PageItems sets the field holding the items of a page. By default the
+// whole output must be a JSON array.
+func PageItems(field string) PageOption {
+	return func(o *pageOptions) {
+		o.itemsField = field
+	}
+}
+
+// MaxPages stops after n pages.
+func MaxPages(n int) PageOption {
+	return func(o *pageOptions) {
+		o.maxPages = n
+	}
+}
+
+// Paginate runs the list command b repeatedly, passing the token or page
+// number of the next page each time, until all pages have been fetched,
+// and returns the items of all pages. Fields are dotted paths into the
+// JSON output, e.g. "data.items". Commands are built with the Runner from
+// ctx.
+//
+//	items, err := sh.Paginate(ctx,
+//		sh.New("aws").Arg("s3api").Arg("list-objects-v2").OptV("--bucket", bucket),
+//		sh.PageToken("--starting-token", "NextToken"),
+//		sh.PageItems("Contents"))
+func Paginate(ctx context.Context, b Argv, opts ...PageOption) ([]json.RawMessage, error) {
+	var o pageOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tokenFlag == "" && o.pageFlag == "" {
+		return nil, fmt.Errorf("sh: paginate: PageToken or PageNumber is required")
+	}
+
+	argv := b.Items()
+	var items []json.RawMessage
+	token := ""
+	for page := 0; o.maxPages <= 0 || page < o.maxPages; page++ {
+		builder := FromContext(ctx).New(argv[0])
+		for _, arg := range argv[1:] {
+			builder.Arg(arg)
+		}
+		switch {
+		case o.pageFlag != "":
+			builder.OptV(o.pageFlag, strconv.Itoa(o.firstPage+page))
+		case token != "":
+			builder.OptV(o.tokenFlag, token)
+		}
+
+		result, err := builder.Build(ctx).Run()
+		if err != nil {
+			return items, fmt.Errorf("sh: paginate: page %d: %w", page+1, err)
+		}
+
+		var out any
+		if err := json.Unmarshal(result.Stdout(), &out); err != nil {
+			return items, fmt.Errorf("sh: paginate: page %d: %w", page+1, err)
+		}
+		pageItems, err := pageItemsOf(result.Stdout(), o.itemsField)
+		if err != nil {
+			return items, fmt.Errorf("sh: paginate: page %d: %w", page+1, err)
+		}
+		items = append(items, pageItems...)
+
+		if o.pageFlag != "" {
+			if len(pageItems) == 0 {
+				break
+			}
+			continue
+		}
+		next, _ := lookupField(out, o.tokenField).(string)
+		if next == "" || next == token {
+			break
+		}
+		token = next
+	}
+	return items, nil
+}
+
+// pageItemsOf returns the elements of the array at field of the JSON
+// document data, or of data itself for an empty field.
+func pageItemsOf(data []byte, field string) ([]json.RawMessage, error) {
+	if field == "" {
+		var items []json.RawMessage
+		err := json.Unmarshal(data, &items)
+		return items, err
+	}
+
+	var doc map[string]json.RawMessage
+	path := strings.Split(field, ".")
+	for i, key := range path {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path[:i], "."), err)
+		}
+		var ok bool
+		if data, ok = doc[key]; !ok {
+			return nil, nil // pages without items may omit the field
+		}
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return items, nil
+}
+
+// lookupField returns the value at the dotted path in a decoded JSON
+// document, or nil.
+func lookupField(doc any, field string) any {
+	for _, key := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return nil
+		}
+		doc = m[key]
+	}
+	return doc
+}
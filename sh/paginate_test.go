@@ -0,0 +1,64 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPaginateToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cli := filepath.Join(t.TempDir(), "fake-cloud")
+	script := `#!/bin/sh
+case "$*" in
+*"--starting-token t2"*) echo '{"Items":[3],"Meta":{"Next":""}}' ;;
+*"--starting-token t1"*) echo '{"Items":[2],"Meta":{"Next":"t2"}}' ;;
+*) echo '{"Items":[1],"Meta":{"Next":"t1"}}' ;;
+esac
+`
+	if err := os.WriteFile(cli, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := sh.Paginate(ctx, sh.New(cli).Arg("list"),
+		sh.PageToken("--starting-token", "Meta.Next"),
+		sh.PageItems("Items"))
+	if err != nil {
+		t.Fatalf("Paginate failed: %v", err)
+	}
+	if len(items) != 3 || string(items[0]) != "1" || string(items[2]) != "3" {
+		t.Errorf("Expected items of all pages, got %s", items)
+	}
+
+	items, _ = sh.Paginate(ctx, sh.New(cli), sh.PageToken("--starting-token", "Meta.Next"), sh.PageItems("Items"), sh.MaxPages(2))
+	if len(items) != 2 {
+		t.Errorf("Expected 2 pages of items, got %s", items)
+	}
+}
+
+func TestPaginateNumber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cli := filepath.Join(t.TempDir(), "fake-api")
+	script := `#!/bin/sh
+if [ "$2" -le 2 ]; then echo "[\"page$2\"]"; else echo '[]'; fi
+`
+	if err := os.WriteFile(cli, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := sh.Paginate(ctx, sh.New(cli), sh.PageNumber("--page", 1))
+	if err != nil {
+		t.Fatalf("Paginate failed: %v", err)
+	}
+	if len(items) != 2 || string(items[1]) != `"page2"` {
+		t.Errorf("Expected two pages, got %s", items)
+	}
+}
@@ -0,0 +1,240 @@
+package sh
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// Placeholder is a value filled in before a command runs, such as a form
+// field in a TUI. Positional placeholders must be given a value; option
+// placeholders are left out when their value is empty. Building a Builder
+// directly leaves all placeholders out.
+type Placeholder struct {
+	Name        string
+	Description string
+	// Flag is the option taking the value, or empty for a positional
+	// argument.
+	Flag string
+}
+
+// Items returns nothing, as the placeholder has no value yet.
+func (p *Placeholder) Items() []string {
+	return []string{}
+}
+
+// Parent returns the parent component, which is always nil for
+// placeholders.
+func (p *Placeholder) Parent() CmdComponent {
+	return nil
+}
+
+// String renders the placeholder as in a usage line: "<name>" for
+// arguments and "[--flag <name>]" for options.
+func (p *Placeholder) String() string {
+	if p.Flag == "" {
+		return "<" + p.Name + ">"
+	}
+	return fmt.Sprintf("[%s <%s>]", p.Flag, p.Name)
+}
+
+// fill returns the arguments for the placeholder given value.
+func (p *Placeholder) fill(value string) ([]string, error) {
+	switch {
+	case p.Flag == "":
+		if value == "" {
+			return nil, fmt.Errorf("sh: missing value for <%s>", p.Name)
+		}
+		return []string{value}, nil
+	case value == "":
+		return nil, nil
+	}
+	return []string{p.Flag, value}, nil
+}
+
+// Describe attaches a description to the command, shown by frontends
+// rendering the tree returned by Tree.
+func (b *Builder) Describe(description string) *Builder {
+	b.description = description
+	return b
+}
+
+// Placeholder adds a positional argument to be filled in through Tree
+// before the command runs.
+func (b *Builder) Placeholder(name, description string) *Builder {
+	b.components = append(b.components, &Placeholder{Name: name, Description: description})
+	return b
+}
+
+// OptPlaceholder adds an option whose value is filled in through Tree
+// before the command runs. The option is left out if no value is given.
+func (b *Builder) OptPlaceholder(flag, name, description string) *Builder {
+	b.components = append(b.components, &Placeholder{Name: name, Description: description, Flag: flag})
+	return b
+}
+
+// Describe attaches a description to the subcommand and returns the SubCmd.
+func (s *SubCmd) Describe(description string) *SubCmd {
+	s.Builder.Describe(description)
+	return s
+}
+
+// Placeholder adds a positional placeholder to the subcommand and returns
+// the SubCmd.
+func (s *SubCmd) Placeholder(name, description string) *SubCmd {
+	s.Builder.Placeholder(name, description)
+	return s
+}
+
+// OptPlaceholder adds an option placeholder to the subcommand and returns
+// the SubCmd.
+func (s *SubCmd) OptPlaceholder(flag, name, description string) *SubCmd {
+	s.Builder.OptPlaceholder(flag, name, description)
+	return s
+}
+
+// CommandNode is a command or subcommand in the tree returned by
+// Builder.Tree, for frontends rendering pickers and forms that construct
+// commands interactively:
+//
+//	git := sh.New("git").Describe("version control")
+//	git.SubCommand("clone").Describe("copy a repository").
+//		OptPlaceholder("--branch", "branch", "branch to check out").
+//		Placeholder("url", "repository to clone")
+//
+//	node, _ := git.Tree().Find("clone")
+//	cmd, err := node.Build(ctx, map[string]string{"url": url})
+type CommandNode struct {
+	// Name is the program or subcommand name.
+	Name        string
+	Description string
+	// Path is the names from the program down to this node, e.g.
+	// ["git", "remote", "add"].
+	Path []string
+	// Placeholders are the values to fill in, in command line order.
+	Placeholders []*Placeholder
+	Subcommands  []*CommandNode
+
+	chain []*Builder // builders from the root to this node
+}
+
+// Tree returns the builder and the subcommands created from it with
+// SubCommand as a tree of CommandNodes. A later subcommand replaces an
+// earlier one of the same name. Call Tree on the root builder.
+func (b *Builder) Tree() *CommandNode {
+	return b.tree(nil, nil)
+}
+
+func (b *Builder) tree(path []string, chain []*Builder) *CommandNode {
+	path = append(path[:len(path):len(path)], b.Cmd)
+	chain = append(chain[:len(chain):len(chain)], b)
+
+	n := &CommandNode{
+		Name:        b.Cmd,
+		Description: b.description,
+		Path:        path,
+		chain:       chain,
+	}
+	for _, c := range b.components {
+		if p, ok := c.(*Placeholder); ok {
+			n.Placeholders = append(n.Placeholders, p)
+		}
+	}
+	for _, sub := range b.subs {
+		n.Subcommands = append(n.Subcommands, sub.Builder.tree(path, chain))
+	}
+	return n
+}
+
+// Find returns the descendant reached by following the subcommand names.
+func (n *CommandNode) Find(names ...string) (*CommandNode, bool) {
+	for _, name := range names {
+		var next *CommandNode
+		for _, sub := range n.Subcommands {
+			if sub.Name == name {
+				next = sub
+				break
+			}
+		}
+		if next == nil {
+			return nil, false
+		}
+		n = next
+	}
+	return n, true
+}
+
+// All returns the node and all its descendants, depth first, e.g. to list
+// every command in a fuzzy finder.
+func (n *CommandNode) All() iter.Seq[*CommandNode] {
+	return func(yield func(*CommandNode) bool) {
+		n.walk(yield)
+	}
+}
+
+func (n *CommandNode) walk(yield func(*CommandNode) bool) bool {
+	if !yield(n) {
+		return false
+	}
+	for _, sub := range n.Subcommands {
+		if !sub.walk(yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Items returns the command line of the node with every placeholder filled
+// from values, keyed by placeholder name. It fails if a positional
+// placeholder has no value.
+func (n *CommandNode) Items(values map[string]string) ([]string, error) {
+	var items []string
+	for _, b := range n.chain {
+		items = append(items, b.Cmd)
+		for _, c := range b.components {
+			p, ok := c.(*Placeholder)
+			if !ok {
+				items = append(items, c.Items()...)
+				continue
+			}
+			filled, err := p.fill(values[p.Name])
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, filled...)
+		}
+	}
+	return items, nil
+}
+
+// Build builds the command of the node with its placeholders filled from
+// values, using the runner of the root builder.
+func (n *CommandNode) Build(ctx context.Context, values map[string]string) (Cmd, error) {
+	items, err := n.Items(values)
+	if err != nil {
+		return nil, err
+	}
+	b := &Builder{Cmd: items[0], runner: n.chain[0].runner}
+	for _, item := range items[1:] {
+		b.Arg(item)
+	}
+	return b.Build(ctx), nil
+}
+
+// String returns a usage line for the node, such as
+// "git clone [--branch <branch>] <url>".
+func (n *CommandNode) String() string {
+	var words []string
+	for _, b := range n.chain {
+		words = append(words, quote(b.Cmd))
+		for _, c := range b.components {
+			if p, ok := c.(*Placeholder); ok {
+				words = append(words, p.String())
+			} else {
+				words = append(words, QuoteItems(c.Items())...)
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,102 @@
+package sh_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func paletteTree() *sh.Builder {
+	git := sh.New("git").Describe("version control")
+	git.SubCommand("clone").Describe("copy a repository").
+		OptB("--quiet").
+		OptPlaceholder("--branch", "branch", "branch to check out").
+		Placeholder("url", "repository to clone")
+	git.SubCommand("status").Describe("show the working tree status")
+	return git
+}
+
+func TestBuilderTree(t *testing.T) {
+	tree := paletteTree().Tree()
+	if tree.Name != "git" || tree.Description != "version control" || len(tree.Subcommands) != 2 {
+		t.Fatalf("Unexpected root %+v", tree)
+	}
+
+	clone, ok := tree.Find("clone")
+	if !ok {
+		t.Fatal("Expected to find clone")
+	}
+	if !slices.Equal(clone.Path, []string{"git", "clone"}) || clone.Description != "copy a repository" {
+		t.Errorf("Unexpected node %+v", clone)
+	}
+	if len(clone.Placeholders) != 2 || clone.Placeholders[0].Name != "branch" || clone.Placeholders[0].Flag != "--branch" ||
+		clone.Placeholders[1].Name != "url" || clone.Placeholders[1].Description != "repository to clone" {
+		t.Errorf("Unexpected placeholders %+v", clone.Placeholders)
+	}
+	if got, want := clone.String(), "git clone --quiet [--branch <branch>] <url>"; got != want {
+		t.Errorf("Expected usage %q, got %q", want, got)
+	}
+
+	if _, ok := tree.Find("clone", "nope"); ok {
+		t.Error("Expected no node for an unknown subcommand")
+	}
+
+	var names []string
+	for n := range tree.All() {
+		names = append(names, strings.Join(n.Path, " "))
+	}
+	if !slices.Equal(names, []string{"git", "git clone", "git status"}) {
+		t.Errorf("Unexpected nodes %q", names)
+	}
+}
+
+func TestBuilderTreeReplacesSubcommands(t *testing.T) {
+	git := sh.New("git")
+	git.SubCommand("status").OptB("--short")
+	git.SubCommand("status").Describe("latest")
+
+	tree := git.Tree()
+	if len(tree.Subcommands) != 1 || tree.Subcommands[0].Description != "latest" {
+		t.Errorf("Expected the later subcommand to replace the earlier one, got %+v", tree.Subcommands)
+	}
+}
+
+func TestCommandNodeBuild(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clone, _ := paletteTree().Tree().Find("clone")
+
+	items, err := clone.Items(map[string]string{"url": "https://example.com/repo.git", "branch": "main"})
+	if err != nil {
+		t.Fatalf("Items failed: %v", err)
+	}
+	want := []string{"git", "clone", "--quiet", "--branch", "main", "https://example.com/repo.git"}
+	if !slices.Equal(items, want) {
+		t.Errorf("Expected %q, got %q", want, items)
+	}
+
+	// Empty option placeholders are left out
+	items, _ = clone.Items(map[string]string{"url": "repo"})
+	if !slices.Equal(items, []string{"git", "clone", "--quiet", "repo"}) {
+		t.Errorf("Unexpected items %q", items)
+	}
+
+	if _, err := clone.Build(ctx, nil); err == nil || !strings.Contains(err.Error(), "<url>") {
+		t.Errorf("Expected an error for the missing url, got %v", err)
+	}
+
+	echo := sh.New("echo").Placeholder("text", "what to print")
+	cmd, err := echo.Tree().Build(ctx, map[string]string{"text": "hello world"})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	result, err := cmd.Run()
+	if err != nil || result.TrimmedString() != "hello world" {
+		t.Errorf("Expected the filled in argument, got %q, %v", result.TrimmedString(), err)
+	}
+}
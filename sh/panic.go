@@ -0,0 +1,140 @@
+package sh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicError is returned instead of crashing the program when code run by
+// the package panics: middleware, hooks, writers, health checks and the
+// functions building commands for Supervise, Compose and Debounce, as well
+// as the package itself.
+type PanicError struct {
+	// Cmd is the name of the command whose run panicked, if any.
+	Cmd string
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Cmd != "" {
+		return fmt.Sprintf("sh: %s: panic: %v", e.Cmd, e.Value)
+	}
+	return fmt.Sprintf("sh: panic: %v", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// recoverRun, deferred by execute, fails the command with a *PanicError if
+// its run panicked.
+func (cm *cmdImpl) recoverRun() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := &PanicError{Cmd: cm.cmd, Value: r, Stack: debug.Stack()}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.result == nil {
+		cm.result = &resultImpl{name: cm.cmd, exitCode: -1, stdout: []byte{}, stderr: []byte{}}
+	}
+	cm.err = err
+}
+
+// catchPanic, deferred, turns a panic into a *PanicError stored in *err.
+// It must be deferred itself, as recover only stops panics when called by
+// the deferred function.
+func catchPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// protect runs f, turning a panic into an error.
+func protect(f func() error) (err error) {
+	defer catchPanic(&err)
+	return f()
+}
+
+// buildSafely calls build, turning a panic into an error.
+func buildSafely(ctx context.Context, build func(ctx context.Context) Cmd) (cmd Cmd, err error) {
+	defer catchPanic(&err)
+	return build(ctx), nil
+}
+
+var panicHandler atomic.Pointer[func(*PanicError)]
+
+// SetPanicHandler sets the function called with panics recovered in
+// goroutines of the package that have no caller to return them to, such as
+// those copying output to the terminal of WithPTY. By default they are
+// printed to stderr with their stack trace. A nil h restores the default.
+func SetPanicHandler(h func(*PanicError)) {
+	if h == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&h)
+}
+
+// goSafe runs f in a new goroutine, handing a panic to the panic handler
+// rather than crashing the program.
+func goSafe(f func()) {
+	go func() {
+		var err error
+		defer func() {
+			if err != nil {
+				handlePanic(err.(*PanicError))
+			}
+		}()
+		defer catchPanic(&err)
+		f()
+	}()
+}
+
+func handlePanic(p *PanicError) {
+	if h := panicHandler.Load(); h != nil {
+		(*h)(p)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v\n%s", p, p.Stack)
+}
+
+// safeWriter turns panics of the writer it wraps into errors, for writers
+// called from goroutines started by os/exec.
+type safeWriter struct {
+	w io.Writer
+}
+
+func (s safeWriter) Write(p []byte) (n int, err error) {
+	defer catchPanic(&err)
+	return s.w.Write(p)
+}
+
+// safeWriters wraps the writers given to a command in a safeWriter, except
+// for files, which are written by the child itself.
+func safeWriters(w io.Writer) io.Writer {
+	if _, isFile := w.(*os.File); w == nil || isFile {
+		return w
+	}
+	return safeWriter{w}
+}
+
+// safeReader turns panics of the reader it wraps into errors.
+type safeReader struct {
+	r io.Reader
+}
+
+func (s safeReader) Read(p []byte) (n int, err error) {
+	defer catchPanic(&err)
+	return s.r.Read(p)
+}
@@ -0,0 +1,80 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPanicInMiddlewareFailsCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("true").Build(ctx).WithMiddleware(func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			panic("middleware bug")
+		}
+	}).Run()
+
+	var panicErr *sh.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+	if panicErr.Cmd != "true" || panicErr.Value != "middleware bug" || len(panicErr.Stack) == 0 {
+		t.Errorf("Expected the command, value and stack, got %+v", panicErr)
+	}
+	if result == nil || result.ExitCode() != -1 {
+		t.Errorf("Expected a result with exit code -1, got %v", result)
+	}
+}
+
+type panickingWriter struct{}
+
+func (panickingWriter) Write([]byte) (int, error) { panic("writer bug") }
+
+func TestPanicInWriterFailsCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sh.New("echo").Arg("hi").Build(ctx).WithStdout(panickingWriter{}).Run()
+	var panicErr *sh.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Expected a PanicError, got %v", err)
+	}
+}
+
+func TestBuildWithoutCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sh.New("").Build(ctx).Run()
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) || !errors.Is(err, sh.ErrNoCommand) {
+		t.Errorf("Expected a StartError for ErrNoCommand, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustBuild to panic without a command")
+		}
+	}()
+	sh.New("").MustBuild(ctx)
+}
+
+func TestPanicInSupervisedBuild(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		panic("build bug")
+	})
+	s.Start(ctx)
+
+	var panicErr *sh.PanicError
+	if err := s.Wait(); !errors.As(err, &panicErr) {
+		t.Errorf("Expected a PanicError, got %v", err)
+	}
+}
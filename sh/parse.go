@@ -0,0 +1,145 @@
+package sh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse splits a shell-like command line into a Builder, so commands from
+// configuration files or user input can be run without invoking /bin/sh.
+// Words are separated by blanks; single quotes preserve everything
+// literally, double quotes allow backslash escapes of $, `, ", \ and
+// newline, and an unquoted backslash escapes the next character.
+//
+// Parse accepts everything Quote and Builder.String produce and returns the
+// original words; this round trip is fuzz tested. Parse does not perform
+// expansion, redirection or pipelines; RunString joins pipelines and, with
+// SafetyPermissive, leaves the rest to the shell. Unquoted shell operators
+// and expansions (| & ; < > ( ) $ `), glob patterns (* ? [), a leading
+// ~ or #, and a leading NAME=value assignment are rejected instead of being
+// passed on literally, so that no line means something else to Parse than
+// to a shell. So are unterminated quotes.
+func Parse(line string) (*Builder, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("sh: parse %q: empty command", line)
+	}
+
+	b := New(words[0])
+	for _, word := range words[1:] {
+		b.Arg(word)
+	}
+	return b, nil
+}
+
+// splitPipeline splits line at the "|" operators outside quotes, leaving
+// "||" in place for splitWords to reject.
+func splitPipeline(line string) ([]string, error) {
+	var stages []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("sh: parse %q: unterminated single quote at offset %d", line, i)
+			}
+			i += 1 + end
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case '|':
+			if i+1 < len(line) && line[i+1] == '|' {
+				i++
+				continue
+			}
+			stages = append(stages, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(stages, line[start:]), nil
+}
+
+// splitWords tokenizes line as described for Parse. It works on bytes
+// rather than runes: all special characters are ASCII, and bytes that are
+// not valid UTF-8 are kept as they are, so that Parse(Quote(args...))
+// returns args for any arguments.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	plain := true // no part of the word is quoted or escaped
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord, plain = false, true
+			}
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				// A backslash-newline is a line continuation
+				if line[i] == '\n' {
+					continue
+				}
+				word.WriteByte(line[i])
+			}
+			inWord, plain = true, false
+		case c == '\'':
+			inWord, plain = true, false
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("sh: parse %q: unterminated single quote at offset %d", line, i)
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += 1 + end
+		case c == '"':
+			inWord, plain = true, false
+			start := i
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) >= 0 {
+					i++
+					if line[i] == '\n' {
+						continue
+					}
+				} else if line[i] == '$' || line[i] == '`' {
+					return nil, fmt.Errorf("sh: parse %q: unsupported expansion %q at offset %d", line, line[i], i)
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("sh: parse %q: unterminated double quote at offset %d", line, start)
+			}
+		case strings.IndexByte("|&;<>()$`", c) >= 0:
+			return nil, fmt.Errorf("sh: parse %q: unsupported shell operator %q at offset %d", line, c, i)
+		case strings.IndexByte("*?[", c) >= 0:
+			return nil, fmt.Errorf("sh: parse %q: unsupported glob pattern %q at offset %d", line, c, i)
+		case c == '~' && !inWord:
+			return nil, fmt.Errorf("sh: parse %q: unsupported tilde expansion at offset %d", line, i)
+		case c == '#' && !inWord:
+			return nil, fmt.Errorf("sh: parse %q: unsupported comment at offset %d", line, i)
+		case c == '=' && len(words) == 0 && plain && isAssignment(word.String()+"="):
+			return nil, fmt.Errorf("sh: parse %q: unsupported variable assignment at offset %d", line, i)
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
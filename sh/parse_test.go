@@ -0,0 +1,148 @@
+package sh_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"git log --oneline -n 5 'some file'", []string{"git", "log", "--oneline", "-n", "5", "some file"}},
+		{`echo "a \"quoted\" \$word" it\'s`, []string{"echo", `a "quoted" $word`, "it's"}},
+		{`printf 'single \n stays' "double \n stays"`, []string{"printf", `single \n stays`, `double \n stays`}},
+		{"  tabs\tand  \\\n continued  ", []string{"tabs", "and", "continued"}},
+		{`cmd '' "" a''b`, []string{"cmd", "", "", "ab"}},
+		{`grep 'a|b' "x > y"`, []string{"grep", "a|b", "x > y"}},
+		{`echo a=b x~y x#y '*.go' \~`, []string{"echo", "a=b", "x~y", "x#y", "*.go", "~"}},
+		{sh.Quote("FOO=1", "env"), []string{"FOO=1", "env"}},
+	}
+
+	for _, tt := range tests {
+		b, err := sh.Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.line, err)
+			continue
+		}
+		if got := b.Items(); !slices.Equal(got, tt.want) {
+			t.Errorf("Parse(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"   ",
+		"echo 'unterminated",
+		`echo "unterminated`,
+		"cat file | grep x",
+		"echo hi > out",
+		"rm -rf $HOME",
+		`echo "$(id)"`,
+		"true && false",
+		"echo `id`",
+		"ls *.go",
+		"echo file?.txt",
+		"ls [ab]",
+		"echo ~",
+		"cd ~/src",
+		"FOO=1 env",
+		"echo hi # comment",
+	} {
+		if _, err := sh.Parse(line); err == nil {
+			t.Errorf("Expected Parse(%q) to fail", line)
+		}
+	}
+}
+
+func TestParseRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b, err := sh.Parse(`printf '%s|' "hello world" plain`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	result, err := b.Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout())); got != "hello world|plain|" {
+		t.Errorf("Expected 'hello world|plain|', got %q", got)
+	}
+}
+
+// FuzzQuoteParseRoundTrip checks that Parse splits the command line
+// rendered by Quote back into the same arguments, whatever they contain.
+func FuzzQuoteParseRoundTrip(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"", ""},
+		{"it's", `"double"`},
+		{`back\slash`, `\`},
+		{"new\nline", "tab\there"},
+		{"$HOME", "`id`"},
+		{"a|b&c;d", "<in >out"},
+		{"~user", "#comment"},
+		{"*.go", "[abc]?"},
+		{"'''", `\'`},
+		{"\xff\xfe", "日本語"},
+		{"-n", "--flag=value with space"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		args := []string{"cmd", a, b}
+		line := sh.Quote(args...)
+		parsed, err := sh.Parse(line)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", line, err)
+		}
+		if got := parsed.Items(); !slices.Equal(got, args) {
+			t.Fatalf("Parse(%q) = %q, want %q", line, got, args)
+		}
+		if parsed.String() != line {
+			t.Fatalf("String() = %q, want %q", parsed.String(), line)
+		}
+	})
+}
+
+// FuzzParse checks that Parse never panics and that what it accepts
+// survives being rendered with String and parsed again.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"git log --oneline -n 5 'some file'",
+		`echo "a \"quoted\" \$word" it\'s`,
+		"  tabs\tand  \\\n continued  ",
+		`cmd '' "" a''b`,
+		`unterminated 'quote`,
+		`"unterminated`,
+		`echo $HOME | wc`,
+		`trailing\`,
+		"\"a\\\nb\"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		b, err := sh.Parse(line)
+		if err != nil {
+			return
+		}
+		again, err := sh.Parse(b.String())
+		if err != nil {
+			t.Fatalf("Parse(%q) failed on the rendering of %q: %v", b.String(), line, err)
+		}
+		if !slices.Equal(again.Items(), b.Items()) {
+			t.Fatalf("Round trip of %q changed %q to %q", line, b.Items(), again.Items())
+		}
+	})
+}
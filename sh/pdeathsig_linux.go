@@ -0,0 +1,39 @@
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+func (cm *cmdImpl) WithParentDeathSignal(sig os.Signal) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// The kernel sends the signal when the thread that started the command
+	// exits, not the process, and the Go runtime ends threads whose locked
+	// goroutine returns. Keeping the goroutine running the command locked
+	// to its thread until the command exits means no other goroutine can
+	// lock, and so end, that thread in the meantime.
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			s, ok := sig.(syscall.Signal)
+			if !ok {
+				return fmt.Errorf("sh: unsupported parent death signal %v", sig)
+			}
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.Pdeathsig = s
+			runtime.LockOSThread()
+			return nil
+		},
+		after: func(error) error {
+			runtime.UnlockOSThread()
+			return nil
+		},
+	})
+	return cm
+}
@@ -0,0 +1,29 @@
+package sh_test
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithParentDeathSignal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("echo").
+		Arg("guarded").
+		Build(ctx).
+		WithParentDeathSignal(syscall.SIGTERM).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	if strings.TrimSpace(string(result.Stdout())) != "guarded" {
+		t.Errorf("Expected 'guarded', got '%s'", result.Stdout())
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func (cm *cmdImpl) WithParentDeathSignal(os.Signal) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: parent death signal: %w", errors.ErrUnsupported)
+		},
+	})
+	return cm
+}
@@ -0,0 +1,67 @@
+//go:build unix && !linux
+
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// watchdogScript waits for its stdin to be closed and signals the command
+// unless a line was written first, which the parent does once the command
+// has exited.
+const watchdogScript = `read _ || kill -$0 $1 2>/dev/null`
+
+func (cm *cmdImpl) WithParentDeathSignal(sig os.Signal) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// Without PR_SET_PDEATHSIG a watchdog process holds the read end of a
+	// pipe whose write end only this process has; if it dies, the pipe
+	// is closed and the watchdog signals the command
+	var watchdog *exec.Cmd
+	var alive *os.File
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			if _, ok := sig.(syscall.Signal); !ok {
+				return fmt.Errorf("sh: unsupported parent death signal %v", sig)
+			}
+			return nil
+		},
+		started: func(cmd *exec.Cmd) error {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			s := int(sig.(syscall.Signal))
+			watchdog = exec.Command("/bin/sh", "-c", watchdogScript, strconv.Itoa(s), strconv.Itoa(cmd.Process.Pid))
+			watchdog.Stdin = r
+			// Keep it out of our process group, so that a ^C killing
+			// this process does not kill the watchdog too
+			watchdog.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			if err := watchdog.Start(); err != nil {
+				w.Close()
+				watchdog = nil
+				return fmt.Errorf("sh: parent death watchdog: %w", err)
+			}
+			alive = w
+			return nil
+		},
+		after: func(error) error {
+			if watchdog == nil {
+				return nil
+			}
+			alive.Write([]byte("\n"))
+			alive.Close()
+			watchdog.Wait()
+			watchdog, alive = nil, nil
+			return nil
+		},
+	})
+	return cm
+}
@@ -0,0 +1,151 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAlreadyRunning is returned when a PID file names a process that is
+	// still running.
+	ErrAlreadyRunning = errors.New("sh: process from pid file is already running")
+	// ErrStalePidFile is returned when a PID file names a process that no
+	// longer exists.
+	ErrStalePidFile = errors.New("sh: stale pid file")
+)
+
+// Process is a handle to a running process that was not necessarily started
+// by the current program, such as one re-attached with FromPidFile.
+type Process struct {
+	Pid int
+}
+
+// FromPidFile re-attaches to the process whose PID is stored in the file at
+// path. It returns ErrStalePidFile if that process is no longer running.
+func FromPidFile(path string) (*Process, error) {
+	pid, running, err := pidFileProcess(path)
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		return nil, fmt.Errorf("%w: %s (pid %d)", ErrStalePidFile, path, pid)
+	}
+	return &Process{Pid: pid}, nil
+}
+
+// Signal sends sig to the process.
+func (p *Process) Signal(sig os.Signal) error {
+	proc, err := os.FindProcess(p.Pid)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+// Kill forcibly terminates the process.
+func (p *Process) Kill() error {
+	proc, err := os.FindProcess(p.Pid)
+	if err != nil {
+		return err
+	}
+	return proc.Kill()
+}
+
+// Alive reports whether the process is still running.
+func (p *Process) Alive() bool {
+	return processAlive(p.Pid)
+}
+
+// Wait polls until the process has exited or ctx is done. Since the process
+// is not a child of the current program its exit status is not available.
+func (p *Process) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for p.Alive() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (cm *cmdImpl) WithPidFile(path string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// The lock is held from the check until the PID is written, so that
+	// of two commands started at once only one finds the file unused
+	pid := 0
+	var unlock func()
+	release := func() {
+		if unlock != nil {
+			unlock()
+			unlock = nil
+		}
+	}
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			var err error
+			if unlock, err = lockFile(path + ".lock"); err != nil {
+				return err
+			}
+			old, running, err := pidFileProcess(path)
+			if err == nil && running {
+				release()
+				return fmt.Errorf("%w: %s (pid %d)", ErrAlreadyRunning, path, old)
+			}
+			return nil
+		},
+		started: func(cmd *exec.Cmd) error {
+			defer release()
+			pid = cmd.Process.Pid
+			return writeFileAtomic(path, []byte(strconv.Itoa(pid)+"\n"), 0o644)
+		},
+		after: func(error) error {
+			// The command may not have started
+			release()
+			// Only remove the file if it still refers to this process.
+			if current, err := readPidFile(path); err == nil && current == pid {
+				return os.Remove(path)
+			}
+			return nil
+		},
+	})
+	return cm
+}
+
+// pidFileProcess returns the PID stored in the file at path and whether
+// that process is still running. A process started after the file was
+// written only reuses the PID and does not count.
+func pidFileProcess(path string) (pid int, running bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false, err
+	}
+	if pid, err = readPidFile(path); err != nil {
+		return 0, false, err
+	}
+	return pid, processAlive(pid) && !processStartedAfter(pid, info.ModTime()), nil
+}
+
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("sh: invalid pid file %s", path)
+	}
+	return pid, nil
+}
@@ -0,0 +1,137 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithPidFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "daemon.pid")
+	cmd := sh.New("sleep").
+		Arg("10").
+		Build(ctx).
+		WithPidFile(path)
+	cmd.Start()
+	defer cmd.Cancel()
+
+	var proc *sh.Process
+	deadline := time.Now().Add(time.Second)
+	for {
+		var err error
+		if proc, err = sh.FromPidFile(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected pid file to be written: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A second instance must refuse to start while the first is running
+	_, err := sh.New("sleep").Arg("10").Build(ctx).WithPidFile(path).Run()
+	if !errors.Is(err, sh.ErrAlreadyRunning) {
+		t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+	}
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal failed: %v", err)
+	}
+	cmd.Wait()
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected pid file to be removed after exit, got %v", err)
+	}
+}
+
+func TestFromPidFileStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.pid")
+
+	// Find a PID that is not in use
+	pid := 999999
+	for syscall.Kill(pid, 0) == nil {
+		pid--
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := sh.FromPidFile(path)
+	if !errors.Is(err, sh.ErrStalePidFile) {
+		t.Errorf("Expected ErrStalePidFile, got %v", err)
+	}
+
+	// Stale files are replaced when starting a new command
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sh.New("true").Build(ctx).WithPidFile(path).Run(); err != nil {
+		t.Errorf("Expected stale pid file to be replaced, got %v", err)
+	}
+}
+
+func TestCmdWithPidFileConcurrentStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "daemon.pid")
+	errs := make(chan error, 8)
+	for range cap(errs) {
+		go func() {
+			_, err := sh.New("sleep").Arg("1").Build(ctx).WithPidFile(path).Run()
+			errs <- err
+		}()
+	}
+
+	ran := 0
+	for range cap(errs) {
+		err := <-errs
+		switch {
+		case err == nil:
+			ran++
+		case !errors.Is(err, sh.ErrAlreadyRunning):
+			t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	}
+	if ran != 1 {
+		t.Errorf("Expected exactly one of the concurrent starts to run, got %d", ran)
+	}
+}
+
+func TestCmdWithPidFileReusedPid(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc")
+	}
+	path := filepath.Join(t.TempDir(), "daemon.pid")
+
+	// The test process is alive but started after the file was written,
+	// so it merely reuses the recorded PID
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sh.FromPidFile(path); !errors.Is(err, sh.ErrStalePidFile) {
+		t.Errorf("Expected ErrStalePidFile for a reused PID, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sh.New("true").Build(ctx).WithPidFile(path).Run(); err != nil {
+		t.Errorf("Expected the pid file naming a reused PID to be replaced, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package sh
+
+import (
+	"errors"
+	"slices"
+	"syscall"
+)
+
+func (r *resultImpl) PipeStatus() []int {
+	if r.pipeStatus == nil {
+		return []int{r.exitCode}
+	}
+	return r.pipeStatus
+}
+
+// startPipe starts the parent of a piped command and closes the write end
+// of the pipe once the parent has exited, so the command sees end of input.
+func (cm *cmdImpl) startPipe() {
+	cm.parent.Start()
+
+	if cm.pipeWriter != nil {
+		goSafe(func() {
+			<-cm.parent.Done()
+			cm.pipeWriter.Close()
+		})
+	}
+}
+
+// finishPipe waits for the parent of a piped command and records the exit
+// codes of all stages. As with "set -o pipefail", the pipeline fails if any
+// stage failed; the error of the last failing stage is reported. A stage
+// stopped by a broken pipe, like seq in "seq 1 100000 | head -n 1", is not
+// a failure: the next stage merely did not want the rest of its output.
+func (cm *cmdImpl) finishPipe() {
+	// Unblock a parent still writing to a command that never started
+	if cm.pipeReader != nil {
+		cm.pipeReader.Close()
+	}
+
+	parentResult, parentErr := cm.parent.Wait()
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if r, ok := cm.result.(*resultImpl); ok {
+		status := []int{-1}
+		if parentResult != nil {
+			status = parentResult.PipeStatus()
+		}
+		r.pipeStatus = append(slices.Clone(status), r.exitCode)
+	}
+	if cm.err == nil && parentErr != nil && !brokenPipe(parentResult, parentErr) {
+		cm.err = parentErr
+	}
+	for _, closeFile := range cm.pipeClosers {
+		if err := closeFile(cm.err); cm.err == nil && err != nil {
+			cm.err = err
+		}
+	}
+}
+
+// brokenPipe reports whether a stage failed only because the stage reading
+// its output exited first, killing it with SIGPIPE or failing the copy of
+// its output with EPIPE.
+func brokenPipe(result Result, err error) bool {
+	if errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if result == nil {
+		return false
+	}
+	sig, ok := result.Signaled()
+	return ok && sig == syscall.SIGPIPE
+}
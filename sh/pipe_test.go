@@ -0,0 +1,217 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPipeStreamsConcurrently(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The consumer exits on the first line while the producer keeps running
+	cmd := sh.New("sh").
+		OptV("-c", "echo ready; exec sleep 10").
+		Build(ctx).
+		Pipe("head").OptV("-n", 1).Build()
+
+	start := time.Now()
+	var first string
+	for line := range cmd.Lines() {
+		first = line
+		break
+	}
+	if first != "ready" {
+		t.Fatalf("Expected 'ready', got %q", first)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected output before the producer exited, took %v", elapsed)
+	}
+	cmd.Cancel()
+	cmd.Wait()
+}
+
+func TestPipeMultiStage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("1").Arg("200000").
+		Build(ctx).
+		Pipe("grep").Arg("7").Build().
+		Pipe("wc").OptB("-l").Build()
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout())); got != "81902" {
+		t.Errorf("Expected 81902 matching lines, got %q", got)
+	}
+	if status := result.PipeStatus(); len(status) != 3 || status[0] != 0 || status[1] != 0 || status[2] != 0 {
+		t.Errorf("Expected three successful stages, got %v", status)
+	}
+}
+
+func TestPipeStatusReportsFailedStage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").OptV("-c", "echo data; exit 3").
+		Build(ctx).
+		Pipe("cat").Build()
+
+	result, err := cmd.Wait()
+	if err == nil {
+		t.Error("Expected the failing first stage to fail the pipeline")
+	}
+	if string(result.Stdout()) != "data\n" {
+		t.Errorf("Expected the last stage to still see the output, got %q", result.Stdout())
+	}
+	if status := result.PipeStatus(); len(status) != 2 || status[0] != 3 || status[1] != 0 {
+		t.Errorf("Expected status [3 0], got %v", status)
+	}
+}
+
+func TestPipeConsumerExitsEarly(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// yes never exits on its own; it must be stopped by the broken pipe
+	result, _ := sh.New("yes").Build(ctx).Pipe("head").OptV("-n", 2).Build().Run()
+	if string(result.Stdout()) != "y\ny\n" {
+		t.Errorf("Expected two lines, got %q", result.Stdout())
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the producer to stop without waiting for the timeout")
+	}
+}
+
+func TestPipeStageEnv(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, other := t.TempDir(), t.TempDir()
+	report := `cat; echo "$STAGE $TOKEN $(basename "$PWD")"`
+
+	cmd := sh.New("sh").OptV("-c", report).
+		Build(ctx).WithStdin(strings.NewReader("")).
+		WithEnv("STAGE", "one").WithEnv("TOKEN", "secret").WithDir(first).
+		Pipe("sh").OptV("-c", report).Build().
+		Pipe("sh").OptV("-c", report).WithEnv("STAGE", "three").WithoutEnv("TOKEN").WithDir(other).Build()
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	firstBase, otherBase := filepath.Base(first), filepath.Base(other)
+	want := "one secret " + firstBase + "\n" +
+		"one secret " + firstBase + "\n" +
+		"three  " + otherBase + "\n"
+	if string(result.Stdout()) != want {
+		t.Errorf("Expected per-stage settings:\n%s\ngot:\n%s", want, result.Stdout())
+	}
+}
+
+func TestPipeTeeTo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var seen bytes.Buffer
+	cmd := sh.New("printf").Arg("a\nb\nc\n").
+		Build(ctx).
+		Pipe("grep").Arg("-v").Arg("b").TeeTo(&seen).Build().
+		Pipe("wc").OptB("-l").Build()
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if got := result.TrimmedString(); got != "2" {
+		t.Errorf("Expected 2 lines at the end of the pipeline, got %q", got)
+	}
+	if got := seen.String(); got != "a\nb\nc\n" {
+		t.Errorf("Expected the input of grep to be copied, got %q", got)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestPipeTeeToFailureKeepsPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("seq").Arg("1").Arg("1000").
+		Build(ctx).
+		Pipe("wc").OptB("-l").TeeTo(failingWriter{}).Build()
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Expected a failing tee to be ignored, got %v", err)
+	}
+	if got := result.TrimmedString(); got != "1000" {
+		t.Errorf("Expected 1000 lines, got %q", got)
+	}
+}
+
+func TestPipeBrokenPipeIsNotAFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.RunString(ctx, "seq 1 200000 | head -n 1", sh.SafetyStrict)
+	if err != nil {
+		t.Fatalf("Expected the pipeline to succeed, got %v", err)
+	}
+	if string(result.Stdout()) != "1\n" {
+		t.Errorf("Expected the first line, got %q", result.Stdout())
+	}
+
+	result, err = sh.New("yes").Build(ctx).Pipe("head").OptV("-n", 1).Build().Run()
+	if err != nil {
+		t.Fatalf("Expected yes | head to succeed, got %v", err)
+	}
+	if string(result.Stdout()) != "y\n" {
+		t.Errorf("Expected one line, got %q", result.Stdout())
+	}
+}
+
+func TestPipeDoesNotKeepUpstreamOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	source := sh.New("seq").Arg("1").Arg("100000").Build(ctx)
+	result, err := source.Pipe("tail").OptV("-n", 1).Build().Run()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if string(result.Stdout()) != "100000\n" {
+		t.Errorf("Expected the last line, got %q", result.Stdout())
+	}
+	upstream, _ := source.Wait()
+	if n := len(upstream.Stdout()); n != 0 {
+		t.Errorf("Expected the upstream stage to keep no output, kept %d bytes", n)
+	}
+}
+
+func TestPipeKeepsBoundedUpstreamOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	source := sh.New("seq").Arg("1").Arg("100000").Build(ctx).WithMaxOutput(6)
+	if _, err := source.Pipe("tail").OptV("-n", 1).Build().Run(); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	upstream, _ := source.Wait()
+	if string(upstream.Stdout()) != "1\n2\n3\n" || !upstream.Truncated() {
+		t.Errorf("Expected the upstream stage to keep its first 6 bytes, got %q", upstream.Stdout())
+	}
+}
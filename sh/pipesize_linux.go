@@ -0,0 +1,21 @@
+package sh
+
+import (
+	"os"
+	"syscall"
+)
+
+// fSetPipeSize is F_SETPIPE_SZ, which package syscall does not define.
+const fSetPipeSize = 1031
+
+// setPipeSize grows the kernel buffer of the pipe f to at least n bytes, as
+// far as /proc/sys/fs/pipe-max-size allows for unprivileged processes.
+func setPipeSize(f *os.File, n int) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	raw.Control(func(fd uintptr) {
+		syscall.Syscall(syscall.SYS_FCNTL, fd, fSetPipeSize, uintptr(n))
+	})
+}
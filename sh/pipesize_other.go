@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sh
+
+import "os"
+
+// setPipeSize does nothing: pipe buffers can only be resized on Linux.
+func setPipeSize(*os.File, int) {}
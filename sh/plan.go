@@ -0,0 +1,275 @@
+package sh
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotApproved is returned by Run and Wait for a command marked with
+// WithApproval that was not approved with Approve.
+var ErrNotApproved = errors.New("sh: command not approved")
+
+// ErrPlanExpired is returned by Approve, and by Run and Wait in place of
+// ErrNotApproved, for a Plan created longer ago than the TTL set with
+// SetPlanTTL. It matches ErrNotApproved with errors.Is.
+var ErrPlanExpired = fmt.Errorf("%w: plan expired", ErrNotApproved)
+
+// ErrInvalidPlan is returned by Approve for a Plan whose signature does not
+// match, because it was altered or signed with another key.
+var ErrInvalidPlan = errors.New("sh: invalid plan")
+
+// Plan is the intent to run a command, for reviewing destructive commands
+// before they run: a program prints or stores the plan of a command marked
+// with WithApproval, a person or another system reviews it, and the program
+// passes it to Approve before running the same command. Plans are signed,
+// so that a plan altered after review is not approved, and expire after the
+// TTL set with SetPlanTTL.
+//
+//	cmd := sh.New("terraform").Arg("destroy").Arg("-auto-approve").Build(ctx).WithApproval()
+//	plan := cmd.Plan()
+//	// ... store the plan as JSON, have it reviewed and load it again
+//	if err := sh.Approve(plan); err != nil {
+//		return err
+//	}
+//	_, err := cmd.Run()
+type Plan struct {
+	// Command is the command line as String renders it, with secrets
+	// masked.
+	Command string `json:"command"`
+	Dir     string `json:"dir,omitempty"`
+	// Fingerprint identifies the exact command line, working directory,
+	// environment, stdin set with WithStdinString or WithStdinBytes, and
+	// options changing how the command runs such as WithElevation, secrets
+	// included.
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+	// Nonce tells plans of the same command apart, so that each permits
+	// one run however often it is approved.
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+func (p Plan) String() string {
+	s := p.Command
+	if p.Dir != "" {
+		s += " (in " + p.Dir + ")"
+	}
+	return s + " [" + p.Fingerprint[:min(len(p.Fingerprint), 16)] + "]"
+}
+
+// DefaultPlanTTL is how long plans can be approved and run after they were
+// created, unless changed with SetPlanTTL.
+const DefaultPlanTTL = time.Hour
+
+var plans = struct {
+	sync.Mutex
+	key      []byte
+	ttl      time.Duration
+	approved map[string]map[string]time.Time // fingerprint to the nonces of approved plans and when they were created
+	used     map[string]time.Time            // nonces of plans whose run has started, until they expire
+}{ttl: DefaultPlanTTL}
+
+// SetPlanKey sets the key plans are signed with. Without one, a random key
+// is used, which makes plans valid only within the process that created
+// them; programs approving plans in a later run must set the same key in
+// both.
+func SetPlanKey(key []byte) {
+	plans.Lock()
+	defer plans.Unlock()
+	plans.key = slices.Clone(key)
+}
+
+// SetPlanTTL sets how long plans can be approved and run after they were
+// created; older plans fail with ErrPlanExpired. A ttl of zero or less
+// lets plans be used at any time.
+func SetPlanTTL(ttl time.Duration) {
+	plans.Lock()
+	defer plans.Unlock()
+	plans.ttl = ttl
+}
+
+// planExpired reports whether a plan created at created can no longer be
+// used. The caller must hold plans.
+func planExpired(created time.Time) bool {
+	return plans.ttl > 0 && time.Since(created) > plans.ttl
+}
+
+// planKey returns the signing key. The caller must hold plans.
+func planKey() []byte {
+	if plans.key == nil {
+		plans.key = make([]byte, 32)
+		rand.Read(plans.key)
+	}
+	return plans.key
+}
+
+func (p Plan) sign(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range []string{p.Command, p.Dir, p.Fingerprint, p.Created.UTC().Format(time.RFC3339Nano), p.Nonce} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// Approve permits one run of the command p was created for. Approving the
+// same plan again has no effect, so a plan that was reviewed once permits
+// one run however often it is replayed; each run to approve needs a plan
+// of its own. Plans older than the TTL set with SetPlanTTL fail with
+// ErrPlanExpired.
+func Approve(p Plan) error {
+	plans.Lock()
+	defer plans.Unlock()
+	sig, err := hex.DecodeString(p.Signature)
+	if err != nil || !hmac.Equal(sig, p.sign(planKey())) {
+		return fmt.Errorf("%w: %s", ErrInvalidPlan, p.Command)
+	}
+	if planExpired(p.Created) {
+		return fmt.Errorf("sh: %s: %w", p.Command, ErrPlanExpired)
+	}
+	if _, used := plans.used[p.Nonce]; used {
+		return nil
+	}
+	if plans.approved == nil {
+		plans.approved = make(map[string]map[string]time.Time)
+	}
+	if plans.approved[p.Fingerprint] == nil {
+		plans.approved[p.Fingerprint] = make(map[string]time.Time)
+	}
+	plans.approved[p.Fingerprint][p.Nonce] = p.Created
+	return nil
+}
+
+// consumeApproval uses up an approval of the command with fingerprint. It
+// returns ErrPlanExpired if the only approvals left have expired, and
+// ErrNotApproved if there are none.
+func consumeApproval(fingerprint string) error {
+	plans.Lock()
+	defer plans.Unlock()
+	for nonce, created := range plans.used {
+		if planExpired(created) {
+			delete(plans.used, nonce)
+		}
+	}
+
+	err := ErrNotApproved
+	nonces := plans.approved[fingerprint]
+	for nonce, created := range nonces {
+		delete(nonces, nonce)
+		if planExpired(created) {
+			err = ErrPlanExpired
+			continue
+		}
+		if plans.used == nil {
+			plans.used = make(map[string]time.Time)
+		}
+		plans.used[nonce] = created
+		err = nil
+		break
+	}
+	if len(nonces) == 0 {
+		delete(plans.approved, fingerprint)
+	}
+	return err
+}
+
+func (cm *cmdImpl) WithApproval() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.approval = true
+	return cm
+}
+
+func (cm *cmdImpl) Plan() Plan {
+	p := Plan{
+		Command:     cm.String(),
+		Fingerprint: cm.planFingerprint(),
+		Created:     time.Now().UTC(),
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	p.Nonce = hex.EncodeToString(nonce)
+	cm.mu.RLock()
+	p.Dir = cm.dir
+	cm.mu.RUnlock()
+
+	plans.Lock()
+	defer plans.Unlock()
+	p.Signature = hex.EncodeToString(p.sign(planKey()))
+	return p
+}
+
+// planFingerprint identifies what the command runs and how, unlike
+// Fingerprint, which only covers the command line.
+func (cm *cmdImpl) planFingerprint() string {
+	// Lock for writing: reading stdin replaces it
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(cm.cmd)
+	for _, arg := range cm.args {
+		write(arg)
+	}
+	write("\x00arg0")
+	write(cm.arg0)
+	write("\x00dir")
+	write(cm.dir)
+	write("\x00env")
+	for _, key := range slices.Sorted(maps.Keys(cm.env)) {
+		write(key + "=" + cm.env[key])
+	}
+	write("\x00unset")
+	write(strings.Join(cm.envUnset, "\x00"))
+	write("\x00inherit")
+	write(fmt.Sprintf("%t %t %q", cm.envNoInherit, cm.scrubEnv, cm.envKeep))
+	write("\x00stdin")
+	if cm.stdin != nil {
+		// Input of unknown content, such as a file or pipe, is only told
+		// apart by its type
+		if stdin, err := cm.cacheStdin(); err == nil {
+			write(string(stdin))
+		} else {
+			write(fmt.Sprintf("%T", cm.stdin))
+		}
+	}
+	write("\x00options")
+	for _, tag := range cm.planTags {
+		write(tag)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// approver returns a function using up an approval of cm, to be called
+// right before the command starts, or nil if cm needs no approval. The
+// fingerprint is taken when approver is called, before stdin is handed to
+// the process. However often the returned function is called, as when
+// middleware retries the run, it uses up one approval.
+func (cm *cmdImpl) approver() func() error {
+	cm.mu.RLock()
+	needed := cm.approval
+	cm.mu.RUnlock()
+	if !needed {
+		return nil
+	}
+
+	fingerprint := cm.planFingerprint()
+	return sync.OnceValue(func() error {
+		if err := consumeApproval(fingerprint); err != nil {
+			return fmt.Errorf("sh: %s: %w", cm.cmd, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,178 @@
+package sh_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestPlanApprove(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("echo").Arg("destroy").Build(ctx).WithApproval()
+	if _, err := cmd.Run(); !errors.Is(err, sh.ErrNotApproved) {
+		t.Fatalf("Expected ErrNotApproved before approval, got %v", err)
+	}
+
+	// Plans survive serialization for review
+	data, err := json.Marshal(sh.New("echo").Arg("destroy").Build(ctx).Plan())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan sh.Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Command != "echo destroy" {
+		t.Errorf("Expected the rendered command, got %q", plan.Command)
+	}
+	if err := sh.Approve(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = sh.New("echo").Arg("destroy").Build(ctx).WithApproval()
+	if result, err := cmd.Run(); err != nil || result.TrimmedString() != "destroy" {
+		t.Fatalf("Expected the approved command to run, got %v", err)
+	}
+	cmd = sh.New("echo").Arg("destroy").Build(ctx).WithApproval()
+	if _, err := cmd.Run(); !errors.Is(err, sh.ErrNotApproved) {
+		t.Errorf("Expected an approval to permit one run, got %v", err)
+	}
+}
+
+func TestPlanDiffersFromApprovedCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plan := sh.New("echo").Arg("a").Build(ctx).Plan()
+	if err := sh.Approve(plan); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.New("echo").Arg("a").Build(ctx).WithApproval().Run() // use up the approval
+
+	for _, cmd := range []sh.Cmd{
+		sh.New("echo").Arg("b").Build(ctx),
+		sh.New("echo").Arg("a").Build(ctx).WithEnv("MODE", "force"),
+		sh.New("echo").Arg("a").Build(ctx).WithDir(t.TempDir()),
+		sh.New("echo").Arg("a").Build(ctx).WithStdinString("yes\n"),
+		sh.New("echo").Arg("a").Build(ctx).WithScrubbedEnv(),
+	} {
+		if _, err := cmd.WithApproval().Run(); !errors.Is(err, sh.ErrNotApproved) {
+			t.Errorf("Expected %s not to be approved, got %v", cmd, err)
+		}
+	}
+}
+
+func TestPlanApprovalKeptByDryRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	build := func() sh.Cmd {
+		return sh.New("cat").Build(ctx).WithStdinString("approved input").WithApproval()
+	}
+	if err := sh.Approve(build().Plan()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run starts nothing, so the approval is left for the real run
+	if _, err := build().WithDryRun().Run(); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	result, err := build().Run()
+	if err != nil {
+		t.Fatalf("Expected the approval to be left for the real run, got %v", err)
+	}
+	if string(result.Stdout()) != "approved input" {
+		t.Errorf("Expected stdin to reach the approved command, got %q", result.Stdout())
+	}
+}
+
+func TestApproveRejectsTamperedPlan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plan := sh.New("echo").Arg("harmless").Build(ctx).Plan()
+	plan.Command = "echo other"
+	if err := sh.Approve(plan); !errors.Is(err, sh.ErrInvalidPlan) {
+		t.Errorf("Expected ErrInvalidPlan for an altered plan, got %v", err)
+	}
+	plan.Signature = "nothex"
+	if err := sh.Approve(plan); !errors.Is(err, sh.ErrInvalidPlan) {
+		t.Errorf("Expected ErrInvalidPlan for a bad signature, got %v", err)
+	}
+}
+
+func TestSetPlanKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sh.SetPlanKey([]byte("first"))
+	plan := sh.New("echo").Build(ctx).Plan()
+	sh.SetPlanKey([]byte("second"))
+	defer sh.SetPlanKey(nil)
+	if err := sh.Approve(plan); !errors.Is(err, sh.ErrInvalidPlan) {
+		t.Errorf("Expected plans signed with another key to be rejected, got %v", err)
+	}
+}
+
+func TestApproveSamePlanTwice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plan := sh.New("echo").Arg("once").Build(ctx).Plan()
+	for range 2 {
+		if err := sh.Approve(plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sh.New("echo").Arg("once").Build(ctx).WithApproval().Run(); err != nil {
+		t.Fatalf("Expected the approved command to run, got %v", err)
+	}
+	if _, err := sh.New("echo").Arg("once").Build(ctx).WithApproval().Run(); !errors.Is(err, sh.ErrNotApproved) {
+		t.Errorf("Expected a plan approved twice to permit one run, got %v", err)
+	}
+
+	// Nor does approving it again after the run permit another
+	if err := sh.Approve(plan); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sh.New("echo").Arg("once").Build(ctx).WithApproval().Run(); !errors.Is(err, sh.ErrNotApproved) {
+		t.Errorf("Expected a replayed plan not to permit another run, got %v", err)
+	}
+
+	// A new plan of the same command permits another run
+	if err := sh.Approve(sh.New("echo").Arg("once").Build(ctx).Plan()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sh.New("echo").Arg("once").Build(ctx).WithApproval().Run(); err != nil {
+		t.Errorf("Expected a second plan to permit a second run, got %v", err)
+	}
+}
+
+func TestSetPlanTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sh.SetPlanTTL(50 * time.Millisecond)
+	defer sh.SetPlanTTL(sh.DefaultPlanTTL)
+
+	if err := sh.Approve(sh.New("echo").Arg("stale").Build(ctx).Plan()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	_, err := sh.New("echo").Arg("stale").Build(ctx).WithApproval().Run()
+	if !errors.Is(err, sh.ErrPlanExpired) || !errors.Is(err, sh.ErrNotApproved) {
+		t.Errorf("Expected an expired plan to be refused when run, got %v", err)
+	}
+
+	plan := sh.New("echo").Arg("stale").Build(ctx).Plan()
+	time.Sleep(100 * time.Millisecond)
+	if err := sh.Approve(plan); !errors.Is(err, sh.ErrPlanExpired) {
+		t.Errorf("Expected an expired plan to be refused by Approve, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package sh
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync/atomic"
+)
+
+// Policy restricts what commands may run, as a guardrail for programs that
+// run commands influenced by user input. It is enforced when a command
+// runs, after its executable has been looked up in PATH; a command that
+// violates it fails with a *PolicyError without being started, and also
+// when it would only be printed by a dry run or replayed from a cache. Set
+// it with SetPolicy.
+type Policy struct {
+	// Allow lists the executables that may run, by absolute path or by
+	// name such as "git". A name only allows the command when it is looked
+	// up in PATH. An empty list allows all executables not denied.
+	Allow []string
+	// Deny lists executables that may never run, by absolute path or by
+	// name, which also matches the executable given by path.
+	Deny []string
+	// DenyArgs refuses commands with an argument matching any of the
+	// patterns, e.g. `^--upload-pack` for git.
+	DenyArgs []*regexp.Regexp
+	// DenyEnv lists variables that commands may not be given with WithEnv
+	// and similar, as path.Match patterns such as "LD_*". Variables
+	// inherited from the current process are not checked.
+	DenyEnv []string
+	// RequireAbsolutePath refuses commands not named by an absolute path,
+	// so that PATH plays no part in choosing the executable.
+	RequireAbsolutePath bool
+	// Pinned maps executable names to the absolute paths they must resolve
+	// to through PATH, e.g. {"git": "/usr/bin/git"}, to catch a tampered
+	// PATH.
+	Pinned map[string]string
+	// Authorize decides whether the caller may run the command line argv,
+	// after the other rules allowed it. The caller is the Identity of the
+	// context the command was built with, or the zero Identity if it has
+	// none. A non-nil error refuses the command, for per-command
+	// role-based access control in servers running commands for others.
+	Authorize func(caller Identity, argv []string) error
+}
+
+// PolicyError is returned by Run and Wait for a command refused by the
+// Policy.
+type PolicyError struct {
+	// Cmd is the name of the command.
+	Cmd string
+	// Rule is the Policy field that refused the command, e.g. "Allow".
+	Rule string
+	// Reason explains the refusal.
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("sh: %s: refused by policy (%s): %s", e.Cmd, e.Rule, e.Reason)
+}
+
+// Is reports the error as ErrPolicy, like rejections by a Runner's Policy.
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicy
+}
+
+// activePolicy is the policy set with SetPolicy, if any.
+var activePolicy atomic.Pointer[Policy]
+
+// SetPolicy enforces p on all commands run from now on. The policy must not
+// be modified afterwards. Passing nil removes the policy.
+func SetPolicy(p *Policy) {
+	activePolicy.Store(p)
+}
+
+// check returns a *PolicyError if the command cm, prepared as cmd,
+// violates the policy.
+func (p *Policy) check(cm *cmdImpl, cmd *exec.Cmd) error {
+	refuse := func(rule, format string, args ...any) error {
+		return &PolicyError{Cmd: cm.cmd, Rule: rule, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if p.RequireAbsolutePath && !filepath.IsAbs(cm.cmd) {
+		return refuse("RequireAbsolutePath", "%q is not an absolute path", cm.cmd)
+	}
+	if want, ok := p.Pinned[cm.cmd]; ok && cmd.Err == nil && filepath.Clean(cmd.Path) != filepath.Clean(want) {
+		return refuse("Pinned", "resolved to %s instead of %s", cmd.Path, want)
+	}
+	if matchesExecutable(p.Deny, cm.cmd, cmd.Path, false) {
+		return refuse("Deny", "executable %s is denied", cmd.Path)
+	}
+	if len(p.Allow) > 0 && !matchesExecutable(p.Allow, cm.cmd, cmd.Path, true) {
+		return refuse("Allow", "executable %s is not allowed", cmd.Path)
+	}
+	for _, arg := range cm.args {
+		for _, re := range p.DenyArgs {
+			if re.MatchString(arg) {
+				return refuse("DenyArgs", "argument %q matches %s", arg, re)
+			}
+		}
+	}
+	for key := range cm.env {
+		for _, pattern := range p.DenyEnv {
+			if ok, _ := path.Match(pattern, key); ok {
+				return refuse("DenyEnv", "variable %s matches %s", key, pattern)
+			}
+		}
+	}
+	if p.Authorize != nil {
+		caller, _ := IdentityFromContext(cm.ctx)
+		if err := p.Authorize(caller, append([]string{cm.cmd}, cm.args...)); err != nil {
+			name := caller.Name
+			if name == "" {
+				name = "anonymous caller"
+			}
+			return refuse("Authorize", "%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// matchesExecutable reports whether any of list names the command.
+// Absolute paths match the resolved path. Names match the base name of the
+// resolved path, or with strict only commands looked up in PATH by that
+// name, so that "./git" is not taken for "git".
+func matchesExecutable(list []string, name, resolved string, strict bool) bool {
+	return slices.ContainsFunc(list, func(entry string) bool {
+		if filepath.IsAbs(entry) {
+			return filepath.Clean(entry) == filepath.Clean(resolved)
+		}
+		if strict {
+			return entry == name
+		}
+		return entry == filepath.Base(resolved)
+	})
+}
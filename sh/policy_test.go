@@ -0,0 +1,98 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestSetPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	echo, err := exec.LookPath("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.SetPolicy(&sh.Policy{
+		Allow:    []string{"echo", "true", "/bin/sh"},
+		Deny:     []string{"true"},
+		DenyArgs: []*regexp.Regexp{regexp.MustCompile(`^--exec`)},
+		DenyEnv:  []string{"LD_*"},
+		Pinned:   map[string]string{"echo": echo},
+	})
+	defer sh.SetPolicy(nil)
+
+	for _, tt := range []struct {
+		cmd  sh.Cmd
+		rule string
+	}{
+		{sh.New("echo").Arg("ok").Build(ctx), ""},
+		{sh.New("sh").OptV("-c", "exit 0").Build(ctx), "Allow"},
+		{sh.New("/bin/sh").OptV("-c", "exit 0").Build(ctx), ""},
+		{sh.New("true").Build(ctx), "Deny"},
+		{sh.New("echo").Arg("--exec=x").Build(ctx), "DenyArgs"},
+		{sh.New("echo").Build(ctx).WithEnv("LD_PRELOAD", "x.so"), "DenyEnv"},
+		// A pipeline is checked stage by stage
+		{sh.New("echo").Build(ctx).Pipe("cat").Build(), "Allow"},
+	} {
+		result, err := tt.cmd.Run()
+		var policyErr *sh.PolicyError
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.cmd, err)
+		case tt.rule == "":
+		case !errors.As(err, &policyErr):
+			t.Errorf("%s: expected a PolicyError, got %v", tt.cmd, err)
+		case policyErr.Rule != tt.rule || !errors.Is(err, sh.ErrPolicy):
+			t.Errorf("%s: expected rule %s, got %v", tt.cmd, tt.rule, err)
+		case result.ExitCode() != -1:
+			t.Errorf("%s: expected the command not to run, got exit code %d", tt.cmd, result.ExitCode())
+		}
+	}
+}
+
+func TestSetPolicyPaths(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sh.SetPolicy(&sh.Policy{RequireAbsolutePath: true})
+	if _, err := sh.New("true").Build(ctx).Run(); err == nil {
+		t.Error("Expected a command looked up in PATH to be refused")
+	}
+	sh.SetPolicy(&sh.Policy{Pinned: map[string]string{"true": "/nonexistent/true"}})
+	if _, err := sh.New("true").Build(ctx).Run(); err == nil {
+		t.Error("Expected a command resolving elsewhere to be refused")
+	}
+
+	sh.SetPolicy(nil)
+	if _, err := sh.New("true").Build(ctx).Run(); err != nil {
+		t.Errorf("Expected no policy after SetPolicy(nil), got %v", err)
+	}
+}
+
+func TestSetPolicyAppliesToDryRunAndCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := sh.NewDirCache(t.TempDir())
+	if _, err := sh.New("echo").Arg("cached").Build(ctx).WithCache(cache).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	sh.SetPolicy(&sh.Policy{Deny: []string{"echo"}})
+	defer sh.SetPolicy(nil)
+
+	var policyErr *sh.PolicyError
+	if _, err := sh.New("echo").Arg("cached").Build(ctx).WithCache(cache).Run(); !errors.As(err, &policyErr) {
+		t.Errorf("Expected a cached result of a denied command to be refused, got %v", err)
+	}
+	if _, err := sh.New("echo").Arg("dry").Build(ctx).WithDryRun().Run(); !errors.As(err, &policyErr) {
+		t.Errorf("Expected a dry run of a denied command to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func (cm *cmdImpl) WithDropPrivileges(string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: drop privileges: %w", errors.ErrUnsupported)
+		},
+	})
+	return cm
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithDropPrivileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no 'nobody' user on this system")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Setenv("SH_ROOT_SECRET", "hunter2")
+
+	result, err := sh.New("sh").
+		OptV("-c", "id -u; env").
+		Build(ctx).
+		WithDropPrivileges("nobody").
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v: %s", err, result.Stderr())
+	}
+
+	output := string(result.Stdout())
+	if uid, _, _ := strings.Cut(output, "\n"); uid != nobody.Uid {
+		t.Errorf("Expected uid %s, got %s", nobody.Uid, uid)
+	}
+	if strings.Contains(output, "SH_ROOT_SECRET") {
+		t.Errorf("Expected host environment to be dropped, got: %s", output)
+	}
+	if !strings.Contains(output, "USER=nobody") {
+		t.Errorf("Expected USER=nobody in environment, got: %s", output)
+	}
+}
+
+func TestCmdWithDropPrivilegesUnknownUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := sh.New("true").
+		Build(ctx).
+		WithDropPrivileges("sh-no-such-user").
+		Run()
+	if err == nil {
+		t.Error("Expected error for unknown user")
+	}
+}
@@ -0,0 +1,82 @@
+//go:build unix
+
+package sh
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+func (cm *cmdImpl) WithDropPrivileges(username string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cred, u, err := lookupCredential(username)
+	if err == nil {
+		// Start from a minimal environment, keeping explicitly set variables.
+		cm.scrubEnv = true
+		cm.envKeep = nil
+		for k, v := range map[string]string{
+			"HOME":    u.HomeDir,
+			"USER":    u.Username,
+			"LOGNAME": u.Username,
+			"PATH":    "/usr/local/bin:/usr/bin:/bin",
+		} {
+			if _, ok := cm.env[k]; !ok {
+				cm.env[k] = v
+			}
+		}
+	}
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			if err != nil {
+				return fmt.Errorf("sh: drop privileges: %w", err)
+			}
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			// The kernel clears all capabilities when switching from root
+			// to an unprivileged uid, except ambient capabilities that were
+			// explicitly requested with WithAmbientCaps.
+			cmd.SysProcAttr.Credential = cred
+			return nil
+		},
+	})
+	return cm
+}
+
+// lookupCredential returns the credential of the named user, including all
+// of their supplementary groups.
+func lookupCredential(username string) (*syscall.Credential, *user.User, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has non-numeric uid %q", username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has non-numeric gid %q", username, u.Gid)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range groupIDs {
+		if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+			cred.Groups = append(cred.Groups, uint32(g))
+		}
+	}
+
+	return cred, u, nil
+}
@@ -0,0 +1,36 @@
+//go:build !unix
+
+package sh
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// processMatches cannot tell whether the running process pid is the one
+// recorded in a PidRegistry without a process table to inspect.
+func processMatches(int, string, uint64) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// processStartTime is not available without a process table.
+func processStartTime(int) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// processStartedAfter cannot tell when a process started without a process
+// table, so it reports false.
+func processStartedAfter(int, time.Time) bool {
+	return false
+}
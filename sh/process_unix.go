@@ -0,0 +1,101 @@
+//go:build unix
+
+package sh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processMatches reports whether the running process pid is the one
+// recorded with the given start time or, for entries without one, started
+// from the executable name. It fails if /proc is not there to tell.
+func processMatches(pid int, name string, start uint64) (bool, error) {
+	if start != 0 {
+		got, err := processStartTime(pid)
+		if err != nil {
+			return false, err
+		}
+		return got == start, nil
+	}
+
+	cmdline, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return false, err
+	}
+
+	arg0, _, _ := bytes.Cut(cmdline, []byte{0})
+	return filepath.Base(string(arg0)) == filepath.Base(name), nil
+}
+
+// processStartTime returns when the process pid started, in clock ticks
+// since boot, from /proc/<pid>/stat.
+func processStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name in parentheses may contain spaces; starttime is
+	// the 22nd field and the 20th after it
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat.
+const clockTicks = 100
+
+// processStartedAfter reports whether the process pid started after t,
+// which means it only reuses the PID of a process recorded at t. It
+// reports false when the start time cannot be read.
+func processStartedAfter(pid int, t time.Time) bool {
+	ticks, err := processStartTime(pid)
+	if err != nil {
+		return false
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return false
+	}
+	started := boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+	// The boot time is only known to the second
+	return started.After(t.Add(time.Second))
+}
+
+// bootTime returns when the system booted, from /proc/stat.
+func bootTime() (time.Time, error) {
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("no btime in /proc/stat")
+}
@@ -0,0 +1,250 @@
+// Package prompt asks questions for command-line tools built on package
+// sh: yes/no confirmations, choices from a list and validated text input.
+// It uses the terminal handling of package sh, so raw mode is restored by
+// sh.RestoreTerminals and on signals like that of commands run with
+// WithInteractive or WithPTY.
+//
+//	if ok, err := prompt.Confirm("Deploy to production?", false); err != nil || !ok {
+//		return err
+//	}
+//	env, err := prompt.Select("Environment", []string{"staging", "production"})
+//
+// When input is not a terminal, such as a pipe in tests or CI, answers are
+// read line by line.
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// ErrInterrupted is returned when the user presses ^C while choosing with
+// Select on a terminal.
+var ErrInterrupted = errors.New("prompt: interrupted")
+
+// Prompter asks questions on an input and output, typically a terminal.
+type Prompter struct {
+	in  *bufio.Reader
+	tty *os.File // in, if it is a terminal
+	out io.Writer
+}
+
+// New returns a Prompter reading answers from in and writing questions to
+// out. Select lets the user choose with the arrow keys if in is a terminal.
+func New(in io.Reader, out io.Writer) *Prompter {
+	p := &Prompter{in: bufio.NewReader(in), out: out}
+	if f, ok := in.(*os.File); ok && sh.IsTerminal(f) {
+		p.tty = f
+	}
+	return p
+}
+
+// Default asks on stdin and stderr, keeping stdout free for output.
+var Default = New(os.Stdin, os.Stderr)
+
+// Confirm asks a yes/no question with Default.
+func Confirm(question string, def bool) (bool, error) {
+	return Default.Confirm(question, def)
+}
+
+// Input asks for text with Default.
+func Input(question string, opts ...InputOption) (string, error) {
+	return Default.Input(question, opts...)
+}
+
+// Select asks for a choice with Default.
+func Select(question string, options []string) (int, error) {
+	return Default.Select(question, options)
+}
+
+// Confirm asks a yes/no question until answered with y, yes, n or no in
+// any case. An empty answer picks def.
+func (p *Prompter) Confirm(question string, def bool) (bool, error) {
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s %s ", question, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Please answer yes or no.")
+	}
+}
+
+// InputOption configures Input.
+type InputOption func(*inputConfig)
+
+type inputConfig struct {
+	def      string
+	validate func(string) error
+}
+
+// WithDefault sets the answer used when the user enters nothing.
+func WithDefault(value string) InputOption {
+	return func(c *inputConfig) {
+		c.def = value
+	}
+}
+
+// WithValidation checks every answer; Input shows the error and asks
+// again until validate accepts one.
+func WithValidation(validate func(string) error) InputOption {
+	return func(c *inputConfig) {
+		c.validate = validate
+	}
+}
+
+// Input asks for a line of text, trimmed of surrounding whitespace.
+func (p *Prompter) Input(question string, opts ...InputOption) (string, error) {
+	var cfg inputConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	for {
+		if cfg.def != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, cfg.def)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		answer, err := p.readLine()
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			answer = cfg.def
+		}
+		if cfg.validate != nil {
+			if err := cfg.validate(answer); err != nil {
+				fmt.Fprintf(p.out, "Invalid answer: %v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// Select asks for one of options and returns its index. On a terminal the
+// user moves through the list with the arrow keys or j and k and picks
+// with enter; otherwise the options are numbered and the answer is read
+// as a number.
+func (p *Prompter) Select(question string, options []string) (int, error) {
+	if len(options) == 0 {
+		return -1, errors.New("prompt: no options to select from")
+	}
+	if p.tty != nil {
+		if restore, err := sh.MakeRaw(p.tty); err == nil {
+			defer restore()
+			return p.selectKeys(question, options)
+		}
+	}
+
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	for {
+		fmt.Fprintf(p.out, "Choose 1-%d: ", len(options))
+		answer, err := p.readLine()
+		if err != nil {
+			return -1, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+	}
+}
+
+// selectKeys lets the user pick an option with the keyboard while the
+// terminal is in raw mode, where output needs explicit carriage returns.
+func (p *Prompter) selectKeys(question string, options []string) (int, error) {
+	fmt.Fprintf(p.out, "%s\r\n", question)
+	selected := 0
+	render := func() {
+		for i, option := range options {
+			marker := "  "
+			if i == selected {
+				marker = "> "
+			}
+			fmt.Fprintf(p.out, "\x1b[2K%s%s\r\n", marker, option)
+		}
+	}
+	render()
+
+	for {
+		key, err := p.readKey()
+		if err != nil {
+			return -1, err
+		}
+		switch key {
+		case "\r", "\n":
+			return selected, nil
+		case "\x03":
+			return -1, ErrInterrupted
+		case "\x1b[A", "k":
+			selected = (selected + len(options) - 1) % len(options)
+		case "\x1b[B", "j":
+			selected = (selected + 1) % len(options)
+		default:
+			continue
+		}
+		// Move back up over the list and draw it again
+		fmt.Fprintf(p.out, "\x1b[%dA", len(options))
+		render()
+	}
+}
+
+// readKey reads a key press: a single byte or an escape sequence such as
+// an arrow key.
+func (p *Prompter) readKey() (string, error) {
+	b, err := p.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if b != 0x1b {
+		return string(b), nil
+	}
+	// CSI sequences end in a byte from @ to ~
+	seq := []byte{b}
+	for p.in.Buffered() > 0 || len(seq) < 3 {
+		next, err := p.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seq = append(seq, next)
+		if len(seq) >= 3 && next >= '@' && next <= '~' {
+			break
+		}
+	}
+	return string(seq), nil
+}
+
+// readLine reads an answer. The end of input without an answer is
+// reported as io.ErrUnexpectedEOF.
+func (p *Prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
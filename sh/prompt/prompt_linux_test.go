@@ -0,0 +1,78 @@
+package prompt_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/prompt"
+)
+
+// openTestPTY returns both ends of a new pseudo-terminal in raw mode.
+func openTestPTY(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock int32
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatal(errno)
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	// Drain the output so that writes to the terminal never block
+	go io.Copy(io.Discard, master)
+
+	// Keys typed ahead must not be taken as lines or signals before Select
+	// makes the terminal raw
+	restore, err := sh.MakeRaw(slave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { restore() })
+	return master, slave
+}
+
+func TestSelectKeys(t *testing.T) {
+	master, slave := openTestPTY(t)
+	p := prompt.New(slave, slave)
+
+	// Down twice wraps around to the first option, then up to the last
+	if _, err := master.WriteString("\x1b[Bj\x1b[A\x1b[A\r"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Select("Environment", []string{"dev", "staging", "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("Expected 0, got %d", got)
+	}
+}
+
+func TestSelectKeysInterrupted(t *testing.T) {
+	master, slave := openTestPTY(t)
+	p := prompt.New(slave, slave)
+
+	if _, err := master.WriteString("j\x03"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Select("Environment", []string{"dev", "prod"}); !errors.Is(err, prompt.ErrInterrupted) {
+		t.Errorf("Expected ErrInterrupted, got %v", err)
+	}
+}
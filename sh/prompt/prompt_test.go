@@ -0,0 +1,116 @@
+package prompt_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benoctopus/pkg/sh/prompt"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		def   bool
+		want  bool
+	}{
+		{"y\n", false, true},
+		{"YES\n", false, true},
+		{"n\n", true, false},
+		{"\n", true, true},
+		{"\n", false, false},
+		{"maybe\nyes\n", false, true},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		got, err := prompt.New(strings.NewReader(tt.input), &out).Confirm("Continue?", tt.def)
+		if err != nil {
+			t.Fatalf("Confirm(%q) failed: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("Confirm(%q, %v) = %v, want %v", tt.input, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmReasks(t *testing.T) {
+	var out strings.Builder
+	prompt.New(strings.NewReader("maybe\nn\n"), &out).Confirm("Continue?", false)
+	if got := strings.Count(out.String(), "Continue? [y/N]"); got != 2 {
+		t.Errorf("Expected the question twice, got:\n%s", out.String())
+	}
+}
+
+func TestConfirmEOF(t *testing.T) {
+	_, err := prompt.New(strings.NewReader(""), io.Discard).Confirm("Continue?", true)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestInputValidation(t *testing.T) {
+	var out strings.Builder
+	p := prompt.New(strings.NewReader("\n  bob  \n"), &out)
+	got, err := p.Input("Name", prompt.WithValidation(func(s string) error {
+		if s == "" {
+			return errors.New("name is required")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "bob" {
+		t.Errorf("Expected bob, got %q", got)
+	}
+	if !strings.Contains(out.String(), "name is required") {
+		t.Errorf("Expected the validation error to be shown, got:\n%s", out.String())
+	}
+}
+
+func TestInputDefault(t *testing.T) {
+	var out strings.Builder
+	got, err := prompt.New(strings.NewReader("\n"), &out).Input("Region", prompt.WithDefault("eu-west-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "eu-west-1" {
+		t.Errorf("Expected the default, got %q", got)
+	}
+	if !strings.Contains(out.String(), "Region [eu-west-1]: ") {
+		t.Errorf("Expected the default in the question, got %q", out.String())
+	}
+}
+
+func TestSelectLines(t *testing.T) {
+	var out strings.Builder
+	p := prompt.New(strings.NewReader("7\nx\n2\n"), &out)
+	got, err := p.Select("Environment", []string{"staging", "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("Expected 1, got %d", got)
+	}
+	if !strings.Contains(out.String(), "  2) production\n") {
+		t.Errorf("Expected numbered options, got:\n%s", out.String())
+	}
+}
+
+func TestSelectNoOptions(t *testing.T) {
+	if _, err := prompt.New(strings.NewReader("1\n"), io.Discard).Select("Pick", nil); err == nil {
+		t.Error("Expected an error without options")
+	}
+}
+
+func TestSequentialQuestions(t *testing.T) {
+	// Answers buffered for one question must remain for the next
+	p := prompt.New(strings.NewReader("y\nalice\n"), io.Discard)
+	if ok, err := p.Confirm("Continue?", false); err != nil || !ok {
+		t.Fatalf("Confirm = %v, %v", ok, err)
+	}
+	if name, err := p.Input("Name"); err != nil || name != "alice" {
+		t.Fatalf("Input = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,313 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// openPTY allocates a new pseudo-terminal pair and returns its master and
+// slave ends.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlock pty: %w", err)
+	}
+
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("get pty number: %w", err)
+	}
+
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	trackPTY(master.Fd())
+	trackPTY(slave.Fd())
+	return master, slave, nil
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// controllingTerminalHook returns a hook that gives the child a fresh session
+// whose controlling terminal is a pseudo-terminal bridged to tty. A tty that
+// is itself a terminal is put into raw mode while the command runs, so that
+// echo, line editing and signal keys are left to the pseudo-terminal.
+func controllingTerminalHook(tty io.ReadWriter) execHook {
+	var master, slave, devTTY *os.File
+	var term io.ReadWriter
+	var restore func() error
+	var stopResize func()
+	var copied, read chan struct{}
+
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			term, devTTY, restore, stopResize = tty, nil, nil, func() {}
+			copied, read = make(chan struct{}), make(chan struct{})
+			if term == nil {
+				f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+				if err != nil {
+					return fmt.Errorf("open controlling terminal: %w", err)
+				}
+				devTTY, term = f, f
+			} else if _, ok := term.(interface{ SetReadDeadline(time.Time) error }); !ok {
+				// Nothing could stop the copy of tty to the terminal once
+				// the command exits, so it would keep taking our input
+				return fmt.Errorf("controlling terminal: %T has no SetReadDeadline method", term)
+			}
+
+			var err error
+			master, slave, err = openPTY()
+			if err != nil {
+				if devTTY != nil {
+					devTTY.Close()
+				}
+				return err
+			}
+
+			if f, ok := term.(*os.File); ok && IsTerminal(f) {
+				// Otherwise the real terminal echoes what the child reads
+				// with echo off, such as passwords, and handles ^C itself
+				if restore, err = SaveTerminal(f); err == nil {
+					if _, err = makeRaw(f); err != nil {
+						restore()
+						restore = nil
+					}
+				}
+				if err != nil {
+					releasePTY(slave.Fd())
+					slave.Close()
+					releasePTY(master.Fd())
+					master.Close()
+					master, slave = nil, nil
+					if devTTY != nil {
+						devTTY.Close()
+					}
+					return fmt.Errorf("raw mode: %w", err)
+				}
+				copyWindowSize(master, f)
+				stopResize = forwardWindowSize(master, f)
+			}
+
+			// The slave is passed as the first extra file, so it is
+			// descriptor 3 + its index in the child.
+			cmd.ExtraFiles = append(cmd.ExtraFiles, slave)
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			// A new session is also a new process group; asking for
+			// both would fail with EPERM.
+			cmd.SysProcAttr.Setsid = true
+			cmd.SysProcAttr.Setpgid = false
+			cmd.SysProcAttr.Setctty = true
+			cmd.SysProcAttr.Ctty = 2 + len(cmd.ExtraFiles)
+
+			pty, t, copiedDone, readDone := master, term, copied, read
+			goSafe(func() {
+				defer close(copiedDone)
+				io.Copy(t, pty)
+			})
+			goSafe(func() {
+				defer close(readDone)
+				io.Copy(pty, t)
+			})
+			return nil
+		},
+		after: func(error) error {
+			stopResize()
+			if slave != nil {
+				releasePTY(slave.Fd())
+				slave.Close()
+				slave = nil
+			}
+			if master != nil {
+				// Let pending terminal output drain unless a background
+				// process still holds the terminal open.
+				select {
+				case <-copied:
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			// Stop reading the terminal, which would otherwise take input
+			// meant for this process once the command has exited
+			if d, ok := term.(interface{ SetReadDeadline(time.Time) error }); ok && d.SetReadDeadline(time.Now()) == nil {
+				<-read
+				d.SetReadDeadline(time.Time{})
+			}
+			if restore != nil {
+				restore()
+				restore = nil
+			}
+			if devTTY != nil {
+				devTTY.Close()
+				devTTY = nil
+			}
+
+			if master != nil {
+				releasePTY(master.Fd())
+				master.Close()
+				master = nil
+			}
+			return nil
+		},
+	}
+}
+
+// ptyHook returns a hook that connects the child's stdin, stdout and stderr
+// to a new pseudo-terminal that is also its controlling terminal. Output is
+// copied to the configured stdout writers and the configured stdin is copied
+// to the terminal. If stdin is itself a terminal it is put into raw mode and
+// window size changes are propagated while the command runs.
+func ptyHook() execHook {
+	var master, slave *os.File
+	var restore func() error
+	var stopResize func()
+	var copied chan struct{}
+
+	closeSlave := func() error {
+		if slave == nil {
+			return nil
+		}
+		releasePTY(slave.Fd())
+		err := slave.Close()
+		slave = nil
+		return err
+	}
+	closeMaster := func() {
+		if master != nil {
+			releasePTY(master.Fd())
+			master.Close()
+			master = nil
+		}
+	}
+
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			restore, stopResize = nil, func() {}
+			copied = make(chan struct{})
+
+			var err error
+			master, slave, err = openPTY()
+			if err != nil {
+				return err
+			}
+
+			in, out := cmd.Stdin, cmd.Stdout
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.Setsid = true
+			cmd.SysProcAttr.Setpgid = false
+			cmd.SysProcAttr.Setctty = true
+			cmd.SysProcAttr.Ctty = 0
+
+			if f, ok := in.(*os.File); ok && IsTerminal(f) {
+				// Saving registers the state to be restored even if
+				// this process is killed while the command runs
+				if restore, err = SaveTerminal(f); err == nil {
+					if _, err = makeRaw(f); err != nil {
+						restore()
+						restore = nil
+					}
+				}
+				if err != nil {
+					closeSlave()
+					closeMaster()
+					return fmt.Errorf("raw mode: %w", err)
+				}
+				copyWindowSize(master, f)
+				stopResize = forwardWindowSize(master, f)
+			}
+
+			// The goroutines outlive this run's variables if a later run
+			// reuses the hook
+			pty, done := master, copied
+			goSafe(func() {
+				defer close(done)
+				// Reading the master fails with EIO once the child exits
+				if out != nil {
+					io.Copy(out, pty)
+				} else {
+					io.Copy(io.Discard, pty)
+				}
+			})
+			if in != nil {
+				goSafe(func() {
+					// Fails once after has closed the master
+					if _, err := io.Copy(pty, in); err == nil {
+						// Signal end of input like ^D on a terminal
+						pty.Write([]byte{4})
+					}
+				})
+			}
+			return nil
+		},
+		started: func(*exec.Cmd) error {
+			// Only the child may hold the slave, so the master sees EIO
+			// once the child exits
+			return closeSlave()
+		},
+		after: func(error) error {
+			stopResize()
+			if restore != nil {
+				restore()
+			}
+			// The slave is still open if the command failed to start
+			closeSlave()
+			select {
+			case <-copied:
+			case <-time.After(100 * time.Millisecond):
+			}
+			closeMaster()
+			return nil
+		},
+	}
+}
+
+// forwardWindowSize copies the window size of src to the pseudo-terminal
+// master on every SIGWINCH until the returned function is called.
+func forwardWindowSize(master, src *os.File) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	done := make(chan struct{})
+
+	goSafe(func() {
+		for {
+			select {
+			case <-ch:
+				copyWindowSize(master, src)
+			case <-done:
+				return
+			}
+		}
+	})
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,141 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+type ttyBuffer struct {
+	mu  sync.Mutex
+	in  *strings.Reader
+	out bytes.Buffer
+}
+
+func (t *ttyBuffer) Read(p []byte) (int, error) {
+	return t.in.Read(p)
+}
+
+func (t *ttyBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.out.Write(p)
+}
+
+func (t *ttyBuffer) SetReadDeadline(time.Time) error {
+	// Reads never block
+	return nil
+}
+
+func (t *ttyBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.out.String()
+}
+
+func TestCmdWithControllingTerminal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tty := &ttyBuffer{in: strings.NewReader("")}
+	cmd := sh.New("sh").
+		OptV("-c", "echo prompt > /dev/tty; cat").
+		Build(ctx).
+		WithStdin(strings.NewReader("piped data")).
+		WithControllingTerminal(tty)
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	// Data should still flow through the regular pipes
+	output := strings.TrimSpace(string(result.Stdout()))
+	if output != "piped data" {
+		t.Errorf("Expected 'piped data' on stdout, got '%s'", output)
+	}
+
+	// The prompt should have been written to the terminal instead
+	if !strings.Contains(tty.String(), "prompt") {
+		t.Errorf("Expected terminal to receive 'prompt', got '%s'", tty.String())
+	}
+}
+
+func TestCmdWithPTY(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").
+		OptV("-c", `test -t 0 && test -t 1 && test -t 2 && echo "on a tty"; read line; echo "got $line" >&2`).
+		Build(ctx).
+		WithStdin(strings.NewReader("hello\n")).
+		WithPTY().
+		Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	// Terminal output uses CRLF line endings and echoes input
+	out := string(result.Stdout())
+	if !strings.Contains(out, "on a tty\r\n") {
+		t.Errorf("Expected the command to see a terminal, got %q", out)
+	}
+	if !strings.Contains(out, "got hello\r\n") {
+		t.Errorf("Expected stdin to be fed and stderr merged, got %q", out)
+	}
+}
+
+func TestCmdWithPTYClosesTerminalOnStartFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	countFDs := func() int {
+		entries, _ := os.ReadDir("/proc/self/fd")
+		return len(entries)
+	}
+	before := countFDs()
+	for range 5 {
+		if _, err := sh.New("/nonexistent/binary").Build(ctx).WithPTY().Run(); err == nil {
+			t.Fatal("Expected the command to fail to start")
+		}
+	}
+	if after := countFDs(); after > before {
+		t.Errorf("Expected the terminals of failed starts to be closed, %d descriptors leaked", after-before)
+	}
+}
+
+func TestCmdWithControllingTerminalStopsReading(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tty, user := net.Pipe()
+	defer user.Close()
+	if _, err := sh.New("true").Build(ctx).WithControllingTerminal(tty).Run(); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	// Nothing may be left reading the terminal once the command exited
+	user.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := user.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected input after the command to be left unread, got %v", err)
+	}
+}
+
+func TestCmdWithControllingTerminalRequiresReadDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tty := struct{ io.ReadWriter }{&ttyBuffer{in: strings.NewReader("")}}
+	if _, err := sh.New("true").Build(ctx).WithControllingTerminal(tty).Run(); err == nil {
+		t.Error("Expected a terminal without SetReadDeadline to be rejected")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+func controllingTerminalHook(io.ReadWriter) execHook {
+	return execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: pseudo-terminal: %w", errors.ErrUnsupported)
+		},
+	}
+}
+
+func ptyHook() execHook {
+	return execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: pseudo-terminal: %w", errors.ErrUnsupported)
+		},
+	}
+}
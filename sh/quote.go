@@ -0,0 +1,147 @@
+package sh
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Quote renders args as a command line for a POSIX shell, quoting arguments
+// that contain spaces or shell metacharacters, so that it can be logged or
+// pasted into a terminal and runs exactly the same command. Parse splits
+// the result back into args, whatever bytes they contain, so the rendering
+// is also safe to store and to send to other machines.
+func Quote(args ...string) string {
+	return quoteAll(args)
+}
+
+// QuoteItems quotes each of args individually, e.g. the result of Items,
+// for callers that join or format the words themselves.
+func QuoteItems(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return quoted
+}
+
+// quote returns arg quoted for a POSIX shell. Arguments consisting only of
+// safe characters are returned unchanged.
+func quote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.IndexFunc(arg, needsQuote) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// QuoteWindows renders args as a Windows command line, quoted so that
+// CommandLineToArgvW and the C runtime split it back into args. This is how
+// commands are passed to programs on Windows; cmd.exe has its own rules, see
+// NewCmdShell.
+func QuoteWindows(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteWindows(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteWindows quotes arg if it is empty or contains whitespace or double
+// quotes. Backslashes are only special before a double quote, where each
+// has to be doubled.
+func quoteWindows(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			// Escape the backslashes and the quote itself
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(r)
+	}
+	// Backslashes before the closing quote must not escape it
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quoteAll quotes each of args and joins them with spaces.
+func quoteAll(args []string) string {
+	quoted := QuoteItems(args)
+	// A shell would take a command name such as "FOO=1" for an assignment
+	if len(args) > 0 && isAssignment(args[0]) {
+		quoted[0] = "'" + args[0] + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// isAssignment reports whether word has the form of a shell variable
+// assignment, NAME=value.
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range []byte(name) {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (i == 0 || !(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the command as it would be typed in a POSIX shell.
+func (b *Builder) String() string {
+	return quoteAll(b.Items())
+}
+
+// String renders the command including its parent command as it would be
+// typed in a POSIX shell.
+func (s *SubCmd) String() string {
+	return quoteAll(s.Items())
+}
+
+// String renders the command as it would be typed in a POSIX shell,
+// including variables set with WithEnv and any commands piped into it.
+func (cm *cmdImpl) String() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var words []string
+	for _, key := range slices.Sorted(maps.Keys(cm.env)) {
+		words = append(words, key+"="+quote(cm.redact(cm.env[key])))
+	}
+	words = append(words, quote(cm.cmd))
+	for _, arg := range cm.redactAll(cm.args) {
+		words = append(words, quote(arg))
+	}
+
+	line := strings.Join(words, " ")
+	if cm.parent != nil {
+		line = cm.parent.String() + " | " + line
+	}
+	return line
+}
+
+func needsQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-_./:=@%+,", r)
+}
@@ -0,0 +1,69 @@
+package sh_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBuilderString(t *testing.T) {
+	b := sh.New("git").OptV("-m", "fix: it's done").Arg("path with spaces").Arg("$HOME").Arg("")
+	want := `git -m 'fix: it'\''s done' 'path with spaces' '$HOME' ''`
+	if got := b.String(); got != want {
+		t.Errorf("Builder.String() = %s, want %s", got, want)
+	}
+
+	// The rendered line parses back to the same words
+	parsed, err := sh.Parse(b.String())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !slices.Equal(parsed.Items(), b.Items()) {
+		t.Errorf("Round trip changed the command: %q != %q", parsed.Items(), b.Items())
+	}
+
+	sub := sh.New("docker").SubCommand("run").OptB("--rm").Arg("alpine:3.19")
+	if got := sub.String(); got != "docker run --rm alpine:3.19" {
+		t.Errorf("SubCmd.String() = %s", got)
+	}
+
+	if got := sh.QuoteItems([]string{"a b", "c"}); !slices.Equal(got, []string{"'a b'", "c"}) {
+		t.Errorf("QuoteItems = %q", got)
+	}
+	if got := sh.Quote("echo", "*"); got != "echo '*'" {
+		t.Errorf("Quote = %s", got)
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	ctx := context.Background()
+
+	cmd := sh.New("grep").Arg("a b").Build(ctx).WithEnv("LC_ALL", "C").WithEnv("GREP_COLOR", "1;32")
+	if got := cmd.String(); got != "GREP_COLOR='1;32' LC_ALL=C grep 'a b'" {
+		t.Errorf("Cmd.String() = %s", got)
+	}
+
+	piped := sh.New("cat").Arg("my file").Build(ctx).Pipe("wc").OptB("-l").Build()
+	if got := piped.String(); got != "cat 'my file' | wc -l" {
+		t.Errorf("piped Cmd.String() = %s", got)
+	}
+}
+
+func TestQuoteWindows(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"git", "status"}, `git status`},
+		{[]string{"C:\\Program Files\\app.exe", ""}, `"C:\Program Files\app.exe" ""`},
+		{[]string{`say "hi"`}, `"say \"hi\""`},
+		{[]string{`a\b`, `a\\"b`}, `a\b "a\\\\\"b"`},
+		{[]string{`dir\ with\`}, `"dir\ with\\"`},
+	} {
+		if got := sh.QuoteWindows(tt.args...); got != tt.want {
+			t.Errorf("QuoteWindows(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
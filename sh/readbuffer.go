@@ -0,0 +1,122 @@
+package sh
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+func (cm *cmdImpl) WithReadBufferSize(n int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if n > 0 {
+		cm.hooks = append(cm.hooks, readBufferHook(n, 0))
+	}
+	return cm
+}
+
+func (cm *cmdImpl) WithPipeBufferSize(n int) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if n <= 0 {
+		return cm
+	}
+	cm.hooks = append(cm.hooks, readBufferHook(n, n))
+	if cm.pipeReader != nil {
+		setPipeSize(cm.pipeReader, n)
+	}
+	return cm
+}
+
+// bufferedCopy copies what the child writes to a pipe to the writer the
+// child would otherwise have been given, in reads of a fixed size.
+type bufferedCopy struct {
+	pw        *os.File
+	closePipe sync.Once
+	done      chan struct{}
+	err       error
+}
+
+func startBufferedCopy(dst io.Writer, size, pipeSize int) (*bufferedCopy, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	if pipeSize > 0 {
+		setPipeSize(pw, pipeSize)
+	}
+	c := &bufferedCopy{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		// Closing the read end on a failed write makes the child see a
+		// broken pipe, as with the copying done by os/exec
+		defer pr.Close()
+		defer catchPanic(&c.err)
+		buf := make([]byte, size)
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					c.err = werr
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// closeWriter drops the parent's copy of the write end, so that the copy
+// ends once the child and its children have closed theirs.
+func (c *bufferedCopy) closeWriter() {
+	c.closePipe.Do(func() { c.pw.Close() })
+}
+
+// readBufferHook replaces the copying of stdout and stderr done by os/exec,
+// which reads 32 KiB at a time, with reads of size bytes, from pipes grown
+// to pipeSize bytes unless it is 0.
+func readBufferHook(size, pipeSize int) execHook {
+	var copies []*bufferedCopy
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			copies = nil
+			for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+				// Files are handed to the child as they are
+				if _, isFile := (*w).(*os.File); isFile || *w == nil {
+					continue
+				}
+				c, err := startBufferedCopy(*w, size, pipeSize)
+				if err != nil {
+					for _, c := range copies {
+						c.closeWriter()
+					}
+					return err
+				}
+				copies = append(copies, c)
+				*w = c.pw
+			}
+			return nil
+		},
+		started: func(*exec.Cmd) error {
+			for _, c := range copies {
+				c.closeWriter()
+			}
+			return nil
+		},
+		after: func(error) error {
+			var err error
+			for _, c := range copies {
+				c.closeWriter()
+				<-c.done
+				if err == nil {
+					err = c.err
+				}
+			}
+			return err
+		},
+	}
+}
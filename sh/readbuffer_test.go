@@ -0,0 +1,105 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestWithReadBufferSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var streamed bytes.Buffer
+	result, err := sh.New("sh").Arg("-c").Arg("seq 100000; echo done >&2").Build(ctx).
+		WithReadBufferSize(1 << 20).
+		WithStdout(&streamed).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want strings.Builder
+	for i := 1; i <= 100000; i++ {
+		fmt.Fprintln(&want, i)
+	}
+	if string(result.Stdout()) != want.String() || streamed.String() != want.String() {
+		t.Errorf("Expected all of stdout captured and streamed, got %d and %d bytes", len(result.Stdout()), streamed.Len())
+	}
+	if string(result.Stderr()) != "done\n" {
+		t.Errorf("Expected stderr captured, got %q", result.Stderr())
+	}
+}
+
+func TestWithPipeBufferSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("seq").Arg("200000").Build(ctx).
+		WithPipeBufferSize(1 << 20).
+		Pipe("wc").Arg("-l").Build().
+		WithPipeBufferSize(1 << 20).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TrimmedString() != "200000" {
+		t.Errorf("Expected all lines through the resized pipes, got %q", result.TrimmedString())
+	}
+}
+
+func TestWithReadBufferSizeStartFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sh.New("no-such-command-sh-test").Build(ctx).WithReadBufferSize(1 << 16).Run()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the start to fail")
+		}
+	case <-ctx.Done():
+		t.Fatal("Run hung after a failed start")
+	}
+}
+
+// benchmarkCapture runs a child writing size bytes of output as fast as it
+// can, capturing none of it so that only the reading is measured.
+func benchmarkCapture(b *testing.B, readBuffer, pipeBuffer int) {
+	const size = 256 << 20
+	b.SetBytes(size)
+	ctx := context.Background()
+	for range b.N {
+		_, err := sh.New("head").Arg("-c").Arg(fmt.Sprint(size)).Arg("/dev/zero").Build(ctx).
+			WithReadBufferSize(readBuffer).
+			WithPipeBufferSize(pipeBuffer).
+			WithBinaryOutput(discardHash{}).
+			Run()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCaptureDefault(b *testing.B)      { benchmarkCapture(b, 0, 0) }
+func BenchmarkCaptureReadBuffer1M(b *testing.B) { benchmarkCapture(b, 1<<20, 0) }
+func BenchmarkCaptureReadBuffer4M(b *testing.B) { benchmarkCapture(b, 4<<20, 0) }
+func BenchmarkCapturePipeBuffer1M(b *testing.B) { benchmarkCapture(b, 0, 1<<20) }
+
+// discardHash is a hash.Hash ignoring its input, to capture nothing.
+type discardHash struct{}
+
+func (discardHash) Write(p []byte) (int, error) { return len(p), nil }
+func (discardHash) Sum(b []byte) []byte         { return b }
+func (discardHash) Reset()                      {}
+func (discardHash) Size() int                   { return 0 }
+func (discardHash) BlockSize() int              { return 1 }
@@ -0,0 +1,158 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// ErrNotReady is returned by a command started with StartAfter when its
+// upstream command exits without ever becoming ready.
+var ErrNotReady = errors.New("sh: upstream command exited before becoming ready")
+
+func (cm *cmdImpl) Ready() <-chan struct{} {
+	return cm.ready
+}
+
+func (cm *cmdImpl) WithReadyPattern(re *regexp.Regexp) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.readyGated = true
+
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			cmd.Stdout = &readyWriter{w: cmd.Stdout, re: re, ready: cm.markReady}
+			cmd.Stderr = &readyWriter{w: cmd.Stderr, re: re, ready: cm.markReady}
+			return nil
+		},
+	})
+	return cm
+}
+
+func (cm *cmdImpl) WithReadyCheck(check func(ctx context.Context) error, interval time.Duration) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.readyGated = true
+
+	var stop context.CancelFunc
+	cm.hooks = append(cm.hooks, execHook{
+		started: func(*exec.Cmd) error {
+			var ctx context.Context
+			ctx, stop = context.WithCancel(cm.ctx)
+			goSafe(func() {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+
+				// A panicking check counts as failed
+				for protect(func() error { return check(ctx) }) != nil {
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+				cm.markReady()
+			})
+			return nil
+		},
+		after: func(error) error {
+			// The command may not have started
+			if stop != nil {
+				stop()
+			}
+			return nil
+		},
+	})
+	return cm
+}
+
+func (cm *cmdImpl) StartAfter(upstream Cmd) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.upstreams = append(cm.upstreams, upstream)
+	return cm
+}
+
+// markReady signals that the command is ready.
+func (cm *cmdImpl) markReady() {
+	cm.readyOnce.Do(func() {
+		close(cm.ready)
+	})
+}
+
+// awaitUpstreams starts the commands this command was ordered after with
+// StartAfter and blocks until all of them are ready.
+func (cm *cmdImpl) awaitUpstreams() error {
+	for _, up := range cm.upstreams {
+		up.Start()
+	}
+
+	for _, up := range cm.upstreams {
+		select {
+		case <-up.Ready():
+		case <-up.Done():
+			// Readiness may have been signalled right before exiting.
+			select {
+			case <-up.Ready():
+			default:
+				return ErrNotReady
+			}
+		case <-cm.ctx.Done():
+			return cm.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// maxReadyLine is how much of a line without a newline readyWriter keeps
+// to match; only the end of longer lines is matched.
+const maxReadyLine = 64 << 10
+
+// readyWriter passes output through to w and calls ready when a line
+// matching re has been written.
+type readyWriter struct {
+	w     io.Writer
+	re    *regexp.Regexp
+	ready func()
+	line  []byte
+	found bool
+}
+
+func (r *readyWriter) Write(p []byte) (int, error) {
+	if !r.found {
+		r.line = append(r.line, p...)
+		for {
+			i := bytes.IndexByte(r.line, '\n')
+			if i < 0 {
+				// Also match partial lines such as prompts without a newline.
+				if r.re.Match(r.line) {
+					r.matched()
+				}
+				break
+			}
+			if r.re.Match(r.line[:i]) {
+				r.matched()
+				break
+			}
+			r.line = r.line[i+1:]
+		}
+		if len(r.line) > maxReadyLine {
+			r.line = bytes.Clone(r.line[len(r.line)-maxReadyLine:])
+		}
+	}
+
+	if r.w == nil {
+		return len(p), nil
+	}
+	return r.w.Write(p)
+}
+
+func (r *readyWriter) matched() {
+	r.found = true
+	r.line = nil
+	r.ready()
+}
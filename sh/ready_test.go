@@ -0,0 +1,99 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestStartAfterReadyPattern(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	marker := filepath.Join(t.TempDir(), "up")
+	server := sh.New("sh").
+		OptV("-c", "sleep 0.1; touch "+marker+"; echo 'listening on :8080'; exec sleep 10").
+		Build(ctx).
+		WithReadyPattern(regexp.MustCompile(`listening on :\d+`))
+	defer server.Cancel()
+
+	// The client only succeeds if the server has already created the marker
+	result, err := sh.New("test").
+		OptV("-f", marker).
+		Build(ctx).
+		StartAfter(server).
+		Run()
+	if err != nil {
+		t.Fatalf("Downstream command failed: %v", err)
+	}
+	if result.ExitCode() != 0 {
+		t.Errorf("Expected exit code 0, got %d", result.ExitCode())
+	}
+
+	if server.IsDone() {
+		t.Error("Expected upstream to keep running after becoming ready")
+	}
+}
+
+func TestStartAfterReadyCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	marker := filepath.Join(t.TempDir(), "up")
+	server := sh.New("sh").
+		OptV("-c", "sleep 0.1; touch "+marker+"; exec sleep 10").
+		Build(ctx).
+		WithReadyCheck(func(ctx context.Context) error {
+			_, err := os.Stat(marker)
+			return err
+		}, 10*time.Millisecond)
+	defer server.Cancel()
+
+	_, err := sh.New("test").OptV("-f", marker).Build(ctx).StartAfter(server).Run()
+	if err != nil {
+		t.Fatalf("Downstream command failed: %v", err)
+	}
+}
+
+func TestReadyPatternLongLine(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A prompt at the end of a line far longer than the part kept for
+	// matching still counts
+	server := sh.New("sh").
+		OptV("-c", "head -c 1000000 /dev/zero | tr '\\0' x; printf 'ready> '; exec sleep 10").
+		Build(ctx).
+		WithReadyPattern(regexp.MustCompile(`ready> $`))
+	server.Start()
+	defer server.Cancel()
+
+	select {
+	case <-server.Ready():
+	case <-server.Done():
+		t.Fatal("Expected the server to become ready before exiting")
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the server to become ready")
+	}
+}
+
+func TestStartAfterNotReady(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := sh.New("echo").
+		Arg("crashed").
+		Build(ctx).
+		WithReadyPattern(regexp.MustCompile("listening"))
+
+	_, err := sh.New("true").Build(ctx).StartAfter(server).Run()
+	if !errors.Is(err, sh.ErrNotReady) {
+		t.Errorf("Expected ErrNotReady, got %v", err)
+	}
+}
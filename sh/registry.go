@@ -0,0 +1,262 @@
+package sh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PidRegistry is a file recording the PIDs of running commands. If the
+// program crashes, children it started may keep running; a later run can
+// call ReapOrphans on the same registry to kill them. Programs sharing a
+// registry serialize their updates with a lock on its path+".lock", and
+// a run can keep a heartbeat in its path+".heartbeat" so that others tell
+// whether it is still alive.
+type PidRegistry struct {
+	path string
+	mu   sync.Mutex
+}
+
+// registryEntry is a single running process recorded in a PidRegistry.
+type registryEntry struct {
+	pid   int
+	name  string
+	start uint64 // when the process started, in clock ticks since boot
+}
+
+// NewPidRegistry returns a registry stored in the file at path. The file is
+// created when the first command is recorded.
+func NewPidRegistry(path string) *PidRegistry {
+	return &PidRegistry{path: path}
+}
+
+// ReapOrphans kills every process still recorded in the registry, which are
+// left over from a previous run that did not exit cleanly, and clears the
+// registry. It returns the PIDs that were killed. A process that cannot be
+// told apart from a later one reusing its PID, for lack of a process table
+// to read its start time from, is not killed but reported in the error.
+//
+// Call ReapOrphans only once the run that recorded the processes is gone,
+// for example when LastHeartbeat is older than its heartbeat interval.
+func (r *PidRegistry) ReapOrphans() ([]int, error) {
+	unlock, err := r.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var killed []int
+	var errs []error
+	for _, e := range entries {
+		if !processAlive(e.pid) {
+			continue
+		}
+		if match, err := processMatches(e.pid, e.name, e.start); err != nil {
+			errs = append(errs, fmt.Errorf("sh: not killing %d, cannot verify it: %w", e.pid, err))
+			continue
+		} else if !match {
+			continue
+		}
+
+		p, err := os.FindProcess(e.pid)
+		if err == nil {
+			err = p.Kill()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("kill %d: %w", e.pid, err))
+			continue
+		}
+		killed = append(killed, e.pid)
+	}
+
+	if err := r.write(nil); err != nil {
+		errs = append(errs, err)
+	}
+	return killed, errors.Join(errs...)
+}
+
+// Heartbeat refreshes the registry's heartbeat file now and then every
+// interval until ctx is done, to show other programs that the current run
+// is alive. It returns the error of the first refresh; later ones are
+// retried on the next tick.
+func (r *PidRegistry) Heartbeat(ctx context.Context, interval time.Duration) error {
+	if err := r.beat(); err != nil {
+		return err
+	}
+
+	goSafe(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.beat()
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// LastHeartbeat returns when the registry's heartbeat was last refreshed.
+// It returns an error wrapping os.ErrNotExist if no run ever called
+// Heartbeat on it.
+func (r *PidRegistry) LastHeartbeat() (time.Time, error) {
+	info, err := os.Stat(r.path + ".heartbeat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (r *PidRegistry) beat() error {
+	path := r.path + ".heartbeat"
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.WriteFile(path, nil, 0o644)
+	}
+	return err
+}
+
+// lock serializes changes to the registry file, between goroutines and
+// between programs sharing it.
+func (r *PidRegistry) lock() (func(), error) {
+	r.mu.Lock()
+	unlock, err := lockFile(r.path + ".lock")
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		r.mu.Unlock()
+	}, nil
+}
+
+func (r *PidRegistry) add(e registryEntry) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := r.read()
+	if err != nil {
+		return err
+	}
+	return r.write(append(entries, e))
+}
+
+func (r *PidRegistry) remove(pid int) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.pid != pid {
+			kept = append(kept, e)
+		}
+	}
+	return r.write(kept)
+}
+
+func (r *PidRegistry) read() ([]registryEntry, error) {
+	f, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []registryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pidStr, rest, _ := strings.Cut(scanner.Text(), "\t")
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			continue
+		}
+		// Entries written by older versions have no start time
+		name, startStr, _ := strings.Cut(rest, "\t")
+		start, _ := strconv.ParseUint(startStr, 10, 64)
+		entries = append(entries, registryEntry{pid: pid, name: name, start: start})
+	}
+	return entries, scanner.Err()
+}
+
+// write atomically replaces the registry file with entries.
+func (r *PidRegistry) write(entries []registryEntry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%d\t%s\t%d\n", e.pid, e.name, e.start)
+	}
+	return writeFileAtomic(r.path, []byte(b.String()), 0o644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	err = errors.Join(err, f.Chmod(perm), f.Close())
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (cm *cmdImpl) WithPidRegistry(r *PidRegistry) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	pid := 0
+	cm.hooks = append(cm.hooks, execHook{
+		started: func(cmd *exec.Cmd) error {
+			pid = cmd.Process.Pid
+			// The start time tells the process apart from a later one
+			// reusing its PID, whatever its argv[0]
+			start, _ := processStartTime(pid)
+			return r.add(registryEntry{pid: pid, name: cmd.Path, start: start})
+		},
+		after: func(error) error {
+			if pid == 0 {
+				return nil
+			}
+			return r.remove(pid)
+		},
+	})
+	return cm
+}
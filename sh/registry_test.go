@@ -0,0 +1,179 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithPidRegistry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pids")
+	registry := sh.NewPidRegistry(path)
+
+	cmd := sh.New("sleep").
+		Arg("0.2").
+		Build(ctx).
+		WithPidRegistry(registry)
+	cmd.Start()
+
+	// The PID should be recorded while the command is running
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "sleep") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected registry to record the running command, got '%s'", data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := cmd.Wait(); err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	// And removed once it exited
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != "" {
+		t.Errorf("Expected registry to be empty after exit, got '%s'", data)
+	}
+}
+
+func TestPidRegistryReapOrphans(t *testing.T) {
+	// Simulate a child left behind by a crashed previous run
+	orphan := exec.Command("sleep", "10")
+	if err := orphan.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer orphan.Process.Kill()
+
+	path := filepath.Join(t.TempDir(), "pids")
+	entry := strconv.Itoa(orphan.Process.Pid) + "\t" + orphan.Path + "\n"
+	if err := os.WriteFile(path, []byte(entry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	killed, err := sh.NewPidRegistry(path).ReapOrphans()
+	if err != nil {
+		t.Fatalf("ReapOrphans failed: %v", err)
+	}
+	if len(killed) != 1 || killed[0] != orphan.Process.Pid {
+		t.Errorf("Expected orphan %d to be killed, got %v", orphan.Process.Pid, killed)
+	}
+
+	err = orphan.Wait()
+	if status, ok := err.(*exec.ExitError); !ok || status.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+		t.Errorf("Expected orphan to be killed by SIGKILL, got %v", err)
+	}
+}
+
+func TestPidRegistryReapOrphanWithArg0(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pids")
+	cmd := sh.New("sleep").Arg0("worker").Arg("10").Build(ctx).
+		WithPidRegistry(sh.NewPidRegistry(path))
+	cmd.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "sleep") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected registry to record the running command, got '%s'", data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A later run finds the process although its argv[0] is not the
+	// executable
+	killed, err := sh.NewPidRegistry(path).ReapOrphans()
+	if err != nil {
+		t.Fatalf("ReapOrphans failed: %v", err)
+	}
+	if len(killed) != 1 {
+		t.Errorf("Expected the orphan to be killed, got %v", killed)
+	}
+	cmd.Wait()
+}
+
+func TestPidRegistrySharedFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Registries opened separately on the same file, as by separate
+	// programs, must not lose each other's entries
+	path := filepath.Join(t.TempDir(), "pids")
+	var cmds []sh.Cmd
+	for range 8 {
+		cmd := sh.New("sleep").Arg("10").Build(ctx).WithPidRegistry(sh.NewPidRegistry(path))
+		cmd.Start()
+		cmds = append(cmds, cmd)
+	}
+	defer func() {
+		for _, cmd := range cmds {
+			cmd.Cancel()
+			cmd.Wait()
+		}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Count(string(data), "\n") == len(cmds) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d entries in the registry, got '%s'", len(cmds), data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPidRegistryHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := sh.NewPidRegistry(filepath.Join(t.TempDir(), "pids"))
+	if _, err := registry.LastHeartbeat(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no heartbeat before Heartbeat, got %v", err)
+	}
+
+	if err := registry.Heartbeat(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	first, err := registry.LastHeartbeat()
+	if err != nil {
+		t.Fatalf("LastHeartbeat failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		last, _ := registry.LastHeartbeat()
+		if last.After(first) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the heartbeat to be refreshed after %v", first)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,295 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostKeyPolicy controls how ssh verifies the keys of remote hosts.
+type HostKeyPolicy int
+
+const (
+	// HostKeyDefault leaves host key checking to the ssh configuration.
+	HostKeyDefault HostKeyPolicy = iota
+	// HostKeyStrict only connects to hosts whose keys are already known.
+	HostKeyStrict
+	// HostKeyAcceptNew records keys of unknown hosts but refuses hosts whose
+	// key has changed.
+	HostKeyAcceptNew
+	// HostKeyInsecure disables host key verification entirely. Only use it
+	// for throwaway hosts such as freshly created test VMs.
+	HostKeyInsecure
+)
+
+// Remote is a host reachable with the system ssh client. Commands are run
+// on it by wrapping their arguments, properly quoted for the remote shell,
+// in an ssh invocation, and files are transferred with scp, which speaks
+// the SFTP protocol since OpenSSH 9.0. Input set with WithStdin needs no
+// staging, as ssh forwards the local stdin to the remote command; use
+// StageFile for files the command opens by path.
+type Remote struct {
+	// Host is the host name or address, optionally prefixed with "user@".
+	Host string
+	// Alias names the host in output and results instead of Host, such as
+	// its inventory name.
+	Alias string
+	// User overrides the login user.
+	User string
+	// Port overrides the ssh port when non-zero.
+	Port int
+	// Jump lists bastion hosts to connect through, in order ("ProxyJump").
+	Jump []string
+	// HostKeys selects the host key verification policy.
+	HostKeys HostKeyPolicy
+	// KeepAlive sends keep-alive messages at this interval when non-zero,
+	// so idle connections are not dropped by firewalls.
+	KeepAlive time.Duration
+	// Multiplex reuses a single connection for all commands and transfers
+	// to the host, keeping it open for this long after the last one
+	// finished. Zero opens a new connection for every command. The control
+	// socket lives in a directory only the user can access, under
+	// $XDG_RUNTIME_DIR or the user cache directory; without either, every
+	// command opens a new connection.
+	Multiplex time.Duration
+	// Options are passed to ssh as "-o" options, e.g. "BatchMode=yes".
+	Options []string
+	// Vars are substituted into the arguments of commands run through a
+	// Fleet using text/template syntax, e.g. "--role={{.role}}".
+	Vars map[string]string
+}
+
+// NewRemote returns a Remote for host, which may have the form
+// "user@host:port". Commands and transfers on a host starting with "-"
+// fail, since ssh would read it as an option.
+func NewRemote(host string) Remote {
+	r := Remote{Host: host}
+
+	// Bare IPv6 addresses contain colons but no port.
+	i := strings.LastIndex(host, ":")
+	if i < 0 || (strings.Count(host, ":") > 1 && !strings.Contains(host, "]:")) {
+		return r
+	}
+	if port, err := strconv.Atoi(host[i+1:]); err == nil {
+		r.Host, r.Port = strings.NewReplacer("[", "", "]", "").Replace(host[:i]), port
+	}
+	return r
+}
+
+// Name returns the host name used to label output and results.
+func (r Remote) Name() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	return r.Host
+}
+
+// Command returns a builder running the command described by b on the
+// remote host.
+func (r Remote) Command(b Argv) *Builder {
+	ssh := New("ssh")
+	for _, opt := range r.sshOptions() {
+		ssh.Arg(opt)
+	}
+	if err := checkHost(r.Host); err != nil {
+		ssh.err = err
+	}
+
+	// ssh reads options after the host too, unless they end before it
+	return ssh.Arg("--").Arg(r.Host).Arg(quoteAll(b.Items()))
+}
+
+// Close shuts down the shared connection kept open by Multiplex, if any.
+func (r Remote) Close(ctx context.Context) error {
+	if r.Multiplex <= 0 {
+		return nil
+	}
+	if err := checkHost(r.Host); err != nil {
+		return err
+	}
+	if _, err := controlDir(); err != nil {
+		// Without a control socket there is no shared connection
+		return nil
+	}
+
+	ssh := New("ssh")
+	for _, opt := range r.sshOptions() {
+		ssh.Arg(opt)
+	}
+
+	result, err := ssh.OptV("-O", "exit").Arg("--").Arg(r.Host).Build(ctx).Run()
+	if err != nil {
+		return fmt.Errorf("close connection to %s: %w: %s", r.Host, err, bytes.TrimSpace(result.Stderr()))
+	}
+	return nil
+}
+
+// checkHost returns an error if host would be read as an option by ssh or
+// scp, such as "-oProxyCommand=..." taken from inventory data.
+func checkHost(host string) error {
+	if strings.HasPrefix(host, "-") {
+		return fmt.Errorf("sh: invalid ssh host %q", host)
+	}
+	return nil
+}
+
+// sshOptions returns the ssh flags for r, excluding the destination.
+func (r Remote) sshOptions() []string {
+	var opts []string
+	if r.User != "" {
+		opts = append(opts, "-l", r.User)
+	}
+	if r.Port != 0 {
+		opts = append(opts, "-p", strconv.Itoa(r.Port))
+	}
+	for _, o := range r.options() {
+		opts = append(opts, "-o", o)
+	}
+	return opts
+}
+
+// options returns the "-o" options shared by ssh and scp.
+func (r Remote) options() []string {
+	var opts []string
+	if len(r.Jump) > 0 {
+		opts = append(opts, "ProxyJump="+strings.Join(r.Jump, ","))
+	}
+
+	switch r.HostKeys {
+	case HostKeyStrict:
+		opts = append(opts, "StrictHostKeyChecking=yes")
+	case HostKeyAcceptNew:
+		opts = append(opts, "StrictHostKeyChecking=accept-new")
+	case HostKeyInsecure:
+		opts = append(opts, "StrictHostKeyChecking=no", "UserKnownHostsFile="+os.DevNull)
+	}
+
+	if r.KeepAlive > 0 {
+		opts = append(opts, "ServerAliveInterval="+strconv.Itoa(seconds(r.KeepAlive)))
+	}
+	if r.Multiplex > 0 {
+		if dir, err := controlDir(); err == nil {
+			opts = append(opts,
+				"ControlMaster=auto",
+				"ControlPath="+filepath.Join(dir, "%C"),
+				"ControlPersist="+strconv.Itoa(seconds(r.Multiplex)),
+			)
+		}
+	}
+
+	return append(opts, r.Options...)
+}
+
+// controlDir returns the directory for ssh control sockets, creating it
+// with access for the user only: anyone able to open a control socket can
+// run commands over the connection.
+func controlDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		var err error
+		if base, err = os.UserCacheDir(); err != nil {
+			return "", err
+		}
+	}
+	dir := filepath.Join(base, "sh-ssh")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	// MkdirAll leaves the permissions of an existing directory alone
+	return dir, os.Chmod(dir, 0o700)
+}
+
+// seconds returns d in whole seconds, rounded up to at least one.
+func seconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+// Upload copies the local file or directory to path on the remote host
+// using scp.
+func (r Remote) Upload(ctx context.Context, local, path string) error {
+	return r.scp(ctx, local, r.target(path))
+}
+
+// Download copies the file or directory at path on the remote host to local
+// using scp.
+func (r Remote) Download(ctx context.Context, path, local string) error {
+	return r.scp(ctx, r.target(path), local)
+}
+
+// StageFile uploads the local file into a new private directory under the
+// remote /tmp, readable by the login user only, and returns its path, so
+// it can be passed as an argument to commands run on the host. cleanup
+// removes the directory again; it runs with ctx, so call it before ctx is
+// done.
+func (r Remote) StageFile(ctx context.Context, local string) (path string, cleanup func() error, err error) {
+	result, err := r.Command(New("mktemp").Arg("-d").Arg("/tmp/sh-stage.XXXXXXXXXX")).Build(ctx).Run()
+	if err != nil {
+		return "", nil, fmt.Errorf("stage %s: %w: %s", local, err, bytes.TrimSpace(result.Stderr()))
+	}
+	dir := result.TrimmedString()
+	cleanup = func() error {
+		result, err := r.Command(New("rm").Arg("-rf").Arg("--").Arg(dir)).Build(ctx).Run()
+		if err != nil {
+			return fmt.Errorf("remove %s: %w: %s", dir, err, bytes.TrimSpace(result.Stderr()))
+		}
+		return nil
+	}
+
+	path = dir + "/" + filepath.Base(local)
+	if err := r.Upload(ctx, local, path); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if result, err := r.Command(New("chmod").Arg("0600").Arg(path)).Build(ctx).Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("stage %s: %w: %s", local, err, bytes.TrimSpace(result.Stderr()))
+	}
+	return path, cleanup, nil
+}
+
+func (r Remote) scp(ctx context.Context, from, to string) error {
+	if err := checkHost(r.Host); err != nil {
+		return err
+	}
+
+	scp := New("scp").OptB("-q").OptB("-r")
+	if r.Port != 0 {
+		scp.OptV("-P", r.Port)
+	}
+	for _, o := range r.options() {
+		scp.OptV("-o", o)
+	}
+
+	// Local paths may start with "-" as well
+	result, err := scp.Arg("--").Arg(from).Arg(to).Build(ctx).Run()
+	if err != nil {
+		return fmt.Errorf("scp %s %s: %w: %s", from, to, err, bytes.TrimSpace(result.Stderr()))
+	}
+	return nil
+}
+
+// target returns the scp destination for path on the remote host.
+func (r Remote) target(path string) string {
+	user, host := r.User, r.Host
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, host = host[:i], host[i+1:]
+	}
+
+	// IPv6 addresses need their brackets to be told apart from the path
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if user != "" {
+		host = user + "@" + host
+	}
+	return host + ":" + path
+}
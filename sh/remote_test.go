@@ -0,0 +1,209 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// fakeSSH installs an ssh stand-in on PATH that runs the remote command
+// locally with FAKE_SSH_HOST set to the destination host.
+func fakeSSH(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+FAKE_SSH_HOST="$2"
+shift 2
+export FAKE_SSH_HOST
+exec sh -c "$1"
+`
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// fakeSCP installs an scp stand-in on PATH that copies files locally,
+// ignoring the host part of remote paths.
+func fakeSCP(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+while [ $# -gt 2 ]; do shift; done
+exec cp -r "${1#*:}" "${2#*:}"
+`
+	if err := os.WriteFile(filepath.Join(dir, "scp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRemoteCommand(t *testing.T) {
+	remote := sh.NewRemote("deploy@web1:2222")
+	remote.Options = []string{"BatchMode=yes"}
+
+	items := remote.Command(sh.New("echo").Arg("hello world")).Items()
+	expected := []string{"ssh", "-p", "2222", "-o", "BatchMode=yes", "--", "deploy@web1", "echo 'hello world'"}
+
+	if strings.Join(items, "\x00") != strings.Join(expected, "\x00") {
+		t.Errorf("Expected %q, got %q", expected, items)
+	}
+}
+
+func TestRemoteRejectsOptionHost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	remote := sh.NewRemote("-oProxyCommand=touch /tmp/pwned")
+	if _, err := remote.Command(sh.New("true")).Build(ctx).Run(); err == nil {
+		t.Error("Expected a host starting with '-' to be rejected")
+	}
+}
+
+func TestRemoteConnectionOptions(t *testing.T) {
+	remote := sh.Remote{
+		Host:      "db1",
+		Jump:      []string{"bastion1", "bastion2"},
+		HostKeys:  sh.HostKeyAcceptNew,
+		KeepAlive: 15 * time.Second,
+		Multiplex: time.Minute,
+	}
+
+	items := strings.Join(remote.Command(sh.New("uptime")).Items(), " ")
+	for _, expected := range []string{
+		"-o ProxyJump=bastion1,bastion2",
+		"-o StrictHostKeyChecking=accept-new",
+		"-o ServerAliveInterval=15",
+		"-o ControlMaster=auto",
+		"-o ControlPersist=60",
+		"-- db1 uptime",
+	} {
+		if !strings.Contains(items, expected) {
+			t.Errorf("Expected command to contain '%s', got '%s'", expected, items)
+		}
+	}
+}
+
+func TestRemoteUploadDownload(t *testing.T) {
+	fakeSCP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "payload.txt")
+	if err := os.WriteFile(local, []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := sh.NewRemote("web1")
+	uploaded := filepath.Join(dir, "uploaded.txt")
+	if err := remote.Upload(ctx, local, uploaded); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	downloaded := filepath.Join(dir, "downloaded.txt")
+	if err := remote.Download(ctx, uploaded, downloaded); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(downloaded)
+	if string(data) != "payload" {
+		t.Errorf("Expected 'payload', got '%s'", data)
+	}
+
+	if err := remote.Download(ctx, filepath.Join(dir, "missing"), downloaded); err == nil {
+		t.Error("Expected error downloading a missing file")
+	}
+}
+
+func TestRemoteStageFile(t *testing.T) {
+	fakeSSH(t)
+	fakeSCP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	local := filepath.Join(t.TempDir(), "payload.txt")
+	if err := os.WriteFile(local, []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	staged, cleanup, err := sh.NewRemote("web1").StageFile(ctx, local)
+	if err != nil {
+		t.Fatalf("StageFile failed: %v", err)
+	}
+	dir := filepath.Dir(staged)
+	if !strings.HasPrefix(dir, "/tmp/sh-stage.") || filepath.Base(staged) != "payload.txt" {
+		t.Errorf("Unexpected staged path '%s'", staged)
+	}
+
+	// Other users must not be able to read or replace the file
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("Expected a private staging directory, got %v, %v", info, err)
+	}
+	if info, err := os.Stat(staged); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected a private staged file, got %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(staged); string(data) != "payload" {
+		t.Errorf("Expected 'payload', got '%s'", data)
+	}
+
+	if err := cleanup(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the staging directory to be removed, got %v", err)
+	}
+}
+
+func TestRemoteControlPathIsPrivate(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	remote := sh.Remote{Host: "db1", Multiplex: time.Minute}
+	items := strings.Join(remote.Command(sh.New("uptime")).Items(), " ")
+	dir := filepath.Join(runtimeDir, "sh-ssh")
+	if expected := "-o ControlPath=" + filepath.Join(dir, "%C"); !strings.Contains(items, expected) {
+		t.Errorf("Expected command to contain '%s', got '%s'", expected, items)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o700 {
+		t.Errorf("Expected the socket directory to be private, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRemoteUploadIPv6(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Record the arguments scp is given
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + args + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "scp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	remote := sh.NewRemote("deploy@[::1]:2222")
+	if err := remote.Upload(ctx, "-payload.txt", "/srv/payload.txt"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(args)
+	if !strings.HasSuffix(string(data), "--\n-payload.txt\ndeploy@[::1]:/srv/payload.txt\n") {
+		t.Errorf("Unexpected scp arguments %q", data)
+	}
+}
@@ -0,0 +1,190 @@
+package sh
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Restartable is the downstream stage of a pipeline that can be stopped and
+// restarted while the upstream command keeps running, e.g. to reload a log
+// shipper without restarting the producer feeding it.
+type Restartable struct {
+	upstream Cmd
+	build    func() Cmd
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	stage  Cmd
+	w      *os.File // write end of the stage's stdin; nil while stopped
+	gen    int      // incremented whenever w changes
+	closed bool
+}
+
+// PipeRestartable connects the stdout of upstream to a downstream stage built
+// by build, which must return an unstarted command without stdin. build is
+// called again on every Restart.
+//
+// While no stage is running, because it was stopped or exited on its own,
+// upstream output is held back until the next Restart and the upstream
+// blocks writing. Only input a stopped stage had read but not processed is
+// lost.
+func PipeRestartable(upstream Cmd, build func() Cmd) *Restartable {
+	r := &Restartable{upstream: upstream, build: build}
+	r.cond = sync.NewCond(&r.mu)
+	upstream.WithStdout(r)
+	return r
+}
+
+// Start starts the first stage and the upstream command.
+func (r *Restartable) Start() *Restartable {
+	r.startStage()
+	r.upstream.Start()
+	return r
+}
+
+// Stage returns the current downstream stage.
+func (r *Restartable) Stage() Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stage
+}
+
+// Stop cancels the current stage and waits for it to exit. The upstream
+// keeps running.
+func (r *Restartable) Stop() {
+	r.mu.Lock()
+	stage, w := r.stage, r.w
+	r.w = nil
+	r.gen++
+	r.mu.Unlock()
+
+	if w != nil {
+		w.Close()
+	}
+	if stage != nil {
+		stage.Cancel()
+		stage.Wait()
+	}
+}
+
+// Restart stops the current stage and starts a new one, which receives the
+// upstream output from where the old stage left off. It does nothing once
+// the pipeline has finished or been cancelled.
+func (r *Restartable) Restart() {
+	r.Stop()
+	r.startStage()
+}
+
+// Cancel cancels both the upstream and the current stage.
+func (r *Restartable) Cancel() {
+	r.mu.Lock()
+	r.closed = true
+	stage := r.stage
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	r.upstream.Cancel()
+	if stage != nil {
+		stage.Cancel()
+	}
+}
+
+// Wait waits for the upstream to exit, ends the input of the current stage
+// and waits for it. It returns the result of the stage and, as with
+// "set -o pipefail", the error of the stage or otherwise of the upstream.
+// Wait blocks while the stage is stopped and upstream output is pending.
+func (r *Restartable) Wait() (Result, error) {
+	_, upstreamErr := r.upstream.Wait()
+
+	r.mu.Lock()
+	r.closed = true
+	stage, w := r.stage, r.w
+	r.w = nil
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	if w != nil {
+		w.Close()
+	}
+	result, err := stage.Wait()
+	if err == nil {
+		err = upstreamErr
+	}
+	return result, err
+}
+
+// Write forwards upstream output to the current stage, waiting for a
+// restart while none is running.
+func (r *Restartable) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		r.mu.Lock()
+		for r.w == nil && !r.closed {
+			r.cond.Wait()
+		}
+		w, gen := r.w, r.gen
+		r.mu.Unlock()
+
+		if w == nil {
+			// Cancelled; nobody is left to read
+			return len(p), nil
+		}
+
+		n, err := w.Write(p[written:])
+		written += n
+		if err != nil {
+			r.mu.Lock()
+			if r.gen == gen {
+				// The stage exited on its own
+				r.w = nil
+				r.gen++
+				w.Close()
+			}
+			r.mu.Unlock()
+		}
+	}
+	return len(p), nil
+}
+
+func (r *Restartable) startStage() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	stage := r.build()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		if cm, ok := stage.(*cmdImpl); ok {
+			cm.mu.Lock()
+			cm.hooks = append(cm.hooks, execHook{
+				before: func(*exec.Cmd) error { return err },
+			})
+			cm.mu.Unlock()
+		}
+		r.stage = stage
+		stage.Start()
+		return
+	}
+
+	stage.WithStdin(pr)
+	if cm, ok := stage.(*cmdImpl); ok {
+		cm.mu.Lock()
+		cm.hooks = append(cm.hooks, execHook{
+			// The child holds its own copy of the read end; dropping ours
+			// makes writes fail once the child exits
+			started: func(*exec.Cmd) error { return pr.Close() },
+		})
+		cm.mu.Unlock()
+	}
+	goSafe(func() {
+		<-stage.Done()
+		pr.Close()
+	})
+
+	r.stage, r.w = stage, pw
+	r.gen++
+	r.cond.Broadcast()
+	stage.Start()
+}
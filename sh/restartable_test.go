@@ -0,0 +1,108 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPipeRestartable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	producer := sh.New("sh").
+		OptV("-c", "for i in $(seq 1 30); do echo $i; sleep 0.02; done").
+		Build(ctx)
+
+	var out syncBuffer
+	builds := 0
+	p := sh.PipeRestartable(producer, func() sh.Cmd {
+		builds++
+		return sh.New("cat").Build(ctx).WithStdout(&out)
+	}).Start()
+
+	for !strings.Contains(out.String(), "5\n") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	first := p.Stage()
+	p.Restart()
+	if p.Stage() == first {
+		t.Error("Expected a new stage after Restart")
+	}
+	if !first.IsDone() {
+		t.Error("Expected the old stage to have exited")
+	}
+
+	result, err := p.Wait()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if builds != 2 {
+		t.Errorf("Expected 2 stages to be built, got %d", builds)
+	}
+	if !strings.HasSuffix(string(result.Stdout()), "30\n") {
+		t.Errorf("Expected the new stage to read to the end, got %q", result.Stdout())
+	}
+
+	// The producer ran once, so no line repeats
+	var want strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintln(&want, i)
+	}
+	if out.String() != want.String() {
+		t.Errorf("Expected every line exactly once, got %q", out.String())
+	}
+}
+
+func TestPipeRestartableHoldsOutputWhileStopped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	producer := sh.New("sh").OptV("-c", "echo one; sleep 0.1; echo two").Build(ctx)
+
+	var out syncBuffer
+	p := sh.PipeRestartable(producer, func() sh.Cmd {
+		return sh.New("cat").Build(ctx).WithStdout(&out)
+	}).Start()
+
+	for out.String() != "one\n" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Stop()
+	time.Sleep(300 * time.Millisecond)
+	if out.String() != "one\n" {
+		t.Errorf("Expected no output while stopped, got %q", out.String())
+	}
+
+	p.Restart()
+	if _, err := p.Wait(); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if out.String() != "one\ntwo\n" {
+		t.Errorf("Expected held output after restart, got %q", out.String())
+	}
+}
@@ -0,0 +1,253 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults are the settings a Runner applies to the commands it builds.
+// Zero fields leave the command's behavior unchanged.
+type Defaults struct {
+	// Stdin, Stdout and Stderr are the streams used by WithInteractive
+	// and WithPager.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Env holds variables set on every command, as with WithEnvMap.
+	Env map[string]string
+	// Dir is the working directory of commands that do not set one.
+	Dir string
+	// Logger receives the lifecycle events of the commands, instead of
+	// the logger set with SetLogger.
+	Logger *slog.Logger
+	// Policy is consulted with the command line of every command before
+	// it runs; a non-nil error fails the command without running it.
+	Policy func(argv []string) error
+	// Executor runs commands in place of starting a local process, e.g.
+	// to run them remotely or to fake them in tests. It receives the
+	// fully configured Execution; exec hooks such as WithPTY or
+	// WithStdoutFile are not applied.
+	Executor RunFunc
+	// Metrics is told about every finished command.
+	Metrics Metrics
+	// Middleware wraps every command run by the runner, inside the
+	// command's own middleware and outside middleware registered with Use.
+	Middleware []Middleware
+	// DryRun skips running the commands, as with WithDryRun.
+	DryRun bool
+	// MaxProcesses limits how many of the runner's commands run at once,
+	// like SetMaxConcurrentProcesses does for the whole program.
+	MaxProcesses int
+	// RateLimit limits how many of the runner's commands start per second,
+	// allowing bursts of RateBurst commands, like SetRateLimit does for
+	// the whole program.
+	RateLimit float64
+	RateBurst int
+}
+
+// Metrics receives measurements of finished commands.
+type Metrics interface {
+	// ObserveRun is called once per execution with the command's Name,
+	// prefixed with its Group and a slash if it has one, its exit code (-1 if it did not exit normally), how long it ran and the
+	// error it failed with, if any.
+	ObserveRun(name string, exitCode int, duration time.Duration, err error)
+}
+
+// ErrPolicy is returned for commands rejected by a Runner's Policy, and
+// matches the *PolicyError of commands refused by the Policy set with
+// SetPolicy.
+var ErrPolicy = errors.New("sh: rejected by policy")
+
+// Runner builds commands with its own Defaults. Libraries embedding this
+// package should use their own Runner rather than the package-level
+// defaults, so they do not change each other's configuration.
+type Runner struct {
+	mu       sync.RWMutex
+	defaults Defaults
+	cleanups CleanupStack
+	limits   *limiter // nil without MaxProcesses and RateLimit
+}
+
+// defaultRunner holds the package-level defaults used by New and the
+// SetDefault functions.
+var defaultRunner = &Runner{defaults: Defaults{
+	Stdin:  os.Stdin,
+	Stdout: os.Stdout,
+	Stderr: os.Stderr,
+}}
+
+// NewRunnerWithDefaults returns a Runner building commands with d. Nil
+// streams fall back to the process's standard streams.
+func NewRunnerWithDefaults(d Defaults) *Runner {
+	if d.Stdin == nil {
+		d.Stdin = os.Stdin
+	}
+	if d.Stdout == nil {
+		d.Stdout = os.Stdout
+	}
+	if d.Stderr == nil {
+		d.Stderr = os.Stderr
+	}
+	d.Env = maps.Clone(d.Env)
+	d.Middleware = slices.Clone(d.Middleware)
+	return &Runner{defaults: d, limits: newLimiter(d.MaxProcesses, d.RateLimit, d.RateBurst)}
+}
+
+// New creates a command builder for cmd whose commands use the runner's
+// defaults.
+func (r *Runner) New(cmd string) *Builder {
+	return &Builder{Cmd: cmd, runner: r}
+}
+
+// Parse parses a command line like the package-level Parse, returning a
+// builder whose commands use the runner's defaults.
+func (r *Runner) Parse(line string) (*Builder, error) {
+	b, err := Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	b.runner = r
+	return b, nil
+}
+
+// Use adds middleware wrapping every command run by the runner.
+func (r *Runner) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults.Middleware = append(r.defaults.Middleware, mw...)
+}
+
+// Defaults returns the runner's defaults.
+func (r *Runner) Defaults() Defaults {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d := r.defaults
+	d.Env = maps.Clone(d.Env)
+	d.Middleware = slices.Clone(d.Middleware)
+	return d
+}
+
+// executor returns the runner's Executor, or nil to start processes.
+func (r *Runner) executor() RunFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaults.Executor
+}
+
+// wrap wraps run in the runner's middleware and policy.
+func (r *Runner) wrap(run RunFunc) RunFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.defaults.Middleware) - 1; i >= 0; i-- {
+		run = r.defaults.Middleware[i](run)
+	}
+	if policy := r.defaults.Policy; policy != nil {
+		next := run
+		run = func(ctx context.Context, e *Execution) error {
+			if err := policy(e.Exec.Args); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrPolicy, e.Exec.Args[0], err)
+			}
+			return next(ctx, e)
+		}
+	}
+	return run
+}
+
+// observe reports a finished command to the runner's Metrics.
+func (r *Runner) observe(name string, exitCode int, duration time.Duration, err error) {
+	r.mu.RLock()
+	m := r.defaults.Metrics
+	r.mu.RUnlock()
+	if m != nil {
+		m.ObserveRun(name, exitCode, duration, err)
+	}
+}
+
+// observeWait reports how long a command waited for the limits to the
+// runner's Metrics, if they implement WaitMetrics.
+func (r *Runner) observeWait(name string, wait time.Duration) {
+	r.mu.RLock()
+	m, ok := r.defaults.Metrics.(WaitMetrics)
+	r.mu.RUnlock()
+	if ok {
+		m.ObserveWait(name, wait)
+	}
+}
+
+// Limits returns the statistics of the runner's MaxProcesses and RateLimit
+// limits, like the package-level Limits.
+func (r *Runner) Limits() LimitStats {
+	return r.limits.Stats()
+}
+
+// apply configures a newly built command with the runner's defaults.
+func (r *Runner) apply(cm *cmdImpl) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cm.runner = r
+	for k, v := range r.defaults.Env {
+		cm.env[k] = v
+	}
+	cm.dir = r.defaults.Dir
+	cm.dryRun = r.defaults.DryRun
+	if r.defaults.Logger != nil {
+		cm.log = newLogConfig(r.defaults.Logger, nil)
+	}
+}
+
+// stdio returns the default streams of the runner.
+func (r *Runner) stdio() (io.Reader, io.Writer, io.Writer) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaults.Stdin, r.defaults.Stdout, r.defaults.Stderr
+}
+
+func (r *Runner) setStdin(in io.Reader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults.Stdin = in
+}
+
+func (r *Runner) setStdout(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults.Stdout = w
+}
+
+func (r *Runner) setStderr(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults.Stderr = w
+}
+
+type runnerKey struct{}
+
+// WithRunner returns a copy of ctx carrying r, for FromContext.
+func WithRunner(ctx context.Context, r *Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, r)
+}
+
+// FromContext returns the Runner stored in ctx with WithRunner, or the
+// package-level runner used by New if there is none. It lets deeply nested
+// code build commands with the configuration chosen by its caller:
+//
+//	func fetch(ctx context.Context, repo string) error {
+//		_, err := sh.FromContext(ctx).New("git").Arg("fetch").Build(ctx).WithDir(repo).Run()
+//		return err
+//	}
+func FromContext(ctx context.Context) *Runner {
+	if r, ok := ctx.Value(runnerKey{}).(*Runner); ok && r != nil {
+		return r
+	}
+	return defaultRunner
+}
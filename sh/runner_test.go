@@ -0,0 +1,135 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestRunnerWithDefaults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var out, logs bytes.Buffer
+	dir := t.TempDir()
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{
+		Stdin:  strings.NewReader("from runner\n"),
+		Stdout: &out,
+		Env:    map[string]string{"SH_RUNNER": "scoped"},
+		Dir:    dir,
+		Logger: slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	result, err := runner.New("sh").OptV("-c", `cat; echo "$SH_RUNNER"; pwd`).Build(ctx).WithInteractive().Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "from runner\nscoped\n" + dir + "\n"
+	if string(result.Stdout()) != want {
+		t.Errorf("Expected %q, got %q", want, result.Stdout())
+	}
+	if out.String() != want {
+		t.Errorf("Expected the runner's stdout to receive %q, got %q", want, out.String())
+	}
+	if !strings.Contains(logs.String(), "sh: exit") {
+		t.Errorf("Expected the runner's logger to be used, got %q", logs.String())
+	}
+
+	// Commands built without the runner are unaffected
+	result, err = sh.New("sh").OptV("-c", `echo "$SH_RUNNER"`).Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Stdout()) != "\n" {
+		t.Errorf("Expected the runner's env not to leak, got %q", result.Stdout())
+	}
+}
+
+type recordingMetrics struct {
+	names []string
+	codes []int
+}
+
+func (m *recordingMetrics) ObserveRun(name string, exitCode int, _ time.Duration, _ error) {
+	m.names = append(m.names, name)
+	m.codes = append(m.codes, exitCode)
+}
+
+func TestRunnerPolicyExecutorMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metrics := &recordingMetrics{}
+	var wrapped []string
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{
+		Policy: func(argv []string) error {
+			if argv[0] == "rm" {
+				return errors.New("rm is not allowed")
+			}
+			return nil
+		},
+		Executor: func(_ context.Context, e *sh.Execution) error {
+			_, err := io.WriteString(e.Exec.Stdout, "faked "+strings.Join(e.Exec.Args, " ")+"\n")
+			return err
+		},
+		Metrics: metrics,
+	})
+	runner.Use(func(next sh.RunFunc) sh.RunFunc {
+		return func(ctx context.Context, e *sh.Execution) error {
+			wrapped = append(wrapped, e.Exec.Args[0])
+			return next(ctx, e)
+		}
+	})
+
+	b, err := runner.Parse("deploy --env prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := b.Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if string(result.Stdout()) != "faked deploy --env prod\n" {
+		t.Errorf("Expected the executor to run the command, got %q", result.Stdout())
+	}
+
+	_, err = runner.New("rm").Arg("-rf").Arg("/").Build(ctx).Run()
+	if !errors.Is(err, sh.ErrPolicy) {
+		t.Errorf("Expected a policy error, got %v", err)
+	}
+
+	if strings.Join(wrapped, ",") != "deploy" {
+		t.Errorf("Expected middleware to run for allowed commands only, got %v", wrapped)
+	}
+	if strings.Join(metrics.names, ",") != "deploy,rm" || metrics.codes[0] != 0 || metrics.codes[1] != -1 {
+		t.Errorf("Unexpected metrics: %+v", metrics)
+	}
+}
+
+func TestRunnerFromContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if sh.FromContext(ctx) == nil {
+		t.Fatal("Expected the default runner without a runner in ctx")
+	}
+
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{DryRun: true})
+	ctx = sh.WithRunner(ctx, runner)
+	if sh.FromContext(ctx) != runner {
+		t.Fatal("Expected the runner stored in ctx")
+	}
+
+	result, err := sh.FromContext(ctx).New("false").Build(ctx).Run()
+	if err != nil || !result.Invocation().DryRun {
+		t.Errorf("Expected the runner's dry-run mode to apply, got %v", err)
+	}
+}
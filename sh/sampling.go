@@ -0,0 +1,102 @@
+package sh
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// SamplingOption configures WithOutputSampling.
+type SamplingOption func(*sampling)
+
+type sampling struct {
+	rate  int
+	after int
+	keep  *regexp.Regexp
+}
+
+// defaultSamplingKeep matches the lines WithOutputSampling keeps unless
+// SampleKeep is given.
+var defaultSamplingKeep = regexp.MustCompile(`(?i)error|fail|fatal|panic|warn|exception`)
+
+// maxSampledLine is the length after which a line without a newline is
+// sampled as if it ended, so binary output cannot grow the pending line
+// without bound.
+const maxSampledLine = 64 << 10
+
+// SampleAfter sets how many lines of each stream are captured in full
+// before sampling starts. The default is 1000.
+func SampleAfter(lines int) SamplingOption {
+	return func(s *sampling) {
+		s.after = lines
+	}
+}
+
+// SampleKeep sets the pattern of lines that are always captured, such as
+// errors. The default matches "error", "fail", "fatal", "panic", "warn" and
+// "exception" in any case; nil keeps no lines beyond the sample.
+func SampleKeep(re *regexp.Regexp) SamplingOption {
+	return func(s *sampling) {
+		s.keep = re
+	}
+}
+
+func (cm *cmdImpl) WithOutputSampling(rate int, opts ...SamplingOption) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	s := &sampling{rate: rate, after: 1000, keep: defaultSamplingKeep}
+	for _, opt := range opts {
+		opt(s)
+	}
+	cm.sampling = s
+	if rate <= 1 {
+		cm.sampling = nil
+	}
+	return cm
+}
+
+// lineSampler decides line by line what a boundedBuffer keeps.
+type lineSampler struct {
+	*sampling
+	line []byte // incomplete last line
+	n    int    // lines seen
+}
+
+// write stores the lines of p in b that are kept, holding back an
+// incomplete last line.
+func (s *lineSampler) write(b *boundedBuffer, p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.line = append(s.line, p...)
+			if len(s.line) >= maxSampledLine {
+				s.flush(b)
+			}
+			return
+		}
+		if len(s.line) == 0 {
+			s.sample(b, p[:i+1])
+		} else {
+			s.line = append(s.line, p[:i+1]...)
+			s.flush(b)
+		}
+		p = p[i+1:]
+	}
+}
+
+// flush samples the incomplete last line, if any.
+func (s *lineSampler) flush(b *boundedBuffer) {
+	if len(s.line) > 0 {
+		s.sample(b, s.line)
+		s.line = s.line[:0]
+	}
+}
+
+func (s *lineSampler) sample(b *boundedBuffer, line []byte) {
+	s.n++
+	if s.n <= s.after || (s.n-s.after)%s.rate == 0 || (s.keep != nil && s.keep.Match(line)) {
+		b.store(line)
+		return
+	}
+	b.truncated = true
+}
@@ -0,0 +1,70 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdWithOutputSampling(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var streamed bytes.Buffer
+	result, err := sh.New("sh").
+		OptV("-c", `i=1; while [ $i -le 20 ]; do if [ $i = 13 ]; then echo "error at $i"; else echo "line $i"; fi; i=$((i+1)); done; printf tail`).
+		Build(ctx).
+		WithStdout(&streamed).
+		WithOutputSampling(5, sh.SampleAfter(3)).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "line 1\nline 2\nline 3\nline 8\nerror at 13\nline 18\n"
+	if got := string(result.Stdout()); got != want {
+		t.Errorf("Expected sampled output %q, got %q", want, got)
+	}
+	if !result.Truncated() {
+		t.Error("Expected Truncated to report dropped lines")
+	}
+	if lines := strings.Count(streamed.String(), "\n"); lines != 20 || !strings.HasSuffix(streamed.String(), "tail") {
+		t.Errorf("Expected every line to be streamed, got %q", streamed.String())
+	}
+}
+
+func TestCmdWithOutputSamplingKeep(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").
+		OptV("-c", "printf 'a\\nkeep b\\nc\\nd\\n' >&2").
+		Build(ctx).
+		WithOutputSampling(10, sh.SampleAfter(0), sh.SampleKeep(regexp.MustCompile("^keep"))).
+		Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if got := string(result.Stderr()); got != "keep b\n" {
+		t.Errorf("Expected only the kept line, got %q", got)
+	}
+}
+
+func TestCmdWithOutputSamplingQuiet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Output below the threshold is captured in full
+	result, err := sh.New("printf").Arg("one\ntwo").Build(ctx).WithOutputSampling(100).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if got := string(result.Stdout()); got != "one\ntwo" || result.Truncated() {
+		t.Errorf("Expected full output, got %q (truncated %t)", got, result.Truncated())
+	}
+}
@@ -0,0 +1,375 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+// ScriptBuilder runs a sequence of commands one after the other, like a
+// shell script with "set -e", but reporting the result of every step. It
+// is built with Script.
+//
+// The environment, working directory, timeout and retries of the script
+// apply to all of its steps. Each step can override them with the Step
+// methods, which apply to the step added last. A step's settings take
+// precedence over the script's, which take precedence over the Defaults
+// of the Runner the step's Builder comes from; variables are overridden
+// one by one.
+type ScriptBuilder struct {
+	ctx             context.Context
+	steps           []scriptStep
+	env             map[string]string
+	dir             string
+	timeout         time.Duration
+	retry           scriptRetry
+	continueOnError bool
+}
+
+type scriptStep struct {
+	builder *Builder
+	line    string // for steps given as text
+	err     error  // from parsing line
+	skipIf  *Builder
+	onlyIf  *Builder
+	env     map[string]string
+	dir     string
+	timeout time.Duration // 0 inherits the script's, negative means none
+	retry   scriptRetry   // inherits the script's if attempts is 0
+}
+
+type scriptRetry struct {
+	attempts int
+	strategy backoff.Strategy
+}
+
+// StepResult is the outcome of one step of a script.
+type StepResult struct {
+	// Line is the step as a command line.
+	Line string
+	// Result is nil for the builtins cd and export and for steps that did
+	// not run.
+	Result Result
+	Err    error
+	// Skipped is true for steps after a failure when failing fast and for
+	// steps whose SkipIf or OnlyIf probe said they need not run;
+	// SkipReason says which.
+	Skipped    bool
+	SkipReason string
+	// Attempts is how many times the step's command ran, more than once
+	// if it was retried.
+	Attempts int
+}
+
+// Script returns an empty script running its steps with ctx. Steps share a
+// working directory and environment, which the builtins "cd dir" and
+// "export KEY=value" change for the steps after them, as in a shell.
+func Script(ctx context.Context) *ScriptBuilder {
+	return &ScriptBuilder{ctx: ctx, env: make(map[string]string)}
+}
+
+// Add appends a command as a step.
+func (s *ScriptBuilder) Add(b *Builder) *ScriptBuilder {
+	s.steps = append(s.steps, scriptStep{builder: b, line: b.String()})
+	return s
+}
+
+// Line appends a command line as a step, split into words as by Parse, so
+// it must not use pipelines, redirection or expansion.
+func (s *ScriptBuilder) Line(line string) *ScriptBuilder {
+	step := scriptStep{line: line}
+	if !isScriptBuiltin(line) {
+		step.builder, step.err = Parse(line)
+	}
+	s.steps = append(s.steps, step)
+	return s
+}
+
+// Lines appends every line of text as a step, e.g. a snippet that would
+// otherwise be passed to "sh -c". Blank lines and lines starting with #
+// are skipped, and a line ending in a backslash continues on the next.
+func (s *ScriptBuilder) Lines(text string) *ScriptBuilder {
+	var pending string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if cont, ok := strings.CutSuffix(line, `\`); ok {
+			pending += cont + " "
+			continue
+		}
+		line, pending = pending+line, ""
+		if line != "" && !strings.HasPrefix(line, "#") {
+			s.Line(line)
+		}
+	}
+	if line := strings.TrimSpace(pending); line != "" {
+		s.Line(line)
+	}
+	return s
+}
+
+// SkipIf makes the step added last run only if probe fails, e.g.
+// "test -d build" for a step creating the directory, so that rerunning
+// the script skips work already done. The probe runs in the script's
+// directory and environment at that point.
+func (s *ScriptBuilder) SkipIf(probe *Builder) *ScriptBuilder {
+	s.steps[len(s.steps)-1].skipIf = probe
+	return s
+}
+
+// OnlyIf makes the step added last run only if probe succeeds.
+func (s *ScriptBuilder) OnlyIf(probe *Builder) *ScriptBuilder {
+	s.steps[len(s.steps)-1].onlyIf = probe
+	return s
+}
+
+// Env sets an environment variable for all steps.
+func (s *ScriptBuilder) Env(key, value string) *ScriptBuilder {
+	s.env[key] = value
+	return s
+}
+
+// Dir sets the working directory the script starts in.
+func (s *ScriptBuilder) Dir(dir string) *ScriptBuilder {
+	s.dir = dir
+	return s
+}
+
+// Timeout stops every step still running after d, unless the step sets
+// its own timeout.
+func (s *ScriptBuilder) Timeout(d time.Duration) *ScriptBuilder {
+	s.timeout = d
+	return s
+}
+
+// Retry runs a failing step again, up to attempts times in all, waiting
+// between attempts as strategy dictates; a nil strategy retries at once.
+// Steps may set their own retries.
+func (s *ScriptBuilder) Retry(attempts int, strategy backoff.Strategy) *ScriptBuilder {
+	s.retry = scriptRetry{attempts: max(attempts, 1), strategy: strategy}
+	return s
+}
+
+// StepEnv sets an environment variable for the step added last only,
+// overriding a variable of the same name set for the script.
+func (s *ScriptBuilder) StepEnv(key, value string) *ScriptBuilder {
+	step := &s.steps[len(s.steps)-1]
+	if step.env == nil {
+		step.env = make(map[string]string)
+	}
+	step.env[key] = value
+	return s
+}
+
+// StepDir runs the step added last in dir, relative to the script's
+// directory at that point, without changing it for the steps after it.
+func (s *ScriptBuilder) StepDir(dir string) *ScriptBuilder {
+	s.steps[len(s.steps)-1].dir = dir
+	return s
+}
+
+// StepTimeout sets the timeout of the step added last, overriding the
+// script's. A negative d runs the step without a timeout.
+func (s *ScriptBuilder) StepTimeout(d time.Duration) *ScriptBuilder {
+	s.steps[len(s.steps)-1].timeout = d
+	return s
+}
+
+// StepRetry sets the retries of the step added last, overriding the
+// script's; see Retry. An attempts of 1 disables retries for the step.
+func (s *ScriptBuilder) StepRetry(attempts int, strategy backoff.Strategy) *ScriptBuilder {
+	s.steps[len(s.steps)-1].retry = scriptRetry{attempts: max(attempts, 1), strategy: strategy}
+	return s
+}
+
+// ContinueOnError keeps running the remaining steps after one fails, as
+// without "set -e".
+func (s *ScriptBuilder) ContinueOnError() *ScriptBuilder {
+	s.continueOnError = true
+	return s
+}
+
+// Run runs the steps in order and returns a StepResult for each of them.
+// By default it stops at the first failing step, marking the rest as
+// skipped; the error names the step. With ContinueOnError the errors of
+// all failed steps are joined.
+func (s *ScriptBuilder) Run() ([]StepResult, error) {
+	dir := s.dir
+	env := maps.Clone(s.env)
+	results := make([]StepResult, 0, len(s.steps))
+	var errs []error
+
+	for i, step := range s.steps {
+		res := StepResult{Line: step.line}
+		if len(errs) > 0 && !s.continueOnError {
+			res.Skipped = true
+			res.SkipReason = "an earlier step failed"
+			results = append(results, res)
+			continue
+		}
+
+		build := s.stepBuild(step, dir, env)
+		run := func(b *Builder) (Result, error) {
+			return build(b).Run()
+		}
+		switch {
+		case step.err != nil:
+			res.Err = step.err
+		case s.ctx.Err() != nil:
+			res.Err = s.ctx.Err()
+		default:
+			res.SkipReason, res.Err = step.skip(run)
+			res.Skipped = res.SkipReason != ""
+		}
+		switch {
+		case res.Err != nil || res.Skipped:
+		case step.builder == nil:
+			res.Err = runScriptBuiltin(step.line, &dir, env)
+		default:
+			res.Result, res.Attempts, res.Err = s.runStep(step, build)
+		}
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("sh: script step %d (%s): %w", i+1, step.line, res.Err))
+		}
+		results = append(results, res)
+	}
+	return results, errors.Join(errs...)
+}
+
+// skip runs the probes of the step with run and returns why it is
+// skipped, if it is. A probe that cannot start fails the step rather than
+// deciding it.
+func (step scriptStep) skip(run func(*Builder) (Result, error)) (reason string, err error) {
+	var startErr *StartError
+	if step.skipIf != nil {
+		_, err := run(step.skipIf)
+		if errors.As(err, &startErr) {
+			return "", fmt.Errorf("SkipIf probe: %w", err)
+		}
+		if err == nil {
+			return fmt.Sprintf("%s succeeded", step.skipIf), nil
+		}
+	}
+	if step.onlyIf != nil {
+		_, err := run(step.onlyIf)
+		if errors.As(err, &startErr) {
+			return "", fmt.Errorf("OnlyIf probe: %w", err)
+		}
+		if err != nil {
+			return fmt.Sprintf("%s failed: %v", step.onlyIf, err), nil
+		}
+	}
+	return "", nil
+}
+
+// stepBuild returns a function building commands for step, given the
+// script's current directory and environment.
+func (s *ScriptBuilder) stepBuild(step scriptStep, dir string, env map[string]string) func(*Builder) Cmd {
+	if len(step.env) > 0 {
+		env = maps.Clone(env)
+		maps.Copy(env, step.env)
+	}
+	if step.dir != "" {
+		if filepath.IsAbs(step.dir) || dir == "" {
+			dir = step.dir
+		} else {
+			dir = filepath.Join(dir, step.dir)
+		}
+	}
+	timeout := s.timeout
+	if step.timeout != 0 {
+		timeout = step.timeout
+	}
+
+	return func(b *Builder) Cmd {
+		cmd := b.Build(s.ctx).WithEnvMap(env)
+		if dir != "" {
+			cmd.WithDir(dir)
+		}
+		if timeout > 0 {
+			cmd.WithTimeout(timeout)
+		}
+		return cmd
+	}
+}
+
+// runStep runs the command of step, retrying it as configured, and
+// returns the outcome of the last attempt and the number of attempts.
+func (s *ScriptBuilder) runStep(step scriptStep, build func(*Builder) Cmd) (Result, int, error) {
+	retry := s.retry
+	if step.retry.attempts != 0 {
+		retry = step.retry
+	}
+	strategy := retry.strategy
+	if strategy == nil {
+		strategy = backoff.Constant(0)
+	}
+	b := backoff.New(strategy)
+
+	for attempt := 1; ; attempt++ {
+		cmd := build(step.builder).WithAttempt(attempt)
+		result, err := cmd.Run()
+		if err == nil || attempt >= retry.attempts || s.ctx.Err() != nil {
+			return result, attempt, err
+		}
+		delay := b.Next()
+		logRetry(cmd, attempt, delay, err)
+		if backoff.Sleep(s.ctx, delay) != nil {
+			return result, attempt, err
+		}
+	}
+}
+
+func isScriptBuiltin(line string) bool {
+	name, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	return name == "cd" || name == "export"
+}
+
+// runScriptBuiltin runs cd or export against the script's state.
+func runScriptBuiltin(line string, dir *string, env map[string]string) error {
+	b, err := Parse(line)
+	if err != nil {
+		return err
+	}
+	args := b.Items()[1:]
+
+	if b.Cmd == "export" {
+		for _, arg := range args {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("export: expected KEY=value, got %q", arg)
+			}
+			env[key] = value
+		}
+		return nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("cd: expected one directory, got %d", len(args))
+	}
+	target := args[0]
+	if !filepath.IsAbs(target) {
+		base := *dir
+		if base == "" {
+			if base, err = os.Getwd(); err != nil {
+				return err
+			}
+		}
+		target = filepath.Join(base, target)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("cd: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cd: %s: not a directory", target)
+	}
+	*dir = target
+	return nil
+}
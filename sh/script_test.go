@@ -0,0 +1,212 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+func TestScript(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+
+	results, err := sh.Script(ctx).
+		Dir(dir).
+		Env("GREETING", "hello").
+		Lines(`
+			# set up
+			cd sub
+			export NAME=world \
+				OTHER=x
+			sh -c 'echo "$GREETING $NAME"; pwd'
+		`).
+		Add(sh.New("sh").OptV("-c", "echo err >&2; exit 3")).
+		Line("echo never").
+		Run()
+
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Fatalf("Expected the exit error of step 4, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "step 4") {
+		t.Errorf("Expected the error to name the step, got %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 step results, got %d", len(results))
+	}
+
+	if results[0].Line != "cd sub" || results[0].Result != nil || results[0].Err != nil {
+		t.Errorf("Unexpected cd step %+v", results[0])
+	}
+	want := "hello world\n" + filepath.Join(dir, "sub") + "\n"
+	if got := string(results[2].Result.Stdout()); got != want {
+		t.Errorf("Expected shared env and dir, got %q", got)
+	}
+	if r := results[3]; r.Result.ExitCode() != 3 || string(r.Result.Stderr()) != "err\n" {
+		t.Errorf("Expected per-step output and exit code, got %+v", r)
+	}
+	if r := results[4]; !r.Skipped || r.Result != nil || r.Err != nil {
+		t.Errorf("Expected the last step to be skipped, got %+v", r)
+	}
+}
+
+func TestScriptContinueOnError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.Script(ctx).
+		ContinueOnError().
+		Line("false").
+		Line("cd /does/not/exist").
+		Line("echo 'still here'").
+		Line("echo unterminated 'quote").
+		Run()
+	if err == nil {
+		t.Fatal("Expected the failures to be reported")
+	}
+	for _, step := range []string{"step 1", "step 2", "step 4"} {
+		if !strings.Contains(err.Error(), step) {
+			t.Errorf("Expected %s in %v", step, err)
+		}
+	}
+	if got := string(results[2].Result.Stdout()); got != "still here\n" {
+		t.Errorf("Expected later steps to run, got %q", got)
+	}
+	if results[3].Err == nil || results[3].Result != nil {
+		t.Errorf("Expected a parse error for step 4, got %+v", results[3])
+	}
+}
+
+func TestScriptSkipIf(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	script := func() *sh.ScriptBuilder {
+		return sh.Script(ctx).Dir(dir).
+			Line("mkdir build").SkipIf(sh.New("test").OptV("-d", "build")).
+			Line("touch build/ok").OnlyIf(sh.New("test").OptV("-d", "build")).
+			Line("touch never").OnlyIf(sh.New("test").OptV("-e", "missing"))
+	}
+
+	results, err := script().Run()
+	if err != nil {
+		t.Fatalf("First run failed: %v", err)
+	}
+	if results[0].Skipped || results[1].Skipped {
+		t.Errorf("Expected the first run to do the work, got %+v", results)
+	}
+	if !results[2].Skipped || !strings.Contains(results[2].SkipReason, "test -e missing failed") {
+		t.Errorf("Expected the OnlyIf step to be skipped, got %+v", results[2])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "never")); err == nil {
+		t.Error("Expected the skipped step not to run")
+	}
+
+	// Rerunning skips the mkdir that would now fail
+	results, err = script().Run()
+	if err != nil {
+		t.Fatalf("Rerun failed: %v", err)
+	}
+	if !results[0].Skipped || results[0].SkipReason != "test -d build succeeded" || results[0].Result != nil {
+		t.Errorf("Expected mkdir to be skipped, got %+v", results[0])
+	}
+}
+
+func TestScriptSkipIfProbeMissing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.Script(ctx).
+		Line("true").SkipIf(sh.New("no-such-probe-command")).
+		Run()
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) || results[0].Skipped {
+		t.Errorf("Expected a probe that cannot start to fail the step, got %v", err)
+	}
+}
+
+func TestScriptDefaults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Env: map[string]string{"A": "runner", "B": "runner", "C": "runner"}})
+	echo := func() *sh.Builder {
+		return runner.New("sh").OptV("-c", `echo "$A $B $C $(basename "$PWD")"`)
+	}
+	results, err := sh.Script(ctx).
+		Dir(dir).
+		Env("B", "script").
+		Env("C", "script").
+		Add(echo()).
+		Add(echo()).StepEnv("C", "step").StepDir("sub").
+		Add(echo()).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"runner script script " + filepath.Base(dir),
+		"runner script step sub",
+		"runner script script " + filepath.Base(dir),
+	}
+	for i, w := range want {
+		if got := strings.TrimSpace(string(results[i].Result.Stdout())); got != w {
+			t.Errorf("Step %d: expected %q, got %q", i+1, w, got)
+		}
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := sh.Script(ctx).
+		Timeout(50 * time.Millisecond).
+		ContinueOnError().
+		Line("sleep 1").
+		Line("sleep 0.2").StepTimeout(-1).
+		Run()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the first step to time out, got %v", err)
+	}
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("Expected only the first step to fail, got %v and %v", results[0].Err, results[1].Err)
+	}
+}
+
+func TestScriptRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Succeeds on the third run
+	counter := filepath.Join(t.TempDir(), "count")
+	flaky := sh.New("sh").OptV("-c", `echo x >> "$1"; [ "$(wc -l < "$1")" -ge 3 ]`).Arg("sh").Arg(counter)
+
+	results, err := sh.Script(ctx).
+		Retry(5, backoff.Constant(time.Millisecond)).
+		Add(flaky).
+		Line("false").StepRetry(1, nil).
+		Run()
+
+	if results[0].Err != nil || results[0].Attempts != 3 {
+		t.Errorf("Expected the flaky step to succeed on the third attempt, got %d: %v", results[0].Attempts, results[0].Err)
+	}
+	if err == nil || results[1].Attempts != 1 {
+		t.Errorf("Expected the step without retries to fail once, got %d: %v", results[1].Attempts, err)
+	}
+}
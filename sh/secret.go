@@ -0,0 +1,85 @@
+package sh
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+func (cm *cmdImpl) WithSecret(value string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.addSecret(value)
+	return cm
+}
+
+func (cm *cmdImpl) WithSecretEnv(key, value string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.setEnv(key, value)
+	cm.addSecret(value)
+	return cm
+}
+
+func (cm *cmdImpl) WithOutputRedaction() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.redactOutput = true
+	return cm
+}
+
+func (cm *cmdImpl) addSecret(value string) {
+	// An empty secret would match everywhere
+	if value == "" || slices.Contains(cm.secrets, value) {
+		return
+	}
+	cm.secrets = append(cm.secrets, value)
+	// Longer secrets first, so a secret containing another is masked whole
+	slices.SortStableFunc(cm.secrets, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	pairs := make([]string, 0, 2*len(cm.secrets))
+	for _, s := range cm.secrets {
+		pairs = append(pairs, s, redacted)
+	}
+	cm.redactor = strings.NewReplacer(pairs...)
+}
+
+// redact masks the command's secrets in s. The caller must hold cm.mu or
+// know that the command is running, when its secrets no longer change.
+func (cm *cmdImpl) redact(s string) string {
+	if cm.redactor == nil {
+		return s
+	}
+	return cm.redactor.Replace(s)
+}
+
+// redactAll returns values with the command's secrets masked.
+func (cm *cmdImpl) redactAll(values []string) []string {
+	if cm.redactor == nil {
+		return values
+	}
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = cm.redactor.Replace(v)
+	}
+	return out
+}
+
+// redactBytes masks the command's secrets in captured output.
+func (cm *cmdImpl) redactBytes(b []byte) []byte {
+	if cm.redactor == nil || b == nil {
+		return b
+	}
+	return []byte(cm.redactor.Replace(string(b)))
+}
+
+// RedactedArgs returns the command line of the execution with the secrets
+// marked by WithSecret and WithSecretEnv masked, for middleware recording
+// commands.
+func (e *Execution) RedactedArgs() []string {
+	if cm, ok := e.Cmd.(*cmdImpl); ok {
+		return cm.redactAll(e.Exec.Args)
+	}
+	return e.Exec.Args
+}
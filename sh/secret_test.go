@@ -0,0 +1,159 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestSecretString(t *testing.T) {
+	ctx := context.Background()
+
+	cmd := sh.New("curl").OptV("-H", "Authorization: Bearer s3cr3t").Arg("https://example.com").Build(ctx).
+		WithSecret("s3cr3t").
+		WithSecretEnv("DB_PASS", "hunter2")
+
+	got := cmd.String()
+	if strings.Contains(got, "s3cr3t") || strings.Contains(got, "hunter2") {
+		t.Errorf("Expected secrets to be masked, got %s", got)
+	}
+	want := "DB_PASS='[REDACTED]' curl -H 'Authorization: Bearer [REDACTED]' https://example.com"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestSecretOverlapping(t *testing.T) {
+	cmd := sh.New("echo").Arg("abcdef").Build(context.Background()).
+		WithSecret("abc").
+		WithSecret("abcdef").
+		WithSecret("")
+
+	if got := cmd.String(); got != "echo '[REDACTED]'" {
+		t.Errorf("Expected the longer secret to be masked whole, got %s", got)
+	}
+}
+
+func TestSecretReachesCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("sh").OptV("-c", `echo "$1 $TOKEN"`).Arg("sh").Arg("s3cr3t").Build(ctx).
+		WithSecret("s3cr3t").
+		WithSecretEnv("TOKEN", "hunter2").
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout())); got != "s3cr3t hunter2" {
+		t.Errorf("Expected the command to receive the secrets, got %q", got)
+	}
+}
+
+func TestSecretLogged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := sh.New("true").Arg("--token=s3cr3t").Build(ctx).
+		WithSecret("s3cr3t").
+		WithSecretEnv("REGION", "hunter2").
+		WithLogger(logger).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") || strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("Expected secrets to be masked in logs:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "--token=[REDACTED]") {
+		t.Errorf("Expected the masked argument in logs:\n%s", buf.String())
+	}
+}
+
+func TestSecretMiddleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var args []string
+	_, err := sh.New("true").Arg("s3cr3t").Build(ctx).
+		WithSecret("s3cr3t").
+		WithMiddleware(func(next sh.RunFunc) sh.RunFunc {
+			return func(ctx context.Context, e *sh.Execution) error {
+				args = e.RedactedArgs()
+				return next(ctx, e)
+			}
+		}).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[1] != "[REDACTED]" {
+		t.Errorf("Expected masked arguments, got %q", args)
+	}
+}
+
+func TestOutputRedaction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var streamed bytes.Buffer
+	result, err := sh.New("sh").OptV("-c", `echo "token is $TOKEN"; echo "$TOKEN" >&2`).Build(ctx).
+		WithSecretEnv("TOKEN", "hunter2").
+		WithOutputRedaction().
+		WithCombinedOutput().
+		WithStdout(&streamed).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(result.Stdout()); got != "token is [REDACTED]\n" {
+		t.Errorf("Expected masked stdout, got %q", got)
+	}
+	if got := string(result.Stderr()); got != "[REDACTED]\n" {
+		t.Errorf("Expected masked stderr, got %q", got)
+	}
+	if strings.Contains(string(result.Combined()), "hunter2") {
+		t.Errorf("Expected masked combined output, got %q", result.Combined())
+	}
+	if got := streamed.String(); got != "token is hunter2\n" {
+		t.Errorf("Expected writers to receive the output as is, got %q", got)
+	}
+}
+
+func TestOutputNotRedactedByDefault(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("echo").Arg("hunter2").Build(ctx).WithSecret("hunter2").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(result.Stdout()); got != "hunter2\n" {
+		t.Errorf("Expected stdout as is, got %q", got)
+	}
+}
+
+func TestSecretEnvInheritedByPipeStage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("echo").Arg("data").Build(ctx).
+		WithSecretEnv("TOKEN", "hunter2").
+		WithOutputRedaction().
+		Pipe("sh").OptV("-c", `cat >/dev/null; echo "$TOKEN" >&2`).Build().
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(result.Stderr()); got != "[REDACTED]\n" {
+		t.Errorf("Expected the inherited secret to be masked, got %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sync/atomic"
+)
+
+// secretFile is an option whose value is the path of a file holding a
+// secret, written before the command starts and removed once it exits.
+type secretFile struct {
+	flag  string
+	value string
+	// token stands for the path, which differs from run to run, in the
+	// command line until the command starts
+	token string
+}
+
+var secretFiles atomic.Int64
+
+// Items returns the flag followed by a stand-in for the file's path.
+func (s *secretFile) Items() []string {
+	return []string{s.flag, s.token}
+}
+
+// Parent returns the parent component, which is always nil for secret
+// files.
+func (s *secretFile) Parent() CmdComponent {
+	return nil
+}
+
+// OptSecretFile passes value to the command through a file rather than on
+// the command line, where any user of the machine can read it in ps or
+// /proc: the command gets flag followed by the path of a file only the
+// current user can read, holding value, which is removed once the command
+// exits. Use it for tools taking both, e.g. replace
+//
+//	OptV("--token", token)
+//
+// with
+//
+//	OptSecretFile("--token-file", token)
+//
+// The value is masked like those marked with WithSecret. For tools reading
+// secrets from the environment, use Cmd.WithSecretEnv.
+func (b *Builder) OptSecretFile(flag, value string) *Builder {
+	token := fmt.Sprintf("<secret-file-%d>", secretFiles.Add(1))
+	b.components = append(b.components, &secretFile{flag: flag, value: value, token: token})
+	return b
+}
+
+// OptSecretFile adds an option passing value through a file to the
+// subcommand and returns the SubCmd. See Builder.OptSecretFile.
+func (s *SubCmd) OptSecretFile(flag, value string) *SubCmd {
+	s.Builder.OptSecretFile(flag, value)
+	return s
+}
+
+// OptSecretFile adds an option passing value through a file to the pipe
+// command and returns the PipeBuilder. See Builder.OptSecretFile.
+func (pb *PipeBuilder) OptSecretFile(flag, value string) *PipeBuilder {
+	pb.Builder.OptSecretFile(flag, value)
+	return pb
+}
+
+// WriteSecretFile writes value to a new temporary file only the current
+// user can read, for passing secrets to tools by path in ways OptSecretFile
+// does not cover, e.g. in a configuration file. cleanup removes the file.
+func WriteSecretFile(value string) (path string, cleanup func() error, err error) {
+	// CreateTemp creates files only the current user can read
+	f, err := os.CreateTemp("", "sh-secret-*")
+	if err != nil {
+		return "", nil, err
+	}
+	path = f.Name()
+	cleanup = func() error {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// secretFilesHook writes the files of secrets before the command starts,
+// putting their paths in place of their tokens, and removes them once it
+// has exited.
+func secretFilesHook(secrets []*secretFile) execHook {
+	var cleanups []func() error
+	removeAll := func() error {
+		var err error
+		for _, cleanup := range cleanups {
+			if cerr := cleanup(); err == nil {
+				err = cerr
+			}
+		}
+		cleanups = nil
+		return err
+	}
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			for _, s := range secrets {
+				i := slices.Index(cmd.Args, s.token)
+				if i < 0 {
+					continue
+				}
+				path, cleanup, err := WriteSecretFile(s.value)
+				if err != nil {
+					removeAll()
+					return err
+				}
+				cleanups = append(cleanups, cleanup)
+				cmd.Args[i] = path
+			}
+			return nil
+		},
+		after: func(error) error {
+			return removeAll()
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestOptSecretFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// $0 is the flag and $1 the path
+	cmd := sh.New("sh").
+		OptV("-c", `cat "$1"; echo; echo "$1"`).
+		OptSecretFile("--token-file", "s3cr3t").
+		Build(ctx)
+	if strings.Contains(cmd.String(), "s3cr3t") {
+		t.Errorf("Expected the secret to stay off the command line, got %s", cmd)
+	}
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, path, _ := strings.Cut(result.TrimmedString(), "\n")
+	if content != "s3cr3t" {
+		t.Errorf("Expected the file to hold the secret, got %q", content)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed after the run, got %v", path, err)
+	}
+	if strings.Contains(strings.Join(result.Invocation().Args, " "), "s3cr3t") {
+		t.Errorf("Expected the secret to stay off argv, got %q", result.Invocation().Args)
+	}
+}
+
+func TestWriteSecretFile(t *testing.T) {
+	path, cleanup, err := sh.WriteSecretFile("s3cr3t")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the file to exist, got %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "s3cr3t" {
+		t.Errorf("Expected the secret, got %q", data)
+	}
+
+	if err := cleanup(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// inheritedFilesEnv tells a process started by Reexec how many descriptors
+// it inherited, starting at descriptor 3.
+const inheritedFilesEnv = "SH_INHERITED_FILES"
+
+// Reexec describes a new instance of the current program, or of a managed
+// tool, to start after its binary has been replaced, e.g. by a self-update.
+// Zero fields default to those of the current process.
+type Reexec struct {
+	// Path is the executable to start; it defaults to os.Executable.
+	Path string
+	// Args are the arguments, excluding the program name; they default to
+	// os.Args[1:].
+	Args []string
+	// Env is the environment; it defaults to os.Environ.
+	Env []string
+	// Files are handed over to the new process as descriptors 3, 4, ...,
+	// typically the files of listening sockets so connections are not
+	// refused during the update. The new process retrieves them with
+	// InheritedFiles or InheritedListeners.
+	Files []*os.File
+}
+
+// Command returns an unstarted command for the new instance. It shares the
+// standard input and output of the current process.
+func (r Reexec) Command(ctx context.Context) (Cmd, error) {
+	path := r.Path
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("sh: reexec: %w", err)
+		}
+		path = exe
+	}
+	args := r.Args
+	if args == nil && len(os.Args) > 1 {
+		args = os.Args[1:]
+	}
+	env := r.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(withoutEnv(env, inheritedFilesEnv), inheritedFilesEnv+"="+strconv.Itoa(len(r.Files)))
+
+	b := New(path)
+	for _, arg := range args {
+		b.Arg(arg)
+	}
+
+	cm := b.Build(ctx).WithInteractive().(*cmdImpl)
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(cmd *exec.Cmd) error {
+			cmd.Env = env
+			cmd.ExtraFiles = append(cmd.ExtraFiles, r.Files...)
+			return nil
+		},
+	})
+	return cm, nil
+}
+
+// InheritedFiles returns the files handed over by the process that started
+// the current one with Reexec, or nil.
+func InheritedFiles() []*os.File {
+	n, err := strconv.Atoi(os.Getenv(inheritedFilesEnv))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	files := make([]*os.File, n)
+	for i := range files {
+		files[i] = os.NewFile(uintptr(3+i), "inherited-"+strconv.Itoa(i))
+	}
+	return files
+}
+
+// InheritedListeners returns the listening sockets handed over with
+// Reexec.
+func InheritedListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, f := range InheritedFiles() {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return listeners, fmt.Errorf("sh: inherited listener: %w", err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// ReplaceExecutable replaces the executable at path with the contents of
+// src, keeping its file mode. The new binary is written next to the old one
+// and renamed into place, so path never holds a partial file. Windows does
+// not allow overwriting a running executable, so there the old binary is
+// first moved aside to path+".old"; remove it with RemoveReplacedExecutable
+// once it is no longer running.
+func ReplaceExecutable(path string, src io.Reader) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".new*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, src)
+	err = errors.Join(err, tmp.Sync(), tmp.Close())
+	if err != nil {
+		return fmt.Errorf("sh: replace executable: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return fmt.Errorf("sh: replace executable: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			// Put the old binary back
+			os.Rename(old, path)
+			return fmt.Errorf("sh: replace executable: %w", err)
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RemoveReplacedExecutable removes the old binary left behind by
+// ReplaceExecutable on Windows. It is a no-op if there is none.
+func RemoveReplacedExecutable(path string) error {
+	err := os.Remove(path + ".old")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// withoutEnv returns env without the variable name.
+func withoutEnv(env []string, name string) []string {
+	kept := make([]string, 0, len(env))
+	for _, kv := range env {
+		if len(kv) > len(name) && kv[:len(name)] == name && kv[len(name)] == '=' {
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return kept
+}
@@ -0,0 +1,80 @@
+package sh_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestReplaceExecutable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sh.ReplaceExecutable(path, strings.NewReader("new")); err != nil {
+		t.Fatalf("ReplaceExecutable failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "new" {
+		t.Errorf("Expected the new contents, got %q", data)
+	}
+	info, _ := os.Stat(path)
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o755 {
+		t.Errorf("Expected mode 0755 to be kept, got %v", info.Mode().Perm())
+	}
+	if err := sh.RemoveReplacedExecutable(path); err != nil {
+		t.Errorf("RemoveReplacedExecutable failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected no leftover files, got %d entries", len(entries))
+	}
+}
+
+func TestReexecHandsOverListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Descriptor handover is not supported on Windows")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cmd, err := sh.Reexec{
+		Path:  "/bin/sh",
+		Args:  []string{"-c", `echo "$SH_INHERITED_FILES $KEPT"; test -e /dev/fd/3 && echo fd3`},
+		Env:   []string{"KEPT=yes", "SH_INHERITED_FILES=9"},
+		Files: []*os.File{f},
+	}.Command(ctx)
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	result, err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Reexec failed: %v", err)
+	}
+	if got := string(result.Stdout()); got != "1 yes\nfd3\n" {
+		t.Errorf("Expected the file count, environment and descriptor to be passed, got %q", got)
+	}
+}
@@ -0,0 +1,436 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RestartPolicy says when a service manager or a Supervisor restarts a
+// service.
+type RestartPolicy int
+
+const (
+	// RestartOnFailure restarts the service when it exits unsuccessfully.
+	RestartOnFailure RestartPolicy = iota
+	// RestartAlways restarts the service whenever it exits. Windows does
+	// not restart services that exit successfully.
+	RestartAlways
+	// RestartNever leaves the service stopped once it exits.
+	RestartNever
+)
+
+// ServiceSpec describes a command to be installed as a persistent service
+// of the operating system's service manager: a systemd unit on Linux, a
+// launchd job on macOS or a Windows service.
+type ServiceSpec struct {
+	// Name identifies the service: the unit name without ".service", the
+	// launchd label or the Windows service name.
+	Name        string
+	Description string
+	// Args is the absolute path of the program followed by its arguments.
+	Args []string
+	// Env holds the variables set for the service. Service managers start
+	// services with a minimal environment rather than the installer's.
+	Env map[string]string
+	// Dir is the working directory. Not supported for Windows services.
+	Dir     string
+	Restart RestartPolicy
+	// User runs a system service as the named user. Not supported for
+	// Windows services, which need the account's password.
+	User string
+}
+
+// NewServiceSpec returns a spec running cmd as the service name, with the
+// variables set on cmd with WithEnv and its working directory. The program
+// is resolved to an absolute path, as service managers do not search PATH.
+func NewServiceSpec(name string, cmd Cmd) (ServiceSpec, error) {
+	cm, ok := cmd.(*cmdImpl)
+	if !ok {
+		return ServiceSpec{}, fmt.Errorf("sh: cannot install %T as a service", cmd)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	program, err := exec.LookPath(cm.cmd)
+	if err != nil {
+		return ServiceSpec{}, fmt.Errorf("sh: service %s: %w", name, err)
+	}
+	if program, err = filepath.Abs(program); err != nil {
+		return ServiceSpec{}, fmt.Errorf("sh: service %s: %w", name, err)
+	}
+
+	return ServiceSpec{
+		Name: name,
+		Args: append([]string{program}, cm.args...),
+		Env:  maps.Clone(cm.env),
+		Dir:  cm.dir,
+	}, nil
+}
+
+// ServiceOption configures InstallService and UninstallService.
+type ServiceOption func(*serviceConfig)
+
+type serviceConfig struct {
+	user bool
+	dir  string
+}
+
+// UserService installs the service for the current user rather than the
+// whole system: a systemd user unit or a launchd agent. It has no effect on
+// Windows.
+func UserService() ServiceOption {
+	return func(c *serviceConfig) {
+		c.user = true
+	}
+}
+
+// ServiceDir overrides the directory the unit file or plist is written to.
+func ServiceDir(dir string) ServiceOption {
+	return func(c *serviceConfig) {
+		c.dir = dir
+	}
+}
+
+// InstallService installs spec with the platform's service manager, enables
+// it to start at boot (or login, for user services) and starts it. System
+// services usually require root or administrator rights. The service
+// manager's tools are run with the runner from ctx.
+//
+// A Windows service must implement the service control protocol, e.g. with
+// golang.org/x/sys/windows/svc; other programs are stopped by the service
+// manager shortly after starting.
+func InstallService(ctx context.Context, spec ServiceSpec, opts ...ServiceOption) error {
+	if spec.Name == "" || len(spec.Args) == 0 {
+		return errors.New("sh: service spec needs a name and a program")
+	}
+	cfg := newServiceConfig(opts)
+
+	switch runtime.GOOS {
+	case "linux":
+		path, err := cfg.unitPath(spec.Name, ".service", systemdDir)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(spec.SystemdUnit(cfg.user)), 0o644); err != nil {
+			return fmt.Errorf("sh: install service %s: %w", spec.Name, err)
+		}
+		return runService(ctx,
+			cfg.systemctl("daemon-reload"),
+			cfg.systemctl("enable", "--now", spec.Name+".service"))
+	case "darwin":
+		path, err := cfg.unitPath(spec.Name, ".plist", launchdDir)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(spec.LaunchdPlist(cfg.user)), 0o644); err != nil {
+			return fmt.Errorf("sh: install service %s: %w", spec.Name, err)
+		}
+		return runService(ctx, []string{"launchctl", "bootstrap", launchdDomain(cfg.user), path})
+	case "windows":
+		argvs, err := spec.windowsInstall()
+		if err != nil {
+			return err
+		}
+		return runService(ctx, argvs...)
+	}
+	return fmt.Errorf("sh: install service: %w", errors.ErrUnsupported)
+}
+
+// UninstallService stops the named service, disables it and removes its
+// unit file or plist.
+func UninstallService(ctx context.Context, name string, opts ...ServiceOption) error {
+	cfg := newServiceConfig(opts)
+
+	switch runtime.GOOS {
+	case "linux":
+		path, err := cfg.unitPath(name, ".service", systemdDir)
+		if err != nil {
+			return err
+		}
+		if err := runService(ctx, cfg.systemctl("disable", "--now", name+".service")); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("sh: uninstall service %s: %w", name, err)
+		}
+		return runService(ctx, cfg.systemctl("daemon-reload"))
+	case "darwin":
+		path, err := cfg.unitPath(name, ".plist", launchdDir)
+		if err != nil {
+			return err
+		}
+		if err := runService(ctx, []string{"launchctl", "bootout", launchdDomain(cfg.user) + "/" + name}); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("sh: uninstall service %s: %w", name, err)
+		}
+		return nil
+	case "windows":
+		// Stopping fails if the service is not running, which is fine
+		FromContext(ctx).New("sc.exe").Arg("stop").Arg(name).Build(ctx).Run()
+		return runService(ctx, []string{"sc.exe", "delete", name})
+	}
+	return fmt.Errorf("sh: uninstall service: %w", errors.ErrUnsupported)
+}
+
+func newServiceConfig(opts []ServiceOption) serviceConfig {
+	var cfg serviceConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// unitPath returns the path of the unit file for the service name, in the
+// configured directory or the one returned by defaultDir.
+func (c serviceConfig) unitPath(name, ext string, defaultDir func(user bool) (string, error)) (string, error) {
+	dir := c.dir
+	if dir == "" {
+		var err error
+		if dir, err = defaultDir(c.user); err != nil {
+			return "", fmt.Errorf("sh: service %s: %w", name, err)
+		}
+	}
+	return filepath.Join(dir, name+ext), nil
+}
+
+func (c serviceConfig) systemctl(args ...string) []string {
+	if c.user {
+		return append([]string{"systemctl", "--user"}, args...)
+	}
+	return append([]string{"systemctl"}, args...)
+}
+
+func systemdDir(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(config, "systemd", "user")
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+func launchdDir(user bool) (string, error) {
+	if !user {
+		return "/Library/LaunchDaemons", nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents"), nil
+}
+
+func launchdDomain(user bool) string {
+	if user {
+		return "gui/" + strconv.Itoa(os.Getuid())
+	}
+	return "system"
+}
+
+// runService runs each argv in turn, stopping at the first failure.
+func runService(ctx context.Context, argvs ...[]string) error {
+	for _, argv := range argvs {
+		b := FromContext(ctx).New(argv[0])
+		for _, arg := range argv[1:] {
+			b.Arg(arg)
+		}
+		cmd := b.Build(ctx)
+		result, err := cmd.Run()
+		if err != nil {
+			if result != nil && len(bytes.TrimSpace(result.Stderr())) > 0 {
+				return fmt.Errorf("sh: %s: %w: %s", cmd, err, bytes.TrimSpace(result.Stderr()))
+			}
+			return fmt.Errorf("sh: %s: %w", cmd, err)
+		}
+	}
+	return nil
+}
+
+// SystemdUnit renders spec as a systemd service unit, for a user unit if
+// user is set.
+func (s ServiceSpec) SystemdUnit(user bool) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	if s.Description != "" {
+		fmt.Fprintf(&b, "Description=%s\n", systemdValue(s.Description))
+	}
+
+	b.WriteString("\n[Service]\n")
+	quoted := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		quoted[i] = systemdQuote(arg, true)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(k+"="+s.Env[k], false))
+	}
+	if s.Dir != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdValue(s.Dir))
+	}
+	if s.User != "" && !user {
+		fmt.Fprintf(&b, "User=%s\n", systemdValue(s.User))
+	}
+	fmt.Fprintf(&b, "Restart=%s\n", map[RestartPolicy]string{
+		RestartOnFailure: "on-failure",
+		RestartAlways:    "always",
+		RestartNever:     "no",
+	}[s.Restart])
+
+	b.WriteString("\n[Install]\n")
+	if user {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String()
+}
+
+// systemdQuote quotes s for a systemd unit file, escaping specifiers and,
+// in ExecStart arguments, variable references.
+func systemdQuote(s string, execArg bool) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "\n", `\n`).Replace(s)
+	if execArg {
+		s = strings.ReplaceAll(s, "$", "$$")
+	}
+	if s == "" || strings.ContainsAny(s, " \t'\"\\") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// systemdValue escapes s for a unit file setting such as Description or
+// WorkingDirectory that systemd takes literally, without unquoting, apart
+// from specifiers. Line breaks, which would end the setting and start
+// another, cannot be escaped there and become spaces.
+func systemdValue(s string) string {
+	return strings.NewReplacer("%", "%%", "\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// LaunchdPlist renders spec as a launchd property list, for an agent of the
+// current user if user is set.
+func (s ServiceSpec) LaunchdPlist(user bool) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistKey(&b, "Label", s.Name)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range s.Args {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	if len(s.Env) > 0 {
+		b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+			fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(k), xmlEscape(s.Env[k]))
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	if s.Dir != "" {
+		plistKey(&b, "WorkingDirectory", s.Dir)
+	}
+	if s.User != "" && !user {
+		plistKey(&b, "UserName", s.User)
+	}
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	switch s.Restart {
+	case RestartAlways:
+		b.WriteString("\t<key>KeepAlive</key>\n\t<true/>\n")
+	case RestartOnFailure:
+		b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	}
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+func plistKey(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", key, xmlEscape(value))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// windowsInstall returns the sc.exe and reg.exe commands creating, configuring
+// and starting spec as a Windows service.
+func (s ServiceSpec) windowsInstall() ([][]string, error) {
+	if s.Dir != "" || s.User != "" {
+		return nil, fmt.Errorf("sh: service %s: working directory and user for Windows services: %w", s.Name, errors.ErrUnsupported)
+	}
+
+	quoted := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		quoted[i] = windowsQuote(arg)
+	}
+	create := []string{"sc.exe", "create", s.Name, "binPath=", strings.Join(quoted, " "), "start=", "auto"}
+	if s.Description != "" {
+		create = append(create, "DisplayName=", s.Description)
+	}
+	argvs := [][]string{create}
+
+	if len(s.Env) > 0 {
+		vars := make([]string, 0, len(s.Env))
+		for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+			vars = append(vars, k+"="+s.Env[k])
+		}
+		argvs = append(argvs, []string{"reg.exe", "add",
+			`HKLM\SYSTEM\CurrentControlSet\Services\` + s.Name,
+			"/v", "Environment", "/t", "REG_MULTI_SZ", "/d", strings.Join(vars, `\0`), "/f"})
+	}
+	if s.Restart != RestartNever {
+		argvs = append(argvs,
+			[]string{"sc.exe", "failure", s.Name, "reset=", "86400", "actions=", "restart/5000/restart/5000/restart/5000"},
+			// Also restart services reporting an error exit code, not
+			// only those that crash
+			[]string{"sc.exe", "failureflag", s.Name, "1"})
+	}
+	return append(argvs, []string{"sc.exe", "start", s.Name}), nil
+}
+
+// windowsQuote quotes s for a Windows command line as parsed by
+// CommandLineToArgvW.
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*backslashes))
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/shtest"
+)
+
+func TestNewServiceSpec(t *testing.T) {
+	ctx := context.Background()
+
+	cmd := sh.New("sh").OptV("-c", "exec sleep 100").Build(ctx).
+		WithEnv("MODE", "prod").WithDir("/srv/app")
+	spec, err := sh.NewServiceSpec("worker", cmd)
+	if err != nil {
+		t.Fatalf("NewServiceSpec failed: %v", err)
+	}
+	if !filepath.IsAbs(spec.Args[0]) || strings.Join(spec.Args[1:], " ") != "-c exec sleep 100" {
+		t.Errorf("Unexpected args: %q", spec.Args)
+	}
+	if spec.Env["MODE"] != "prod" || spec.Dir != "/srv/app" || spec.Restart != sh.RestartOnFailure {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+
+	if _, err := sh.NewServiceSpec("x", sh.New("no-such-daemon").Build(ctx)); err == nil {
+		t.Error("Expected an error for a program not in PATH")
+	}
+}
+
+func TestServiceSpecSystemdUnit(t *testing.T) {
+	spec := sh.ServiceSpec{
+		Name:        "worker",
+		Description: "Queue worker",
+		Args:        []string{"/usr/bin/worker", "--queue", "jobs $1", "100%"},
+		Env:         map[string]string{"B": "two words", "A": "$HOME"},
+		Dir:         "/srv/app",
+		Restart:     sh.RestartAlways,
+		User:        "app",
+	}
+
+	want := `[Unit]
+Description=Queue worker
+
+[Service]
+ExecStart=/usr/bin/worker --queue "jobs $$1" 100%%
+Environment=A=$HOME
+Environment="B=two words"
+WorkingDirectory=/srv/app
+User=app
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`
+	if got := spec.SystemdUnit(false); got != want {
+		t.Errorf("Unexpected unit:\n%s", got)
+	}
+
+	user := spec.SystemdUnit(true)
+	if strings.Contains(user, "User=") || !strings.Contains(user, "WantedBy=default.target") {
+		t.Errorf("Unexpected user unit:\n%s", user)
+	}
+}
+
+func TestServiceSpecSystemdUnitEscaping(t *testing.T) {
+	spec := sh.ServiceSpec{
+		Name:        "worker",
+		Description: "100% done\nExecStartPre=/bin/evil",
+		Args:        []string{"/usr/bin/worker"},
+		Dir:         "/srv/my app",
+		User:        "app\nUser=root",
+	}
+
+	unit := spec.SystemdUnit(false)
+	for _, want := range []string{
+		"Description=100%% done ExecStartPre=/bin/evil\n",
+		"WorkingDirectory=/srv/my app\n",
+		"User=app User=root\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Expected the unit to contain %q, got:\n%s", want, unit)
+		}
+	}
+	if strings.Contains(unit, "\nExecStartPre=") || strings.Contains(unit, "\nUser=root") {
+		t.Errorf("Expected no injected settings, got:\n%s", unit)
+	}
+}
+
+func TestServiceSpecLaunchdPlist(t *testing.T) {
+	spec := sh.ServiceSpec{
+		Name: "com.example.worker",
+		Args: []string{"/usr/local/bin/worker", "a<b"},
+		Env:  map[string]string{"MODE": "prod"},
+	}
+
+	plist := spec.LaunchdPlist(true)
+	for _, want := range []string{
+		"<key>Label</key>\n\t<string>com.example.worker</string>",
+		"<string>a&lt;b</string>",
+		"<key>MODE</key>\n\t\t<string>prod</string>",
+		"<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("Expected the plist to contain %q:\n%s", want, plist)
+		}
+	}
+}
+
+func TestInstallService(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("installing with systemd is tested on Linux")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fake := shtest.New()
+	fake.Expect("systemctl", shtest.Rest)
+	ctx = sh.WithRunner(ctx, sh.NewRunnerWithDefaults(sh.Defaults{Executor: fake.Executor()}))
+
+	dir := t.TempDir()
+	spec := sh.ServiceSpec{Name: "worker", Args: []string{"/usr/bin/worker"}}
+	if err := sh.InstallService(ctx, spec, sh.ServiceDir(dir), sh.UserService()); err != nil {
+		t.Fatalf("InstallService failed: %v", err)
+	}
+
+	unit, err := os.ReadFile(filepath.Join(dir, "worker.service"))
+	if err != nil || !strings.Contains(string(unit), "ExecStart=/usr/bin/worker\n") {
+		t.Errorf("Expected the unit file to be written, got %q, %v", unit, err)
+	}
+	fake.AssertCalled(t, "systemctl", "--user", "daemon-reload")
+	fake.AssertCalled(t, "systemctl", "--user", "enable", "--now", "worker.service")
+
+	if err := sh.UninstallService(ctx, "worker", sh.ServiceDir(dir), sh.UserService()); err != nil {
+		t.Fatalf("UninstallService failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "worker.service")); !os.IsNotExist(err) {
+		t.Errorf("Expected the unit file to be removed, got %v", err)
+	}
+	fake.AssertCalled(t, "systemctl", "--user", "disable", "--now", "worker.service")
+}
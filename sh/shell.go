@@ -0,0 +1,45 @@
+package sh
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"runtime"
+	"unicode/utf16"
+)
+
+// NewPowerShell returns a builder running script with PowerShell: Windows
+// PowerShell on Windows and PowerShell 7 (pwsh) elsewhere. The script is
+// passed base64-encoded, so it needs no quoting. Errors stop the script and
+// make the command fail, as with "set -e" in a POSIX shell.
+func NewPowerShell(script string) *Builder {
+	exe := "pwsh"
+	if runtime.GOOS == "windows" {
+		exe = "powershell"
+	}
+	return New(exe).
+		OptB("-NoLogo").
+		OptB("-NoProfile").
+		OptB("-NonInteractive").
+		OptV("-EncodedCommand", encodePowerShell("$ErrorActionPreference = 'Stop'\n"+script))
+}
+
+// encodePowerShell encodes script for -EncodedCommand: UTF-16LE in base64.
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	b := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// NewCmdShell returns a builder running script with cmd.exe, such as
+// "dir /b & ver". cmd.exe parses its command line itself rather than
+// following the quoting rules of QuoteWindows, so the script is passed
+// verbatim and must be quoted for cmd.exe, e.g. with ^ before & and |
+// that are meant literally.
+func NewCmdShell(script string) *Builder {
+	b := New("cmd.exe").OptB("/d").OptB("/s").OptV("/c", script)
+	b.cmdShell = true
+	return b
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package sh
+
+// cmdShellHook does nothing: outside Windows, arguments are passed to
+// programs as they are.
+func cmdShellHook() execHook {
+	return execHook{}
+}
@@ -0,0 +1,72 @@
+package sh_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestNewPowerShell(t *testing.T) {
+	items := sh.NewPowerShell(`Write-Output "héllo"`).Items()
+	if i := slices.Index(items, "-EncodedCommand"); i < 0 || i+1 >= len(items) {
+		t.Fatalf("Expected -EncodedCommand, got %q", items)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(items[len(items)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(raw[2*i:])
+	}
+	if script := string(utf16.Decode(units)); !strings.HasSuffix(script, "\nWrite-Output \"héllo\"") {
+		t.Errorf("Unexpected decoded script %q", script)
+	}
+
+	if _, err := exec.LookPath(items[0]); err != nil {
+		t.Skipf("%s not available", items[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := sh.NewPowerShell(`Write-Output "héllo"`).Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v: %s", err, result.Stderr())
+	}
+	if got := strings.TrimSpace(string(result.Stdout())); got != "héllo" {
+		t.Errorf("Expected héllo, got %q", got)
+	}
+	if _, err := sh.NewPowerShell(`Get-Item C:\does\not\exist`).Build(ctx).Run(); err == nil {
+		t.Error("Expected a failing cmdlet to fail the command")
+	}
+}
+
+func TestNewCmdShell(t *testing.T) {
+	script := `echo "a b" & echo c`
+	items := sh.NewCmdShell(script).Items()
+	if want := []string{"cmd.exe", "/d", "/s", "/c", script}; !slices.Equal(items, want) {
+		t.Fatalf("Items() = %q, want %q", items, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		t.Skip("cmd.exe is only available on Windows")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := sh.NewCmdShell(script).Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %v: %s", err, result.Stderr())
+	}
+	if got := string(result.Stdout()); got != "\"a b\" \r\nc\r\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package sh
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// cmdShellHook passes the last argument, the script of NewCmdShell, in
+// quotes but otherwise unchanged: with /s, cmd.exe strips the outer quotes
+// and runs the rest as typed.
+func cmdShellHook() execHook {
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			last := len(cmd.Args) - 1
+			cmd.SysProcAttr.CmdLine = QuoteWindows(cmd.Args[:last]...) + ` "` + cmd.Args[last] + `"`
+			return nil
+		},
+	}
+}
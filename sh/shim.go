@@ -0,0 +1,141 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// shim rewrites command lines starting with old.
+type shim struct {
+	old, new []string
+	// flag shims rename old[1] to new[1] anywhere in the arguments of
+	// command old[0]; new is empty to drop the flag.
+	flag   bool
+	warned bool
+}
+
+var (
+	shimMu sync.Mutex
+	shims  []*shim
+	shimW  io.Writer = os.Stderr
+)
+
+// Shim registers a replacement for a deprecated command, so that commands
+// built from then on whose command line starts with the words of old run
+// replacement instead, keeping their remaining arguments. A warning naming
+// the replacement is printed the first time each shim applies. The returned
+// function removes the shim again. It returns an error if old is not a
+// valid command line.
+//
+//	remove, err := sh.Shim("docker-compose", sh.New("docker").SubCommand("compose"))
+func Shim(old string, replacement Argv) (remove func(), err error) {
+	words, err := splitWords(old)
+	if err == nil && len(words) == 0 {
+		err = ErrNoCommand
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sh: invalid shim %q: %w", old, err)
+	}
+	return addShim(&shim{old: words, new: replacement.Items()}), nil
+}
+
+// MustShim is like Shim but panics if old is not a valid command line, for
+// shims registered at init time.
+func MustShim(old string, replacement Argv) (remove func()) {
+	remove, err := Shim(old, replacement)
+	if err != nil {
+		panic(err)
+	}
+	return remove
+}
+
+// ShimFlag registers a replacement for a deprecated flag of cmd, renaming
+// old to new wherever it appears in the arguments of commands built from
+// then on. An empty new drops the flag. Only the flag itself is rewritten;
+// a separate value following it is kept. The returned function removes the
+// shim again.
+func ShimFlag(cmd, old, new string) (remove func()) {
+	s := &shim{old: []string{cmd, old}, flag: true}
+	if new != "" {
+		s.new = []string{cmd, new}
+	}
+	return addShim(s)
+}
+
+// SetShimWarnings sets where shim warnings are printed, os.Stderr by
+// default. A nil w disables the warnings.
+func SetShimWarnings(w io.Writer) {
+	shimMu.Lock()
+	defer shimMu.Unlock()
+	shimW = w
+}
+
+func addShim(s *shim) func() {
+	shimMu.Lock()
+	shims = append(shims, s)
+	shimMu.Unlock()
+
+	return func() {
+		shimMu.Lock()
+		defer shimMu.Unlock()
+		shims = slices.DeleteFunc(shims, func(other *shim) bool { return other == s })
+	}
+}
+
+// applyShims rewrites args with the registered shims, in registration
+// order.
+func applyShims(args []string) []string {
+	shimMu.Lock()
+	defer shimMu.Unlock()
+
+	for _, s := range shims {
+		var applied bool
+		if s.flag {
+			args, applied = s.rewriteFlag(args)
+		} else {
+			args, applied = s.rewriteCommand(args)
+		}
+		if applied && !s.warned && shimW != nil {
+			s.warned = true
+			fmt.Fprintf(shimW, "sh: warning: %s\n", s.warning())
+		}
+	}
+	return args
+}
+
+func (s *shim) rewriteCommand(args []string) ([]string, bool) {
+	if len(args) < len(s.old) || !slices.Equal(args[:len(s.old)], s.old) {
+		return args, false
+	}
+	return append(slices.Clone(s.new), args[len(s.old):]...), true
+}
+
+func (s *shim) rewriteFlag(args []string) ([]string, bool) {
+	if len(args) == 0 || args[0] != s.old[0] || !slices.Contains(args[1:], s.old[1]) {
+		return args, false
+	}
+
+	rewritten := []string{args[0]}
+	for _, arg := range args[1:] {
+		switch {
+		case arg != s.old[1]:
+			rewritten = append(rewritten, arg)
+		case s.new != nil:
+			rewritten = append(rewritten, s.new[1])
+		}
+	}
+	return rewritten, true
+}
+
+func (s *shim) warning() string {
+	switch {
+	case s.flag && s.new == nil:
+		return fmt.Sprintf("%s %s is deprecated and was dropped", s.old[0], s.old[1])
+	case s.flag:
+		return fmt.Sprintf("%s %s is deprecated, use %s", s.old[0], s.old[1], s.new[1])
+	}
+	return fmt.Sprintf("%s is deprecated, use %s", quoteAll(s.old), quoteAll(s.new))
+}
@@ -0,0 +1,61 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestShim(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var warnings bytes.Buffer
+	sh.SetShimWarnings(&warnings)
+	defer sh.SetShimWarnings(nil)
+
+	remove, err := sh.Shim("old-echo -n", sh.New("echo").SubCommand("new"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer remove()
+	defer sh.ShimFlag("echo", "--legacy", "--modern")()
+	defer sh.ShimFlag("echo", "--obsolete", "")()
+
+	cmd := sh.New("old-echo").OptB("-n").Arg("hi").Build(ctx)
+	if got := cmd.String(); got != "echo new hi" {
+		t.Errorf("Expected the shimmed command, got %q", got)
+	}
+	sh.New("old-echo").OptB("-n").Build(ctx)
+
+	cmd = sh.New("echo").OptB("--legacy").OptB("--obsolete").Arg("x").Build(ctx)
+	if got := cmd.String(); got != "echo --modern x" {
+		t.Errorf("Expected the flags to be rewritten, got %q", got)
+	}
+
+	want := "sh: warning: old-echo -n is deprecated, use echo new\n" +
+		"sh: warning: echo --legacy is deprecated, use --modern\n" +
+		"sh: warning: echo --obsolete is deprecated and was dropped\n"
+	if warnings.String() != want {
+		t.Errorf("Expected one warning per shim, got:\n%s", warnings.String())
+	}
+
+	remove()
+	if got := sh.New("old-echo").OptB("-n").Build(ctx).String(); !strings.HasPrefix(got, "old-echo") {
+		t.Errorf("Expected removed shim not to apply, got %q", got)
+	}
+}
+
+func TestShimInvalid(t *testing.T) {
+	if _, err := sh.Shim(`old-echo "unterminated`, sh.New("echo")); err == nil {
+		t.Error("Expected an error for an invalid command line")
+	}
+	if _, err := sh.Shim("", sh.New("echo")); !errors.Is(err, sh.ErrNoCommand) {
+		t.Errorf("Expected ErrNoCommand for an empty command line, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+// Package shtest fakes command execution for tests of code using package
+// sh, so they do not need real binaries on PATH.
+//
+//	fake := shtest.New()
+//	fake.Expect("git", "rev-parse", "HEAD").Stdout("abc123\n")
+//	fake.Expect("git", "push", "...").Exit(1).Stderr("rejected\n")
+//	fake.Install(t)
+//
+//	deploy(ctx) // runs git through package sh
+//
+//	fake.AssertCalled(t, "git", "push", "origin", "main")
+//	fake.AssertExpectations(t)
+package shtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// Rest matches any number of remaining arguments when used as the last
+// pattern of Expect.
+const Rest = "..."
+
+// Call is a command run while a Fake was installed.
+type Call struct {
+	Args  []string
+	Env   []string
+	Dir   string
+	Stdin []byte
+}
+
+// Fake answers commands with scripted results instead of running them.
+// Commands matching no rule fail with an error.
+type Fake struct {
+	mu    sync.Mutex
+	rules []*Rule
+	calls []Call
+}
+
+// Rule is a scripted response to commands matching its patterns.
+type Rule struct {
+	patterns []string
+	stdout   string
+	stderr   string
+	exitCode int
+	delay    time.Duration
+	times    int
+	calls    int
+}
+
+// New returns a Fake without rules.
+func New() *Fake {
+	return &Fake{}
+}
+
+// Expect adds a rule for commands whose arguments, including the command
+// name, match patterns one by one in path.Match syntax. A final Rest
+// pattern matches any remaining arguments. Rules are tried in the order
+// they were added. By default a rule matches any number of times and
+// succeeds without output.
+func (f *Fake) Expect(patterns ...string) *Rule {
+	r := &Rule{patterns: patterns}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+	return r
+}
+
+// Stdout sets the output the command writes to stdout.
+func (r *Rule) Stdout(s string) *Rule {
+	r.stdout = s
+	return r
+}
+
+// Stderr sets the output the command writes to stderr.
+func (r *Rule) Stderr(s string) *Rule {
+	r.stderr = s
+	return r
+}
+
+// Exit sets the command's exit code.
+func (r *Rule) Exit(code int) *Rule {
+	r.exitCode = code
+	return r
+}
+
+// Delay makes the command take d, or until it is cancelled.
+func (r *Rule) Delay(d time.Duration) *Rule {
+	r.delay = d
+	return r
+}
+
+// Times limits the rule to n matches, after which later rules are tried.
+// AssertExpectations checks that it matched exactly n times.
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+func (r *Rule) match(args []string) bool {
+	if r.times > 0 && r.calls >= r.times {
+		return false
+	}
+
+	patterns := r.patterns
+	if n := len(patterns); n > 0 && patterns[n-1] == Rest {
+		patterns = patterns[:n-1]
+		if len(args) < len(patterns) {
+			return false
+		}
+		args = args[:len(patterns)]
+	}
+	if len(args) != len(patterns) {
+		return false
+	}
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, args[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Executor returns a sh.RunFunc answering commands from the fake, for
+// sh.Defaults.Executor.
+func (f *Fake) Executor() sh.RunFunc {
+	return func(ctx context.Context, e *sh.Execution) error {
+		call := Call{
+			Args: slices.Clone(e.Exec.Args),
+			Env:  slices.Clone(e.Exec.Env),
+			Dir:  e.Exec.Dir,
+		}
+		if e.Exec.Stdin != nil {
+			call.Stdin, _ = io.ReadAll(e.Exec.Stdin)
+		}
+
+		f.mu.Lock()
+		f.calls = append(f.calls, call)
+		var rule *Rule
+		for _, r := range f.rules {
+			if r.match(call.Args) {
+				r.calls++
+				rule = r
+				break
+			}
+		}
+		f.mu.Unlock()
+
+		if rule == nil {
+			return fmt.Errorf("shtest: unexpected command: %s", sh.Quote(call.Args...))
+		}
+
+		if rule.delay > 0 {
+			t := time.NewTimer(rule.delay)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if e.Exec.Stdout != nil {
+			io.WriteString(e.Exec.Stdout, rule.stdout)
+		}
+		if e.Exec.Stderr != nil {
+			io.WriteString(e.Exec.Stderr, rule.stderr)
+		}
+		if rule.exitCode != 0 {
+			return &sh.ExitError{Cmd: call.Args[0], ExitCode: rule.exitCode}
+		}
+		return nil
+	}
+}
+
+// Middleware returns the fake as middleware, for faking a single command
+// with sh.Cmd.WithMiddleware.
+func (f *Fake) Middleware() sh.Middleware {
+	executor := f.Executor()
+	return func(sh.RunFunc) sh.RunFunc {
+		return executor
+	}
+}
+
+// Install fakes every command run through package sh until the end of the
+// test.
+func (f *Fake) Install(t testing.TB) {
+	t.Helper()
+	t.Cleanup(sh.Use(f.Middleware()))
+}
+
+// Calls returns the commands run so far.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// AssertCalled fails the test unless a command with exactly args was run.
+func (f *Fake) AssertCalled(t testing.TB, args ...string) {
+	t.Helper()
+	for _, call := range f.Calls() {
+		if slices.Equal(call.Args, args) {
+			return
+		}
+	}
+	t.Errorf("shtest: expected %s to be run; ran:\n%s", sh.Quote(args...), f.callList())
+}
+
+// AssertNotCalled fails the test if a command matching patterns, as for
+// Expect, was run.
+func (f *Fake) AssertNotCalled(t testing.TB, patterns ...string) {
+	t.Helper()
+	r := &Rule{patterns: patterns}
+	for _, call := range f.Calls() {
+		if r.match(call.Args) {
+			t.Errorf("shtest: expected no command matching %s; ran %s", strings.Join(patterns, " "), sh.Quote(call.Args...))
+		}
+	}
+}
+
+// AssertExpectations fails the test for rules limited with Times that did
+// not match as often as expected.
+func (f *Fake) AssertExpectations(t testing.TB) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rules {
+		if r.times > 0 && r.calls != r.times {
+			t.Errorf("shtest: expected %d calls of %s, got %d", r.times, strings.Join(r.patterns, " "), r.calls)
+		}
+	}
+}
+
+func (f *Fake) callList() string {
+	var b bytes.Buffer
+	for _, call := range f.Calls() {
+		fmt.Fprintf(&b, "\t%s\n", sh.Quote(call.Args...))
+	}
+	return b.String()
+}
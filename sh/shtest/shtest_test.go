@@ -0,0 +1,83 @@
+package shtest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/shtest"
+)
+
+func TestFakeInstall(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fake := shtest.New()
+	fake.Expect("git", "rev-parse", "HEAD").Stdout("abc123\n")
+	fake.Expect("git", "push", shtest.Rest).Exit(1).Stderr("rejected\n").Times(1)
+	fake.Install(t)
+
+	result, err := sh.New("git").Arg("rev-parse").Arg("HEAD").Build(ctx).Run()
+	if err != nil || string(result.Stdout()) != "abc123\n" {
+		t.Fatalf("Expected scripted output, got %q, %v", result.Stdout(), err)
+	}
+
+	result, err = sh.New("git").Arg("push").Arg("origin").Arg("main").Build(ctx).Run()
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || result.ExitCode() != 1 || string(result.Stderr()) != "rejected\n" {
+		t.Errorf("Expected scripted failure, got %v (exit %d)", err, result.ExitCode())
+	}
+
+	_, err = sh.New("rm").Arg("-rf").Arg("/").Build(ctx).Run()
+	if err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Errorf("Expected unmatched commands to fail, got %v", err)
+	}
+
+	fake.AssertCalled(t, "git", "push", "origin", "main")
+	fake.AssertNotCalled(t, "git", "fetch", shtest.Rest)
+	fake.AssertExpectations(t)
+	if len(fake.Calls()) != 3 {
+		t.Errorf("Expected 3 recorded calls, got %d", len(fake.Calls()))
+	}
+}
+
+func TestFakePerCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fake := shtest.New()
+	fake.Expect("cat").Stdout("faked\n")
+	fake.Expect("slow").Delay(time.Minute)
+
+	result, err := sh.New("cat").Build(ctx).
+		WithStdin(strings.NewReader("input")).
+		WithMiddleware(fake.Middleware()).
+		Run()
+	if err != nil || string(result.Stdout()) != "faked\n" {
+		t.Fatalf("Expected scripted output, got %q, %v", result.Stdout(), err)
+	}
+	if string(fake.Calls()[0].Stdin) != "input" {
+		t.Errorf("Expected stdin to be recorded, got %q", fake.Calls()[0].Stdin)
+	}
+
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Executor: fake.Executor()})
+	cmd := runner.New("slow").Build(ctx)
+	cmd.Start()
+	cmd.Cancel()
+	if _, err := cmd.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the delay to be cancellable, got %v", err)
+	}
+
+	// Pipelines are faked stage by stage
+	fake.Expect("echo", shtest.Rest).Stdout("a\nb\n")
+	fake.Expect("wc", "-l").Stdout("2\n")
+	piped := sh.New("echo").Arg("x").Build(ctx).WithMiddleware(fake.Middleware()).
+		Pipe("wc").OptB("-l").Build().WithMiddleware(fake.Middleware())
+	result, err = piped.Run()
+	if err != nil || string(result.Stdout()) != "2\n" {
+		t.Errorf("Expected the pipeline to be faked, got %q, %v", result.Stdout(), err)
+	}
+}
@@ -0,0 +1,76 @@
+package sh
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ErrNotStarted is returned by Signal when the command has not started,
+// and by Compose.Restart and Compose.Wait before Compose.Start.
+var ErrNotStarted = errors.New("sh: command not started")
+
+func (cm *cmdImpl) WithProcessGroup() Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.processGroup = true
+	cm.hooks = append(cm.hooks, processGroupHook())
+	return cm
+}
+
+func (cm *cmdImpl) Signal(sig os.Signal) error {
+	cm.mu.RLock()
+	proc, group := cm.process, cm.processGroup
+	cm.mu.RUnlock()
+
+	if proc == nil {
+		if cm.IsDone() {
+			return os.ErrProcessDone
+		}
+		return ErrNotStarted
+	}
+	if cm.IsDone() {
+		return os.ErrProcessDone
+	}
+	if group {
+		return signalGroup(proc.Pid, sig)
+	}
+	return proc.Signal(sig)
+}
+
+// ForwardSignals relays the given signals received by the current process
+// to cmd until the returned stop function is called or cmd exits. Without
+// any signals, os.Interrupt is forwarded. While forwarding, the signals no
+// longer terminate the current process.
+func ForwardSignals(cmd Cmd, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	done := make(chan struct{})
+
+	goSafe(func() {
+		for {
+			select {
+			case sig := <-ch:
+				cmd.Signal(sig)
+			case <-cmd.Done():
+				signal.Stop(ch)
+				return
+			case <-done:
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !unix && !windows
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// defaultStopSignal asks a command to stop, for DefaultEscalation.
+var defaultStopSignal = os.Interrupt
+
+// processGroupHook fails to start the command: process groups are only
+// supported on Unix.
+func processGroupHook() execHook {
+	return execHook{
+		before: func(*exec.Cmd) error {
+			return fmt.Errorf("sh: process group: %w", errors.ErrUnsupported)
+		},
+	}
+}
+
+func signalGroup(int, os.Signal) error {
+	return fmt.Errorf("sh: process group: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// defaultStopSignal asks a command to stop, for DefaultEscalation.
+var defaultStopSignal os.Signal = syscall.SIGTERM
+
+// processGroupHook starts the command in a new process group.
+func processGroupHook() execHook {
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.Setpgid = true
+			return nil
+		},
+	}
+}
+
+// signalGroup sends sig to the process group led by pid.
+func signalGroup(pid int, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("sh: unsupported signal %v", sig)
+	}
+	err := syscall.Kill(-pid, s)
+	if err == syscall.ESRCH {
+		return os.ErrProcessDone
+	}
+	return err
+}
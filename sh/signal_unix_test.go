@@ -0,0 +1,75 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdSignalProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").
+		OptV("-c", `sleep 30 & echo started; wait`).
+		Build(ctx).
+		WithProcessGroup()
+
+	if err := cmd.Signal(syscall.SIGTERM); !errors.Is(err, sh.ErrNotStarted) {
+		t.Errorf("Expected ErrNotStarted, got %v", err)
+	}
+
+	var lines []string
+	for line := range cmd.Lines() {
+		lines = append(lines, line)
+		// The background sleep only exits if the whole group is signalled
+		if err := cmd.Signal(syscall.SIGTERM); err != nil {
+			t.Fatalf("Signal failed: %v", err)
+		}
+	}
+
+	result, _ := cmd.Wait()
+	if result.ExitCode() == 0 {
+		t.Error("Expected the command to be terminated")
+	}
+	if strings.Join(lines, ",") != "started" {
+		t.Errorf("Unexpected output: %v", lines)
+	}
+	if err := cmd.Signal(syscall.SIGTERM); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("Expected os.ErrProcessDone after exit, got %v", err)
+	}
+}
+
+func TestForwardSignals(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").
+		OptV("-c", `trap "echo got USR1; exit 0" USR1; echo ready; while :; do sleep 0.05; done`).
+		Build(ctx)
+
+	stop := sh.ForwardSignals(cmd, syscall.SIGUSR1)
+	defer stop()
+
+	for line := range cmd.Lines() {
+		if line == "ready" {
+			syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		}
+	}
+
+	result, err := cmd.Wait()
+	if err != nil {
+		t.Fatalf("Expected the trap to exit cleanly: %v", err)
+	}
+	if !strings.Contains(string(result.Stdout()), "got USR1") {
+		t.Errorf("Expected the forwarded signal to reach the command, got %q", result.Stdout())
+	}
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// defaultStopSignal asks a command to stop, for DefaultEscalation. It
+// becomes a CTRL_BREAK event for commands with their own process group.
+var defaultStopSignal = os.Interrupt
+
+// processGroupHook starts the command in a new console process group, the
+// unit CTRL_BREAK events are sent to.
+func processGroupHook() execHook {
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+			return nil
+		},
+	}
+}
+
+// signalGroup delivers sig to the process group led by pid. Windows has no
+// signals: os.Interrupt becomes a CTRL_BREAK event, which console programs
+// handle like ^C, and os.Kill terminates the leader.
+func signalGroup(pid int, sig os.Signal) error {
+	switch sig {
+	case os.Interrupt:
+		return sendCtrlBreak(pid)
+	case os.Kill:
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return os.ErrProcessDone
+		}
+		defer p.Release()
+		return p.Kill()
+	}
+	return fmt.Errorf("sh: unsupported signal %v", sig)
+}
+
+// sendCtrlBreak sends a CTRL_BREAK event to the process group led by pid.
+// It only reaches processes attached to the same console as the current
+// one.
+func sendCtrlBreak(pid int) error {
+	if ok, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(pid)); ok == 0 {
+		return fmt.Errorf("sh: CTRL_BREAK: %w", err)
+	}
+	return nil
+}
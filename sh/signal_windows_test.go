@@ -0,0 +1,58 @@
+//go:build windows
+
+package sh_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdGracePeriodSendsCtrlBreak(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := sh.NewPowerShell(`Write-Output ready; Start-Sleep 30`).
+		Build(ctx).
+		WithGracePeriod(10 * time.Second)
+	var stopped time.Time
+	for line := range cmd.Lines() {
+		if line == "ready" {
+			stopped = time.Now()
+			cancel()
+		}
+	}
+
+	// Only the CTRL_BREAK event stops it before the grace period is over
+	if _, err := cmd.Wait(); err == nil {
+		t.Error("Expected the cancelled command to fail")
+	}
+	if elapsed := time.Since(stopped); elapsed > 5*time.Second {
+		t.Errorf("Expected CTRL_BREAK to stop the command, took %v", elapsed)
+	}
+}
+
+func TestCmdSignalInterruptProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	cmd := sh.NewPowerShell(`Write-Output ready; Start-Sleep 30`).Build(ctx).WithProcessGroup()
+	for line := range cmd.Lines() {
+		if line == "ready" {
+			if err := cmd.Signal(os.Interrupt); err != nil {
+				t.Fatalf("Signal failed: %v", err)
+			}
+		}
+	}
+
+	result, _ := cmd.Wait()
+	if result.ExitCode() == 0 {
+		t.Error("Expected the command to be interrupted")
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the command to stop before the timeout")
+	}
+}
@@ -0,0 +1,102 @@
+package sh
+
+import "context"
+
+// Tracer starts spans for command executions. It is a small interface so
+// that any tracing library can be plugged in without this package depending
+// on it; an OpenTelemetry adapter is a few lines:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) StartSpan(ctx context.Context, name string) (context.Context, sh.Span) {
+//		ctx, span := t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span, ctx}
+//	}
+//
+//	type otelSpan struct {
+//		trace.Span
+//		ctx context.Context
+//	}
+//
+//	func (s otelSpan) SetAttribute(key string, value any) {
+//		s.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+//	}
+//
+//	func (s otelSpan) RecordError(err error) {
+//		s.Span.RecordError(err)
+//		s.SetStatus(codes.Error, err.Error())
+//	}
+//
+//	func (s otelSpan) End() { s.Span.End() }
+//
+//	func (s otelSpan) TraceParent() string {
+//		carrier := propagation.MapCarrier{}
+//		propagation.TraceContext{}.Inject(s.ctx, carrier)
+//		return carrier["traceparent"]
+//	}
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+	// TraceParent returns the W3C trace context of the span, e.g.
+	// "00-<trace id>-<span id>-01", or "" if it should not be propagated.
+	TraceParent() string
+}
+
+// TracingMiddleware creates a span per command execution named after the
+// command, or after its Builder.Name, with attributes for the command line,
+// name and group, process ID, exit code and stderr size; the span's duration is the command's. The span context is
+// propagated to the command in the TRACEPARENT environment variable, so
+// instrumented children join the trace.
+//
+//	sh.Use(sh.TracingMiddleware(otelTracer{otel.Tracer("sh")}))
+func TracingMiddleware(t Tracer) Middleware {
+	return func(next RunFunc) RunFunc {
+		return func(ctx context.Context, e *Execution) error {
+			name := e.Exec.Args[0]
+			cm, _ := e.Cmd.(*cmdImpl)
+			if cm != nil && cm.name != "" {
+				name = cm.name
+			}
+			ctx, span := t.StartSpan(ctx, "exec "+name)
+			defer span.End()
+
+			if cm != nil && cm.name != "" {
+				span.SetAttribute("sh.name", cm.name)
+			}
+			if cm != nil && cm.group != "" {
+				span.SetAttribute("sh.group", cm.group)
+			}
+			span.SetAttribute("process.command", e.Exec.Args[0])
+			span.SetAttribute("process.command_args", e.RedactedArgs())
+			if e.Exec.Dir != "" {
+				span.SetAttribute("process.working_directory", e.Exec.Dir)
+			}
+			if tp := span.TraceParent(); tp != "" {
+				e.Exec.Env = append(withoutEnv(e.Exec.Environ(), "TRACEPARENT"), "TRACEPARENT="+tp)
+			}
+
+			stderrSize := &countingWriter{w: e.Exec.Stderr}
+			e.Exec.Stderr = stderrSize
+
+			err := next(ctx, e)
+
+			if e.Exec.Process != nil {
+				span.SetAttribute("process.pid", e.Exec.Process.Pid)
+			}
+			if e.Exec.ProcessState != nil {
+				span.SetAttribute("process.exit.code", e.Exec.ProcessState.ExitCode())
+			}
+			span.SetAttribute("process.stderr.size", stderrSize.n.Load())
+			if err != nil {
+				span.RecordError(err)
+			}
+			return err
+		}
+	}
+}
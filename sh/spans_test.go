@@ -0,0 +1,71 @@
+package sh_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, sh.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &fakeSpan{name: name, attrs: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+type fakeSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *fakeSpan) RecordError(err error)              { s.err = err }
+func (s *fakeSpan) End()                               { s.ended = true }
+func (s *fakeSpan) TraceParent() string {
+	return "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tracer := &fakeTracer{}
+	result, err := sh.New("sh").OptV("-c", `echo "$TRACEPARENT"; echo oops >&2; exit 3`).Build(ctx).
+		WithMiddleware(sh.TracingMiddleware(tracer)).
+		Run()
+	if err == nil {
+		t.Fatal("Expected the command to fail")
+	}
+	if got := strings.TrimSpace(string(result.Stdout())); got != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Errorf("Expected TRACEPARENT in the child, got %q", got)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "exec sh" || !span.ended || span.err == nil {
+		t.Errorf("Unexpected span: %+v", span)
+	}
+	if span.attrs["process.exit.code"] != 3 {
+		t.Errorf("Expected exit code 3, got %v", span.attrs["process.exit.code"])
+	}
+	if span.attrs["process.stderr.size"] != int64(5) {
+		t.Errorf("Expected 5 bytes of stderr, got %v", span.attrs["process.stderr.size"])
+	}
+	if args, _ := span.attrs["process.command_args"].([]string); len(args) != 3 {
+		t.Errorf("Unexpected args: %v", span.attrs["process.command_args"])
+	}
+}
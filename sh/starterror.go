@@ -0,0 +1,116 @@
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// StartStage is the step of starting a process at which a StartError
+// occurred.
+type StartStage int
+
+const (
+	// StageSetup covers preparing the process before it is spawned:
+	// pseudo-terminals, pipes and other file descriptors.
+	StageSetup StartStage = iota
+	// StageLookPath means the executable was not found in PATH or is not
+	// executable.
+	StageLookPath
+	// StageDir means the working directory does not exist or is not a
+	// directory.
+	StageDir
+	// StageEnv means the environment contains an invalid variable.
+	StageEnv
+	// StageExec means the operating system refused to spawn the process.
+	StageExec
+)
+
+func (s StartStage) String() string {
+	switch s {
+	case StageSetup:
+		return "setup"
+	case StageLookPath:
+		return "lookpath"
+	case StageDir:
+		return "dir"
+	case StageEnv:
+		return "env"
+	case StageExec:
+		return "exec"
+	}
+	return fmt.Sprintf("StartStage(%d)", int(s))
+}
+
+// StartError is returned when a command could not be started at all, as
+// opposed to an ExitError for a command that ran and failed. Retrying a
+// StartError is pointless unless it is Temporary.
+type StartError struct {
+	// Cmd is the name of the command.
+	Cmd string
+	// Stage is the step that failed.
+	Stage StartStage
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("%s: start failed at %s: %v", e.Cmd, e.Stage, e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the failure is caused by a shortage of
+// resources, such as processes or file descriptors, and may succeed when
+// retried.
+func (e *StartError) Temporary() bool {
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EMFILE, syscall.ENFILE, syscall.ENOMEM, syscall.ETXTBSY} {
+		if errors.Is(e.Err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStart validates what os/exec would otherwise report with ambiguous
+// errors, e.g. a missing working directory as a missing executable.
+func checkStart(name string, cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return &StartError{Cmd: name, Stage: StageLookPath, Err: cmd.Err}
+	}
+
+	if cmd.Dir != "" {
+		info, err := os.Stat(cmd.Dir)
+		if err == nil && !info.IsDir() {
+			err = &os.PathError{Op: "chdir", Path: cmd.Dir, Err: syscall.ENOTDIR}
+		}
+		if err != nil {
+			return &StartError{Cmd: name, Stage: StageDir, Err: err}
+		}
+	}
+
+	for _, kv := range cmd.Env {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || key == "" || strings.ContainsRune(kv, 0) {
+			return &StartError{Cmd: name, Stage: StageEnv, Err: fmt.Errorf("invalid environment variable %q", kv)}
+		}
+	}
+	return nil
+}
+
+// startError classifies an error returned by exec.Cmd.Start.
+func startError(name string, err error) error {
+	stage := StageExec
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, syscall.ENOENT):
+		stage = StageLookPath
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		stage = StageSetup
+	}
+	return &StartError{Cmd: name, Stage: stage, Err: err}
+}
@@ -0,0 +1,47 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestStartError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name  string
+		cmd   sh.Cmd
+		stage sh.StartStage
+	}{
+		{"missing binary", sh.New("sh-no-such-binary").Build(ctx), sh.StageLookPath},
+		{"missing dir", sh.New("true").Build(ctx).WithDir(filepath.Join(t.TempDir(), "missing")), sh.StageDir},
+		{"invalid env", sh.New("true").Build(ctx).WithEnv("BAD", "a\x00b"), sh.StageEnv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cmd.Run()
+			var startErr *sh.StartError
+			if !errors.As(err, &startErr) {
+				t.Fatalf("Expected a StartError, got %T: %v", err, err)
+			}
+			if startErr.Stage != tt.stage {
+				t.Errorf("Expected stage %v, got %v", tt.stage, startErr.Stage)
+			}
+			if startErr.Temporary() {
+				t.Error("Expected a permanent error")
+			}
+		})
+	}
+
+	_, err := sh.New("false").Build(ctx).Run()
+	var startErr *sh.StartError
+	if errors.As(err, &startErr) {
+		t.Errorf("Expected a runtime failure not to be a StartError: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package sh
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunRecord is a single command execution stored in a StateStore.
+type RunRecord struct {
+	// Key identifies the task or command across runs. It defaults to the
+	// command's Name if it was given one, or else the Fingerprint of the
+	// command line.
+	Key      string        `json:"key"`
+	Args     []string      `json:"args"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exit_code"`
+	Error    string        `json:"error,omitempty"`
+	// Attempt is the attempt number set with WithAttempt; 0 and 1 both
+	// mean a first attempt.
+	Attempt int `json:"attempt,omitempty"`
+	// Group is the command's Group, if any.
+	Group string `json:"group,omitempty"`
+	// Meta holds the metadata attached to the command with WithMeta.
+	Meta map[string]string `json:"meta,omitempty"`
+	// Stdout and Stderr hold the end of the command's output, with its
+	// secrets masked, if the store keeps output; see KeepOutput.
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// Failed reports whether the run failed.
+func (r RunRecord) Failed() bool {
+	return r.ExitCode != 0 || r.Error != ""
+}
+
+// Grep returns the lines of the recorded stdout and stderr matching re.
+func (r RunRecord) Grep(re *regexp.Regexp) []string {
+	var lines []string
+	for _, out := range []string{r.Stdout, r.Stderr} {
+		if out == "" {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+			if line = strings.TrimSuffix(line, "\r"); re.MatchString(line) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// Result returns the recorded run as a Result, with the recorded output.
+func (r RunRecord) Result() Result {
+	result := &resultImpl{
+		exitCode:  r.ExitCode,
+		stdout:    []byte(r.Stdout),
+		stderr:    []byte(r.Stderr),
+		startTime: r.Start,
+		endTime:   r.Start.Add(r.Duration),
+		meta:      r.Meta,
+	}
+	if len(r.Args) > 0 {
+		result.name = r.Args[0]
+	}
+	return result
+}
+
+// StateQuery selects records from a StateStore. Zero fields match all
+// records.
+type StateQuery struct {
+	Key        string
+	Since      time.Time
+	FailedOnly bool
+	// Binary selects the runs of an executable, given by name such as
+	// "terraform" or by the path it was run with.
+	Binary string
+	// ExitCode selects runs by exit code, e.g. Not(0).
+	ExitCode ExitCodes
+	// Output selects runs with a line of recorded stdout or stderr
+	// matching it.
+	Output *regexp.Regexp
+}
+
+// ExitCodes matches the exit codes of runs in a StateQuery. Runs that did
+// not exit normally have the exit code -1.
+type ExitCodes func(code int) bool
+
+// OneOf matches the given exit codes.
+func OneOf(codes ...int) ExitCodes {
+	return func(code int) bool { return slices.Contains(codes, code) }
+}
+
+// Not matches all exit codes but the given ones.
+func Not(codes ...int) ExitCodes {
+	return func(code int) bool { return !slices.Contains(codes, code) }
+}
+
+// match reports whether rec is selected by q.
+func (q StateQuery) match(rec RunRecord) bool {
+	switch {
+	case q.Key != "" && rec.Key != q.Key,
+		rec.Start.Before(q.Since),
+		q.FailedOnly && !rec.Failed(),
+		q.ExitCode != nil && !q.ExitCode(rec.ExitCode):
+		return false
+	}
+	if q.Binary != "" {
+		if len(rec.Args) == 0 || rec.Args[0] != q.Binary && filepath.Base(rec.Args[0]) != q.Binary {
+			return false
+		}
+	}
+	if q.Output != nil && len(rec.Grep(q.Output)) == 0 {
+		return false
+	}
+	return true
+}
+
+// RunStats summarizes the recorded runs of a key.
+type RunStats struct {
+	Runs         int
+	Failures     int
+	LastRun      time.Time
+	LastFailed   bool
+	MeanDuration time.Duration
+}
+
+// StateStore persists the outcome of command runs across program
+// invocations in an append-only JSON lines file, so tools can skip work
+// done since a point in time, retry only failures or report trends.
+type StateStore struct {
+	path       string
+	mu         sync.Mutex
+	keepOutput int
+}
+
+// OpenStateStore opens the state store at path, creating the file if it
+// does not exist.
+func OpenStateStore(path string) (*StateStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &StateStore{path: path}, nil
+}
+
+// KeepOutput makes the store record the last limit bytes of the stdout and
+// stderr of the runs recorded with WithStateStore from now on, so that
+// queries can search and show them.
+func (s *StateStore) KeepOutput(limit int) *StateStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepOutput = limit
+	return s
+}
+
+// Record appends rec to the store.
+func (s *StateStore) Record(rec RunRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// maxRecordSize bounds the records read back, which is reached with output
+// kept from very large runs.
+const maxRecordSize = 64 << 20
+
+// Query returns the records matching q, oldest first.
+func (s *StateStore) Query(q StateQuery) ([]RunRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		var rec RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // skip lines torn by a crash
+		}
+		if q.match(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Last returns the most recent record for key.
+func (s *StateStore) Last(key string) (RunRecord, bool, error) {
+	records, err := s.Query(StateQuery{Key: key})
+	if err != nil || len(records) == 0 {
+		return RunRecord{}, false, err
+	}
+	return records[len(records)-1], true, nil
+}
+
+// Stats summarizes the recorded runs of key.
+func (s *StateStore) Stats(key string) (RunStats, error) {
+	records, err := s.Query(StateQuery{Key: key})
+	if err != nil {
+		return RunStats{}, err
+	}
+
+	var stats RunStats
+	var total time.Duration
+	for _, rec := range records {
+		stats.Runs++
+		if rec.Failed() {
+			stats.Failures++
+		}
+		total += rec.Duration
+	}
+	if stats.Runs > 0 {
+		last := records[len(records)-1]
+		stats.LastRun = last.Start
+		stats.LastFailed = last.Failed()
+		stats.MeanDuration = total / time.Duration(stats.Runs)
+	}
+	return stats, nil
+}
+
+// Fingerprint returns a stable identifier for a command line.
+func Fingerprint(args ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (cm *cmdImpl) WithStateStore(s *StateStore, key string) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	args := append([]string{cm.cmd}, cm.args...)
+	if key == "" && cm.name != "" {
+		key = cm.name
+	} else if key == "" {
+		key = Fingerprint(args...)
+	}
+
+	s.mu.Lock()
+	keep := s.keepOutput
+	s.mu.Unlock()
+	var stdout, stderr *boundedBuffer
+	if keep > 0 {
+		stdout = &boundedBuffer{max: keep, tail: true}
+		stderr = &boundedBuffer{max: keep, tail: true}
+		cm.stdout = appendWriter(cm.stdout, stdout)
+		cm.stderr = appendWriter(cm.stderr, stderr)
+	}
+
+	var start time.Time
+	cm.hooks = append(cm.hooks, execHook{
+		before: func(*exec.Cmd) error {
+			start = time.Now()
+			return nil
+		},
+		finished: func(runErr error) error {
+			rec := RunRecord{
+				Key:      key,
+				Args:     cm.redactAll(args),
+				Start:    start,
+				Duration: time.Since(start),
+				Group:    cm.Group(),
+				Meta:     cm.Meta(),
+				Attempt:  cm.attempt,
+			}
+			if keep > 0 {
+				out, _ := stdout.snapshot()
+				errOut, _ := stderr.snapshot()
+				rec.Stdout = string(cm.redactBytes(out))
+				rec.Stderr = string(cm.redactBytes(errOut))
+			}
+			if runErr != nil {
+				rec.ExitCode = -1
+				if code, ok := ExitCode(runErr); ok {
+					rec.ExitCode = code
+				}
+				rec.Error = runErr.Error()
+			}
+			return s.Record(rec)
+		},
+	})
+	return cm
+}
@@ -0,0 +1,196 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestStateStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+
+	before := time.Now()
+	sh.New("true").Build(ctx).WithStateStore(store, "lint").Run()
+	sh.New("false").Build(ctx).WithStateStore(store, "lint").Run()
+	sh.New("echo").Arg("hi").Build(ctx).WithStateStore(store, "").Run()
+
+	stats, err := store.Stats("lint")
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Runs != 2 || stats.Failures != 1 || !stats.LastFailed {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.LastRun.Before(before) {
+		t.Errorf("Expected last run after %v, got %v", before, stats.LastRun)
+	}
+
+	failed, err := store.Query(sh.StateQuery{FailedOnly: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(failed) != 1 || failed[0].Key != "lint" || failed[0].ExitCode != 1 {
+		t.Errorf("Expected the failed lint run, got %+v", failed)
+	}
+
+	last, ok, err := store.Last(sh.Fingerprint("echo", "hi"))
+	if err != nil || !ok {
+		t.Fatalf("Expected a record for the fingerprinted command: %v", err)
+	}
+	if last.Args[1] != "hi" {
+		t.Errorf("Unexpected args: %v", last.Args)
+	}
+
+	recent, _ := store.Query(sh.StateQuery{Since: time.Now().Add(time.Hour)})
+	if len(recent) != 0 {
+		t.Errorf("Expected no records in the future, got %d", len(recent))
+	}
+}
+
+func TestStateStoreQueryOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.KeepOutput(1 << 10)
+
+	run := func(script string) {
+		sh.New("sh").Arg("-c").Arg(script).Build(ctx).
+			WithSecret("hunter2").
+			WithStateStore(store, "").
+			Run()
+	}
+	run("echo plan ok")
+	run("echo applying; echo 'Error: lock held by hunter2' >&2; exit 1")
+	run("echo applying; exit 2")
+	sh.New("true").Build(ctx).WithStateStore(store, "").Run()
+
+	failed, err := store.Query(sh.StateQuery{Binary: "sh", ExitCode: sh.Not(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("Expected the 2 failed runs of sh, got %+v", failed)
+	}
+
+	locked, err := store.Query(sh.StateQuery{Output: regexp.MustCompile(`^Error: lock`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 1 {
+		t.Fatalf("Expected the run with the lock error, got %+v", locked)
+	}
+	if lines := locked[0].Grep(regexp.MustCompile(`Error`)); !slices.Equal(lines, []string{"Error: lock held by [REDACTED]"}) {
+		t.Errorf("Expected the matching line with secrets masked, got %q", lines)
+	}
+	result := locked[0].Result()
+	if result.ExitCode() != 1 || result.TrimmedString() != "applying" {
+		t.Errorf("Expected the recorded result, got exit code %d and %q", result.ExitCode(), result.Stdout())
+	}
+
+	two, _ := store.Query(sh.StateQuery{ExitCode: sh.OneOf(2)})
+	if len(two) != 1 || two[0].ExitCode != 2 {
+		t.Errorf("Expected the run exiting with 2, got %+v", two)
+	}
+}
+
+func TestStateStoreFlakyCommands(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+
+	// "flaky" passes on its second attempt in one of two runs, "broken"
+	// never passes and "stable" always does
+	sh.New("false").Build(ctx).WithStateStore(store, "flaky").WithAttempt(1).Run()
+	sh.New("true").Build(ctx).WithStateStore(store, "flaky").WithAttempt(2).Run()
+	sh.New("true").Build(ctx).WithStateStore(store, "flaky").WithAttempt(1).Run()
+	sh.New("false").Build(ctx).WithStateStore(store, "broken").WithAttempt(1).Run()
+	sh.New("false").Build(ctx).WithStateStore(store, "broken").WithAttempt(2).Run()
+	sh.New("true").Build(ctx).WithStateStore(store, "stable").Run()
+
+	report, err := store.Report(time.Time{})
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(report.Commands) != 3 {
+		t.Fatalf("Expected three commands, got %+v", report.Commands)
+	}
+	broken := report.Commands[0]
+	if broken.Key != "broken" || broken.Runs != 1 || broken.Failures != 1 || broken.PassedOnRetry != 0 {
+		t.Errorf("Unexpected report for broken: %+v", broken)
+	}
+
+	flaky := report.FlakyCommands(0.25)
+	if len(flaky) != 1 || flaky[0].Key != "flaky" || flaky[0].Runs != 2 || flaky[0].FlakeRate() != 0.5 {
+		t.Errorf("Expected only flaky to be reported, got %+v", flaky)
+	}
+	if flaky := report.FlakyCommands(0.5); len(flaky) != 0 {
+		t.Errorf("Expected no command above 50%%, got %+v", flaky)
+	}
+}
+
+func TestStateStoreRecordsFinalOutcome(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := sh.OpenStateStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+
+	sh.New("true").Build(ctx).WithStateStore(store, "rejected").
+		SuccessWhen(func(sh.Result) error { return errors.New("no") }).
+		Run()
+	sh.New("false").Build(ctx).WithStateStore(store, "accepted").
+		ExpectExit(1).
+		Run()
+
+	if stats, _ := store.Stats("rejected"); stats.Failures != 1 {
+		t.Errorf("Expected the SuccessWhen rejection to be recorded as a failure, got %+v", stats)
+	}
+	if stats, _ := store.Stats("accepted"); stats.Runs != 1 || stats.Failures != 0 {
+		t.Errorf("Expected the accepted exit code to be recorded as a success, got %+v", stats)
+	}
+}
+
+func TestStateStoreRedactsArgs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := sh.OpenStateStore(path)
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+
+	sh.New("echo").Arg("--token=hunter2").Build(ctx).
+		WithStateStore(store, "login").
+		WithSecret("hunter2").
+		Run()
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected the secret to be masked in the store, got %s", data)
+	}
+}
@@ -0,0 +1,55 @@
+package sh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func (cm *cmdImpl) WithStdinString(s string) Cmd {
+	return cm.WithStdin(strings.NewReader(s))
+}
+
+func (cm *cmdImpl) WithStdinBytes(b []byte) Cmd {
+	return cm.WithStdin(bytes.NewReader(b))
+}
+
+func (cm *cmdImpl) WithStdinJSON(v any) Cmd {
+	data, err := json.Marshal(v)
+	if err != nil {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		cm.hooks = append(cm.hooks, execHook{
+			before: func(*exec.Cmd) error {
+				return fmt.Errorf("encoding stdin: %w", err)
+			},
+		})
+		return cm
+	}
+	return cm.WithStdinBytes(data)
+}
+
+func (r *resultImpl) JSON(v any) error {
+	if err := json.Unmarshal(r.stdout, v); err != nil {
+		return fmt.Errorf("sh: decoding stdout of %s: %w", r.name, err)
+	}
+	return nil
+}
+
+func (r *resultImpl) Lines() []string {
+	out := strings.TrimSuffix(string(r.stdout), "\n")
+	if out == "" {
+		return nil
+	}
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+func (r *resultImpl) TrimmedString() string {
+	return string(bytes.TrimSpace(r.stdout))
+}
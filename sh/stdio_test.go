@@ -0,0 +1,69 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestStdinJSONAndResultJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type item struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	result, err := sh.New("cat").Build(ctx).WithStdinJSON(item{Name: "a", Count: 2}).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	var got item
+	if err := result.JSON(&got); err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	if got != (item{Name: "a", Count: 2}) {
+		t.Errorf("Unexpected round trip: %+v", got)
+	}
+
+	result, _ = sh.New("echo").Arg("not json").Build(ctx).Run()
+	if err := result.JSON(&got); err == nil {
+		t.Error("Expected an error decoding invalid JSON")
+	}
+
+	// Values that cannot be encoded fail the command without starting it
+	_, err = sh.New("cat").Build(ctx).WithStdinJSON(func() {}).Run()
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("Expected a *sh.StartError, got %v", err)
+	}
+}
+
+func TestStdinStringAndResultLines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("cat").Build(ctx).WithStdinString("one\r\ntwo\n\nfour\n").Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if got := result.Lines(); !slices.Equal(got, []string{"one", "two", "", "four"}) {
+		t.Errorf("Unexpected lines: %q", got)
+	}
+
+	result, _ = sh.New("cat").Build(ctx).WithStdinBytes([]byte("  abc123\n")).Run()
+	if got := result.TrimmedString(); got != "abc123" {
+		t.Errorf("Expected trimmed output, got %q", got)
+	}
+
+	result, _ = sh.New("true").Build(ctx).Run()
+	if got := result.Lines(); got != nil {
+		t.Errorf("Expected no lines for empty output, got %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+package sh
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ArgsFromStruct adds an option for each field of the struct v, or pointer to
+// struct, that has a cli tag naming its flag:
+//
+//	type PushOptions struct {
+//		Force  bool     `cli:"--force"`
+//		Remote string   `cli:"--repo"`
+//		Tags   []string `cli:"--push-option"`
+//		Jobs   int      `cli:"--jobs,eq"`
+//		Hosts  []string `cli:"--hosts,sep"`
+//		Ref    string   `cli:",arg"`
+//	}
+//
+// A true bool adds the bare flag. Other values add the flag followed by the
+// value, and slices repeat the flag once per element. Zero values and nil
+// pointers are skipped; a non-nil pointer is always added, so an *int field
+// can pass an explicit 0 and a *bool field an explicit --flag=false.
+//
+// Options after the flag name change how a field is rendered:
+//
+//   - eq joins flag and value into one argument, as in OptEq
+//   - sep joins slice elements with commas, as in OptSep; sep=X uses X instead
+//   - arg adds the value as a positional argument and needs no flag name
+//
+// Fields are added in declaration order. Untagged fields, fields tagged "-"
+// and unexported fields are ignored, and embedded structs are flattened.
+// If v is not a struct, the commands built fail when they run, or
+// MustBuild panics, since that is a programming error.
+func (s *Builder) ArgsFromStruct(v any) *Builder {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return s
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		if s.err == nil {
+			s.err = fmt.Errorf("sh: ArgsFromStruct of non-struct type %T", v)
+		}
+		return s
+	}
+
+	s.argsFromStruct(rv)
+	return s
+}
+
+func (s *Builder) argsFromStruct(rv reflect.Value) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		value := rv.Field(i)
+
+		tag, tagged := field.Tag.Lookup("cli")
+		if field.Anonymous && !tagged {
+			for value.Kind() == reflect.Pointer && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				s.argsFromStruct(value)
+			}
+			continue
+		}
+		if !tagged || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		s.structField(parseCliTag(tag), value)
+	}
+}
+
+// cliTag is a parsed cli struct tag.
+type cliTag struct {
+	flag string
+	eq   bool
+	arg  bool
+	sep  string
+}
+
+func parseCliTag(tag string) cliTag {
+	name, opts, _ := strings.Cut(tag, ",")
+	t := cliTag{flag: name}
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		switch {
+		case opt == "eq":
+			t.eq = true
+		case opt == "arg":
+			t.arg = true
+		case opt == "sep":
+			t.sep = ","
+		case strings.HasPrefix(opt, "sep="):
+			// "sep=," is cut at its comma, leaving "sep=" behind
+			t.sep = cmp.Or(strings.TrimPrefix(opt, "sep="), ",")
+		}
+	}
+	return t
+}
+
+func (s *Builder) structField(tag cliTag, value reflect.Value) {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	} else if value.IsZero() {
+		return
+	}
+
+	switch {
+	case value.Kind() == reflect.Bool && !tag.arg:
+		// Only a pointer can get here with false
+		if !value.Bool() {
+			s.OptEq(tag.flag, false)
+			return
+		}
+		s.OptB(tag.flag)
+	case value.Kind() == reflect.Slice || value.Kind() == reflect.Array:
+		items := make([]any, value.Len())
+		for i := range items {
+			items[i] = value.Index(i).Interface()
+		}
+		if tag.sep != "" {
+			s.structValue(tag, strings.Join(stringify(items), tag.sep))
+			return
+		}
+		for _, item := range items {
+			s.structValue(tag, item)
+		}
+	default:
+		s.structValue(tag, value.Interface())
+	}
+}
+
+func (s *Builder) structValue(tag cliTag, value any) {
+	switch {
+	case tag.arg:
+		s.Arg(fmt.Sprintf("%v", value))
+	case tag.eq:
+		s.OptEq(tag.flag, value)
+	default:
+		s.OptV(tag.flag, value)
+	}
+}
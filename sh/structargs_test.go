@@ -0,0 +1,84 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+type commonOptions struct {
+	Verbose bool `cli:"--verbose"`
+}
+
+type pushOptions struct {
+	commonOptions
+	Force    bool          `cli:"--force"`
+	DryRun   bool          `cli:"--dry-run"`
+	Remote   string        `cli:"--repo"`
+	Push     []string      `cli:"--push-option"`
+	Jobs     int           `cli:"--jobs,eq"`
+	Hosts    []string      `cli:"--hosts,sep"`
+	Paths    []string      `cli:"--paths,sep=:"`
+	Timeout  time.Duration `cli:"--timeout"`
+	Retries  *int          `cli:"--retries"`
+	Verify   *bool         `cli:"--verify"`
+	Internal string        `cli:"-"`
+	Untagged string
+	Refs     []string `cli:",arg"`
+}
+
+func TestArgsFromStruct(t *testing.T) {
+	zero, no := 0, false
+	opts := pushOptions{
+		commonOptions: commonOptions{Verbose: true},
+		Force:         true,
+		Remote:        "origin",
+		Push:          []string{"ci.skip", "merge_request.create"},
+		Jobs:          4,
+		Hosts:         []string{"a", "b"},
+		Paths:         []string{"/bin", "/usr/bin"},
+		Timeout:       time.Minute,
+		Retries:       &zero,
+		Verify:        &no,
+		Internal:      "ignored",
+		Untagged:      "ignored",
+		Refs:          []string{"main", "v1.0"},
+	}
+
+	got := strings.Join(sh.New("git").SubCommand("push").ArgsFromStruct(&opts).Items(), " ")
+	want := "git push --verbose --force --repo origin" +
+		" --push-option ci.skip --push-option merge_request.create" +
+		" --jobs=4 --hosts a,b --paths /bin:/usr/bin --timeout 1m0s" +
+		" --retries 0 --verify=false main v1.0"
+	if got != want {
+		t.Errorf("Unexpected items:\n got: %s\nwant: %s", got, want)
+	}
+
+	// Zero values add nothing
+	if got := strings.Join(sh.New("git").ArgsFromStruct(pushOptions{}).Items(), " "); got != "git" {
+		t.Errorf("Expected no options for zero values, got %s", got)
+	}
+}
+
+func TestArgsFromStructNonStruct(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	b := sh.New("git").ArgsFromStruct("--force")
+	_, err := b.Build(ctx).Run()
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) || startErr.Stage != sh.StageSetup {
+		t.Errorf("Expected a StartError for a non-struct value, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustBuild to panic for a non-struct value")
+		}
+	}()
+	b.MustBuild(ctx)
+}
@@ -0,0 +1,270 @@
+package sh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+// Supervisor keeps a long-running process such as a port-forward or a file
+// watcher running, restarting it when it exits or stops passing its health
+// check. Unlike Compose it manages a single process and by default restarts
+// it forever.
+type Supervisor struct {
+	build          func(ctx context.Context) Cmd
+	policy         RestartPolicy
+	maxRestarts    int
+	backoff        backoff.Strategy
+	stableAfter    time.Duration
+	check          func(ctx context.Context) error
+	checkInterval  time.Duration
+	checkThreshold int
+
+	mu       sync.Mutex
+	cmd      Cmd
+	restarts int
+	ctx      context.Context // of the processes
+	kill     context.CancelFunc
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// Supervise returns a Supervisor for the process built by build, which is
+// called for every (re)start and must return a new, unstarted command
+// built with ctx.
+func Supervise(build func(ctx context.Context) Cmd) *Supervisor {
+	return &Supervisor{build: build, policy: RestartAlways, maxRestarts: -1, stableAfter: time.Minute}
+}
+
+// Policy sets when the process is restarted; the default is RestartAlways.
+// A failed health check counts as a failure. Once the process exits
+// without being restarted the supervisor finishes, and Wait returns the
+// process's error.
+func (s *Supervisor) Policy(p RestartPolicy) *Supervisor {
+	s.policy = p
+	return s
+}
+
+// MaxRestarts sets how many times the process may be restarted before the
+// supervisor gives up with ErrRestartBudgetExceeded. A negative n, the
+// default, restarts it forever.
+func (s *Supervisor) MaxRestarts(n int) *Supervisor {
+	s.maxRestarts = n
+	return s
+}
+
+// Backoff delays restarts as strategy dictates. Without a strategy the
+// process is restarted immediately. The backoff starts over after the
+// process has run for the duration set with StableAfter.
+func (s *Supervisor) Backoff(strategy backoff.Strategy) *Supervisor {
+	s.backoff = strategy
+	return s
+}
+
+// StableAfter sets how long the process must run for its next restart to
+// count as the first again in the backoff schedule. The default is a
+// minute.
+func (s *Supervisor) StableAfter(d time.Duration) *Supervisor {
+	s.stableAfter = d
+	return s
+}
+
+// HealthCheck calls check every interval once the process is ready (see
+// Cmd.Ready) and restarts the process after failures consecutive failed
+// checks, as if it had failed.
+func (s *Supervisor) HealthCheck(check func(ctx context.Context) error, interval time.Duration, failures int) *Supervisor {
+	s.check = check
+	s.checkInterval = interval
+	s.checkThreshold = max(failures, 1)
+	return s
+}
+
+// Start starts the process and returns without waiting for it to become
+// ready. Cancelling ctx kills the process and stops supervising it.
+func (s *Supervisor) Start(ctx context.Context) {
+	s.ctx, s.kill = context.WithCancel(ctx)
+	s.stopping = make(chan struct{})
+	s.done = make(chan struct{})
+	goSafe(s.supervise)
+}
+
+// Stop stops supervising and shuts the process down gracefully: it is sent
+// SIGTERM (a CTRL_BREAK event on Windows) and killed if it is still running
+// after grace. Stop returns once the process has exited, with the error of
+// Wait.
+func (s *Supervisor) Stop(grace time.Duration) error {
+	if s.done == nil {
+		return nil
+	}
+	s.stopOnce.Do(func() { close(s.stopping) })
+
+	if cmd := s.Running(); cmd != nil {
+		if err := cmd.Signal(defaultStopSignal); err == nil {
+			select {
+			case <-s.done:
+			case <-time.After(grace):
+			}
+		}
+	}
+	s.kill()
+	return s.Wait()
+}
+
+// Wait blocks until the supervisor has finished: after Stop, the
+// cancellation of its context, the process exiting without being
+// restarted or running out of restarts. It returns nil after Stop, the
+// context's error if it was cancelled and an error matching
+// ErrRestartBudgetExceeded if the restarts ran out.
+func (s *Supervisor) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Running returns the current instance of the process, or nil between
+// restarts and once the supervisor has finished.
+func (s *Supervisor) Running() Cmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmd
+}
+
+// Restarts returns how many times the process has been restarted.
+func (s *Supervisor) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+// supervise runs and restarts the process until the supervisor finishes.
+func (s *Supervisor) supervise() {
+	defer close(s.done)
+	defer s.kill()
+
+	strategy := s.backoff
+	if strategy == nil {
+		strategy = backoff.Constant(0)
+	}
+	b := backoff.New(strategy)
+
+	for {
+		cmd, err := buildSafely(s.ctx, s.build)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.mu.Lock()
+		s.cmd = cmd
+		s.mu.Unlock()
+
+		started := time.Now()
+		cmd.Start()
+		checkErr := s.watchHealth(cmd)
+		_, err = cmd.Wait()
+		if hErr := checkErr(); hErr != nil {
+			err = fmt.Errorf("sh: health check failed: %w", hErr)
+		}
+
+		s.mu.Lock()
+		s.cmd = nil
+		s.mu.Unlock()
+
+		if s.finished() {
+			return
+		}
+		if s.policy == RestartNever || err == nil && s.policy == RestartOnFailure {
+			s.err = err
+			return
+		}
+		if s.maxRestarts >= 0 && s.Restarts() >= s.maxRestarts {
+			s.err = fmt.Errorf("%w: %s", ErrRestartBudgetExceeded, cmd)
+			if err != nil {
+				s.err = fmt.Errorf("%w: %s: %w", ErrRestartBudgetExceeded, cmd, err)
+			}
+			return
+		}
+		if time.Since(started) >= s.stableAfter {
+			b.Reset()
+		}
+
+		s.mu.Lock()
+		s.restarts++
+		s.mu.Unlock()
+		delay := b.Next()
+		logRetry(cmd, b.Attempt(), delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-s.stopping:
+		case <-s.ctx.Done():
+		}
+		if s.finished() {
+			return
+		}
+	}
+}
+
+// finished reports whether the supervisor was stopped or its context
+// cancelled, setting the error of Wait accordingly.
+func (s *Supervisor) finished() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return true
+	}
+	return false
+}
+
+// watchHealth runs the health check against cmd until it exits, cancelling
+// cmd once the check has failed often enough. The returned function waits
+// for the checks to end and returns the last failure that led to the
+// cancellation, if any.
+func (s *Supervisor) watchHealth(cmd Cmd) func() error {
+	if s.check == nil {
+		return func() error { return nil }
+	}
+
+	var failure error
+	ended := make(chan struct{})
+	goSafe(func() {
+		defer close(ended)
+		select {
+		case <-cmd.Ready():
+		case <-cmd.Done():
+			return
+		}
+
+		ticker := time.NewTicker(s.checkInterval)
+		defer ticker.Stop()
+		failures := 0
+		for {
+			select {
+			case <-ticker.C:
+			case <-cmd.Done():
+				return
+			}
+			if err := protect(func() error { return s.check(s.ctx) }); err != nil {
+				failures++
+				if failures >= s.checkThreshold {
+					failure = err
+					cmd.Cancel()
+					return
+				}
+			} else {
+				failures = 0
+			}
+		}
+	})
+	return func() error {
+		<-ended
+		return failure
+	}
+}
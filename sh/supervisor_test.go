@@ -0,0 +1,187 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/backoff"
+)
+
+var regexpReady = regexp.MustCompile("ready")
+
+func TestSupervisorRestarts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var starts atomic.Int32
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		starts.Add(1)
+		return sh.New("sh").OptV("-c", "exit 1").Build(ctx)
+	}).MaxRestarts(3).Backoff(backoff.Constant(time.Millisecond))
+
+	s.Start(ctx)
+	err := s.Wait()
+	if !errors.Is(err, sh.ErrRestartBudgetExceeded) {
+		t.Fatalf("Expected ErrRestartBudgetExceeded, got %v", err)
+	}
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 {
+		t.Errorf("Expected the last exit error, got %v", err)
+	}
+	if got := starts.Load(); got != 4 {
+		t.Errorf("Expected 4 starts, got %d", got)
+	}
+	if got := s.Restarts(); got != 3 {
+		t.Errorf("Expected 3 restarts, got %d", got)
+	}
+}
+
+func TestSupervisorRestartOnFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Fails the first time, then succeeds
+	marker := filepath.Join(t.TempDir(), "ran")
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		return sh.New("sh").OptV("-c", `[ -e "$1" ] || { touch "$1"; exit 1; }`).Arg("sh").Arg(marker).Build(ctx)
+	}).Policy(sh.RestartOnFailure)
+
+	s.Start(ctx)
+	if err := s.Wait(); err != nil {
+		t.Fatalf("Expected the supervisor to finish cleanly, got %v", err)
+	}
+	if got := s.Restarts(); got != 1 {
+		t.Errorf("Expected 1 restart, got %d", got)
+	}
+}
+
+func TestSupervisorRestartNever(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		return sh.New("sh").OptV("-c", "exit 4").Build(ctx)
+	}).Policy(sh.RestartNever)
+
+	s.Start(ctx)
+	var exitErr *sh.ExitError
+	if err := s.Wait(); !errors.As(err, &exitErr) || exitErr.ExitCode != 4 {
+		t.Errorf("Expected the process's exit error, got %v", err)
+	}
+	if s.Restarts() != 0 {
+		t.Errorf("Expected no restarts, got %d", s.Restarts())
+	}
+}
+
+func TestSupervisorHealthCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var checks atomic.Int32
+	s := sh.Supervise(longRunning).
+		HealthCheck(func(context.Context) error {
+			// The first instance turns unhealthy after two checks
+			if checks.Add(1) > 2 && checks.Load() < 5 {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}, 10*time.Millisecond, 2)
+
+	s.Start(ctx)
+	defer s.Stop(0)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for s.Restarts() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Restarts() != 1 {
+		t.Fatalf("Expected a restart after failed health checks, got %d", s.Restarts())
+	}
+	if s.Running() == nil {
+		// Between instances; give the restart a moment
+		time.Sleep(50 * time.Millisecond)
+	}
+	if s.Running() == nil {
+		t.Error("Expected the process to be running again")
+	}
+}
+
+func TestSupervisorStopGraceful(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	marker := filepath.Join(t.TempDir(), "terminated")
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		return sh.New("sh").
+			OptV("-c", `trap 'touch "$1"; exit 0' TERM; echo ready; while :; do sleep 0.01; done`).
+			Arg("sh").Arg(marker).
+			Build(ctx).
+			WithReadyPattern(regexpReady)
+	})
+	s.Start(ctx)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for s.Running() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-s.Running().Ready()
+
+	if err := s.Stop(3 * time.Second); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Expected the process to handle SIGTERM: %v", err)
+	}
+	if s.Restarts() != 0 || s.Running() != nil {
+		t.Errorf("Expected no restart after Stop, got %d", s.Restarts())
+	}
+}
+
+func TestSupervisorStopKillsAfterGrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := sh.Supervise(func(ctx context.Context) sh.Cmd {
+		return sh.New("sh").OptV("-c", `trap '' TERM; echo ready; while :; do sleep 0.01; done`).
+			Build(ctx).
+			WithReadyPattern(regexpReady)
+	})
+	s.Start(ctx)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for s.Running() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-s.Running().Ready()
+
+	start := time.Now()
+	if err := s.Stop(100 * time.Millisecond); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the process to be killed after the grace period, took %v", elapsed)
+	}
+}
+
+func TestSupervisorContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := sh.Supervise(longRunning)
+	s.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := s.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if s.Restarts() != 0 {
+		t.Errorf("Expected no restarts after cancellation, got %d", s.Restarts())
+	}
+}
@@ -0,0 +1,43 @@
+package sh
+
+import "context"
+
+// Template is a base command configured once and instantiated many times.
+// Unlike a Builder, whose options accumulate with every call, instances of
+// a Template never see each other's arguments:
+//
+//	rsync := sh.New("rsync").OptB("-a").OptB("--delete").Template()
+//	for _, dir := range dirs {
+//		rsync.Build(ctx, dir, "backup:"+dir).Run()
+//	}
+//
+// A Template is safe for concurrent use.
+type Template struct {
+	base *Builder
+}
+
+// Template returns a Template of the builder's current configuration.
+// Later changes to the builder do not affect the Template.
+func (b *Builder) Template() *Template {
+	return &Template{base: b.Clone()}
+}
+
+// New returns a builder starting from the template, for adding options
+// before building.
+func (t *Template) New() *Builder {
+	return t.base.Clone()
+}
+
+// Build builds a command from the template with args appended.
+func (t *Template) Build(ctx context.Context, args ...string) Cmd {
+	b := t.New()
+	for _, arg := range args {
+		b.Arg(arg)
+	}
+	return b.Build(ctx)
+}
+
+// String returns the template's command line.
+func (t *Template) String() string {
+	return Quote(t.base.Items()...)
+}
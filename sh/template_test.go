@@ -0,0 +1,71 @@
+package sh_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestBuilderClone(t *testing.T) {
+	base := sh.New("rsync").OptB("-a")
+	clone := base.Clone().Arg("src")
+	base.OptB("--delete")
+
+	if got := strings.Join(base.Items(), " "); got != "rsync -a --delete" {
+		t.Errorf("Expected the clone not to affect the original, got %s", got)
+	}
+	if got := strings.Join(clone.Items(), " "); got != "rsync -a src" {
+		t.Errorf("Expected the original not to affect the clone, got %s", got)
+	}
+
+	sub := sh.New("git").OptV("-C", "/repo").SubCommand("log")
+	subClone := sub.Clone().OptB("--oneline")
+	sub.Parent().OptB("--no-pager")
+	if got := strings.Join(subClone.Items(), " "); got != "git -C /repo log --oneline" {
+		t.Errorf("Expected the subcommand clone to copy its parent, got %s", got)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	builder := sh.New("echo").OptB("-n")
+	tmpl := builder.Template()
+	builder.Arg("leaked")
+
+	if tmpl.String() != "echo -n" {
+		t.Errorf("Expected the template to ignore later builder changes, got %s", tmpl)
+	}
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 3)
+	for i, word := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := tmpl.Build(ctx, word).Run()
+			if err != nil {
+				t.Errorf("Command failed: %v", err)
+				return
+			}
+			outputs[i] = string(result.Stdout())
+		}()
+	}
+	wg.Wait()
+	if strings.Join(outputs, ",") != "a,b,c" {
+		t.Errorf("Expected independent instances, got %v", outputs)
+	}
+
+	extended := tmpl.New().OptB("-e").Arg(`x\ty`)
+	if got := strings.Join(extended.Items(), " "); got != `echo -n -e x\ty` {
+		t.Errorf("Unexpected extended template: %s", got)
+	}
+	if tmpl.String() != "echo -n" {
+		t.Errorf("Expected New not to modify the template, got %s", tmpl)
+	}
+}
@@ -0,0 +1,14 @@
+package sh
+
+import "os"
+
+// HasControllingTerminal reports whether the current process has a
+// controlling terminal that commands opening /dev/tty could prompt on.
+func HasControllingTerminal() bool {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,71 @@
+package sh
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// IsTerminal reports whether f refers to a terminal.
+func IsTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+
+	var termios syscall.Termios
+	return ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// termState is the saved state of a terminal.
+type termState = syscall.Termios
+
+func getTermState(f *os.File) (termState, error) {
+	var state termState
+	err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&state)))
+	return state, err
+}
+
+func setTermState(f *os.File, state termState) error {
+	return ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&state)))
+}
+
+// makeRaw puts the terminal f into raw mode and returns a function that
+// restores its previous state.
+func makeRaw(f *os.File) (restore func() error, err error) {
+	var old syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+
+	// Equivalent of cfmakeraw(3)
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
+
+// winsize mirrors struct winsize from <sys/ioctl.h>.
+type winsize struct {
+	Rows, Cols, X, Y uint16
+}
+
+// copyWindowSize sets the window size of the terminal dst to that of src.
+func copyWindowSize(dst, src *os.File) error {
+	var ws winsize
+	if err := ioctl(src.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return err
+	}
+	return ioctl(dst.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
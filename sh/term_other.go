@@ -0,0 +1,38 @@
+//go:build !linux
+
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// IsTerminal reports whether f refers to a terminal. Outside of Linux this is
+// a best-effort check for a character device.
+func IsTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// termState is the saved state of a terminal.
+type termState struct{}
+
+func getTermState(*os.File) (termState, error) {
+	return termState{}, fmt.Errorf("sh: terminal state: %w", errors.ErrUnsupported)
+}
+
+func setTermState(*os.File, termState) error {
+	return fmt.Errorf("sh: terminal state: %w", errors.ErrUnsupported)
+}
+
+func makeRaw(*os.File) (restore func() error, err error) {
+	return nil, fmt.Errorf("sh: raw mode: %w", errors.ErrUnsupported)
+}
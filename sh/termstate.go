@@ -0,0 +1,138 @@
+package sh
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// savedTerminal is the state of a terminal saved by SaveTerminal.
+type savedTerminal struct {
+	f     *os.File
+	state termState
+}
+
+var (
+	termMu sync.Mutex
+	// termSaved holds the terminal states to restore on RestoreTerminals
+	// or a terminating signal.
+	termSaved   = make(map[*savedTerminal]struct{})
+	termSignals chan os.Signal
+)
+
+// SaveTerminal saves the state of the terminal f, such as raw mode and
+// echo, and returns a function restoring it. Until then, the state is also
+// restored by RestoreTerminals and when the process receives SIGINT,
+// SIGTERM or SIGHUP, so that a crashed child or an interrupted parent does
+// not leave the terminal without echo. SIGTERM and SIGHUP are re-raised
+// after restoring, so they still terminate a process that does not handle
+// them itself; SIGINT is left to the child, as in a shell.
+//
+// Commands run WithInteractive or WithPTY save and restore the terminal on
+// stdin automatically. Not supported outside of Linux.
+func SaveTerminal(f *os.File) (restore func() error, err error) {
+	state, err := getTermState(f)
+	if err != nil {
+		return nil, err
+	}
+	saved := &savedTerminal{f: f, state: state}
+
+	termMu.Lock()
+	termSaved[saved] = struct{}{}
+	if termSignals == nil {
+		termSignals = make(chan os.Signal, 1)
+		signal.Notify(termSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		ch := termSignals
+		goSafe(func() { restoreOnSignal(ch) })
+	}
+	termMu.Unlock()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() {
+			err = setTermState(saved.f, saved.state)
+			termMu.Lock()
+			defer termMu.Unlock()
+			delete(termSaved, saved)
+			if len(termSaved) == 0 && termSignals != nil {
+				signal.Stop(termSignals)
+				close(termSignals)
+				termSignals = nil
+			}
+		})
+		return err
+	}, nil
+}
+
+// MakeRaw puts the terminal f into raw mode, for reading key presses one
+// at a time, and returns a function restoring its previous state. As with
+// SaveTerminal, the state is also restored by RestoreTerminals and on
+// terminating signals. Not supported outside of Linux.
+func MakeRaw(f *os.File) (restore func() error, err error) {
+	restore, err = SaveTerminal(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := makeRaw(f); err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+// RestoreTerminals restores every terminal state saved by SaveTerminal
+// and not yet restored. Defer it in main, around a recover if needed, to
+// restore the terminal even if the program panics while a command is
+// running.
+func RestoreTerminals() {
+	termMu.Lock()
+	defer termMu.Unlock()
+	for saved := range termSaved {
+		setTermState(saved.f, saved.state)
+	}
+}
+
+func restoreOnSignal(signals chan os.Signal) {
+	for sig := range signals {
+		RestoreTerminals()
+		if sig == os.Interrupt {
+			continue
+		}
+
+		// Deliver the signal again without our handler, so its default
+		// action or the application's own handler applies
+		termMu.Lock()
+		if termSignals == signals {
+			signal.Stop(signals)
+			termSignals = nil
+		}
+		termMu.Unlock()
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+		return
+	}
+}
+
+// terminalStateHook saves the state of the command's stdin if it is a
+// terminal and restores it once the command exits.
+func terminalStateHook() execHook {
+	var restore func() error
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			if f, ok := cmd.Stdin.(*os.File); ok && IsTerminal(f) {
+				// Unsupported platforms simply keep the old behavior
+				restore, _ = SaveTerminal(f)
+			}
+			return nil
+		},
+		after: func(error) error {
+			if restore != nil {
+				restore()
+			}
+			return nil
+		},
+	}
+}
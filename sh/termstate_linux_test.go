@@ -0,0 +1,97 @@
+package sh_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// openTestPTY returns the slave end of a new pseudo-terminal.
+func openTestPTY(t *testing.T) *os.File {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock int32
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatal(errno)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	return slave
+}
+
+// sttyState returns the settings of tty in stty's save format.
+func sttyState(t *testing.T, ctx context.Context, tty *os.File) string {
+	t.Helper()
+	result, err := sh.New("stty").Arg("-g").Build(ctx).WithStdin(tty).Run()
+	if err != nil {
+		t.Fatalf("stty failed: %v", err)
+	}
+	return strings.TrimSpace(string(result.Stdout()))
+}
+
+func TestInteractiveRestoresTerminal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tty := openTestPTY(t)
+	before := sttyState(t, ctx, tty)
+
+	// A child that leaves the terminal in raw mode without echo and dies
+	runner := sh.NewRunnerWithDefaults(sh.Defaults{Stdin: tty})
+	runner.New("sh").OptV("-c", "stty raw -echo; kill -KILL $$").Build(ctx).WithInteractive().Run()
+
+	if after := sttyState(t, ctx, tty); after != before {
+		t.Errorf("Expected the terminal state to be restored:\nbefore: %s\n after: %s", before, after)
+	}
+}
+
+func TestSaveTerminal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tty := openTestPTY(t)
+	before := sttyState(t, ctx, tty)
+
+	restore, err := sh.SaveTerminal(tty)
+	if err != nil {
+		t.Fatalf("SaveTerminal failed: %v", err)
+	}
+	defer restore()
+
+	sh.New("stty").Arg("-echo").Build(ctx).WithStdin(tty).Run()
+	if sttyState(t, ctx, tty) == before {
+		t.Fatal("Expected stty to change the terminal state")
+	}
+
+	sh.RestoreTerminals()
+	if after := sttyState(t, ctx, tty); after != before {
+		t.Errorf("Expected RestoreTerminals to restore the state:\nbefore: %s\n after: %s", before, after)
+	}
+
+	sh.New("stty").Arg("-echo").Build(ctx).WithStdin(tty).Run()
+	if err := restore(); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if after := sttyState(t, ctx, tty); after != before {
+		t.Errorf("Expected restore to restore the state:\nbefore: %s\n after: %s", before, after)
+	}
+}
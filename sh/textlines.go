@@ -0,0 +1,159 @@
+package sh
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TextLines is command output split into lines, with helpers for the
+// sorting, deduplication and filtering usually done by piping into sort,
+// uniq and grep.
+type TextLines []string
+
+// SplitLines splits data into lines. A trailing newline does not produce an
+// empty last line and "\r\n" line endings are accepted.
+func SplitLines(data []byte) TextLines {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return TextLines{}
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// SortOption configures TextLines.Sort.
+type SortOption func(*sortConfig)
+
+type sortConfig struct {
+	compare    func(a, b string) int
+	ignoreCase bool
+	numeric    bool
+	reverse    bool
+}
+
+// IgnoreCase sorts without regard to letter case, like sort -f.
+func IgnoreCase() SortOption {
+	return func(c *sortConfig) { c.ignoreCase = true }
+}
+
+// Numeric compares runs of digits by their value, so "file2" sorts before
+// "file10".
+func Numeric() SortOption {
+	return func(c *sortConfig) { c.numeric = true }
+}
+
+// Reverse reverses the sort order, like sort -r.
+func Reverse() SortOption {
+	return func(c *sortConfig) { c.reverse = true }
+}
+
+// Collate sorts with compare, e.g. the Compare method of a
+// golang.org/x/text/collate.Collator for locale-specific ordering. It takes
+// precedence over IgnoreCase and Numeric.
+func Collate(compare func(a, b string) int) SortOption {
+	return func(c *sortConfig) { c.compare = compare }
+}
+
+// Sort returns the lines in sorted order; the default order is by byte
+// value, like sort in the C locale. The sort is stable and the receiver is
+// not modified.
+func (l TextLines) Sort(opts ...SortOption) TextLines {
+	var cfg sortConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	compare := cfg.compare
+	if compare == nil {
+		compare = func(a, b string) int {
+			if cfg.ignoreCase {
+				a, b = strings.ToLower(a), strings.ToLower(b)
+			}
+			if cfg.numeric {
+				return compareNatural(a, b)
+			}
+			return strings.Compare(a, b)
+		}
+	}
+
+	sorted := slices.Clone(l)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		if cfg.reverse {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	return sorted
+}
+
+// Unique returns the lines with duplicates removed, keeping the first
+// occurrence of each line in its original position. Unlike uniq the input
+// does not need to be sorted.
+func (l TextLines) Unique() TextLines {
+	seen := make(map[string]bool, len(l))
+	unique := make(TextLines, 0, len(l))
+	for _, line := range l {
+		if !seen[line] {
+			seen[line] = true
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+// Filter returns the lines matching re, like grep.
+func (l TextLines) Filter(re *regexp.Regexp) TextLines {
+	matched := make(TextLines, 0, len(l))
+	for _, line := range l {
+		if re.MatchString(line) {
+			matched = append(matched, line)
+		}
+	}
+	return matched
+}
+
+// String joins the lines with trailing newlines.
+func (l TextLines) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return strings.Join(l, "\n") + "\n"
+}
+
+// compareNatural compares a and b treating runs of digits as numbers.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func leadingDigits(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
@@ -0,0 +1,66 @@
+package sh_test
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestTextLinesSort(t *testing.T) {
+	lines := sh.TextLines{"file10", "File2", "file1", "apple"}
+
+	tests := []struct {
+		name string
+		opts []sh.SortOption
+		want []string
+	}{
+		{"bytes", nil, []string{"File2", "apple", "file1", "file10"}},
+		{"ignore case", []sh.SortOption{sh.IgnoreCase()}, []string{"apple", "file1", "file10", "File2"}},
+		{"numeric", []sh.SortOption{sh.IgnoreCase(), sh.Numeric()}, []string{"apple", "file1", "File2", "file10"}},
+		{"reverse", []sh.SortOption{sh.Reverse()}, []string{"file10", "file1", "apple", "File2"}},
+		{"collate", []sh.SortOption{sh.Collate(func(a, b string) int { return len(a) - len(b) })}, []string{"File2", "file1", "apple", "file10"}},
+	}
+
+	for _, tt := range tests {
+		if got := lines.Sort(tt.opts...); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if lines[0] != "file10" {
+		t.Error("Expected Sort not to modify the receiver")
+	}
+}
+
+func TestResultLineHelpers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := sh.New("printf").Arg("b\r\na\nb\nc10\nc9\n").Build(ctx).Run()
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+
+	if got := result.StdoutLines(); !slices.Equal(got, []string{"b", "a", "b", "c10", "c9"}) {
+		t.Errorf("StdoutLines = %q", got)
+	}
+	if got := result.UniqueLines(); !slices.Equal(got, []string{"b", "a", "c10", "c9"}) {
+		t.Errorf("UniqueLines = %q", got)
+	}
+	if got := result.FilterLines(regexp.MustCompile(`^c\d+$`)); !slices.Equal(got, []string{"c10", "c9"}) {
+		t.Errorf("FilterLines = %q", got)
+	}
+
+	got := result.SortLines(sh.Numeric()).Unique().String()
+	if want := strings.Join([]string{"a", "b", "c9", "c10"}, "\n") + "\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if len(sh.SplitLines(nil)) != 0 {
+		t.Error("Expected no lines for empty output")
+	}
+}
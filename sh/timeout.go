@@ -0,0 +1,27 @@
+package sh
+
+import (
+	"os"
+	"time"
+)
+
+func (cm *cmdImpl) WithTimeout(d time.Duration) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.timeout = d
+	return cm
+}
+
+func (cm *cmdImpl) WithGracePeriod(d time.Duration) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.gracePeriod = d
+	return cm
+}
+
+func (cm *cmdImpl) WithStopSignal(sig os.Signal) Cmd {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.stopSignal = sig
+	return cm
+}
@@ -0,0 +1,26 @@
+//go:build !unix && !windows
+
+package sh
+
+import (
+	"os"
+	"os/exec"
+)
+
+// killGroupOnCancel sends the stop signal to cmd when its context is done
+// and kills it once the grace period has passed. Process groups are not
+// used on this platform.
+func (cm *cmdImpl) killGroupOnCancel(cmd *exec.Cmd) {
+	sig := cm.stopSignal
+	if sig == nil || cm.gracePeriod == 0 {
+		sig = os.Kill
+	}
+
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(sig); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cm.gracePeriod
+}
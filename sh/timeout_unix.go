@@ -0,0 +1,47 @@
+//go:build unix
+
+package sh
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killGroupOnCancel runs cmd in its own process group and, when its context
+// is done, signals the whole group with the stop signal followed by SIGKILL
+// once the grace period has passed. Without a grace period the group is
+// killed immediately.
+func (cm *cmdImpl) killGroupOnCancel(cmd *exec.Cmd) {
+	sig := syscall.SIGTERM
+	if s, ok := cm.stopSignal.(syscall.Signal); ok {
+		sig = s
+	}
+	grace := cm.gracePeriod
+	if grace == 0 {
+		sig = syscall.SIGKILL
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		if err := syscall.Kill(-pgid, sig); err != nil {
+			return os.ErrProcessDone
+		}
+		if sig != syscall.SIGKILL {
+			// Reach children that outlive the group leader
+			time.AfterFunc(grace, func() {
+				syscall.Kill(-pgid, syscall.SIGKILL)
+			})
+		}
+		return nil
+	}
+	// Backstop in case the group cannot be killed, e.g. because a child
+	// moved to another group while holding the output pipes open
+	cmd.WaitDelay = grace + time.Second
+}
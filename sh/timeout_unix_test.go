@@ -0,0 +1,87 @@
+//go:build unix
+
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestCmdTimeoutKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The shell and its background child both ignore SIGTERM
+	start := time.Now()
+	result, err := sh.New("sh").
+		OptV("-c", `trap "" TERM; sleep 30 & echo $!; wait`).
+		Build(ctx).
+		WithTimeout(200 * time.Millisecond).
+		WithGracePeriod(300 * time.Millisecond).
+		Run()
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got %v", err)
+	}
+	if elapsed < 500*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("Expected the group to be killed after the grace period, took %v", elapsed)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(result.Stdout())))
+	if err != nil {
+		t.Fatalf("Expected the child pid on stdout, got %q", result.Stdout())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected background child %d to be killed", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCmdCancelSendsStopSignal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := sh.New("sh").
+		OptV("-c", `trap "echo interrupted; exit 0" INT; sleep 30`).
+		Build(ctx).
+		WithStopSignal(os.Interrupt).
+		WithGracePeriod(5 * time.Second)
+	cmd.Start()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	cmd.Cancel()
+	result, _ := cmd.Wait()
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the command to exit on the stop signal, took %v", elapsed)
+	}
+	if !strings.Contains(string(result.Stdout()), "interrupted") {
+		t.Errorf("Expected the trap to run, got %q", result.Stdout())
+	}
+}
+
+// processRunning reports whether pid exists and is not a zombie.
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package sh
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// killGroupOnCancel runs cmd in its own console process group and, when its
+// context is done, sends the group a CTRL_BREAK event, the closest Windows
+// has to SIGTERM, and kills the command once the grace period has passed.
+// Without a grace period, or with os.Kill as the stop signal, the command
+// is killed immediately.
+func (cm *cmdImpl) killGroupOnCancel(cmd *exec.Cmd) {
+	grace := cm.gracePeriod
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+
+	cmd.Cancel = func() error {
+		if grace == 0 || cm.stopSignal == os.Kill {
+			return cmd.Process.Kill()
+		}
+		if err := sendCtrlBreak(cmd.Process.Pid); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = grace
+}
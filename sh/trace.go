@@ -0,0 +1,139 @@
+package sh
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeTracer is the tracer set with EnableTrace, if any.
+var activeTracer atomic.Pointer[tracer]
+
+// EnableTrace writes a timeline of every command's start, fork, first
+// output and exit to w in the Chrome trace event format, loadable in
+// chrome://tracing or Perfetto. Timestamps have microsecond resolution and
+// each command is shown on its own track. Passing nil disables tracing.
+//
+// The output is a JSON array that is left open so events can be streamed;
+// trace viewers accept it as is.
+func EnableTrace(w io.Writer) {
+	if w == nil {
+		activeTracer.Store(nil)
+		return
+	}
+
+	t := &tracer{w: w, epoch: time.Now(), pid: os.Getpid()}
+	t.write([]byte("[\n"))
+	activeTracer.Store(t)
+}
+
+type tracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	epoch time.Time
+	pid   int
+	seq   atomic.Int64
+}
+
+// traceEvent is a single event in the Chrome trace event format.
+type traceEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat"`
+	Phase string         `json:"ph"`
+	TS    int64          `json:"ts"`
+	PID   int            `json:"pid"`
+	TID   int64          `json:"tid"`
+	Scope string         `json:"s,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+func (t *tracer) emit(tid int64, name, phase string, args map[string]any) {
+	ev := traceEvent{
+		Name:  name,
+		Cat:   "sh",
+		Phase: phase,
+		TS:    time.Since(t.epoch).Microseconds(),
+		PID:   t.pid,
+		TID:   tid,
+		Args:  args,
+	}
+	if phase == "i" {
+		ev.Scope = "t"
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	t.write(append(data, ",\n"...))
+}
+
+func (t *tracer) write(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(data)
+}
+
+// hook returns an exec hook that traces a single run of cm.
+func (t *tracer) hook(cm *cmdImpl) execHook {
+	tid := t.seq.Add(1)
+	var name string
+	var firstOutput sync.Once
+	var pid int
+
+	return execHook{
+		before: func(cmd *exec.Cmd) error {
+			name = cmd.Path
+			if len(cmd.Args) > 0 {
+				name = cmd.Args[0]
+			}
+			t.emit(tid, name, "B", map[string]any{"args": cm.redactAll(cmd.Args), "dir": cmd.Dir})
+
+			onOutput := func() {
+				firstOutput.Do(func() {
+					t.emit(tid, "first-output", "i", nil)
+				})
+			}
+			cmd.Stdout = &notifyWriter{w: cmd.Stdout, notify: onOutput}
+			cmd.Stderr = &notifyWriter{w: cmd.Stderr, notify: onOutput}
+			return nil
+		},
+		started: func(cmd *exec.Cmd) error {
+			pid = cmd.Process.Pid
+			t.emit(tid, "fork", "i", map[string]any{"pid": pid})
+			return nil
+		},
+		after: func(runErr error) error {
+			args := map[string]any{"pid": pid, "exit_code": 0}
+			if code, ok := ExitCode(runErr); ok {
+				args["exit_code"] = code
+			} else if runErr != nil {
+				args["exit_code"] = -1
+			}
+			if runErr != nil {
+				args["error"] = runErr.Error()
+			}
+			t.emit(tid, "exit", "i", args)
+			t.emit(tid, name, "E", nil)
+			return nil
+		},
+	}
+}
+
+// notifyWriter calls notify before each write to w.
+type notifyWriter struct {
+	w      io.Writer
+	notify func()
+}
+
+func (nw *notifyWriter) Write(p []byte) (int, error) {
+	nw.notify()
+	if nw.w == nil {
+		return len(p), nil
+	}
+	return nw.w.Write(p)
+}
@@ -0,0 +1,58 @@
+package sh_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+func TestEnableTrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	sh.EnableTrace(&buf)
+	sh.New("echo").Arg("hi").Build(ctx).Run()
+	sh.New("false").Build(ctx).Run()
+	sh.EnableTrace(nil)
+	sh.New("true").Build(ctx).Run()
+
+	// Close the streamed array to parse it
+	trace := strings.TrimSuffix(buf.String(), ",\n") + "]"
+	var events []struct {
+		Name  string         `json:"name"`
+		Phase string         `json:"ph"`
+		TS    int64          `json:"ts"`
+		TID   int64          `json:"tid"`
+		Args  map[string]any `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(trace), &events); err != nil {
+		t.Fatalf("Invalid trace JSON: %v\n%s", err, trace)
+	}
+
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.Name+":"+ev.Phase)
+	}
+	want := "echo:B fork:i first-output:i exit:i echo:E false:B fork:i exit:i false:E"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Unexpected events:\n got %s\nwant %s", got, want)
+	}
+
+	for i := 1; i < len(events); i++ {
+		if events[i].TS < events[i-1].TS {
+			t.Errorf("Expected non-decreasing timestamps, got %d after %d", events[i].TS, events[i-1].TS)
+		}
+	}
+	if events[0].TID == events[len(events)-1].TID {
+		t.Error("Expected each command on its own track")
+	}
+	if code := events[len(events)-2].Args["exit_code"]; code != float64(1) {
+		t.Errorf("Expected exit code 1 for false, got %v", code)
+	}
+}
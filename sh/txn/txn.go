@@ -0,0 +1,185 @@
+// Package txn runs a chain of steps as a transaction: every step registers
+// how to undo it, and when a step fails the steps completed before it are
+// rolled back in reverse order. It codifies the undo pattern of
+// provisioning scripts:
+//
+//	results, err := txn.New().
+//		Step("create volume", txn.Cmd(sh.New("vol").Arg("create").Arg("data")),
+//			txn.Cmd(sh.New("vol").Arg("rm").Arg("data"))).
+//		Step("start server", txn.Cmd(sh.New("server").Arg("start")),
+//			txn.Cmd(sh.New("server").Arg("stop"))).
+//		Step("register dns", txn.Func(registerDNS), txn.Func(unregisterDNS)).
+//		Run(ctx)
+package txn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// ErrRollbackFailed is matched by the error of Run when a rollback failed
+// as well, so the system may be left half changed.
+var ErrRollbackFailed = errors.New("txn: rollback failed")
+
+// Action is something a step does or undoes. It returns the Result of the
+// command it ran, if any.
+type Action func(ctx context.Context) (sh.Result, error)
+
+// Cmd returns an Action running the command built by b.
+func Cmd(b *sh.Builder) Action {
+	return func(ctx context.Context) (sh.Result, error) {
+		return b.Build(ctx).Run()
+	}
+}
+
+// Func returns an Action calling f, for steps done in Go.
+func Func(f func(ctx context.Context) error) Action {
+	return func(ctx context.Context) (sh.Result, error) {
+		return nil, f(ctx)
+	}
+}
+
+// Txn is a chain of steps with rollbacks, built with New.
+type Txn struct {
+	steps []step
+}
+
+type step struct {
+	name   string
+	do     Action
+	undo   Action
+	skipIf Action
+	onlyIf Action
+}
+
+// StepResult is the outcome of a step and of its rollback.
+type StepResult struct {
+	Name string
+	// Result is the Result of the step's command, nil for steps done by a
+	// Func and steps that did not run.
+	Result sh.Result
+	Err    error
+	// Skipped is true for steps after the failed one and for steps whose
+	// SkipIf or OnlyIf probe said they need not run; SkipReason says which.
+	Skipped    bool
+	SkipReason string
+	// RolledBack is true when the step's rollback ran, successfully or not.
+	RolledBack     bool
+	RollbackResult sh.Result
+	RollbackErr    error
+}
+
+// New returns an empty transaction.
+func New() *Txn {
+	return &Txn{}
+}
+
+// Step appends a step that runs do and, should a later step fail, undo. A
+// nil undo means the step needs no rollback.
+func (t *Txn) Step(name string, do, undo Action) *Txn {
+	t.steps = append(t.steps, step{name: name, do: do, undo: undo})
+	return t
+}
+
+// SkipIf makes the step added last run only if probe fails, for steps
+// that can check whether their work is already done, such as a directory
+// existing. Reruns of a transaction then skip the steps done before.
+// Skipped steps are not rolled back.
+func (t *Txn) SkipIf(probe Action) *Txn {
+	t.steps[len(t.steps)-1].skipIf = probe
+	return t
+}
+
+// OnlyIf makes the step added last run only if probe succeeds. Like with
+// SkipIf, skipped steps are not rolled back.
+func (t *Txn) OnlyIf(probe Action) *Txn {
+	t.steps[len(t.steps)-1].onlyIf = probe
+	return t
+}
+
+// Run runs the steps in order and returns a StepResult for each of them.
+// When a step fails, the rollbacks of the steps completed before it run in
+// reverse order; the failed step itself is expected to have left nothing
+// behind. A failed rollback does not stop the others. Rollbacks run even
+// if ctx was cancelled, since that is often why a step failed, but keep
+// its values.
+//
+// The error names the failed step and includes the errors of any failed
+// rollbacks, in which case it matches ErrRollbackFailed.
+func (t *Txn) Run(ctx context.Context) ([]StepResult, error) {
+	results := make([]StepResult, len(t.steps))
+	failed := -1
+	for i, s := range t.steps {
+		results[i].Name = s.name
+		if failed >= 0 {
+			results[i].Skipped = true
+			results[i].SkipReason = fmt.Sprintf("step %q failed", t.steps[failed].name)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+		} else if reason, err := s.skip(ctx); err != nil || reason != "" {
+			results[i].Skipped = reason != ""
+			results[i].SkipReason = reason
+			results[i].Err = err
+		} else {
+			results[i].Result, results[i].Err = s.do(ctx)
+		}
+		if results[i].Err != nil {
+			failed = i
+		}
+	}
+	if failed < 0 {
+		return results, nil
+	}
+
+	errs := []error{fmt.Errorf("txn: step %q: %w", t.steps[failed].name, results[failed].Err)}
+	rollbackCtx := context.WithoutCancel(ctx)
+	for i := failed - 1; i >= 0; i-- {
+		undo := t.steps[i].undo
+		if undo == nil || results[i].Skipped {
+			continue
+		}
+		r := &results[i]
+		r.RolledBack = true
+		r.RollbackResult, r.RollbackErr = undo(rollbackCtx)
+		if r.RollbackErr != nil {
+			errs = append(errs, fmt.Errorf("%w: step %q: %w", ErrRollbackFailed, r.Name, r.RollbackErr))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// skip runs the probes of the step and returns why it is skipped, if it
+// is. A probe that cannot start fails the step rather than deciding it.
+func (s step) skip(ctx context.Context) (reason string, err error) {
+	if s.skipIf != nil {
+		_, err := s.skipIf(ctx)
+		if notStarted(err) {
+			return "", fmt.Errorf("txn: SkipIf probe: %w", err)
+		}
+		if err == nil {
+			return "SkipIf probe succeeded", nil
+		}
+	}
+	if s.onlyIf != nil {
+		_, err := s.onlyIf(ctx)
+		if notStarted(err) {
+			return "", fmt.Errorf("txn: OnlyIf probe: %w", err)
+		}
+		if err != nil {
+			return fmt.Sprintf("OnlyIf probe failed: %v", err), nil
+		}
+	}
+	return "", nil
+}
+
+// notStarted reports whether err means a probe command could not run at
+// all, or was cut short.
+func notStarted(err error) bool {
+	var startErr *sh.StartError
+	return errors.As(err, &startErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
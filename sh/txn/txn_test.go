@@ -0,0 +1,195 @@
+package txn_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+	"github.com/benoctopus/pkg/sh/txn"
+)
+
+// record returns an Action appending name to log.
+func record(log *[]string, name string, err error) txn.Action {
+	return txn.Func(func(context.Context) error {
+		*log = append(*log, name)
+		return err
+	})
+}
+
+func TestRunSuccess(t *testing.T) {
+	var log []string
+	results, err := txn.New().
+		Step("a", record(&log, "do a", nil), record(&log, "undo a", nil)).
+		Step("b", record(&log, "do b", nil), record(&log, "undo b", nil)).
+		Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"do a", "do b"}; !slices.Equal(log, want) {
+		t.Errorf("Expected %q, got %q", want, log)
+	}
+	for _, r := range results {
+		if r.RolledBack || r.Skipped || r.Err != nil {
+			t.Errorf("Expected step %s to succeed, got %+v", r.Name, r)
+		}
+	}
+}
+
+func TestRunRollsBackInReverse(t *testing.T) {
+	var log []string
+	boom := errors.New("boom")
+	results, err := txn.New().
+		Step("a", record(&log, "do a", nil), record(&log, "undo a", nil)).
+		Step("b", record(&log, "do b", nil), nil).
+		Step("c", record(&log, "do c", nil), record(&log, "undo c", nil)).
+		Step("d", record(&log, "do d", boom), record(&log, "undo d", nil)).
+		Step("e", record(&log, "do e", nil), record(&log, "undo e", nil)).
+		Run(context.Background())
+
+	if !errors.Is(err, boom) || errors.Is(err, txn.ErrRollbackFailed) {
+		t.Errorf("Expected the step's error only, got %v", err)
+	}
+	want := []string{"do a", "do b", "do c", "do d", "undo c", "undo a"}
+	if !slices.Equal(log, want) {
+		t.Errorf("Expected %q, got %q", want, log)
+	}
+	if !results[0].RolledBack || results[1].RolledBack || !results[2].RolledBack || results[3].RolledBack {
+		t.Errorf("Unexpected rollbacks: %+v", results)
+	}
+	if !results[4].Skipped {
+		t.Errorf("Expected the last step to be skipped")
+	}
+}
+
+func TestRunRollbackFailure(t *testing.T) {
+	var log []string
+	stuck := errors.New("stuck")
+	_, err := txn.New().
+		Step("a", record(&log, "do a", nil), record(&log, "undo a", nil)).
+		Step("b", record(&log, "do b", nil), record(&log, "undo b", stuck)).
+		Step("c", record(&log, "do c", errors.New("boom")), nil).
+		Run(context.Background())
+
+	if !errors.Is(err, txn.ErrRollbackFailed) || !errors.Is(err, stuck) {
+		t.Errorf("Expected a rollback failure, got %v", err)
+	}
+	if want := []string{"do a", "do b", "do c", "undo b", "undo a"}; !slices.Equal(log, want) {
+		t.Errorf("Expected the remaining rollbacks to run, got %q", log)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "created")
+	results, err := txn.New().
+		Step("create", txn.Cmd(sh.New("touch").Arg(file)), txn.Cmd(sh.New("rm").Arg(file))).
+		Step("fail", txn.Cmd(sh.New("sh").OptV("-c", "exit 3")), nil).
+		Run(ctx)
+
+	var exitErr *sh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Fatalf("Expected the failed step's exit error, got %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed by the rollback, got %v", err)
+	}
+	if results[0].RollbackResult == nil || results[0].RollbackResult.ExitCode() != 0 {
+		t.Errorf("Expected the rollback's result, got %v", results[0].RollbackResult)
+	}
+	if results[1].Result == nil || results[1].Result.ExitCode() != 3 {
+		t.Errorf("Expected the failed step's result, got %v", results[1].Result)
+	}
+}
+
+func TestRunRollsBackAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var log []string
+	results, err := txn.New().
+		Step("a", record(&log, "do a", nil), txn.Func(func(ctx context.Context) error {
+			log = append(log, "undo a")
+			return ctx.Err()
+		})).
+		Step("b", txn.Func(func(context.Context) error {
+			cancel()
+			return nil
+		}), record(&log, "undo b", nil)).
+		Step("c", record(&log, "do c", nil), nil).
+		Run(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if want := []string{"do a", "undo b", "undo a"}; !slices.Equal(log, want) {
+		t.Errorf("Expected %q, got %q", want, log)
+	}
+	if results[0].RollbackErr != nil {
+		t.Errorf("Expected rollbacks to run with a live context, got %v", results[0].RollbackErr)
+	}
+}
+
+func TestRunSkipIf(t *testing.T) {
+	var log []string
+	results, err := txn.New().
+		Step("a", record(&log, "do a", nil), record(&log, "undo a", nil)).
+		Step("b", record(&log, "do b", nil), record(&log, "undo b", nil)).
+		SkipIf(txn.Func(func(context.Context) error { return nil })).
+		Step("c", record(&log, "do c", nil), record(&log, "undo c", nil)).
+		OnlyIf(txn.Func(func(context.Context) error { return errors.New("no") })).
+		Step("d", record(&log, "do d", errors.New("boom")), nil).
+		Run(context.Background())
+
+	if err == nil {
+		t.Fatal("Expected the last step to fail")
+	}
+	// Skipped steps are neither done nor undone
+	if want := []string{"do a", "do d", "undo a"}; !slices.Equal(log, want) {
+		t.Errorf("Expected %q, got %q", want, log)
+	}
+	if !results[1].Skipped || results[1].SkipReason != "SkipIf probe succeeded" || results[1].RolledBack {
+		t.Errorf("Unexpected result for b: %+v", results[1])
+	}
+	if !results[2].Skipped || results[2].SkipReason != "OnlyIf probe failed: no" {
+		t.Errorf("Unexpected result for c: %+v", results[2])
+	}
+}
+
+func TestRunSkipIfCommand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	tx := func() *txn.Txn {
+		return txn.New().
+			Step("create", txn.Cmd(sh.New("mkdir").Arg(dir)), txn.Cmd(sh.New("rmdir").Arg(dir))).
+			SkipIf(txn.Cmd(sh.New("test").OptV("-d", dir)))
+	}
+	if _, err := tx().Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	results, err := tx().Run(ctx)
+	if err != nil {
+		t.Fatalf("Expected the rerun to skip the step, got %v", err)
+	}
+	if !results[0].Skipped {
+		t.Errorf("Expected the step to be skipped, got %+v", results[0])
+	}
+
+	// A probe that cannot run fails the step
+	_, err = txn.New().
+		Step("create", txn.Cmd(sh.New("true")), nil).
+		SkipIf(txn.Cmd(sh.New("no-such-probe-command"))).
+		Run(ctx)
+	var startErr *sh.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("Expected the probe's start error, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package sh
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ToolVersion is a version number in the major.minor.patch form used by
+// most tools, with an optional pre-release suffix.
+type ToolVersion struct {
+	Major, Minor, Patch int
+	// Pre is the pre-release identifier, e.g. "rc.1" for 1.2.0-rc.1.
+	Pre string
+	// Raw is the version as the tool printed it.
+	Raw string
+}
+
+// String returns the version as major.minor.patch[-pre].
+func (v ToolVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 as v is older than, equal to or newer than o.
+// As in semantic versioning, a pre-release is older than its release.
+func (v ToolVersion) Compare(o ToolVersion) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	}
+	return cmp.Compare(v.Pre, o.Pre)
+}
+
+var versionRe = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// ParseVersion parses a version such as "2.43.0", "v1.29" or "1.2.0-rc.1".
+// Missing minor and patch numbers are zero and build metadata after "+" is
+// ignored.
+func ParseVersion(s string) (ToolVersion, error) {
+	m := versionRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return ToolVersion{}, fmt.Errorf("sh: invalid version %q", s)
+	}
+
+	v := ToolVersion{Pre: m[4], Raw: s}
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] != "" {
+			*p, _ = strconv.Atoi(m[i+1])
+		}
+	}
+	return v, nil
+}
+
+// VersionStrategy describes how to ask a tool for its version.
+type VersionStrategy struct {
+	// Args are the arguments that make the tool print its version. The
+	// default is "--version".
+	Args []string
+	// Pattern finds the version in stdout or, failing that, stderr. It
+	// must have a capture group named "version". The default matches the
+	// first number of the form 1.2[.3][-pre].
+	Pattern *regexp.Regexp
+	// Parse, if set, is used instead of Pattern for tools whose output
+	// needs more than a regular expression. It is given stdout followed by
+	// stderr.
+	Parse func(output string) (ToolVersion, error)
+}
+
+var defaultVersionPattern = regexp.MustCompile(`(?P<version>\d+\.\d+(?:\.\d+)?(?:-[0-9A-Za-z.-]+)?)`)
+
+var (
+	versionStrategiesMu sync.RWMutex
+	// versionStrategies maps tool names to how they report their version,
+	// for tools that do not understand --version.
+	versionStrategies = map[string]VersionStrategy{
+		"go":      {Args: []string{"version"}},
+		"java":    {Args: []string{"-version"}},
+		"ssh":     {Args: []string{"-V"}},
+		"kubectl": {Args: []string{"version", "--client"}},
+		"helm":    {Args: []string{"version", "--short"}},
+		"openssl": {Args: []string{"version"}},
+	}
+)
+
+// RegisterVersionStrategy sets how Version asks tool, the base name of its
+// executable, for its version. Tools that print their version for
+// --version need no strategy; those that do not, such as go, java, ssh and
+// kubectl, are known out of the box.
+func RegisterVersionStrategy(tool string, s VersionStrategy) {
+	versionStrategiesMu.Lock()
+	defer versionStrategiesMu.Unlock()
+	versionStrategies[tool] = s
+}
+
+func versionStrategy(tool string) VersionStrategy {
+	versionStrategiesMu.RLock()
+	s := versionStrategies[toolName(tool)]
+	versionStrategiesMu.RUnlock()
+
+	if s.Args == nil {
+		s.Args = []string{"--version"}
+	}
+	if s.Pattern == nil {
+		s.Pattern = defaultVersionPattern
+	}
+	return s
+}
+
+// Version runs tool to find out its version, using the runner from ctx.
+func Version(ctx context.Context, tool string) (ToolVersion, error) {
+	s := versionStrategy(tool)
+	b := FromContext(ctx).New(tool)
+	for _, arg := range s.Args {
+		b.Arg(arg)
+	}
+
+	// Some tools exit non-zero after printing their version
+	result, runErr := b.Build(ctx).Run()
+	var startErr *StartError
+	if errors.As(runErr, &startErr) && startErr.Stage == StageLookPath {
+		return ToolVersion{}, fmt.Errorf("sh: %s is not installed or not in PATH: %w", tool, runErr)
+	}
+	if result == nil {
+		return ToolVersion{}, runErr
+	}
+	if s.Parse != nil {
+		v, err := s.Parse(string(result.Stdout()) + string(result.Stderr()))
+		if err != nil {
+			return ToolVersion{}, fmt.Errorf("sh: cannot find the version of %s in its output: %w", tool, errors.Join(err, runErr))
+		}
+		return v, nil
+	}
+	groups, err := result.Extract(s.Pattern)
+	if err != nil {
+		return ToolVersion{}, fmt.Errorf("sh: cannot find the version of %s in its output: %w", tool, errors.Join(err, runErr))
+	}
+	v, err := ParseVersion(groups["version"])
+	if err != nil {
+		return ToolVersion{}, err
+	}
+	return v, nil
+}
+
+// VersionError is returned by RequireVersion when a tool is too old or too
+// new.
+type VersionError struct {
+	Tool       string
+	Version    ToolVersion
+	Constraint string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("sh: %s %s is installed but %s is required; install a matching version of %s",
+		e.Tool, e.Version, e.Constraint, e.Tool)
+}
+
+// LookPath finds the executable tool like exec.LookPath, returning an
+// error that reads well to someone setting up a development environment.
+// The error matches exec.ErrNotFound and IsNotFound when tool is missing.
+func LookPath(tool string) (string, error) {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("sh: %s is not installed or not in PATH: %w", tool, err)
+	}
+	return path, nil
+}
+
+// Require checks that the command's executable can be found, for failing
+// early with the error of LookPath rather than when the command runs.
+func (b *Builder) Require() error {
+	_, err := LookPath(b.Cmd)
+	return err
+}
+
+// RequireVersion checks the version of the command's executable like the
+// package-level RequireVersion.
+func (b *Builder) RequireVersion(ctx context.Context, constraint string) error {
+	return RequireVersion(ctx, b.Cmd, constraint)
+}
+
+// RequireVersion checks that the installed version of tool satisfies
+// constraint, a comma-separated list of comparisons that must all hold,
+// such as ">=2.40" or ">=1.6, <2". The operators are =, !=, <, <=, > and
+// >=; a bare version means =. It returns a *VersionError if the version
+// does not match, or an error explaining why the version could not be
+// determined.
+func RequireVersion(ctx context.Context, tool, constraint string) error {
+	check, err := parseConstraint(constraint)
+	if err != nil {
+		return err
+	}
+
+	v, err := Version(ctx, tool)
+	if err != nil {
+		return fmt.Errorf("%w; %s %s is required", err, tool, constraint)
+	}
+	if !check(v) {
+		return &VersionError{Tool: tool, Version: v, Constraint: constraint}
+	}
+	return nil
+}
+
+// parseConstraint compiles a version constraint into a predicate.
+func parseConstraint(constraint string) (func(ToolVersion) bool, error) {
+	var checks []func(ToolVersion) bool
+	for _, part := range strings.Split(constraint, ",") {
+		part = strings.TrimSpace(part)
+		rest := strings.TrimLeft(part, "<>=!")
+		op := part[:len(part)-len(rest)]
+		want, err := ParseVersion(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("sh: invalid version constraint %q: %w", constraint, err)
+		}
+
+		var ok func(c int) bool
+		switch op {
+		case "", "=", "==":
+			ok = func(c int) bool { return c == 0 }
+		case "!=":
+			ok = func(c int) bool { return c != 0 }
+		case "<":
+			ok = func(c int) bool { return c < 0 }
+		case "<=":
+			ok = func(c int) bool { return c <= 0 }
+		case ">":
+			ok = func(c int) bool { return c > 0 }
+		case ">=":
+			ok = func(c int) bool { return c >= 0 }
+		default:
+			return nil, fmt.Errorf("sh: invalid version constraint %q: unknown operator %q", constraint, op)
+		}
+		checks = append(checks, func(v ToolVersion) bool { return ok(v.Compare(want)) })
+	}
+
+	return func(v ToolVersion) bool {
+		for _, check := range checks {
+			if !check(v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,164 @@
+package sh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoctopus/pkg/sh"
+)
+
+// fakeTool writes an executable script named name into a directory added
+// to the front of PATH for the rest of the test.
+func fakeTool(t *testing.T, name, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]string{
+		"2.43.0":           "2.43.0",
+		"v1.29":            "1.29.0",
+		"3":                "3.0.0",
+		"1.2.0-rc.1":       "1.2.0-rc.1",
+		"v3.14.0+g3fc9f4b": "3.14.0",
+	} {
+		v, err := sh.ParseVersion(in)
+		if err != nil || v.String() != want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %s", in, v, err, want)
+		}
+	}
+	if _, err := sh.ParseVersion("latest"); err == nil {
+		t.Error("Expected an error for a non-numeric version")
+	}
+
+	older, _ := sh.ParseVersion("1.2.0-rc.1")
+	newer, _ := sh.ParseVersion("1.2.0")
+	if older.Compare(newer) >= 0 || newer.Compare(older) <= 0 || newer.Compare(newer) != 0 {
+		t.Error("Expected a pre-release to be older than its release")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeTool(t, "fancytool", `[ "$1" = --version ] && echo "fancytool version 2.41.0 (build 7)"`)
+	v, err := sh.Version(ctx, "fancytool")
+	if err != nil {
+		t.Fatalf("Version failed: %v", err)
+	}
+	if v.Major != 2 || v.Minor != 41 || v.Patch != 0 || v.Raw != "2.41.0" {
+		t.Errorf("Unexpected version %+v", v)
+	}
+
+	// A strategy for a tool printing its version to stderr and exiting 1
+	fakeTool(t, "oldtool", `[ "$1" = -V ] && echo "OldTool_9.6p1, Lib 3.0.13" >&2; exit 1`)
+	sh.RegisterVersionStrategy("oldtool", sh.VersionStrategy{
+		Args:    []string{"-V"},
+		Pattern: regexp.MustCompile(`OldTool_(?P<version>\d+\.\d+)`),
+	})
+	if v, err := sh.Version(ctx, "oldtool"); err != nil || v.String() != "9.6.0" {
+		t.Errorf("Expected 9.6.0, got %v, %v", v, err)
+	}
+
+	_, err = sh.Version(ctx, "no-such-tool-for-version")
+	if err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("Expected a not installed error, got %v", err)
+	}
+}
+
+func TestRequireVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeTool(t, "fancytool", `echo "fancytool version 2.34.1"`)
+
+	for _, constraint := range []string{">=2.30", ">2.34, <3", "2.34.1", "!=2.40"} {
+		if err := sh.RequireVersion(ctx, "fancytool", constraint); err != nil {
+			t.Errorf("Expected %s to be satisfied, got %v", constraint, err)
+		}
+	}
+
+	err := sh.RequireVersion(ctx, "fancytool", ">=2.40")
+	var versionErr *sh.VersionError
+	if !errors.As(err, &versionErr) || versionErr.Version.String() != "2.34.1" {
+		t.Fatalf("Expected a *sh.VersionError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "fancytool 2.34.1 is installed but >=2.40 is required") {
+		t.Errorf("Unexpected message: %v", err)
+	}
+
+	if err := sh.RequireVersion(ctx, "fancytool", "~>2"); err == nil {
+		t.Error("Expected an error for an invalid constraint")
+	}
+}
+
+func TestVersionParse(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Prints its version as separate fields rather than a dotted number
+	fakeTool(t, "splittool", `echo "major=3"; echo "minor=7"`)
+	sh.RegisterVersionStrategy("splittool", sh.VersionStrategy{
+		Parse: func(output string) (sh.ToolVersion, error) {
+			var major, minor string
+			for _, line := range strings.Fields(output) {
+				if v, ok := strings.CutPrefix(line, "major="); ok {
+					major = v
+				}
+				if v, ok := strings.CutPrefix(line, "minor="); ok {
+					minor = v
+				}
+			}
+			return sh.ParseVersion(major + "." + minor)
+		},
+	})
+	if v, err := sh.Version(ctx, "splittool"); err != nil || v.String() != "3.7.0" {
+		t.Errorf("Expected 3.7.0, got %v, %v", v, err)
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	fakeTool(t, "lookedup", "true")
+	path, err := sh.LookPath("lookedup")
+	if err != nil || filepath.Base(path) != "lookedup" {
+		t.Errorf("Expected the tool to be found, got %q, %v", path, err)
+	}
+
+	_, err = sh.LookPath("no-such-tool-for-lookpath")
+	if !sh.IsNotFound(err) || !strings.Contains(err.Error(), "no-such-tool-for-lookpath is not installed") {
+		t.Errorf("Expected a not installed error, got %v", err)
+	}
+}
+
+func TestBuilderRequire(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeTool(t, "reqtool", `echo "reqtool 1.4.2"`)
+	b := sh.New("reqtool").Arg("build")
+	if err := b.Require(); err != nil {
+		t.Errorf("Expected the tool to be found: %v", err)
+	}
+	if err := b.RequireVersion(ctx, ">=1.4"); err != nil {
+		t.Errorf("Expected the version to match: %v", err)
+	}
+	var versionErr *sh.VersionError
+	if err := b.RequireVersion(ctx, ">=2"); !errors.As(err, &versionErr) {
+		t.Errorf("Expected a *VersionError, got %v", err)
+	}
+
+	if err := sh.New("no-such-tool-for-require").Require(); !sh.IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}